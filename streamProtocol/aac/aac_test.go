@@ -0,0 +1,83 @@
+package aac
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestAudioSpecificConfig(t *testing.T) {
+	config, data, err := ParseConfig("1210")
+	if err != nil {
+		t.Fatalf("ParseConfig error:%v", err)
+	}
+	if config.ObjectType != ObjectTypeAACLC || config.SampleRate != 44100 ||
+		config.ChannelCount != 2 || config.FrameLength != 1024 {
+		t.Errorf("ParseConfig = %+v", config)
+	}
+	marshaled, err := config.Marshal()
+	if err != nil || !bytes.Equal(marshaled, data) {
+		t.Errorf("Marshal = %x,%v, want %x", marshaled, err, data)
+	}
+}
+
+func TestDepacketizeMultipleAU(t *testing.T) {
+	// two AU-headers of 13+3 bits,sizes 2 and 3
+	payload := []byte{0x00, 0x20, 0x00, 0x10, 0x00, 0x18,
+		0xa1, 0xa2, 0xb1, 0xb2, 0xb3}
+	depacketizer := NewDepacketizer(13, 3, 3, 1024)
+	units, err := depacketizer.Decode(&rtp.Packet{
+		Header: rtp.Header{Marker: true, Timestamp: 1000}, Payload: payload})
+	if err != nil {
+		t.Fatalf("Decode error:%v", err)
+	}
+	if len(units) != 2 || !bytes.Equal(units[1].Data, []byte{0xb1, 0xb2, 0xb3}) ||
+		units[1].Timestamp != 2024 {
+		t.Errorf("Decode = %+v", units)
+	}
+}
+
+func TestPacketizeFragmented(t *testing.T) {
+	frame := make([]byte, 3000)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	packets, err := NewPacketizer(1000, 97, 0).Packetize(frame, 4096)
+	if err != nil {
+		t.Fatalf("Packetize error:%v", err)
+	}
+	if len(packets) != 4 || !packets[3].Marker || packets[0].Marker {
+		t.Fatalf("Packetize got %v packets", len(packets))
+	}
+	depacketizer := NewDepacketizer(13, 3, 3, 1024)
+	var units []*AccessUnit
+	for _, packet := range packets {
+		packetUnits, err := depacketizer.Decode(packet)
+		if err != nil {
+			t.Fatalf("Decode error:%v", err)
+		}
+		units = append(units, packetUnits...)
+	}
+	if len(units) != 1 || !bytes.Equal(units[0].Data, frame) || units[0].Timestamp != 4096 {
+		t.Errorf("Decode fragmented got %v units", len(units))
+	}
+}
+
+func TestADTS(t *testing.T) {
+	config := &AudioSpecificConfig{ObjectType: ObjectTypeAACLC, SampleRate: 44100,
+		ChannelCount: 2, FrameLength: 1024}
+	units := [][]byte{{0x21, 0x10}, {0x21, 0x20, 0x30}}
+	data, err := MarshalADTS(config, units)
+	if err != nil {
+		t.Fatalf("MarshalADTS error:%v", err)
+	}
+	if !bytes.Equal(data[:7], []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc}) {
+		t.Errorf("adts header = %x", data[:7])
+	}
+	parsed, parsedUnits, err := ParseADTS(data)
+	if err != nil || *parsed != *config || len(parsedUnits) != 2 ||
+		!bytes.Equal(parsedUnits[1], units[1]) {
+		t.Errorf("ParseADTS = %+v,%x,%v", parsed, parsedUnits, err)
+	}
+}
@@ -0,0 +1,81 @@
+package aac
+
+import (
+	"fmt"
+)
+
+//ADTSHeaderLength length of adts header without crc
+const ADTSHeaderLength = 7
+
+//MarshalADTS prefix each raw AAC frame with an adts header(ISO 14496-3 1.A.2)
+func MarshalADTS(config *AudioSpecificConfig, units [][]byte) ([]byte, error) {
+	frequencyIndex := -1
+	for index, sampleRate := range SampleRates {
+		if sampleRate == config.SampleRate {
+			frequencyIndex = index
+		}
+	}
+	if frequencyIndex < 0 {
+		return nil, fmt.Errorf("adts sample rate %v not support", config.SampleRate)
+	}
+	if config.ObjectType <= 0 || config.ObjectType > 4 {
+		return nil, fmt.Errorf("adts objectType %v not support", config.ObjectType)
+	}
+	size := 0
+	for _, unit := range units {
+		size += ADTSHeaderLength + len(unit)
+	}
+	data := make([]byte, 0, size)
+	for _, unit := range units {
+		length := ADTSHeaderLength + len(unit)
+		if length >= 1<<13 {
+			return nil, fmt.Errorf("adts frame size %v too large", length)
+		}
+		data = append(data,
+			0xff, 0xf1, // syncword,mpeg4,layer 0,no crc
+			byte((config.ObjectType-1)<<6|frequencyIndex<<2|config.ChannelCount>>2),
+			byte(config.ChannelCount&3<<6|length>>11),
+			byte(length>>3),
+			byte(length&7<<5|0x1f), // buffer fullness 0x7ff,variable rate
+			0xfc)                   // one raw data block
+		data = append(data, unit...)
+	}
+	return data, nil
+}
+
+//ParseADTS split adts stream into raw AAC frames,config is from the first header
+func ParseADTS(data []byte) (*AudioSpecificConfig, [][]byte, error) {
+	var config *AudioSpecificConfig
+	units := make([][]byte, 0, 1)
+	for len(data) > 0 {
+		if len(data) < ADTSHeaderLength || data[0] != 0xff || data[1]&0xf0 != 0xf0 {
+			return nil, nil, fmt.Errorf("adts syncword not found")
+		}
+		headerLength := ADTSHeaderLength
+		if data[1]&1 == 0 {
+			headerLength += 2 // crc
+		}
+		length := int(data[3]&3)<<11 | int(data[4])<<3 | int(data[5]>>5)
+		if length < headerLength || length > len(data) {
+			return nil, nil, fmt.Errorf("adts frame size %v out of range", length)
+		}
+		if config == nil {
+			frequencyIndex := int(data[2] >> 2 & 0xf)
+			if frequencyIndex >= len(SampleRates) {
+				return nil, nil, fmt.Errorf("adts samplingFrequencyIndex %v invalid", frequencyIndex)
+			}
+			config = &AudioSpecificConfig{
+				ObjectType:   int(data[2]>>6) + 1,
+				SampleRate:   SampleRates[frequencyIndex],
+				ChannelCount: int(data[2]&1)<<2 | int(data[3]>>6),
+				FrameLength:  1024,
+			}
+		}
+		units = append(units, data[headerLength:length])
+		data = data[length:]
+	}
+	if config == nil {
+		return nil, nil, fmt.Errorf("adts has no frame")
+	}
+	return config, units, nil
+}
@@ -0,0 +1,110 @@
+package aac
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/bits"
+)
+
+//ObjectTypeAACLC mpeg4 audio object type of AAC low complexity
+const ObjectTypeAACLC = 2
+
+//SampleRates sample rates indexed by samplingFrequencyIndex(ISO 14496-3 1.6.3.4)
+var SampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+//AudioSpecificConfig mpeg4 audio config(ISO 14496-3 1.6.2.1),
+//only GASpecificConfig fields needed by depacketizer and muxers are kept
+type AudioSpecificConfig struct {
+	ObjectType   int // audio object type,2 for AAC-LC
+	SampleRate   int // samples per second
+	ChannelCount int // channelConfiguration
+	FrameLength  int // samples per access unit,1024 or 960
+}
+
+//Unmarshal parse AudioSpecificConfig bytes
+func (config *AudioSpecificConfig) Unmarshal(data []byte) error {
+	reader := bits.NewReader(data)
+	objectType, err := reader.ReadBits(5)
+	if err != nil {
+		return fmt.Errorf("AudioSpecificConfig objectType error:%v", err)
+	}
+	if objectType == 31 {
+		extension, err := reader.ReadBits(6)
+		if err != nil {
+			return fmt.Errorf("AudioSpecificConfig objectType error:%v", err)
+		}
+		objectType = 32 + extension
+	}
+	config.ObjectType = int(objectType)
+	frequencyIndex, err := reader.ReadBits(4)
+	if err != nil {
+		return fmt.Errorf("AudioSpecificConfig samplingFrequencyIndex error:%v", err)
+	}
+	switch {
+	case frequencyIndex == 15:
+		sampleRate, err := reader.ReadBits(24)
+		if err != nil {
+			return fmt.Errorf("AudioSpecificConfig samplingFrequency error:%v", err)
+		}
+		config.SampleRate = int(sampleRate)
+	case int(frequencyIndex) < len(SampleRates):
+		config.SampleRate = SampleRates[frequencyIndex]
+	default:
+		return fmt.Errorf("AudioSpecificConfig samplingFrequencyIndex %v invalid", frequencyIndex)
+	}
+	channelCount, err := reader.ReadBits(4)
+	if err != nil {
+		return fmt.Errorf("AudioSpecificConfig channelConfiguration error:%v", err)
+	}
+	config.ChannelCount = int(channelCount)
+	config.FrameLength = 1024
+	// frameLengthFlag of GASpecificConfig
+	if frameLengthFlag, err := reader.ReadBits(1); err == nil && frameLengthFlag == 1 {
+		config.FrameLength = 960
+	}
+	return nil
+}
+
+//Marshal make AudioSpecificConfig bytes
+func (config *AudioSpecificConfig) Marshal() ([]byte, error) {
+	if config.ObjectType <= 0 || config.ObjectType >= 31 {
+		return nil, fmt.Errorf("AudioSpecificConfig objectType %v not support", config.ObjectType)
+	}
+	frequencyIndex := -1
+	for index, sampleRate := range SampleRates {
+		if sampleRate == config.SampleRate {
+			frequencyIndex = index
+		}
+	}
+	if frequencyIndex < 0 {
+		return nil, fmt.Errorf("AudioSpecificConfig sample rate %v not support", config.SampleRate)
+	}
+	if config.ChannelCount <= 0 || config.ChannelCount > 7 {
+		return nil, fmt.Errorf("AudioSpecificConfig channel count %v not support", config.ChannelCount)
+	}
+	frameLengthFlag := 0
+	if config.FrameLength == 960 {
+		frameLengthFlag = 1
+	}
+	return []byte{
+		byte(config.ObjectType<<3 | frequencyIndex>>1),
+		byte(frequencyIndex&1<<7 | config.ChannelCount<<3 | frameLengthFlag<<2),
+	}, nil
+}
+
+//ParseConfig parse hex AudioSpecificConfig from config parameter of fmtp
+func ParseConfig(value string) (*AudioSpecificConfig, []byte, error) {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config %v invalid:%v", value, err)
+	}
+	config := new(AudioSpecificConfig)
+	if err := config.Unmarshal(data); err != nil {
+		return nil, nil, err
+	}
+	return config, data, nil
+}
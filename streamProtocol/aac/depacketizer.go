@@ -0,0 +1,134 @@
+package aac
+
+import (
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/bits"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//MaxAccessUnitSize max size of an access unit
+const MaxAccessUnitSize = 1 << 16
+
+//AccessUnit one aac frame
+type AccessUnit struct {
+	Data      []byte // raw aac frame without adts header
+	Timestamp uint32 // rtp timestamp
+}
+
+//Depacketizer get access units from mpeg4-generic rtp packets(rfc3640),
+//AU-headers are described by sizelength,indexlength and indexdeltalength
+type Depacketizer struct {
+	SizeLength       int // bits of AU-size
+	IndexLength      int // bits of AU-Index
+	IndexDeltaLength int // bits of AU-Index-delta
+	FrameLength      int // samples of an access unit,timestamp step of AUs in a packet
+	fragments        []byte
+	fragmentSize     int
+	fragmenting      bool
+	timestamp        uint32
+	lastSequence     uint16
+	sequenceValid    bool
+}
+
+//NewDepacketizer make a mpeg4-generic depacketizer
+func NewDepacketizer(sizeLength, indexLength, indexDeltaLength, frameLength int) *Depacketizer {
+	if frameLength <= 0 {
+		frameLength = 1024
+	}
+	return &Depacketizer{
+		SizeLength:       sizeLength,
+		IndexLength:      indexLength,
+		IndexDeltaLength: indexDeltaLength,
+		FrameLength:      frameLength,
+	}
+}
+
+//Decode put a rtp packet into depacketizer,return access units in it,
+//a fragmented access unit is returned with its last fragment
+func (depacketizer *Depacketizer) Decode(packet *rtp.Packet) ([]*AccessUnit, error) {
+	if depacketizer.sequenceValid && depacketizer.fragmenting &&
+		(packet.SequenceNumber != depacketizer.lastSequence+1 ||
+			packet.Timestamp != depacketizer.timestamp) {
+		// fragment lost,drop the incomplete access unit
+		depacketizer.fragmenting = false
+		depacketizer.fragments = nil
+	}
+	depacketizer.lastSequence = packet.SequenceNumber
+	depacketizer.sequenceValid = true
+	if depacketizer.SizeLength <= 0 {
+		return nil, fmt.Errorf("sizelength %v not support", depacketizer.SizeLength)
+	}
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("mpeg4-generic rtp payload too short")
+	}
+	headersLength := int(payload[0])<<8 | int(payload[1])
+	headersBytes := (headersLength + 7) / 8
+	if 2+headersBytes > len(payload) {
+		return nil, fmt.Errorf("AU-headers-length %v out of range", headersLength)
+	}
+	reader := bits.NewReader(payload[2 : 2+headersBytes])
+	sizes := make([]int, 0, 1)
+	for reader.Offset() < headersLength {
+		size, err := reader.ReadBits(depacketizer.SizeLength)
+		if err != nil {
+			return nil, fmt.Errorf("AU-size error:%v", err)
+		}
+		indexLength := depacketizer.IndexDeltaLength
+		if len(sizes) == 0 {
+			indexLength = depacketizer.IndexLength
+		}
+		if _, err := reader.ReadBits(indexLength); err != nil {
+			return nil, fmt.Errorf("AU-Index error:%v", err)
+		}
+		sizes = append(sizes, int(size))
+	}
+	data := payload[2+headersBytes:]
+	if depacketizer.fragmenting || (len(sizes) == 1 && sizes[0] > len(data)) {
+		return depacketizer.defragment(packet, sizes, data)
+	}
+	accessUnits := make([]*AccessUnit, 0, len(sizes))
+	for index, size := range sizes {
+		if size > len(data) {
+			return accessUnits, fmt.Errorf("AU-size %v out of range", size)
+		}
+		accessUnits = append(accessUnits, &AccessUnit{
+			Data:      append([]byte(nil), data[:size]...),
+			Timestamp: packet.Timestamp + uint32(index*depacketizer.FrameLength),
+		})
+		data = data[size:]
+	}
+	return accessUnits, nil
+}
+
+//defragment collect fragments of an access unit(rfc3640 3.2.3)
+func (depacketizer *Depacketizer) defragment(packet *rtp.Packet,
+	sizes []int, data []byte) ([]*AccessUnit, error) {
+	if len(sizes) != 1 {
+		depacketizer.fragmenting = false
+		depacketizer.fragments = nil
+		return nil, fmt.Errorf("fragment packet has %v AU-headers", len(sizes))
+	}
+	if !depacketizer.fragmenting {
+		if sizes[0] > MaxAccessUnitSize {
+			return nil, fmt.Errorf("AU-size %v exceeds %v", sizes[0], MaxAccessUnitSize)
+		}
+		depacketizer.fragments = make([]byte, 0, sizes[0])
+		depacketizer.fragmentSize = sizes[0]
+		depacketizer.fragmenting = true
+		depacketizer.timestamp = packet.Timestamp
+	}
+	depacketizer.fragments = append(depacketizer.fragments, data...)
+	if !packet.Marker && len(depacketizer.fragments) < depacketizer.fragmentSize {
+		return nil, nil
+	}
+	depacketizer.fragmenting = false
+	fragments := depacketizer.fragments
+	depacketizer.fragments = nil
+	if len(fragments) != depacketizer.fragmentSize {
+		return nil, fmt.Errorf("fragmented AU size %v, want %v",
+			len(fragments), depacketizer.fragmentSize)
+	}
+	return []*AccessUnit{{Data: fragments, Timestamp: depacketizer.timestamp}}, nil
+}
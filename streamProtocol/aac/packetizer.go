@@ -0,0 +1,77 @@
+package aac
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//DefaultMTU default max size of rtp packet
+const DefaultMTU = 1400
+
+//Packetizer put access units into mpeg4-generic rtp packets in AAC-hbr mode
+//(sizelength=13,indexlength=3,indexdeltalength=3),one access unit per packet,
+//large ones fragmented
+type Packetizer struct {
+	MTU            int    // max size of rtp packet including header
+	PayloadType    uint8  // rtp payload type
+	SSRC           uint32 // rtp ssrc
+	SequenceNumber uint16 // sequence number of next packet
+}
+
+//NewPacketizer make a mpeg4-generic packetizer with random ssrc and sequence number
+func NewPacketizer(mtu int, payloadType uint8, ssrc uint32) *Packetizer {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	if ssrc == 0 {
+		ssrc = rand.Uint32()
+	}
+	return &Packetizer{
+		MTU:            mtu,
+		PayloadType:    payloadType,
+		SSRC:           ssrc,
+		SequenceNumber: uint16(rand.Uint32()),
+	}
+}
+
+//Packetize put an access unit into rtp packets,marker bit set on the last one
+func (packetizer *Packetizer) Packetize(data []byte, timestamp uint32) ([]*rtp.Packet, error) {
+	if len(data) >= 1<<13 {
+		return nil, fmt.Errorf("access unit size %v exceeds sizelength 13", len(data))
+	}
+	maxFragment := packetizer.MTU - rtp.HeaderLength - 4
+	if maxFragment <= 0 {
+		return nil, fmt.Errorf("mtu %v too small", packetizer.MTU)
+	}
+	packets := make([]*rtp.Packet, 0, len(data)/maxFragment+1)
+	for offset := 0; offset < len(data) || offset == 0; offset += maxFragment {
+		end := offset + maxFragment
+		if end > len(data) {
+			end = len(data)
+		}
+		// AU-headers-length 16 bits,AU-size 13 bits,AU-Index 3 bits
+		payload := make([]byte, 4+end-offset)
+		payload[1] = 16
+		payload[2] = byte(len(data) >> 5)
+		payload[3] = byte(len(data) << 3)
+		copy(payload[4:], data[offset:end])
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        rtp.Version,
+				Marker:         end == len(data),
+				PayloadType:    packetizer.PayloadType,
+				SequenceNumber: packetizer.SequenceNumber,
+				Timestamp:      timestamp,
+				SSRC:           packetizer.SSRC,
+			},
+			Payload: payload,
+		})
+		packetizer.SequenceNumber++
+		if end == len(data) {
+			break
+		}
+	}
+	return packets, nil
+}
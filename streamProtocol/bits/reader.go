@@ -0,0 +1,107 @@
+package bits
+
+import "fmt"
+
+//Reader read big endian bits and exp-golomb codes
+type Reader struct {
+	data   []byte
+	offset int // in bits
+}
+
+//NewReader make a bit reader of data
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+//Offset bits already read
+func (reader *Reader) Offset() int {
+	return reader.offset
+}
+
+//Left bits not read
+func (reader *Reader) Left() int {
+	return len(reader.data)*8 - reader.offset
+}
+
+//ReadBits read count(at most 64) bits as unsigned integer
+func (reader *Reader) ReadBits(count int) (uint64, error) {
+	if count > 64 || count > reader.Left() {
+		return 0, fmt.Errorf("read %v bits out of range", count)
+	}
+	var value uint64
+	for i := 0; i < count; i++ {
+		bit := reader.data[reader.offset/8] >> (7 - uint(reader.offset%8)) & 1
+		value = value<<1 | uint64(bit)
+		reader.offset++
+	}
+	return value, nil
+}
+
+//ReadFlag read one bit as bool
+func (reader *Reader) ReadFlag() (bool, error) {
+	bit, err := reader.ReadBits(1)
+	return bit == 1, err
+}
+
+//Skip skip count bits
+func (reader *Reader) Skip(count int) error {
+	if count > reader.Left() {
+		return fmt.Errorf("skip %v bits out of range", count)
+	}
+	reader.offset += count
+	return nil
+}
+
+//ReadUE read unsigned exp-golomb code ue(v)
+func (reader *Reader) ReadUE() (uint32, error) {
+	leadingZeros := 0
+	for {
+		bit, err := reader.ReadBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, fmt.Errorf("exp-golomb code too long")
+		}
+	}
+	value, err := reader.ReadBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(1<<uint(leadingZeros) - 1 + value), nil
+}
+
+//ReadSE read signed exp-golomb code se(v)
+func (reader *Reader) ReadSE() (int32, error) {
+	value, err := reader.ReadUE()
+	if err != nil {
+		return 0, err
+	}
+	if value%2 == 1 {
+		return int32(value/2 + 1), nil
+	}
+	return -int32(value / 2), nil
+}
+
+//RemoveEmulationPrevention remove emulation prevention bytes(0x000003) of nal unit
+func RemoveEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, value := range nalu {
+		if zeros >= 2 && value == 0x03 {
+			zeros = 0
+			continue
+		}
+		if value == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, value)
+	}
+	return rbsp
+}
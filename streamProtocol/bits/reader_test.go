@@ -0,0 +1,32 @@
+package bits
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	// 1 | 010 | 011 | 00100 | 1011...
+	reader := NewReader([]byte{0xa6, 0x4b})
+	if flag, err := reader.ReadFlag(); err != nil || !flag {
+		t.Errorf("ReadFlag = %v,%v", flag, err)
+	}
+	if value, err := reader.ReadUE(); err != nil || value != 1 {
+		t.Errorf("ReadUE = %v,%v, want 1", value, err)
+	}
+	if value, err := reader.ReadSE(); err != nil || value != -1 {
+		t.Errorf("ReadSE = %v,%v, want -1", value, err)
+	}
+	if value, err := reader.ReadUE(); err != nil || value != 3 {
+		t.Errorf("ReadUE = %v,%v, want 3", value, err)
+	}
+	if value, err := reader.ReadBits(4); err != nil || value != 0xb || reader.Left() != 0 {
+		t.Errorf("ReadBits = %v,%v", value, err)
+	}
+	if _, err := reader.ReadBits(1); err == nil {
+		t.Errorf("ReadBits out of range should fail")
+	}
+	if rbsp := RemoveEmulationPrevention([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03}); !bytes.Equal(rbsp, []byte{0x00, 0x00, 0x01, 0x00, 0x00}) {
+		t.Errorf("RemoveEmulationPrevention = %x", rbsp)
+	}
+}
@@ -0,0 +1,116 @@
+package dtls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//CertificateValidity validity of generated certificates
+var CertificateValidity = 365 * 24 * time.Hour
+
+//Certificate a self-signed ecdsa certificate,peers are authenticated
+//by certificate fingerprints in sdp instead of certificate chains
+type Certificate struct {
+	DER        []byte            // x509 certificate in der
+	PrivateKey *ecdsa.PrivateKey // p-256 private key of certificate
+}
+
+//GenerateCertificate make a self-signed certificate of a new p-256 key
+func GenerateCertificate() (*Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ecdsa.GenerateKey error:%v", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("rand error:%v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: "streamProtocol"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CertificateValidity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate error:%v", err)
+	}
+	return &Certificate{DER: der, PrivateKey: privateKey}, nil
+}
+
+//Fingerprint sha-256 fingerprint of certificate as in sdp fingerprint attribute
+func (certificate *Certificate) Fingerprint() string {
+	return Fingerprint(certificate.DER)
+}
+
+//Fingerprint sha-256 fingerprint of der certificate,upper case hex bytes
+//separated by colons(rfc8122 5)
+func Fingerprint(der []byte) string {
+	digest := sha256.Sum256(der)
+	hexBytes := make([]string, len(digest))
+	for index, value := range digest {
+		hexBytes[index] = fmt.Sprintf("%02X", value)
+	}
+	return strings.Join(hexBytes, ":")
+}
+
+//ecdsaSignature asn.1 structure of ecdsa signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+//sign sign sha-256 digest of data with ecdsa key
+func (certificate *Certificate) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, certificate.PrivateKey, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("ecdsa.Sign error:%v", err)
+	}
+	return asn1.Marshal(ecdsaSignature{R: r, S: s})
+}
+
+//verifySignature verify signature of data by public key of der certificate
+func verifySignature(der []byte, algorithm uint16, data, signature []byte) error {
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("x509.ParseCertificate error:%v", err)
+	}
+	digest := sha256.Sum256(data)
+	switch publicKey := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm != signatureECDSASHA256 {
+			break
+		}
+		parsed := new(ecdsaSignature)
+		if _, err := asn1.Unmarshal(signature, parsed); err != nil {
+			return fmt.Errorf("ecdsa signature invalid:%v", err)
+		}
+		if !ecdsa.Verify(publicKey, digest[:], parsed.R, parsed.S) {
+			return fmt.Errorf("ecdsa signature verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if algorithm != signatureRSASHA256 {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("rsa signature verification failed:%v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("signature algorithm %#x not support for %T",
+		algorithm, certificate.PublicKey)
+}
@@ -0,0 +1,853 @@
+package dtls
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/srtp"
+)
+
+// default values are used by Conn
+var (
+	//MTU max size of datagrams of handshake flights
+	MTU = 1200
+	//RetransmitInterval timeout before a flight is resent,doubled at each time
+	RetransmitInterval = time.Second
+	//HandshakeTimeout max duration of handshake
+	HandshakeTimeout = 30 * time.Second
+)
+
+//alert levels and descriptions(rfc5246 7.2)
+const (
+	alertLevelWarning      uint8 = 1
+	alertLevelFatal        uint8 = 2
+	alertCloseNotify       uint8 = 0
+	alertUnexpectedMessage uint8 = 10
+	alertHandshakeFailure  uint8 = 40
+	alertBadCertificate    uint8 = 42
+	alertIllegalParameter  uint8 = 47
+	alertDecryptError      uint8 = 51
+	alertInternalError     uint8 = 80
+	maxPendingRecords            = 32 // records of next epoch kept before keys are ready
+	maxPendingMessages           = 16 // handshake messages received ahead
+	readBufferSize               = 8192
+)
+
+//Transport datagram transport of records,each Read returns one datagram,
+//a connected net.UDPConn meets it
+type Transport interface {
+	Read(data []byte) (int, error)
+	Write(data []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+}
+
+//Config config of Conn
+type Config struct {
+	Certificate           *Certificate             // local certificate,required
+	SRTPProfiles          []srtp.ProtectionProfile // use_srtp profiles in preference order,empty if srtp is not used
+	VerifyPeerCertificate func(der []byte) error   // check peer certificate like its fingerprint,a certificate is required if set
+}
+
+/*
+Conn a dtls 1.2 connection(rfc6347) for keying srtp(rfc5764),
+handshake is ECDHE-ECDSA-AES128-GCM-SHA256 with certificates of both ends,
+application data is not supported,flights are resent with timeout doubled
+until HandshakeTimeout,cookie exchange is only supported as client and
+renegotiation is not supported
+*/
+type Conn struct {
+	transport            Transport
+	config               *Config
+	isClient             bool
+	buffer               []byte
+	readCipher           *cipherState // nil before keys of peer's epoch 1 are ready
+	writeCipher          *cipherState
+	writeSequences       [2]uint64 // sequence numbers of the next record by epoch
+	writeMutex           sync.Mutex
+	pendingRecords       []*record // records of epoch 1 arrived before keys
+	fragments            map[uint16]*fragmentBuffer
+	nextReceiveSequence  uint16
+	nextSendSequence     uint16
+	transcript           []byte       // handshake messages for verification
+	flight               []flightItem // the last flight sent
+	peerRetransmitted    bool         // if peer resent a flight we have received
+	clientRandom         []byte
+	serverRandom         []byte
+	masterSecret         []byte
+	keys                 *keyBlock
+	extendedMasterSecret bool
+	srtpProfile          srtp.ProtectionProfile
+	peerCertificate      []byte
+	handshakeComplete    bool
+}
+
+//flightItem a handshake message or change cipher spec of a flight
+type flightItem struct {
+	epoch   uint16
+	message *handshakeMessage // nil for change cipher spec
+}
+
+//Server make a connection doing handshake as server
+func Server(transport Transport, config *Config) *Conn {
+	return newConn(transport, config, false)
+}
+
+//Client make a connection doing handshake as client
+func Client(transport Transport, config *Config) *Conn {
+	return newConn(transport, config, true)
+}
+
+func newConn(transport Transport, config *Config, isClient bool) *Conn {
+	return &Conn{
+		transport: transport,
+		config:    config,
+		isClient:  isClient,
+		buffer:    make([]byte, readBufferSize),
+		fragments: make(map[uint16]*fragmentBuffer),
+	}
+}
+
+//Handshake do handshake,the last flight is resent in Serve if it is lost
+func (conn *Conn) Handshake() error {
+	if conn.config == nil || conn.config.Certificate == nil {
+		return fmt.Errorf("dtls certificate not set")
+	}
+	deadline := time.Now().Add(HandshakeTimeout)
+	var err error
+	if conn.isClient {
+		err = conn.clientHandshake(deadline)
+	} else {
+		err = conn.serverHandshake(deadline)
+	}
+	conn.transport.SetReadDeadline(time.Time{})
+	if err != nil {
+		return fmt.Errorf("dtls handshake error:%v", err)
+	}
+	conn.handshakeComplete = true
+	return nil
+}
+
+//serverHandshake handshake as server,flights are
+//ClientHello;ServerHello,Certificate,ServerKeyExchange,CertificateRequest,ServerHelloDone;
+//Certificate,ClientKeyExchange,CertificateVerify,ChangeCipherSpec,Finished;
+//ChangeCipherSpec,Finished
+func (conn *Conn) serverHandshake(deadline time.Time) error {
+	message, err := conn.expect(deadline, typeClientHello)
+	if err != nil {
+		return err
+	}
+	hello := new(clientHello)
+	if err := hello.unmarshal(message.body); err != nil {
+		return conn.fail(alertIllegalParameter, err)
+	}
+	supported, secureRenegotiation := false, false
+	for _, suite := range hello.cipherSuites {
+		supported = supported || suite == cipherSuiteECDHEECDSAAES128GCMSHA256
+		secureRenegotiation = secureRenegotiation || suite == cipherSuiteRenegotiationInfoSCSV
+	}
+	if !supported {
+		return conn.fail(alertHandshakeFailure, fmt.Errorf("no cipher suite supported"))
+	}
+	if value, ok := hello.extensions[extensionSupportedGroups]; ok {
+		groups, err := uint16List(value)
+		if err != nil {
+			return conn.fail(alertIllegalParameter, err)
+		}
+		supported = false
+		for _, group := range groups {
+			supported = supported || group == curveP256
+		}
+		if !supported {
+			return conn.fail(alertHandshakeFailure, fmt.Errorf("curve p-256 not offered"))
+		}
+	}
+	extensions := extensions{extensionPointFormats: {1, 0}}
+	if len(conn.config.SRTPProfiles) > 0 {
+		profiles, err := uint16List(hello.extensions[extensionUseSRTP])
+		if err != nil {
+			return conn.fail(alertHandshakeFailure, fmt.Errorf("use_srtp not offered"))
+		}
+		conn.srtpProfile = selectProfile(conn.config.SRTPProfiles, profiles)
+		if conn.srtpProfile == 0 {
+			return conn.fail(alertHandshakeFailure, fmt.Errorf("no srtp profile supported"))
+		}
+		extensions[extensionUseSRTP] = marshalSRTPExtension(uint16(conn.srtpProfile))
+	}
+	if _, ok := hello.extensions[extensionExtendedMasterSecret]; ok {
+		conn.extendedMasterSecret = true
+		extensions[extensionExtendedMasterSecret] = []byte{}
+	}
+	if _, ok := hello.extensions[extensionRenegotiationInfo]; ok || secureRenegotiation {
+		// peers like openssl 3 refuse servers without it
+		extensions[extensionRenegotiationInfo] = []byte{0}
+	}
+	conn.clientRandom = append([]byte{}, hello.random...)
+	if conn.serverRandom, err = randomBytes(randomLength); err != nil {
+		return err
+	}
+	privateKey, publicKey, err := generateECDHE()
+	if err != nil {
+		return err
+	}
+	params := appendVector([]byte{3, byte(curveP256 >> 8), byte(curveP256)}, 1, publicKey)
+	signature, err := conn.config.Certificate.sign(
+		concat(conn.clientRandom, conn.serverRandom, params))
+	if err != nil {
+		return err
+	}
+	certificateRequest := appendVector(nil, 1,
+		[]byte{certificateTypeECDSASign, certificateTypeRSASign})
+	certificateRequest = append(certificateRequest,
+		marshalUint16List(signatureECDSASHA256, signatureRSASHA256)...)
+	certificateRequest = appendVector(certificateRequest, 2, nil)
+	if err := conn.sendFlight(
+		conn.newMessage(0, typeServerHello, (&serverHello{
+			random:      conn.serverRandom,
+			cipherSuite: cipherSuiteECDHEECDSAAES128GCMSHA256,
+			extensions:  extensions,
+		}).marshal()),
+		conn.newMessage(0, typeCertificate, marshalCertificates(conn.config.Certificate.DER)),
+		conn.newMessage(0, typeServerKeyExchange,
+			append(params, marshalSignature(signatureECDSASHA256, signature)...)),
+		conn.newMessage(0, typeCertificateRequest, certificateRequest),
+		conn.newMessage(0, typeServerHelloDone, nil)); err != nil {
+		return err
+	}
+	if message, err = conn.expect(deadline, typeCertificate); err != nil {
+		return err
+	}
+	if err := conn.setPeerCertificate(message.body); err != nil {
+		return err
+	}
+	if message, err = conn.expect(deadline, typeClientKeyExchange); err != nil {
+		return err
+	}
+	peerKey := (&parser{data: message.body}).vector(1)
+	preMasterSecret, err := sharedSecret(privateKey, peerKey)
+	if err != nil {
+		return conn.fail(alertIllegalParameter, err)
+	}
+	conn.deriveKeys(preMasterSecret)
+	if err := conn.setCiphers(); err != nil {
+		return err
+	}
+	if conn.peerCertificate != nil {
+		if message, err = conn.expect(deadline, typeCertificateVerify); err != nil {
+			return err
+		}
+		if err := conn.verifyCertificate(message); err != nil {
+			return err
+		}
+	}
+	if message, err = conn.expect(deadline, typeFinished); err != nil {
+		return err
+	}
+	if !hmac.Equal(message.body, verifyData(conn.masterSecret, labelClientFinished,
+		conn.transcript[:message.offset])) {
+		return conn.fail(alertDecryptError, fmt.Errorf("client Finished mismatch"))
+	}
+	return conn.sendFlight(flightItem{epoch: 0},
+		conn.newMessage(1, typeFinished,
+			verifyData(conn.masterSecret, labelServerFinished, conn.transcript)))
+}
+
+//clientHandshake handshake as client,see serverHandshake for flights
+func (conn *Conn) clientHandshake(deadline time.Time) error {
+	var err error
+	if conn.clientRandom, err = randomBytes(randomLength); err != nil {
+		return err
+	}
+	extensions := extensions{
+		extensionSupportedGroups:      marshalUint16List(curveP256),
+		extensionPointFormats:         {1, 0},
+		extensionSignatureAlgorithms:  marshalUint16List(signatureECDSASHA256, signatureRSASHA256),
+		extensionExtendedMasterSecret: {},
+		extensionRenegotiationInfo:    {0},
+	}
+	if len(conn.config.SRTPProfiles) > 0 {
+		profiles := make([]uint16, len(conn.config.SRTPProfiles))
+		for index, profile := range conn.config.SRTPProfiles {
+			profiles[index] = uint16(profile)
+		}
+		extensions[extensionUseSRTP] = marshalSRTPExtension(profiles...)
+	}
+	offer := &clientHello{
+		random:       conn.clientRandom,
+		cipherSuites: []uint16{cipherSuiteECDHEECDSAAES128GCMSHA256},
+		extensions:   extensions,
+	}
+	if err := conn.sendFlight(conn.newMessage(0, typeClientHello, offer.marshal())); err != nil {
+		return err
+	}
+	message, err := conn.readMessage(deadline)
+	if err != nil {
+		return err
+	}
+	if message.messageType == typeHelloVerifyRequest {
+		// ClientHello is resent with cookie,both are not in transcript(rfc6347 4.2.1)
+		verifyRequest := &parser{data: message.body}
+		verifyRequest.uint16()
+		if offer.cookie = verifyRequest.vector(1); verifyRequest.err != nil {
+			return conn.fail(alertIllegalParameter, fmt.Errorf("HelloVerifyRequest invalid"))
+		}
+		conn.transcript = conn.transcript[:0]
+		if err := conn.sendFlight(conn.newMessage(0, typeClientHello, offer.marshal())); err != nil {
+			return err
+		}
+		if message, err = conn.readMessage(deadline); err != nil {
+			return err
+		}
+	}
+	if message.messageType != typeServerHello {
+		return conn.fail(alertUnexpectedMessage, fmt.Errorf(
+			"handshake message %v received,%v expected", message.messageType, typeServerHello))
+	}
+	hello := new(serverHello)
+	if err := hello.unmarshal(message.body); err != nil {
+		return conn.fail(alertIllegalParameter, err)
+	}
+	if hello.cipherSuite != cipherSuiteECDHEECDSAAES128GCMSHA256 {
+		return conn.fail(alertIllegalParameter,
+			fmt.Errorf("cipher suite %#x not offered", hello.cipherSuite))
+	}
+	conn.serverRandom = hello.random
+	_, conn.extendedMasterSecret = hello.extensions[extensionExtendedMasterSecret]
+	if len(conn.config.SRTPProfiles) > 0 {
+		profiles, err := uint16List(hello.extensions[extensionUseSRTP])
+		if err != nil || len(profiles) != 1 {
+			return conn.fail(alertHandshakeFailure, fmt.Errorf("use_srtp not selected"))
+		}
+		if conn.srtpProfile = selectProfile(conn.config.SRTPProfiles, profiles); conn.srtpProfile == 0 {
+			return conn.fail(alertIllegalParameter,
+				fmt.Errorf("srtp profile %#x not offered", profiles[0]))
+		}
+	}
+	if message, err = conn.expect(deadline, typeCertificate); err != nil {
+		return err
+	}
+	if err := conn.setPeerCertificate(message.body); err != nil {
+		return err
+	}
+	if conn.peerCertificate == nil {
+		return conn.fail(alertBadCertificate, fmt.Errorf("server certificate absent"))
+	}
+	if message, err = conn.expect(deadline, typeServerKeyExchange); err != nil {
+		return err
+	}
+	keyExchange := &parser{data: message.body}
+	curveType, curve, peerKey := keyExchange.uint8(), keyExchange.uint16(), keyExchange.vector(1)
+	params := message.body[:len(message.body)-len(keyExchange.data)]
+	algorithm, signature, err := parseSignature(keyExchange)
+	if err != nil || curveType != 3 || curve != curveP256 {
+		return conn.fail(alertIllegalParameter, fmt.Errorf("ServerKeyExchange invalid"))
+	}
+	if err := verifySignature(conn.peerCertificate, algorithm,
+		concat(conn.clientRandom, conn.serverRandom, params), signature); err != nil {
+		return conn.fail(alertDecryptError, err)
+	}
+	if message, err = conn.readMessage(deadline); err != nil {
+		return err
+	}
+	certificateRequested := message.messageType == typeCertificateRequest
+	if certificateRequested {
+		if message, err = conn.readMessage(deadline); err != nil {
+			return err
+		}
+	}
+	if message.messageType != typeServerHelloDone {
+		return conn.fail(alertUnexpectedMessage,
+			fmt.Errorf("unexpected handshake message %v", message.messageType))
+	}
+	privateKey, publicKey, err := generateECDHE()
+	if err != nil {
+		return err
+	}
+	preMasterSecret, err := sharedSecret(privateKey, peerKey)
+	if err != nil {
+		return conn.fail(alertIllegalParameter, err)
+	}
+	items := make([]flightItem, 0, 5)
+	if certificateRequested {
+		items = append(items, conn.newMessage(0, typeCertificate,
+			marshalCertificates(conn.config.Certificate.DER)))
+	}
+	items = append(items, conn.newMessage(0, typeClientKeyExchange,
+		appendVector(nil, 1, publicKey)))
+	conn.deriveKeys(preMasterSecret)
+	if certificateRequested {
+		signature, err := conn.config.Certificate.sign(conn.transcript)
+		if err != nil {
+			return err
+		}
+		items = append(items, conn.newMessage(0, typeCertificateVerify,
+			marshalSignature(signatureECDSASHA256, signature)))
+	}
+	items = append(items, flightItem{epoch: 0}, conn.newMessage(1, typeFinished,
+		verifyData(conn.masterSecret, labelClientFinished, conn.transcript)))
+	if err := conn.setCiphers(); err != nil {
+		return err
+	}
+	if err := conn.sendFlight(items...); err != nil {
+		return err
+	}
+	if message, err = conn.expect(deadline, typeFinished); err != nil {
+		return err
+	}
+	if !hmac.Equal(message.body, verifyData(conn.masterSecret, labelServerFinished,
+		conn.transcript[:message.offset])) {
+		return conn.fail(alertDecryptError, fmt.Errorf("server Finished mismatch"))
+	}
+	return nil
+}
+
+//selectProfile the first local profile in peer's profiles,0 if none
+func selectProfile(local []srtp.ProtectionProfile, peer []uint16) srtp.ProtectionProfile {
+	for _, profile := range local {
+		for _, value := range peer {
+			if uint16(profile) == value {
+				return profile
+			}
+		}
+	}
+	return 0
+}
+
+//setPeerCertificate keep the first certificate of Certificate message and verify it
+func (conn *Conn) setPeerCertificate(body []byte) error {
+	certificates, err := parseCertificates(body)
+	if err != nil {
+		return conn.fail(alertBadCertificate, err)
+	}
+	if len(certificates) == 0 {
+		if conn.config.VerifyPeerCertificate != nil {
+			return conn.fail(alertBadCertificate, fmt.Errorf("peer certificate absent"))
+		}
+		return nil
+	}
+	if conn.config.VerifyPeerCertificate != nil {
+		if err := conn.config.VerifyPeerCertificate(certificates[0]); err != nil {
+			return conn.fail(alertBadCertificate, err)
+		}
+	}
+	conn.peerCertificate = certificates[0]
+	return nil
+}
+
+//verifyCertificate verify CertificateVerify by peer certificate
+func (conn *Conn) verifyCertificate(message *handshakeMessage) error {
+	algorithm, signature, err := parseSignature(&parser{data: message.body})
+	if err != nil {
+		return conn.fail(alertIllegalParameter, err)
+	}
+	if err := verifySignature(conn.peerCertificate, algorithm,
+		conn.transcript[:message.offset], signature); err != nil {
+		return conn.fail(alertDecryptError, err)
+	}
+	return nil
+}
+
+//deriveKeys derive master secret and keys,session hash of extended master
+//secret is the transcript through ClientKeyExchange(rfc7627 4)
+func (conn *Conn) deriveKeys(preMasterSecret []byte) {
+	if conn.extendedMasterSecret {
+		sessionHash := sha256.Sum256(conn.transcript)
+		conn.masterSecret = prf(preMasterSecret, labelExtendedMasterSecret,
+			sessionHash[:], masterSecretLength)
+	} else {
+		conn.masterSecret = prf(preMasterSecret, labelMasterSecret,
+			concat(conn.clientRandom, conn.serverRandom), masterSecretLength)
+	}
+	conn.keys = newKeyBlock(conn.masterSecret, conn.clientRandom, conn.serverRandom)
+}
+
+//setCiphers make ciphers of epoch 1 and process records of it arrived before
+func (conn *Conn) setCiphers() error {
+	readKey, readIV := conn.keys.serverKey, conn.keys.serverIV
+	writeKey, writeIV := conn.keys.clientKey, conn.keys.clientIV
+	if !conn.isClient {
+		readKey, readIV, writeKey, writeIV = writeKey, writeIV, readKey, readIV
+	}
+	writeCipher, err := newCipherState(writeKey, writeIV)
+	if err != nil {
+		return err
+	}
+	conn.writeMutex.Lock()
+	conn.writeCipher = writeCipher
+	conn.writeMutex.Unlock()
+	if conn.readCipher, err = newCipherState(readKey, readIV); err != nil {
+		return err
+	}
+	pending := conn.pendingRecords
+	conn.pendingRecords = nil
+	for _, record := range pending {
+		if err := conn.processRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//newMessage make handshake message of flight in epoch,it's added to transcript
+func (conn *Conn) newMessage(epoch uint16, messageType uint8, body []byte) flightItem {
+	message := &handshakeMessage{
+		messageType: messageType,
+		sequence:    conn.nextSendSequence,
+		body:        body,
+		offset:      len(conn.transcript),
+	}
+	conn.nextSendSequence++
+	conn.transcript = append(conn.transcript, message.marshal()...)
+	return flightItem{epoch: epoch, message: message}
+}
+
+//sendFlight send a new flight
+func (conn *Conn) sendFlight(items ...flightItem) error {
+	conn.flight = items
+	return conn.resendFlight()
+}
+
+//resendFlight send the last flight,records of it are packed into datagrams
+//not larger than MTU,and get new sequence numbers each time
+func (conn *Conn) resendFlight() error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	datagrams := make([][]byte, 0, 2)
+	var datagram []byte
+	add := func(record []byte) {
+		if len(datagram) > 0 && len(datagram)+len(record) > MTU {
+			datagrams = append(datagrams, datagram)
+			datagram = nil
+		}
+		datagram = append(datagram, record...)
+	}
+	for _, item := range conn.flight {
+		if item.message == nil {
+			add(conn.encodeRecord(contentChangeCipherSpec, item.epoch, []byte{1}))
+			continue
+		}
+		overhead := recordHeaderLength + handshakeHeaderLength
+		if item.epoch > 0 {
+			overhead += explicitNonceLength + gcmTagLength
+		}
+		body := item.message.body
+		for offset := 0; offset == 0 || offset < len(body); offset += MTU - overhead {
+			length := len(body) - offset
+			if length > MTU-overhead {
+				length = MTU - overhead
+			}
+			add(conn.encodeRecord(contentHandshake, item.epoch,
+				marshalFragment(item.message, offset, length)))
+		}
+	}
+	if len(datagram) > 0 {
+		datagrams = append(datagrams, datagram)
+	}
+	for _, datagram := range datagrams {
+		if _, err := conn.transport.Write(datagram); err != nil {
+			return fmt.Errorf("dtls write error:%v", err)
+		}
+	}
+	return nil
+}
+
+//encodeRecord make record of epoch with the next sequence number of it,
+//writeMutex should be held
+func (conn *Conn) encodeRecord(contentType uint8, epoch uint16, data []byte) []byte {
+	sequence := conn.writeSequences[epoch]
+	conn.writeSequences[epoch]++
+	if epoch > 0 {
+		data = conn.writeCipher.seal(contentType, epoch, sequence, data)
+	}
+	return append(marshalHeader(contentType, epoch, sequence, len(data)), data...)
+}
+
+//fail send fatal alert and return err
+func (conn *Conn) fail(description uint8, err error) error {
+	conn.sendAlert(alertLevelFatal, description)
+	return err
+}
+
+//sendAlert send alert in the latest epoch
+func (conn *Conn) sendAlert(level, description uint8) error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	epoch := uint16(0)
+	if conn.writeCipher != nil {
+		epoch = 1
+	}
+	_, err := conn.transport.Write(
+		conn.encodeRecord(contentAlert, epoch, []byte{level, description}))
+	return err
+}
+
+//expect read the next handshake message which should be of messageType
+func (conn *Conn) expect(deadline time.Time, messageType uint8) (*handshakeMessage, error) {
+	message, err := conn.readMessage(deadline)
+	if err != nil {
+		return nil, err
+	}
+	if message.messageType != messageType {
+		return nil, conn.fail(alertUnexpectedMessage, fmt.Errorf(
+			"handshake message %v received,%v expected", message.messageType, messageType))
+	}
+	return message, nil
+}
+
+//readMessage read the next handshake message,the last flight is resent if
+//no message in RetransmitInterval or peer resends its flight
+func (conn *Conn) readMessage(deadline time.Time) (*handshakeMessage, error) {
+	interval := RetransmitInterval
+	for {
+		if message := conn.nextMessage(); message != nil {
+			return message, nil
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			return nil, fmt.Errorf("handshake timeout")
+		}
+		timeout := now.Add(interval)
+		if timeout.After(deadline) {
+			timeout = deadline
+		}
+		conn.transport.SetReadDeadline(timeout)
+		number, err := conn.transport.Read(conn.buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if err := conn.resendFlight(); err != nil {
+					return nil, err
+				}
+				interval *= 2
+				continue
+			}
+			return nil, fmt.Errorf("dtls read error:%v", err)
+		}
+		if err := conn.processDatagram(conn.buffer[:number]); err != nil {
+			return nil, err
+		}
+		if conn.peerRetransmitted && !conn.hasNextMessage() {
+			conn.peerRetransmitted = false
+			if err := conn.resendFlight(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+//hasNextMessage if all fragments of the next handshake message are received
+func (conn *Conn) hasNextMessage() bool {
+	buffer, ok := conn.fragments[conn.nextReceiveSequence]
+	return ok && buffer.count == len(buffer.body)
+}
+
+//nextMessage the next handshake message if all its fragments are received,
+//it's added to transcript
+func (conn *Conn) nextMessage() *handshakeMessage {
+	if !conn.hasNextMessage() {
+		return nil
+	}
+	buffer := conn.fragments[conn.nextReceiveSequence]
+	delete(conn.fragments, conn.nextReceiveSequence)
+	message := &handshakeMessage{
+		messageType: buffer.messageType,
+		sequence:    conn.nextReceiveSequence,
+		body:        buffer.body,
+		offset:      len(conn.transcript),
+	}
+	conn.nextReceiveSequence++
+	conn.transcript = append(conn.transcript, message.marshal()...)
+	return message
+}
+
+//processDatagram process records of datagram,malformed records are dropped,
+//io.EOF is returned at close_notify alert
+func (conn *Conn) processDatagram(datagram []byte) error {
+	records, _ := parseRecords(datagram)
+	for _, record := range records {
+		if err := conn.processRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//processRecord decrypt record and keep its handshake fragments
+func (conn *Conn) processRecord(record *record) error {
+	switch {
+	case record.epoch == 0:
+	case record.epoch == 1 && conn.readCipher == nil:
+		if len(conn.pendingRecords) < maxPendingRecords {
+			record.data = append([]byte{}, record.data...)
+			conn.pendingRecords = append(conn.pendingRecords, record)
+		}
+		return nil
+	case record.epoch == 1:
+		data, err := conn.readCipher.open(record)
+		if err != nil {
+			return nil
+		}
+		record.data = data
+	default:
+		return nil
+	}
+	switch record.contentType {
+	case contentHandshake:
+		fragments, _ := parseFragments(record.data)
+		for _, fragment := range fragments {
+			conn.addFragment(fragment)
+		}
+	case contentAlert:
+		if len(record.data) < 2 {
+			return nil
+		}
+		if record.data[1] == alertCloseNotify {
+			return io.EOF
+		}
+		if record.data[0] == alertLevelFatal {
+			return fmt.Errorf("dtls fatal alert %v received", record.data[1])
+		}
+	}
+	return nil
+}
+
+//addFragment copy fragment into buffer of its message,
+//fragments of messages received before mean peer resent its flight
+func (conn *Conn) addFragment(fragment *fragment) {
+	if fragment.sequence < conn.nextReceiveSequence {
+		conn.peerRetransmitted = true
+		return
+	}
+	if fragment.sequence >= conn.nextReceiveSequence+maxPendingMessages {
+		return
+	}
+	buffer, ok := conn.fragments[fragment.sequence]
+	if !ok {
+		buffer = &fragmentBuffer{
+			messageType: fragment.messageType,
+			body:        make([]byte, fragment.length),
+			received:    make([]bool, fragment.length),
+		}
+		conn.fragments[fragment.sequence] = buffer
+	}
+	if buffer.messageType == fragment.messageType && len(buffer.body) == fragment.length {
+		buffer.add(fragment)
+	}
+}
+
+//Serve process records after handshake until close_notify alert or transport
+//error,the last flight is resent if peer resends its flight as it's lost,
+//nil is returned at close_notify
+func (conn *Conn) Serve() error {
+	for {
+		number, err := conn.transport.Read(conn.buffer)
+		if err != nil {
+			return err
+		}
+		if err := conn.processDatagram(conn.buffer[:number]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if conn.peerRetransmitted {
+			conn.peerRetransmitted = false
+			if err := conn.resendFlight(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//Close send close_notify alert if handshake is complete,transport is not closed
+func (conn *Conn) Close() error {
+	if !conn.handshakeComplete {
+		return nil
+	}
+	return conn.sendAlert(alertLevelWarning, alertCloseNotify)
+}
+
+//PeerCertificate der certificate of peer,nil if absent
+func (conn *Conn) PeerCertificate() []byte {
+	return conn.peerCertificate
+}
+
+//SRTPProfile srtp profile negotiated,0 if none
+func (conn *Conn) SRTPProfile() srtp.ProtectionProfile {
+	return conn.srtpProfile
+}
+
+//ExportKeyingMaterial keying material exporter without context(rfc5705 4)
+func (conn *Conn) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+	if !conn.handshakeComplete {
+		return nil, fmt.Errorf("dtls handshake not complete")
+	}
+	return prf(conn.masterSecret, label,
+		concat(conn.clientRandom, conn.serverRandom), length), nil
+}
+
+//SRTPContexts srtp contexts of keys exported from handshake(rfc5764 4.2),
+//local protects packets sent and remote unprotects packets received
+func (conn *Conn) SRTPContexts() (local, remote *srtp.Context, err error) {
+	if conn.srtpProfile != srtp.ProfileAES128CMHMACSHA180 {
+		return nil, nil, fmt.Errorf("srtp profile not negotiated")
+	}
+	material, err := conn.ExportKeyingMaterial(labelSRTP, 2*(srtp.KeyLength+srtp.SaltLength))
+	if err != nil {
+		return nil, nil, err
+	}
+	clientKey, serverKey := material[:srtp.KeyLength], material[srtp.KeyLength:2*srtp.KeyLength]
+	salts := material[2*srtp.KeyLength:]
+	clientSalt, serverSalt := salts[:srtp.SaltLength], salts[srtp.SaltLength:]
+	if !conn.isClient {
+		clientKey, clientSalt, serverKey, serverSalt = serverKey, serverSalt, clientKey, clientSalt
+	}
+	if local, err = srtp.NewContext(clientKey, clientSalt); err != nil {
+		return nil, nil, err
+	}
+	if remote, err = srtp.NewContext(serverKey, serverSalt); err != nil {
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
+
+//generateECDHE make ephemeral p-256 key pair,public key is uncompressed point
+func generateECDHE() (privateKey, publicKey []byte, err error) {
+	privateKey, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("elliptic.GenerateKey error:%v", err)
+	}
+	return privateKey, elliptic.Marshal(elliptic.P256(), x, y), nil
+}
+
+//sharedSecret x coordinate of ecdh shared point as pre-master secret(rfc4492 5.10)
+func sharedSecret(privateKey, peerKey []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, peerKey)
+	if x == nil {
+		return nil, fmt.Errorf("peer ecdhe public key invalid")
+	}
+	sharedX, _ := curve.ScalarMult(x, y, privateKey)
+	secret := make([]byte, (curve.Params().BitSize+7)/8)
+	value := sharedX.Bytes()
+	copy(secret[len(secret)-len(value):], value)
+	return secret, nil
+}
+
+func randomBytes(length int) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("rand error:%v", err)
+	}
+	return data, nil
+}
+
+func concat(values ...[]byte) []byte {
+	return bytes.Join(values, nil)
+}
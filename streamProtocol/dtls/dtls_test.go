@@ -0,0 +1,172 @@
+package dtls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/srtp"
+)
+
+func TestPRF(t *testing.T) {
+	secret, _ := hex.DecodeString("9bbe436ba940f017b17652849a71db35")
+	seed, _ := hex.DecodeString("a0ba9f936cda311827a6f796ffd5198c")
+	expected := "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a" +
+		"6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab" +
+		"4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff701" +
+		"87347b66"
+	if output := hex.EncodeToString(prf(secret, "test label", seed, 100)); output != expected {
+		t.Errorf("prf = %v, want %v", output, expected)
+	}
+}
+
+//lossyTransport drop the datagram of write number drop
+type lossyTransport struct {
+	*net.UDPConn
+	writes int
+	drop   int
+}
+
+func (transport *lossyTransport) Write(data []byte) (int, error) {
+	transport.writes++
+	if transport.writes == transport.drop {
+		return len(data), nil
+	}
+	return transport.UDPConn.Write(data)
+}
+
+//udpPair make connected udp sockets on loopback
+func udpPair(t *testing.T) (*net.UDPConn, *net.UDPConn) {
+	first, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.DialUDP("udp", nil, first.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	if first, err = net.DialUDP("udp", first.LocalAddr().(*net.UDPAddr),
+		second.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	return first, second
+}
+
+//handshake run handshake of server and client,return their errors
+func handshake(server, client *Conn) (error, error) {
+	result := make(chan error, 1)
+	go func() {
+		result <- server.Handshake()
+	}()
+	clientErr := client.Handshake()
+	return <-result, clientErr
+}
+
+func newConfig(t *testing.T) *Config {
+	certificate, err := GenerateCertificate()
+	if err != nil {
+		t.Fatalf("GenerateCertificate error:%v", err)
+	}
+	return &Config{
+		Certificate:  certificate,
+		SRTPProfiles: []srtp.ProtectionProfile{srtp.ProfileAES128CMHMACSHA180},
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	defaultMTU, defaultInterval := MTU, RetransmitInterval
+	defer func() {
+		MTU, RetransmitInterval = defaultMTU, defaultInterval
+	}()
+	// certificates are fragmented and the first flight of server is lost
+	MTU, RetransmitInterval = 300, 50*time.Millisecond
+	serverConn, clientConn := udpPair(t)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	serverConfig, clientConfig := newConfig(t), newConfig(t)
+	serverConfig.VerifyPeerCertificate = func(der []byte) error {
+		if Fingerprint(der) != clientConfig.Certificate.Fingerprint() {
+			return fmt.Errorf("client fingerprint mismatch")
+		}
+		return nil
+	}
+	clientConfig.VerifyPeerCertificate = func(der []byte) error {
+		if Fingerprint(der) != serverConfig.Certificate.Fingerprint() {
+			return fmt.Errorf("server fingerprint mismatch")
+		}
+		return nil
+	}
+	server := Server(&lossyTransport{UDPConn: serverConn, drop: 1}, serverConfig)
+	client := Client(clientConn, clientConfig)
+	if serverErr, clientErr := handshake(server, client); serverErr != nil || clientErr != nil {
+		t.Fatalf("Handshake error:%v,%v", serverErr, clientErr)
+	}
+	if server.SRTPProfile() != srtp.ProfileAES128CMHMACSHA180 ||
+		!bytes.Equal(server.PeerCertificate(), clientConfig.Certificate.DER) {
+		t.Errorf("server SRTPProfile = %v", server.SRTPProfile())
+	}
+	serverKey, err := server.ExportKeyingMaterial(labelSRTP, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := client.ExportKeyingMaterial(labelSRTP, 60)
+	if err != nil || !bytes.Equal(serverKey, clientKey) {
+		t.Fatalf("ExportKeyingMaterial = %x,%x", serverKey, clientKey)
+	}
+	serverLocal, _, err := server.SRTPContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientRemote, err := client.SRTPContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := []byte{0x80, 0x60, 0, 1, 0, 0, 0, 1, 0, 0, 0, 2, 0xaa, 0xbb}
+	protected, err := serverLocal.EncryptRTP(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unprotected, err := clientRemote.DecryptRTP(protected); err != nil ||
+		!bytes.Equal(unprotected, packet) {
+		t.Errorf("DecryptRTP = %x,%v", unprotected, err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve()
+	}()
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve error:%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Serve not returned at close_notify")
+	}
+}
+
+func TestFingerprintMismatch(t *testing.T) {
+	defaultTimeout := HandshakeTimeout
+	defer func() {
+		HandshakeTimeout = defaultTimeout
+	}()
+	HandshakeTimeout = time.Second
+	serverConn, clientConn := udpPair(t)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	serverConfig, clientConfig := newConfig(t), newConfig(t)
+	clientConfig.VerifyPeerCertificate = func(der []byte) error {
+		return fmt.Errorf("fingerprint mismatch")
+	}
+	serverErr, clientErr := handshake(Server(serverConn, serverConfig),
+		Client(clientConn, clientConfig))
+	if serverErr == nil || clientErr == nil {
+		t.Errorf("Handshake error = %v,%v", serverErr, clientErr)
+	}
+}
@@ -0,0 +1,372 @@
+package dtls
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//handshake message types(rfc5246 7.4,rfc6347 4.3.2)
+const (
+	typeClientHello        uint8 = 1
+	typeServerHello        uint8 = 2
+	typeHelloVerifyRequest uint8 = 3
+	typeCertificate        uint8 = 11
+	typeServerKeyExchange  uint8 = 12
+	typeCertificateRequest uint8 = 13
+	typeServerHelloDone    uint8 = 14
+	typeCertificateVerify  uint8 = 15
+	typeClientKeyExchange  uint8 = 16
+	typeFinished           uint8 = 20
+)
+
+//extension types
+const (
+	extensionSupportedGroups      uint16 = 10
+	extensionPointFormats         uint16 = 11
+	extensionSignatureAlgorithms  uint16 = 13
+	extensionUseSRTP              uint16 = 14
+	extensionExtendedMasterSecret uint16 = 23
+	extensionRenegotiationInfo    uint16 = 0xff01
+)
+
+const (
+	//handshakeHeaderLength type,length,message sequence,fragment offset and length
+	handshakeHeaderLength = 12
+	//cipherSuiteECDHEECDSAAES128GCMSHA256 the only cipher suite supported
+	cipherSuiteECDHEECDSAAES128GCMSHA256 uint16 = 0xc02b
+	//cipherSuiteRenegotiationInfoSCSV signals secure renegotiation like an empty
+	//renegotiation_info extension(rfc5746 3.3)
+	cipherSuiteRenegotiationInfoSCSV uint16 = 0x00ff
+	//curveP256 named curve secp256r1
+	curveP256 uint16 = 23
+	//signatureECDSASHA256 signature algorithm ecdsa_secp256r1_sha256
+	signatureECDSASHA256 uint16 = 0x0403
+	//signatureRSASHA256 signature algorithm rsa_pkcs1_sha256
+	signatureRSASHA256 uint16 = 0x0401
+	//certificateTypeECDSASign client certificate type ecdsa_sign
+	certificateTypeECDSASign uint8 = 64
+	//certificateTypeRSASign client certificate type rsa_sign
+	certificateTypeRSASign uint8 = 1
+	randomLength                 = 32
+	verifyDataLength             = 12
+)
+
+//handshakeMessage a complete handshake message
+type handshakeMessage struct {
+	messageType uint8
+	sequence    uint16
+	body        []byte
+	offset      int // length of transcript before this message
+}
+
+//marshal message as a single fragment
+func (message *handshakeMessage) marshal() []byte {
+	return marshalFragment(message, 0, len(message.body))
+}
+
+//marshalFragment fragment of message body from offset
+func marshalFragment(message *handshakeMessage, offset, length int) []byte {
+	data := make([]byte, handshakeHeaderLength, handshakeHeaderLength+length)
+	data[0] = message.messageType
+	putUint24(data[1:], len(message.body))
+	binary.BigEndian.PutUint16(data[4:], message.sequence)
+	putUint24(data[6:], offset)
+	putUint24(data[9:], length)
+	return append(data, message.body[offset:offset+length]...)
+}
+
+//fragment a received handshake fragment
+type fragment struct {
+	messageType uint8
+	length      int
+	sequence    uint16
+	offset      int
+	data        []byte
+}
+
+//parseFragments split data of handshake record into fragments
+func parseFragments(data []byte) ([]*fragment, error) {
+	fragments := make([]*fragment, 0, 1)
+	for len(data) > 0 {
+		if len(data) < handshakeHeaderLength {
+			return fragments, fmt.Errorf("handshake header truncated")
+		}
+		fragment := &fragment{
+			messageType: data[0],
+			length:      uint24(data[1:]),
+			sequence:    binary.BigEndian.Uint16(data[4:]),
+			offset:      uint24(data[6:]),
+		}
+		length := uint24(data[9:])
+		if len(data) < handshakeHeaderLength+length ||
+			fragment.offset+length > fragment.length {
+			return fragments, fmt.Errorf("handshake fragment invalid")
+		}
+		fragment.data = data[handshakeHeaderLength : handshakeHeaderLength+length]
+		fragments = append(fragments, fragment)
+		data = data[handshakeHeaderLength+length:]
+	}
+	return fragments, nil
+}
+
+//fragmentBuffer reassembly of a handshake message
+type fragmentBuffer struct {
+	messageType uint8
+	body        []byte
+	received    []bool
+	count       int // bytes received
+}
+
+//add copy fragment into buffer
+func (buffer *fragmentBuffer) add(fragment *fragment) {
+	for index, value := range fragment.data {
+		if !buffer.received[fragment.offset+index] {
+			buffer.received[fragment.offset+index] = true
+			buffer.body[fragment.offset+index] = value
+			buffer.count++
+		}
+	}
+}
+
+func putUint24(data []byte, value int) {
+	data[0], data[1], data[2] = byte(value>>16), byte(value>>8), byte(value)
+}
+
+func uint24(data []byte) int {
+	return int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+}
+
+//appendUint16 append big endian value
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
+
+//appendVector append value with length of lengthSize bytes
+func appendVector(data []byte, lengthSize int, value []byte) []byte {
+	for shift := 8 * (lengthSize - 1); shift >= 0; shift -= 8 {
+		data = append(data, byte(len(value)>>uint(shift)))
+	}
+	return append(data, value...)
+}
+
+//parser read fields of handshake message body,the first error is kept
+//and later reads return zero values
+type parser struct {
+	data []byte
+	err  error
+}
+
+//bytes read length bytes
+func (parser *parser) bytes(length int) []byte {
+	if parser.err != nil {
+		return nil
+	}
+	if len(parser.data) < length {
+		parser.err = fmt.Errorf("handshake message truncated")
+		return nil
+	}
+	value := parser.data[:length]
+	parser.data = parser.data[length:]
+	return value
+}
+
+func (parser *parser) uint8() uint8 {
+	if value := parser.bytes(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (parser *parser) uint16() uint16 {
+	if value := parser.bytes(2); value != nil {
+		return binary.BigEndian.Uint16(value)
+	}
+	return 0
+}
+
+//vector read value with length of lengthSize bytes
+func (parser *parser) vector(lengthSize int) []byte {
+	length := 0
+	for _, value := range parser.bytes(lengthSize) {
+		length = length<<8 | int(value)
+	}
+	return parser.bytes(length)
+}
+
+//extensions of hello messages by type
+type extensions map[uint16][]byte
+
+//parseExtensions parse extensions at the end of hello message,they are optional
+func parseExtensions(fields *parser) extensions {
+	result := make(extensions)
+	if fields.err != nil || len(fields.data) == 0 {
+		return result
+	}
+	list := &parser{data: fields.vector(2)}
+	for list.err == nil && len(list.data) > 0 {
+		extensionType := list.uint16()
+		result[extensionType] = list.vector(2)
+	}
+	if list.err != nil {
+		fields.err = list.err
+	}
+	return result
+}
+
+//marshal extensions in the order of types
+func marshalExtensions(types []uint16, values extensions) []byte {
+	data := make([]byte, 0, 64)
+	for _, extensionType := range types {
+		if value, ok := values[extensionType]; ok {
+			data = appendUint16(data, extensionType)
+			data = appendVector(data, 2, value)
+		}
+	}
+	return appendVector(nil, 2, data)
+}
+
+//uint16List parse vector of uint16 with 2-byte length
+func uint16List(data []byte) ([]uint16, error) {
+	list := &parser{data: data}
+	values := list.vector(2)
+	if list.err != nil || len(values)%2 != 0 {
+		return nil, fmt.Errorf("uint16 list invalid")
+	}
+	result := make([]uint16, 0, len(values)/2)
+	for index := 0; index < len(values); index += 2 {
+		result = append(result, binary.BigEndian.Uint16(values[index:]))
+	}
+	return result, nil
+}
+
+//marshalUint16List marshal vector of uint16 with 2-byte length
+func marshalUint16List(values ...uint16) []byte {
+	data := make([]byte, 0, 2*len(values))
+	for _, value := range values {
+		data = appendUint16(data, value)
+	}
+	return appendVector(nil, 2, data)
+}
+
+//clientHello ClientHello(rfc6347 4.2.1)
+type clientHello struct {
+	random       []byte
+	sessionID    []byte
+	cookie       []byte
+	cipherSuites []uint16
+	extensions   extensions
+}
+
+func (hello *clientHello) marshal() []byte {
+	data := appendUint16(nil, version12)
+	data = append(data, hello.random...)
+	data = appendVector(data, 1, hello.sessionID)
+	data = appendVector(data, 1, hello.cookie)
+	data = append(data, marshalUint16List(hello.cipherSuites...)...)
+	data = appendVector(data, 1, []byte{0})
+	return append(data, marshalExtensions([]uint16{extensionSupportedGroups,
+		extensionPointFormats, extensionSignatureAlgorithms, extensionUseSRTP,
+		extensionExtendedMasterSecret, extensionRenegotiationInfo}, hello.extensions)...)
+}
+
+func (hello *clientHello) unmarshal(data []byte) error {
+	fields := &parser{data: data}
+	if version := fields.uint16(); fields.err == nil && version != version12 &&
+		version != 0xfeff {
+		return fmt.Errorf("dtls version %#x not support", version)
+	}
+	hello.random = fields.bytes(randomLength)
+	hello.sessionID = fields.vector(1)
+	hello.cookie = fields.vector(1)
+	suites := fields.vector(2)
+	fields.vector(1)
+	hello.extensions = parseExtensions(fields)
+	if fields.err != nil || len(suites)%2 != 0 {
+		return fmt.Errorf("ClientHello invalid")
+	}
+	hello.cipherSuites = hello.cipherSuites[:0]
+	for index := 0; index < len(suites); index += 2 {
+		hello.cipherSuites = append(hello.cipherSuites, binary.BigEndian.Uint16(suites[index:]))
+	}
+	return nil
+}
+
+//serverHello ServerHello(rfc5246 7.4.1.3)
+type serverHello struct {
+	random      []byte
+	sessionID   []byte
+	cipherSuite uint16
+	extensions  extensions
+}
+
+func (hello *serverHello) marshal() []byte {
+	data := appendUint16(nil, version12)
+	data = append(data, hello.random...)
+	data = appendVector(data, 1, hello.sessionID)
+	data = appendUint16(data, hello.cipherSuite)
+	data = append(data, 0)
+	return append(data, marshalExtensions([]uint16{extensionRenegotiationInfo,
+		extensionExtendedMasterSecret, extensionPointFormats, extensionUseSRTP},
+		hello.extensions)...)
+}
+
+func (hello *serverHello) unmarshal(data []byte) error {
+	fields := &parser{data: data}
+	version := fields.uint16()
+	hello.random = fields.bytes(randomLength)
+	hello.sessionID = fields.vector(1)
+	hello.cipherSuite = fields.uint16()
+	compression := fields.uint8()
+	hello.extensions = parseExtensions(fields)
+	if fields.err != nil {
+		return fmt.Errorf("ServerHello invalid")
+	}
+	if version != version12 || compression != 0 {
+		return fmt.Errorf("ServerHello version %#x or compression %v not support",
+			version, compression)
+	}
+	return nil
+}
+
+//marshalSRTPExtension use_srtp extension of profiles without mki(rfc5764 4.1.1)
+func marshalSRTPExtension(profiles ...uint16) []byte {
+	return append(marshalUint16List(profiles...), 0)
+}
+
+//marshalCertificates Certificate message of der certificates
+func marshalCertificates(certificates ...[]byte) []byte {
+	list := make([]byte, 0, 1024)
+	for _, certificate := range certificates {
+		list = appendVector(list, 3, certificate)
+	}
+	return appendVector(nil, 3, list)
+}
+
+//parseCertificates parse der certificates of Certificate message
+func parseCertificates(data []byte) ([][]byte, error) {
+	fields := &parser{data: data}
+	list := &parser{data: fields.vector(3)}
+	certificates := make([][]byte, 0, 1)
+	for fields.err == nil && list.err == nil && len(list.data) > 0 {
+		certificates = append(certificates, list.vector(3))
+	}
+	if fields.err != nil || list.err != nil {
+		return nil, fmt.Errorf("Certificate invalid")
+	}
+	return certificates, nil
+}
+
+//marshalSignature digitally-signed struct with algorithm
+func marshalSignature(algorithm uint16, signature []byte) []byte {
+	return appendVector(appendUint16(nil, algorithm), 2, signature)
+}
+
+//parseSignature parse digitally-signed struct
+func parseSignature(fields *parser) (uint16, []byte, error) {
+	algorithm := fields.uint16()
+	signature := fields.vector(2)
+	if fields.err != nil {
+		return 0, nil, fmt.Errorf("signature invalid")
+	}
+	return algorithm, signature, nil
+}
@@ -0,0 +1,177 @@
+package dtls
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/srtp"
+)
+
+//keyingMaterialPattern keying material printed by openssl -keymatexport
+var keyingMaterialPattern = regexp.MustCompile(`Keying material: ([0-9A-Fa-f]+)`)
+
+//opensslCommand openssl command of s_client or s_server with certificate of config,
+//test is skipped if openssl is not installed
+func opensslCommand(t *testing.T, config *Config, dir string, args ...string) *exec.Cmd {
+	path, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not installed")
+	}
+	key, err := x509.MarshalECPrivateKey(config.Certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: config.Certificate.DER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args = append(args, "-dtls1_2", "-cert", certFile, "-key", keyFile,
+		"-cipher", "ECDHE-ECDSA-AES128-GCM-SHA256", "-use_srtp", "SRTP_AES128_CM_SHA1_80",
+		"-keymatexport", labelSRTP, "-keymatexportlen", "60")
+	return exec.Command(path, args...)
+}
+
+//checkKeyingMaterial compare keying material of conn with the one printed by openssl
+func checkKeyingMaterial(t *testing.T, conn *Conn, output []byte) {
+	match := keyingMaterialPattern.FindSubmatch(output)
+	if match == nil {
+		t.Fatalf("keying material not exported by openssl:\n%s", output)
+	}
+	expected, err := hex.DecodeString(string(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.SRTPProfile() != srtp.ProfileAES128CMHMACSHA180 {
+		t.Errorf("SRTPProfile = %v", conn.SRTPProfile())
+	}
+	material, err := conn.ExportKeyingMaterial(labelSRTP, 60)
+	if err != nil || !bytes.Equal(material, expected) {
+		t.Errorf("ExportKeyingMaterial = %x,%v, want %x", material, err, expected)
+	}
+}
+
+func TestOpenSSLClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverConn, clientConn := udpPair(t)
+	defer serverConn.Close()
+	// openssl binds the address of the client end
+	clientAddress := clientConn.LocalAddr().String()
+	clientConn.Close()
+	serverConfig, clientConfig := newConfig(t), newConfig(t)
+	serverConfig.VerifyPeerCertificate = func(der []byte) error {
+		if Fingerprint(der) != clientConfig.Certificate.Fingerprint() {
+			t.Errorf("client fingerprint mismatch")
+		}
+		return nil
+	}
+	command := opensslCommand(t, clientConfig, dir, "s_client",
+		"-connect", serverConn.LocalAddr().String(), "-bind", clientAddress)
+	// s_client sends close_notify and exits at eof of stdin
+	command.Stdin = bytes.NewReader(nil)
+	output := new(bytes.Buffer)
+	command.Stdout, command.Stderr = output, output
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer command.Process.Kill()
+	server := Server(serverConn, serverConfig)
+	if err := server.Handshake(); err != nil {
+		command.Process.Kill()
+		command.Wait()
+		t.Fatalf("Handshake error:%v\n%s", err, output)
+	}
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := server.Serve(); err != nil {
+		t.Errorf("Serve error:%v", err)
+	}
+	command.Wait()
+	checkKeyingMaterial(t, server, output.Bytes())
+}
+
+func TestOpenSSLServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverConn, clientConn := udpPair(t)
+	defer clientConn.Close()
+	// openssl listens at the address of the server end
+	_, port, _ := net.SplitHostPort(serverConn.LocalAddr().String())
+	serverConn.Close()
+	serverConfig, clientConfig := newConfig(t), newConfig(t)
+	clientConfig.VerifyPeerCertificate = func(der []byte) error {
+		if Fingerprint(der) != serverConfig.Certificate.Fingerprint() {
+			t.Errorf("server fingerprint mismatch")
+		}
+		return nil
+	}
+	command := opensslCommand(t, serverConfig, dir, "s_server",
+		"-4", "-port", port, "-naccept", "1", "-Verify", "1")
+	// s_server exits at eof of stdin
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer command.Process.Kill()
+	reader, output := bufio.NewReader(stdout), new(bytes.Buffer)
+	for !strings.HasPrefix(output.String(), "ACCEPT") {
+		output.Reset()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("s_server not listening:%v", err)
+		}
+		output.WriteString(line)
+	}
+	exited := make(chan struct{})
+	go func() {
+		io.Copy(output, reader)
+		close(exited)
+	}()
+	client := Client(clientConn, clientConfig)
+	if err := client.Handshake(); err != nil {
+		command.Process.Kill()
+		<-exited
+		t.Fatalf("Handshake error:%v\n%s", err, output)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("s_server not exited at close_notify")
+	}
+	command.Wait()
+	checkKeyingMaterial(t, client, output.Bytes())
+}
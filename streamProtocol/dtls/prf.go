@@ -0,0 +1,65 @@
+package dtls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+//labels of prf(rfc5246,rfc7627)
+const (
+	labelMasterSecret         = "master secret"
+	labelExtendedMasterSecret = "extended master secret"
+	labelKeyExpansion         = "key expansion"
+	labelClientFinished       = "client finished"
+	labelServerFinished       = "server finished"
+	labelSRTP                 = "EXTRACTOR-dtls_srtp"
+)
+
+const (
+	masterSecretLength = 48
+	keyLength          = 16 // aes-128
+	implicitIVLength   = 4  // implicit nonce of aes-gcm
+)
+
+//prf tls 1.2 prf with sha-256(rfc5246 5)
+func prf(secret []byte, label string, seed []byte, length int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	mac := hmac.New(sha256.New, secret)
+	result := make([]byte, 0, length+sha256.Size)
+	a := labelSeed
+	for len(result) < length {
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		result = mac.Sum(result)
+	}
+	return result[:length]
+}
+
+//keyBlock write keys and ivs of client and server
+type keyBlock struct {
+	clientKey, serverKey []byte
+	clientIV, serverIV   []byte
+}
+
+//newKeyBlock expand master secret into keys(rfc5246 6.3)
+func newKeyBlock(masterSecret, clientRandom, serverRandom []byte) *keyBlock {
+	data := prf(masterSecret, labelKeyExpansion,
+		append(append([]byte{}, serverRandom...), clientRandom...),
+		2*keyLength+2*implicitIVLength)
+	return &keyBlock{
+		clientKey: data[:keyLength],
+		serverKey: data[keyLength : 2*keyLength],
+		clientIV:  data[2*keyLength : 2*keyLength+implicitIVLength],
+		serverIV:  data[2*keyLength+implicitIVLength:],
+	}
+}
+
+//verifyData verify data of Finished over handshake transcript
+func verifyData(masterSecret []byte, label string, transcript []byte) []byte {
+	digest := sha256.Sum256(transcript)
+	return prf(masterSecret, label, digest[:], verifyDataLength)
+}
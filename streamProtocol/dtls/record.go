@@ -0,0 +1,128 @@
+package dtls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+//content types of records(rfc5246 6.2.1)
+const (
+	contentChangeCipherSpec uint8 = 20
+	contentAlert            uint8 = 21
+	contentHandshake        uint8 = 22
+	contentApplicationData  uint8 = 23
+)
+
+const (
+	//recordHeaderLength type,version,epoch,sequence number and length(rfc6347 4.1)
+	recordHeaderLength = 13
+	//version12 protocol version of dtls 1.2
+	version12 uint16 = 0xfefd
+	//explicitNonceLength length of explicit nonce in aes-gcm records
+	explicitNonceLength = 8
+	//gcmTagLength length of aes-gcm tag
+	gcmTagLength = 16
+	//maxSequence record sequence number is 48 bits
+	maxSequence = 1<<48 - 1
+)
+
+//record a dtls record,data is decrypted if epoch is not 0
+type record struct {
+	contentType uint8
+	epoch       uint16
+	sequence    uint64
+	data        []byte
+}
+
+//parseRecords split datagram into records,data of records refer to datagram
+func parseRecords(datagram []byte) ([]*record, error) {
+	records := make([]*record, 0, 2)
+	for len(datagram) > 0 {
+		if len(datagram) < recordHeaderLength {
+			return records, fmt.Errorf("record header truncated")
+		}
+		length := int(binary.BigEndian.Uint16(datagram[11:]))
+		if len(datagram) < recordHeaderLength+length {
+			return records, fmt.Errorf("record truncated")
+		}
+		records = append(records, &record{
+			contentType: datagram[0],
+			epoch:       binary.BigEndian.Uint16(datagram[3:]),
+			sequence:    binary.BigEndian.Uint64(datagram[3:]) & maxSequence,
+			data:        datagram[recordHeaderLength : recordHeaderLength+length],
+		})
+		datagram = datagram[recordHeaderLength+length:]
+	}
+	return records, nil
+}
+
+//marshalHeader record header with length of data
+func marshalHeader(contentType uint8, epoch uint16, sequence uint64, length int) []byte {
+	header := make([]byte, recordHeaderLength)
+	header[0] = contentType
+	binary.BigEndian.PutUint16(header[1:], version12)
+	binary.BigEndian.PutUint64(header[3:], uint64(epoch)<<48|sequence)
+	binary.BigEndian.PutUint16(header[11:], uint16(length))
+	return header
+}
+
+//cipherState aes-128-gcm keys of one direction(rfc5288)
+type cipherState struct {
+	aead cipher.AEAD
+	iv   []byte // implicit part of nonce
+}
+
+//newCipherState make cipher state of write key and iv
+func newCipherState(key, iv []byte) (*cipherState, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher error:%v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM error:%v", err)
+	}
+	return &cipherState{aead: aead, iv: iv}, nil
+}
+
+//additionalData sequence number,type,version and plaintext length
+func additionalData(contentType uint8, epoch uint16, sequence uint64, length int) []byte {
+	data := make([]byte, 13)
+	binary.BigEndian.PutUint64(data, uint64(epoch)<<48|sequence)
+	data[8] = contentType
+	binary.BigEndian.PutUint16(data[9:], version12)
+	binary.BigEndian.PutUint16(data[11:], uint16(length))
+	return data
+}
+
+//seal encrypt plaintext of record,explicit nonce is epoch and sequence number
+func (state *cipherState) seal(contentType uint8, epoch uint16,
+	sequence uint64, plaintext []byte) []byte {
+	nonce := make([]byte, len(state.iv)+explicitNonceLength)
+	copy(nonce, state.iv)
+	binary.BigEndian.PutUint64(nonce[len(state.iv):], uint64(epoch)<<48|sequence)
+	output := make([]byte, explicitNonceLength,
+		explicitNonceLength+len(plaintext)+gcmTagLength)
+	copy(output, nonce[len(state.iv):])
+	return state.aead.Seal(output, nonce, plaintext,
+		additionalData(contentType, epoch, sequence, len(plaintext)))
+}
+
+//open decrypt data of record
+func (state *cipherState) open(record *record) ([]byte, error) {
+	if len(record.data) < explicitNonceLength+gcmTagLength {
+		return nil, fmt.Errorf("encrypted record too short:%v", len(record.data))
+	}
+	nonce := make([]byte, len(state.iv)+explicitNonceLength)
+	copy(nonce, state.iv)
+	copy(nonce[len(state.iv):], record.data[:explicitNonceLength])
+	plaintext, err := state.aead.Open(nil, nonce, record.data[explicitNonceLength:],
+		additionalData(record.contentType, record.epoch, record.sequence,
+			len(record.data)-explicitNonceLength-gcmTagLength))
+	if err != nil {
+		return nil, fmt.Errorf("record decryption failed:%v", err)
+	}
+	return plaintext, nil
+}
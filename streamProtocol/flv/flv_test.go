@@ -0,0 +1,25 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFLVTag(t *testing.T) {
+	video := &VideoTag{FrameType: FrameTypeKey, CodecID: VideoCodecAVC,
+		PacketType: PacketTypeRaw, CompositionTime: -40, Data: []byte{1, 2}}
+	parsedVideo := new(VideoTag)
+	if err := parsedVideo.Unmarshal(video.Marshal()); err != nil ||
+		parsedVideo.CompositionTime != -40 || parsedVideo.FrameType != FrameTypeKey ||
+		!bytes.Equal(parsedVideo.Data, video.Data) {
+		t.Errorf("VideoTag = %+v,%v", parsedVideo, err)
+	}
+	audio := &AudioTag{SoundFormat: SoundFormatAAC, SoundRate: 3, SoundSize: 1,
+		SoundType: 1, PacketType: PacketTypeSequenceHeader, Data: []byte{0x12, 0x10}}
+	parsedAudio := new(AudioTag)
+	if err := parsedAudio.Unmarshal(audio.Marshal()); err != nil ||
+		parsedAudio.SoundRate != 3 || parsedAudio.PacketType != PacketTypeSequenceHeader ||
+		!bytes.Equal(parsedAudio.Data, []byte{0x12, 0x10}) {
+		t.Errorf("AudioTag = %+v,%v", parsedAudio, err)
+	}
+}
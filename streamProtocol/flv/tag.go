@@ -0,0 +1,131 @@
+package flv
+
+import "fmt"
+
+//TagType type of flv tag(video file format spec v10 E.4.1)
+type TagType uint8
+
+const (
+	//TagTypeAudio audio tag,body is AudioTag
+	TagTypeAudio TagType = 8
+	//TagTypeVideo video tag,body is VideoTag
+	TagTypeVideo TagType = 9
+	//TagTypeScript script data tag,body is amf0 values like onMetaData
+	TagTypeScript TagType = 18
+)
+
+//Tag a flv tag without tag header,the same as payload of rtmp media messages
+type Tag struct {
+	Type      TagType
+	Timestamp uint32 // decode time in milliseconds
+	Data      []byte // tag body
+}
+
+//flv codec ids(video file format spec v10 E.4.2,E.4.3)
+const (
+	//VideoCodecAVC codec id of h264 in video tags
+	VideoCodecAVC = 7
+	//SoundFormatAAC sound format of AAC in audio tags
+	SoundFormatAAC = 10
+)
+
+//flv frame types of video tags
+const (
+	//FrameTypeKey keyframe,seekable frame
+	FrameTypeKey = 1
+	//FrameTypeInter inter frame
+	FrameTypeInter = 2
+)
+
+//AVC and AAC packet types
+const (
+	//PacketTypeSequenceHeader AVCDecoderConfigurationRecord or AudioSpecificConfig
+	PacketTypeSequenceHeader = 0
+	//PacketTypeRaw nal units of video or a raw aac frame
+	PacketTypeRaw = 1
+	//PacketTypeEndOfSequence end of avc sequence
+	PacketTypeEndOfSequence = 2
+)
+
+//VideoTag body of flv video tag,payload of video messages
+type VideoTag struct {
+	FrameType       uint8 // FrameTypeKey or FrameTypeInter
+	CodecID         uint8 // VideoCodecAVC for h264
+	PacketType      uint8 // AVCPacketType,only for avc
+	CompositionTime int32 // pts-dts in milliseconds,only for avc
+	Data            []byte
+}
+
+//Unmarshal parse video tag body
+func (tag *VideoTag) Unmarshal(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("video tag too short")
+	}
+	tag.FrameType = data[0] >> 4
+	tag.CodecID = data[0] & 0x0f
+	if tag.CodecID != VideoCodecAVC {
+		tag.Data = data[1:]
+		return nil
+	}
+	if len(data) < 5 {
+		return fmt.Errorf("avc video tag too short")
+	}
+	tag.PacketType = data[1]
+	// si24
+	tag.CompositionTime = int32(uint32(data[2])<<24|uint32(data[3])<<16|uint32(data[4])<<8) >> 8
+	tag.Data = data[5:]
+	return nil
+}
+
+//Marshal make video tag body
+func (tag *VideoTag) Marshal() []byte {
+	data := make([]byte, 0, 5+len(tag.Data))
+	data = append(data, tag.FrameType<<4|tag.CodecID&0x0f)
+	if tag.CodecID == VideoCodecAVC {
+		data = append(data, tag.PacketType, byte(tag.CompositionTime>>16),
+			byte(tag.CompositionTime>>8), byte(tag.CompositionTime))
+	}
+	return append(data, tag.Data...)
+}
+
+//AudioTag body of flv audio tag,payload of audio messages
+type AudioTag struct {
+	SoundFormat uint8 // SoundFormatAAC for aac
+	SoundRate   uint8 // 0:5.5k,1:11k,2:22k,3:44k,always 3 for aac
+	SoundSize   uint8 // 0:8 bits,1:16 bits
+	SoundType   uint8 // 0:mono,1:stereo,always 1 for aac
+	PacketType  uint8 // AACPacketType,only for aac
+	Data        []byte
+}
+
+//Unmarshal parse audio tag body
+func (tag *AudioTag) Unmarshal(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("audio tag too short")
+	}
+	tag.SoundFormat = data[0] >> 4
+	tag.SoundRate = data[0] >> 2 & 0x03
+	tag.SoundSize = data[0] >> 1 & 0x01
+	tag.SoundType = data[0] & 0x01
+	if tag.SoundFormat != SoundFormatAAC {
+		tag.Data = data[1:]
+		return nil
+	}
+	if len(data) < 2 {
+		return fmt.Errorf("aac audio tag too short")
+	}
+	tag.PacketType = data[1]
+	tag.Data = data[2:]
+	return nil
+}
+
+//Marshal make audio tag body
+func (tag *AudioTag) Marshal() []byte {
+	data := make([]byte, 0, 2+len(tag.Data))
+	data = append(data, tag.SoundFormat<<4|tag.SoundRate&0x03<<2|
+		tag.SoundSize&0x01<<1|tag.SoundType&0x01)
+	if tag.SoundFormat == SoundFormatAAC {
+		data = append(data, tag.PacketType)
+	}
+	return append(data, tag.Data...)
+}
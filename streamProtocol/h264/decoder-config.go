@@ -0,0 +1,61 @@
+package h264
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//ParseDecoderConfig get the first sps and pps from AVCDecoderConfigurationRecord
+//(ISO 14496-15 5.2.4.1),nal units after it must have 4-byte lengths
+func ParseDecoderConfig(data []byte) (sps, pps []byte, err error) {
+	if len(data) < 6 || data[0] != 1 {
+		return nil, nil, fmt.Errorf("AVCDecoderConfigurationRecord invalid")
+	}
+	if lengthSize := data[4]&0x03 + 1; lengthSize != 4 {
+		return nil, nil, fmt.Errorf("nalu length size %v not support", lengthSize)
+	}
+	offset := 5
+	for _, parameterSets := range []*[]byte{&sps, &pps} {
+		if offset >= len(data) {
+			return nil, nil, fmt.Errorf("AVCDecoderConfigurationRecord too short")
+		}
+		count := int(data[offset])
+		if parameterSets == &sps {
+			count &= 0x1f
+		}
+		offset++
+		for i := 0; i < count; i++ {
+			if offset+2 > len(data) {
+				return nil, nil, fmt.Errorf("AVCDecoderConfigurationRecord too short")
+			}
+			length := int(binary.BigEndian.Uint16(data[offset:]))
+			offset += 2
+			if offset+length > len(data) {
+				return nil, nil, fmt.Errorf("parameter set size %v out of range", length)
+			}
+			if *parameterSets == nil {
+				*parameterSets = data[offset : offset+length]
+			}
+			offset += length
+		}
+	}
+	if sps == nil || pps == nil {
+		return nil, nil, fmt.Errorf("AVCDecoderConfigurationRecord lack sps or pps")
+	}
+	return sps, pps, nil
+}
+
+//MarshalDecoderConfig make AVCDecoderConfigurationRecord of sps and pps
+//with 4-byte nalu lengths
+func MarshalDecoderConfig(sps, pps []byte) ([]byte, error) {
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil, fmt.Errorf("h264 parameter sets missing")
+	}
+	data := make([]byte, 0, 11+len(sps)+len(pps))
+	data = append(data, 1, sps[1], sps[2], sps[3], // version,profile,compatibility,level
+		0xff, // lengthSizeMinusOne = 3
+		0xe1, byte(len(sps)>>8), byte(len(sps)))
+	data = append(data, sps...)
+	data = append(data, 1, byte(len(pps)>>8), byte(len(pps)))
+	return append(data, pps...), nil
+}
@@ -0,0 +1,144 @@
+package h264
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//MaxAccessUnitSize max size of nal units in one access unit
+const MaxAccessUnitSize = 8 << 20
+
+//AccessUnit nal units of one picture
+type AccessUnit struct {
+	NALUs      [][]byte // nal units without start code
+	Timestamp  uint32   // rtp timestamp
+	IsKeyframe bool     // if contains IDR slice
+}
+
+//Depacketizer assemble access units from rtp packets(rfc6184),
+//supports single nal unit,STAP-A and FU-A
+type Depacketizer struct {
+	nalus         [][]byte
+	size          int
+	timestamp     uint32
+	fragments     []byte
+	fragmenting   bool
+	lastSequence  uint16
+	sequenceValid bool
+}
+
+//NewDepacketizer make a h264 depacketizer
+func NewDepacketizer() *Depacketizer {
+	return new(Depacketizer)
+}
+
+//Decode put a rtp packet into depacketizer,return completed access units,
+//access unit is completed by marker bit or timestamp change
+func (depacketizer *Depacketizer) Decode(packet *rtp.Packet) ([]*AccessUnit, error) {
+	accessUnits := make([]*AccessUnit, 0, 1)
+	if depacketizer.sequenceValid &&
+		packet.SequenceNumber != depacketizer.lastSequence+1 && depacketizer.fragmenting {
+		// fragment lost,drop the incomplete nal unit
+		depacketizer.fragmenting = false
+		depacketizer.fragments = nil
+	}
+	depacketizer.lastSequence = packet.SequenceNumber
+	depacketizer.sequenceValid = true
+	if len(depacketizer.nalus) > 0 && packet.Timestamp != depacketizer.timestamp {
+		// marker bit lost
+		accessUnits = append(accessUnits, depacketizer.flush())
+	}
+	depacketizer.timestamp = packet.Timestamp
+	nalus, err := depacketizer.depacketize(packet.Payload)
+	if err != nil {
+		depacketizer.reset()
+		return accessUnits, err
+	}
+	for _, nalu := range nalus {
+		depacketizer.size += len(nalu)
+		if depacketizer.size > MaxAccessUnitSize {
+			depacketizer.reset()
+			return accessUnits, fmt.Errorf("access unit size exceeds %v", MaxAccessUnitSize)
+		}
+		depacketizer.nalus = append(depacketizer.nalus, nalu)
+	}
+	if packet.Marker && len(depacketizer.nalus) > 0 {
+		accessUnits = append(accessUnits, depacketizer.flush())
+	}
+	return accessUnits, nil
+}
+
+//depacketize get complete nal units from rtp payload
+func (depacketizer *Depacketizer) depacketize(payload []byte) ([][]byte, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty h264 rtp payload")
+	}
+	switch naluType := TypeOf(payload); {
+	case naluType >= 1 && naluType <= 23:
+		return [][]byte{append([]byte(nil), payload...)}, nil
+	case naluType == NALUTypeSTAPA:
+		nalus := make([][]byte, 0, 3)
+		for offset := 1; offset < len(payload); {
+			if offset+2 > len(payload) {
+				return nil, fmt.Errorf("STAP-A nalu size out of range")
+			}
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if size == 0 || offset+size > len(payload) {
+				return nil, fmt.Errorf("STAP-A nalu size %v invalid", size)
+			}
+			nalus = append(nalus, append([]byte(nil), payload[offset:offset+size]...))
+			offset += size
+		}
+		return nalus, nil
+	case naluType == NALUTypeFUA:
+		if len(payload) < 3 {
+			return nil, fmt.Errorf("FU-A too short")
+		}
+		start := payload[1]&0x80 != 0
+		end := payload[1]&0x40 != 0
+		if start {
+			header := payload[0]&0xe0 | payload[1]&0x1f
+			depacketizer.fragments = append(make([]byte, 0, 2048), header)
+			depacketizer.fragmenting = true
+		} else if !depacketizer.fragmenting {
+			// start fragment lost,wait for next start
+			return nil, nil
+		}
+		depacketizer.fragments = append(depacketizer.fragments, payload[2:]...)
+		if len(depacketizer.fragments) > MaxAccessUnitSize {
+			return nil, fmt.Errorf("FU-A nalu size exceeds %v", MaxAccessUnitSize)
+		}
+		if !end {
+			return nil, nil
+		}
+		nalu := depacketizer.fragments
+		depacketizer.fragments = nil
+		depacketizer.fragmenting = false
+		return [][]byte{nalu}, nil
+	default:
+		return nil, fmt.Errorf("h264 packet type %v not support", naluType)
+	}
+}
+
+//flush return current access unit and reset
+func (depacketizer *Depacketizer) flush() *AccessUnit {
+	accessUnit := &AccessUnit{
+		NALUs:      depacketizer.nalus,
+		Timestamp:  depacketizer.timestamp,
+		IsKeyframe: IsKeyframe(depacketizer.nalus),
+	}
+	depacketizer.nalus = nil
+	depacketizer.size = 0
+	return accessUnit
+}
+
+//reset drop current access unit
+func (depacketizer *Depacketizer) reset() {
+	depacketizer.nalus = nil
+	depacketizer.size = 0
+	depacketizer.fragments = nil
+	depacketizer.fragmenting = false
+}
@@ -0,0 +1,153 @@
+package h264
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestPacketizeDepacketize(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xe9}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := make([]byte, 3000)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	packetizer := NewPacketizer(500, 96, 0)
+	packets, err := packetizer.Packetize([][]byte{sps, pps, idr}, 9000)
+	if err != nil {
+		t.Fatalf("Packetize error:%v", err)
+	}
+	if TypeOf(packets[0].Payload) != NALUTypeSTAPA {
+		t.Errorf("first packet type = %v, want STAP-A", TypeOf(packets[0].Payload))
+	}
+	for i, packet := range packets {
+		if size := packet.MarshalSize(); size > 500 {
+			t.Errorf("packet %v size %v exceeds mtu", i, size)
+		}
+		if packet.Marker != (i == len(packets)-1) {
+			t.Errorf("packet %v marker = %v", i, packet.Marker)
+		}
+	}
+	depacketizer := NewDepacketizer()
+	var accessUnits []*AccessUnit
+	for _, packet := range packets {
+		units, err := depacketizer.Decode(packet)
+		if err != nil {
+			t.Fatalf("Decode error:%v", err)
+		}
+		accessUnits = append(accessUnits, units...)
+	}
+	if len(accessUnits) != 1 {
+		t.Fatalf("got %v access units, want 1", len(accessUnits))
+	}
+	au := accessUnits[0]
+	if !au.IsKeyframe || au.Timestamp != 9000 || len(au.NALUs) != 3 ||
+		!bytes.Equal(au.NALUs[0], sps) || !bytes.Equal(au.NALUs[1], pps) ||
+		!bytes.Equal(au.NALUs[2], idr) {
+		t.Errorf("access unit wrong:%v nalus,keyframe %v", len(au.NALUs), au.IsKeyframe)
+	}
+}
+
+func TestDepacketizeLostFragment(t *testing.T) {
+	packetizer := NewPacketizer(100, 96, 1)
+	nalu := make([]byte, 500)
+	nalu[0] = 0x41
+	packets, _ := packetizer.Packetize([][]byte{nalu}, 0)
+	next, _ := packetizer.Packetize([][]byte{{0x41, 1, 2}}, 3000)
+	depacketizer := NewDepacketizer()
+	var accessUnits []*AccessUnit
+	for i, packet := range append(packets, next...) {
+		if i == 2 {
+			continue
+		}
+		units, _ := depacketizer.Decode(packet)
+		accessUnits = append(accessUnits, units...)
+	}
+	if len(accessUnits) != 1 || accessUnits[0].Timestamp != 3000 {
+		t.Errorf("access units = %v, want only the complete one", len(accessUnits))
+	}
+}
+
+func TestStartsKeyframe(t *testing.T) {
+	cases := []struct {
+		payload []byte
+		want    bool
+	}{
+		{[]byte{0x67, 0x42}, true},
+		{[]byte{0x68, 0xce}, false},
+		{[]byte{0x65, 0x88}, true},
+		{[]byte{0x41, 0x9a}, false},
+		{[]byte{0x18, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}, true},
+		{[]byte{0x18, 0x00, 0x02, 0x06, 0x05}, false},
+		{[]byte{0x7c, 0x85, 0x88}, true},
+		{[]byte{0x7c, 0x05, 0x88}, false},
+		{[]byte{0x7c, 0x81, 0x9a}, false},
+		{nil, false},
+	}
+	for _, item := range cases {
+		if StartsKeyframe(item.payload) != item.want {
+			t.Errorf("StartsKeyframe(%x) != %v", item.payload, item.want)
+		}
+	}
+}
+
+func TestParseSpropParameterSets(t *testing.T) {
+	sps, pps, err := ParseSpropParameterSets("Z0IAH+kCgPZA,aM48gA==")
+	if err != nil {
+		t.Fatalf("ParseSpropParameterSets error:%v", err)
+	}
+	if TypeOf(sps) != NALUTypeSPS || TypeOf(pps) != NALUTypePPS {
+		t.Errorf("sps = %x,pps = %x", sps, pps)
+	}
+	if ProfileLevelID(sps) != "42001f" {
+		t.Errorf("profile-level-id = %v", ProfileLevelID(sps))
+	}
+}
+
+func TestAnnexB(t *testing.T) {
+	nalus := [][]byte{{0x67, 1}, {0x68, 2}, {0x65, 0, 0, 3}}
+	parsed := AnnexBToNALUs(NALUsToAnnexB(nalus))
+	if len(parsed) != 3 || !bytes.Equal(parsed[2], nalus[2]) {
+		t.Errorf("AnnexBToNALUs = %x", parsed)
+	}
+	parsed, err := AVCCToNALUs(NALUsToAVCC(nalus))
+	if err != nil || len(parsed) != 3 || !bytes.Equal(parsed[1], nalus[1]) {
+		t.Errorf("AVCCToNALUs = %x,%v", parsed, err)
+	}
+}
+
+func TestParseSPS(t *testing.T) {
+	for _, sprop := range []string{
+		"Z2QAKKzZQHgCJ+XARAAAAwAEAAADAPA8YMZY", // high profile
+		"Z00AKp2oHgCJ+WbgICAoAAADAAgAAAMAfCA=", // main profile
+	} {
+		nalu, err := base64.StdEncoding.DecodeString(sprop)
+		if err != nil {
+			t.Fatalf("DecodeString error:%v", err)
+		}
+		sps, err := ParseSPS(nalu)
+		if err != nil {
+			t.Fatalf("ParseSPS error:%v", err)
+		}
+		if sps.Width != 1920 || sps.Height != 1080 {
+			t.Errorf("ParseSPS(%v) = %vx%v, want 1920x1080", sprop, sps.Width, sps.Height)
+		}
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	sps, pps := []byte{0x67, 0x64, 0x00, 0x28, 0xac}, []byte{0x68, 0xeb}
+	data, err := MarshalDecoderConfig(sps, pps)
+	if err != nil {
+		t.Fatalf("MarshalDecoderConfig error:%v", err)
+	}
+	parsedSPS, parsedPPS, err := ParseDecoderConfig(data)
+	if err != nil || !bytes.Equal(parsedSPS, sps) || !bytes.Equal(parsedPPS, pps) {
+		t.Errorf("ParseDecoderConfig = %x,%x,%v", parsedSPS, parsedPPS, err)
+	}
+	if _, _, err := ParseDecoderConfig(data[:len(data)-1]); err == nil {
+		t.Errorf("ParseDecoderConfig of truncated record succeeded")
+	}
+}
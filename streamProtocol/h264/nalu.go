@@ -0,0 +1,190 @@
+package h264
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+//NALUType h264 nal unit type(ITU-T H.264 table 7-1,rfc6184 5.2)
+type NALUType uint8
+
+const (
+	//NALUTypeNonIDR coded slice of a non-IDR picture
+	NALUTypeNonIDR NALUType = 1
+	//NALUTypeIDR coded slice of an IDR picture
+	NALUTypeIDR NALUType = 5
+	//NALUTypeSEI supplemental enhancement information
+	NALUTypeSEI NALUType = 6
+	//NALUTypeSPS sequence parameter set
+	NALUTypeSPS NALUType = 7
+	//NALUTypePPS picture parameter set
+	NALUTypePPS NALUType = 8
+	//NALUTypeAUD access unit delimiter
+	NALUTypeAUD NALUType = 9
+	//NALUTypeSTAPA single-time aggregation packet
+	NALUTypeSTAPA NALUType = 24
+	//NALUTypeSTAPB single-time aggregation packet with DON
+	NALUTypeSTAPB NALUType = 25
+	//NALUTypeMTAP16 multi-time aggregation packet
+	NALUTypeMTAP16 NALUType = 26
+	//NALUTypeMTAP24 multi-time aggregation packet
+	NALUTypeMTAP24 NALUType = 27
+	//NALUTypeFUA fragmentation unit
+	NALUTypeFUA NALUType = 28
+	//NALUTypeFUB fragmentation unit with DON
+	NALUTypeFUB NALUType = 29
+)
+
+//TypeOf nal unit type of nalu
+func TypeOf(nalu []byte) NALUType {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return NALUType(nalu[0] & 0x1f)
+}
+
+//IsKeyframe if access unit contains an IDR slice
+func IsKeyframe(nalus [][]byte) bool {
+	for _, nalu := range nalus {
+		if TypeOf(nalu) == NALUTypeIDR {
+			return true
+		}
+	}
+	return false
+}
+
+//StartsKeyframe if rtp payload(rfc6184) starts a keyframe,that is a sps or an IDR slice,
+//an aggregation packet containing one,or the first fragment of an IDR slice
+func StartsKeyframe(payload []byte) bool {
+	switch naluType := TypeOf(payload); naluType {
+	case NALUTypeSPS, NALUTypeIDR:
+		return true
+	case NALUTypeSTAPA:
+		for offset := 1; offset+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if naluType := TypeOf(payload[offset:]); naluType == NALUTypeSPS || naluType == NALUTypeIDR {
+				return true
+			}
+			offset += size
+		}
+	case NALUTypeFUA:
+		return len(payload) >= 2 && payload[1]&0x80 != 0 && NALUType(payload[1]&0x1f) == NALUTypeIDR
+	}
+	return false
+}
+
+//ParseSpropParameterSets parse sprop-parameter-sets of fmtp(rfc6184 8.1)
+func ParseSpropParameterSets(value string) (sps, pps []byte, err error) {
+	for _, item := range strings.Split(value, ",") {
+		if item == "" {
+			continue
+		}
+		nalu, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sprop-parameter-sets %v invalid:%v", item, err)
+		}
+		switch TypeOf(nalu) {
+		case NALUTypeSPS:
+			sps = nalu
+		case NALUTypePPS:
+			pps = nalu
+		}
+	}
+	if sps == nil || pps == nil {
+		return sps, pps, fmt.Errorf("sprop-parameter-sets %v lack sps or pps", value)
+	}
+	return sps, pps, nil
+}
+
+//SpropParameterSets make sprop-parameter-sets value from sps and pps
+func SpropParameterSets(sps, pps []byte) string {
+	return base64.StdEncoding.EncodeToString(sps) + "," +
+		base64.StdEncoding.EncodeToString(pps)
+}
+
+//ProfileLevelID profile-level-id of fmtp from sps
+func ProfileLevelID(sps []byte) string {
+	if len(sps) < 4 {
+		return "42e01f"
+	}
+	return fmt.Sprintf("%02x%02x%02x", sps[1], sps[2], sps[3])
+}
+
+//AnnexBToNALUs split annex-b byte stream(start code prefixed) into nal units
+func AnnexBToNALUs(data []byte) [][]byte {
+	nalus := make([][]byte, 0, 4)
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				if end > start && data[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	} else if start < 0 && len(data) > 0 {
+		nalus = append(nalus, data)
+	}
+	return nalus
+}
+
+//NALUsToAnnexB join nal units into annex-b byte stream
+func NALUsToAnnexB(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+	data := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, nalu...)
+	}
+	return data
+}
+
+//AVCCToNALUs split avcc(4-byte length prefixed) data into nal units
+func AVCCToNALUs(data []byte) ([][]byte, error) {
+	nalus := make([][]byte, 0, 4)
+	for offset := 0; offset < len(data); {
+		if offset+4 > len(data) {
+			return nil, fmt.Errorf("avcc nalu length out of range")
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+		if length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("avcc nalu size %v out of range", length)
+		}
+		nalus = append(nalus, data[offset:offset+length])
+		offset += length
+	}
+	return nalus, nil
+}
+
+//NALUsToAVCC join nal units into avcc(4-byte length prefixed) data
+func NALUsToAVCC(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+	data := make([]byte, size)
+	offset := 0
+	for _, nalu := range nalus {
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(nalu)))
+		offset += 4 + copy(data[offset+4:], nalu)
+	}
+	return data
+}
@@ -0,0 +1,146 @@
+package h264
+
+import (
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/bits"
+)
+
+//SPS fields of sequence parameter set needed by muxers(ITU-T H.264 7.3.2.1.1)
+type SPS struct {
+	ProfileIdc      uint8
+	ConstraintFlags uint8
+	LevelIdc        uint8
+	ChromaFormatIdc uint32
+	Width           int // picture width after cropping
+	Height          int // picture height after cropping
+}
+
+//skipScalingList skip scaling_list of size sizeOfScalingList
+func skipScalingList(reader *bits.Reader, size int) error {
+	lastScale, nextScale := int32(8), int32(8)
+	for i := 0; i < size; i++ {
+		if nextScale != 0 {
+			deltaScale, err := reader.ReadSE()
+			if err != nil {
+				return err
+			}
+			nextScale = (lastScale + deltaScale + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+	return nil
+}
+
+//ParseSPS parse sequence parameter set nal unit
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if TypeOf(nalu) != NALUTypeSPS || len(nalu) < 4 {
+		return nil, fmt.Errorf("nal unit is not sps")
+	}
+	sps := &SPS{
+		ProfileIdc:      nalu[1],
+		ConstraintFlags: nalu[2],
+		LevelIdc:        nalu[3],
+		ChromaFormatIdc: 1,
+	}
+	reader := bits.NewReader(bits.RemoveEmulationPrevention(nalu[4:]))
+	// errors of exp-golomb codes are checked once at the end,
+	// a reader out of range keeps returning errors
+	var err error
+	readUE := func() uint32 {
+		var value uint32
+		if err == nil {
+			value, err = reader.ReadUE()
+		}
+		return value
+	}
+	readFlag := func() bool {
+		var value bool
+		if err == nil {
+			value, err = reader.ReadFlag()
+		}
+		return value
+	}
+	separateColourPlane := false
+	readUE() // seq_parameter_set_id
+	switch sps.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if sps.ChromaFormatIdc = readUE(); sps.ChromaFormatIdc == 3 {
+			separateColourPlane = readFlag()
+		}
+		readUE()   // bit_depth_luma_minus8
+		readUE()   // bit_depth_chroma_minus8
+		readFlag() // qpprime_y_zero_transform_bypass_flag
+		if readFlag() {
+			// seq_scaling_matrix_present_flag
+			count := 8
+			if sps.ChromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count && err == nil; i++ {
+				if readFlag() {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					err = skipScalingList(reader, size)
+				}
+			}
+		}
+	}
+	readUE() // log2_max_frame_num_minus4
+	switch pocType := readUE(); pocType {
+	case 0:
+		readUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		readFlag() // delta_pic_order_always_zero_flag
+		if err == nil {
+			_, err = reader.ReadSE() // offset_for_non_ref_pic
+		}
+		if err == nil {
+			_, err = reader.ReadSE() // offset_for_top_to_bottom_field
+		}
+		cycle := readUE()
+		for i := uint32(0); i < cycle && err == nil; i++ {
+			_, err = reader.ReadSE() // offset_for_ref_frame
+		}
+	}
+	readUE()   // max_num_ref_frames
+	readFlag() // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := readUE() + 1
+	heightInMapUnits := readUE() + 1
+	frameMbsOnly := readFlag()
+	if !frameMbsOnly {
+		readFlag() // mb_adaptive_frame_field_flag
+	}
+	readFlag() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if readFlag() {
+		cropLeft, cropRight, cropTop, cropBottom = readUE(), readUE(), readUE(), readUE()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ParseSPS error:%v", err)
+	}
+	frameHeightInMbs := heightInMapUnits
+	if !frameMbsOnly {
+		frameHeightInMbs *= 2
+	}
+	cropUnitX, cropUnitY := uint32(1), uint32(1)
+	if !separateColourPlane && sps.ChromaFormatIdc != 0 {
+		// 4:2:0 and 4:2:2 subsample chroma horizontally,4:2:0 also vertically
+		if sps.ChromaFormatIdc != 3 {
+			cropUnitX = 2
+		}
+		if sps.ChromaFormatIdc == 1 {
+			cropUnitY = 2
+		}
+	}
+	if !frameMbsOnly {
+		cropUnitY *= 2
+	}
+	sps.Width = int(widthInMbs*16 - cropUnitX*(cropLeft+cropRight))
+	sps.Height = int(frameHeightInMbs*16 - cropUnitY*(cropTop+cropBottom))
+	return sps, nil
+}
@@ -0,0 +1,174 @@
+package h265
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//MaxAccessUnitSize max size of nal units in one access unit
+const MaxAccessUnitSize = 8 << 20
+
+//AccessUnit nal units of one picture
+type AccessUnit struct {
+	NALUs      [][]byte // nal units without start code
+	Timestamp  uint32   // rtp timestamp
+	IsKeyframe bool     // if contains IRAP slice
+}
+
+//Depacketizer assemble access units from rtp packets(rfc7798),
+//supports single nal unit,aggregation packet and fragmentation unit
+type Depacketizer struct {
+	/*
+		DONLPresent if decoding order number fields present,
+		true when sprop-max-don-diff is greater than 0
+	*/
+	DONLPresent   bool
+	nalus         [][]byte
+	size          int
+	timestamp     uint32
+	fragments     []byte
+	fragmenting   bool
+	lastSequence  uint16
+	sequenceValid bool
+}
+
+//NewDepacketizer make a h265 depacketizer
+func NewDepacketizer(donlPresent bool) *Depacketizer {
+	return &Depacketizer{DONLPresent: donlPresent}
+}
+
+//Decode put a rtp packet into depacketizer,return completed access units,
+//access unit is completed by marker bit or timestamp change
+func (depacketizer *Depacketizer) Decode(packet *rtp.Packet) ([]*AccessUnit, error) {
+	accessUnits := make([]*AccessUnit, 0, 1)
+	if depacketizer.sequenceValid &&
+		packet.SequenceNumber != depacketizer.lastSequence+1 && depacketizer.fragmenting {
+		// fragment lost,drop the incomplete nal unit
+		depacketizer.fragmenting = false
+		depacketizer.fragments = nil
+	}
+	depacketizer.lastSequence = packet.SequenceNumber
+	depacketizer.sequenceValid = true
+	if len(depacketizer.nalus) > 0 && packet.Timestamp != depacketizer.timestamp {
+		// marker bit lost
+		accessUnits = append(accessUnits, depacketizer.flush())
+	}
+	depacketizer.timestamp = packet.Timestamp
+	nalus, err := depacketizer.depacketize(packet.Payload)
+	if err != nil {
+		depacketizer.reset()
+		return accessUnits, err
+	}
+	for _, nalu := range nalus {
+		depacketizer.size += len(nalu)
+		if depacketizer.size > MaxAccessUnitSize {
+			depacketizer.reset()
+			return accessUnits, fmt.Errorf("access unit size exceeds %v", MaxAccessUnitSize)
+		}
+		depacketizer.nalus = append(depacketizer.nalus, nalu)
+	}
+	if packet.Marker && len(depacketizer.nalus) > 0 {
+		accessUnits = append(accessUnits, depacketizer.flush())
+	}
+	return accessUnits, nil
+}
+
+//depacketize get complete nal units from rtp payload
+func (depacketizer *Depacketizer) depacketize(payload []byte) ([][]byte, error) {
+	if len(payload) < NALUHeaderLength {
+		return nil, fmt.Errorf("h265 rtp payload too short")
+	}
+	switch naluType := TypeOf(payload); naluType {
+	case NALUTypeAP:
+		nalus := make([][]byte, 0, 4)
+		offset := NALUHeaderLength
+		if depacketizer.DONLPresent {
+			// DONL of the first unit
+			offset += 2
+		}
+		for first := true; offset < len(payload); first = false {
+			if !first && depacketizer.DONLPresent {
+				// DOND of following units
+				offset++
+			}
+			if offset+2 > len(payload) {
+				return nil, fmt.Errorf("AP nalu size out of range")
+			}
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if size < NALUHeaderLength || offset+size > len(payload) {
+				return nil, fmt.Errorf("AP nalu size %v invalid", size)
+			}
+			nalus = append(nalus, append([]byte(nil), payload[offset:offset+size]...))
+			offset += size
+		}
+		return nalus, nil
+	case NALUTypeFU:
+		headerLength := NALUHeaderLength + 1
+		if depacketizer.DONLPresent {
+			headerLength += 2
+		}
+		if len(payload) <= headerLength {
+			return nil, fmt.Errorf("FU too short")
+		}
+		fuHeader := payload[2]
+		start := fuHeader&0x80 != 0
+		end := fuHeader&0x40 != 0
+		if start {
+			depacketizer.fragments = append(make([]byte, 0, 2048),
+				payload[0]&0x81|(fuHeader&0x3f)<<1, payload[1])
+			depacketizer.fragmenting = true
+		} else if !depacketizer.fragmenting {
+			// start fragment lost,wait for next start
+			return nil, nil
+		}
+		depacketizer.fragments = append(depacketizer.fragments, payload[headerLength:]...)
+		if len(depacketizer.fragments) > MaxAccessUnitSize {
+			return nil, fmt.Errorf("FU nalu size exceeds %v", MaxAccessUnitSize)
+		}
+		if !end {
+			return nil, nil
+		}
+		nalu := depacketizer.fragments
+		depacketizer.fragments = nil
+		depacketizer.fragmenting = false
+		return [][]byte{nalu}, nil
+	case NALUTypePACI:
+		return nil, fmt.Errorf("h265 PACI packet not support")
+	default:
+		if naluType > NALUTypePACI {
+			return nil, fmt.Errorf("h265 packet type %v not support", naluType)
+		}
+		if depacketizer.DONLPresent {
+			if len(payload) < NALUHeaderLength+2 {
+				return nil, fmt.Errorf("h265 single nal unit too short for DONL")
+			}
+			nalu := make([]byte, 0, len(payload)-2)
+			nalu = append(nalu, payload[:NALUHeaderLength]...)
+			return [][]byte{append(nalu, payload[NALUHeaderLength+2:]...)}, nil
+		}
+		return [][]byte{append([]byte(nil), payload...)}, nil
+	}
+}
+
+//flush return current access unit and reset
+func (depacketizer *Depacketizer) flush() *AccessUnit {
+	accessUnit := &AccessUnit{
+		NALUs:      depacketizer.nalus,
+		Timestamp:  depacketizer.timestamp,
+		IsKeyframe: IsKeyframe(depacketizer.nalus),
+	}
+	depacketizer.nalus = nil
+	depacketizer.size = 0
+	return accessUnit
+}
+
+//reset drop current access unit
+func (depacketizer *Depacketizer) reset() {
+	depacketizer.nalus = nil
+	depacketizer.size = 0
+	depacketizer.fragments = nil
+	depacketizer.fragmenting = false
+}
@@ -0,0 +1,117 @@
+package h265
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestPacketizeDepacketize(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c, 0x01}
+	sps := []byte{0x42, 0x01, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xc1, 0x72}
+	idr := make([]byte, 3000)
+	idr[0], idr[1] = 0x26, 0x01
+	for i := 2; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	packets, err := NewPacketizer(600, 96, 0).Packetize([][]byte{vps, sps, pps, idr}, 3600)
+	if err != nil {
+		t.Fatalf("Packetize error:%v", err)
+	}
+	if TypeOf(packets[0].Payload) != NALUTypeAP {
+		t.Errorf("first packet type = %v, want AP", TypeOf(packets[0].Payload))
+	}
+	if TypeOf(packets[1].Payload) != NALUTypeFU {
+		t.Errorf("second packet type = %v, want FU", TypeOf(packets[1].Payload))
+	}
+	depacketizer := NewDepacketizer(false)
+	var accessUnits []*AccessUnit
+	for _, packet := range packets {
+		if packet.MarshalSize() > 600 {
+			t.Errorf("packet size %v exceeds mtu", packet.MarshalSize())
+		}
+		units, err := depacketizer.Decode(packet)
+		if err != nil {
+			t.Fatalf("Decode error:%v", err)
+		}
+		accessUnits = append(accessUnits, units...)
+	}
+	if len(accessUnits) != 1 || len(accessUnits[0].NALUs) != 4 {
+		t.Fatalf("access units wrong:%v", accessUnits)
+	}
+	au := accessUnits[0]
+	if !au.IsKeyframe || !bytes.Equal(au.NALUs[0], vps) || !bytes.Equal(au.NALUs[3], idr) {
+		t.Errorf("access unit wrong,keyframe = %v", au.IsKeyframe)
+	}
+}
+
+func TestDepacketizeDONL(t *testing.T) {
+	// AP with DONL,DOND and two nal units
+	payload := []byte{0x60, 0x01, 0x00, 0x05,
+		0x00, 0x03, 0x40, 0x01, 0xaa,
+		0x01, 0x00, 0x03, 0x42, 0x01, 0xbb}
+	depacketizer := NewDepacketizer(true)
+	units, err := depacketizer.Decode(&rtp.Packet{
+		Header:  rtp.Header{Marker: true, Timestamp: 1},
+		Payload: payload,
+	})
+	if err != nil || len(units) != 1 || len(units[0].NALUs) != 2 ||
+		TypeOf(units[0].NALUs[1]) != NALUTypeSPS {
+		t.Errorf("Decode = %v,%v", units, err)
+	}
+}
+
+func TestStartsKeyframe(t *testing.T) {
+	cases := []struct {
+		payload     []byte
+		donlPresent bool
+		want        bool
+	}{
+		{[]byte{0x40, 0x01, 0x0c}, false, true},
+		{[]byte{0x26, 0x01, 0xaf}, false, true},
+		{[]byte{0x02, 0x01, 0xd0}, false, false},
+		{[]byte{0x62, 0x01, 0x93, 0xaf}, false, true},
+		{[]byte{0x62, 0x01, 0x13, 0xaf}, false, false},
+		{[]byte{0x60, 0x01, 0x00, 0x03, 0x02, 0x01, 0xaa, 0x00, 0x03, 0x26, 0x01, 0xbb}, false, true},
+		{[]byte{0x60, 0x01, 0x00, 0x05, 0x00, 0x03, 0x40, 0x01, 0xaa,
+			0x01, 0x00, 0x03, 0x42, 0x01, 0xbb}, true, true},
+		{[]byte{0x60, 0x01, 0x00, 0x03, 0x02, 0x01, 0xaa}, false, false},
+		{[]byte{0x26}, false, false},
+	}
+	for _, item := range cases {
+		if StartsKeyframe(item.payload, item.donlPresent) != item.want {
+			t.Errorf("StartsKeyframe(%x,%v) != %v", item.payload, item.donlPresent, item.want)
+		}
+	}
+}
+
+func TestParseSpropParameterSets(t *testing.T) {
+	vps, sps, pps, err := ParseSpropParameterSets(
+		"QAEMAf//AWAAAAMAkAAAAwAAAwB4mZgJ",
+		"QgEBAWAAAAMAkAAAAwAAAwB4oAPAgBDlmWZJMrwBAAADAAEAAAMAHgg=",
+		"RAHBcrRiQA==")
+	if err != nil {
+		t.Fatalf("ParseSpropParameterSets error:%v", err)
+	}
+	if TypeOf(vps) != NALUTypeVPS || TypeOf(sps) != NALUTypeSPS || TypeOf(pps) != NALUTypePPS {
+		t.Errorf("parameter sets types wrong")
+	}
+}
+
+func TestParseSPS(t *testing.T) {
+	nalu, err := base64.StdEncoding.DecodeString(
+		"QgEBAWAAAAMAkAAAAwAAAwB4oAPAgBDlmWZJMrwBAAADAAEAAAMAHgg=")
+	if err != nil {
+		t.Fatalf("DecodeString error:%v", err)
+	}
+	sps, err := ParseSPS(nalu)
+	if err != nil {
+		t.Fatalf("ParseSPS error:%v", err)
+	}
+	if sps.Width != 1920 || sps.Height != 1080 || sps.ProfileIdc != 1 || sps.LevelIdc != 120 {
+		t.Errorf("ParseSPS = %+v", sps)
+	}
+}
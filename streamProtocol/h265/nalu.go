@@ -0,0 +1,133 @@
+package h265
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+//NALUType h265 nal unit type(ITU-T H.265 table 7-1,rfc7798 4.4)
+type NALUType uint8
+
+const (
+	//NALUTypeTrailR coded slice of a non-TSA,non-STSA trailing picture
+	NALUTypeTrailR NALUType = 1
+	//NALUTypeBLAWLP first IRAP type,coded slice of a BLA picture
+	NALUTypeBLAWLP NALUType = 16
+	//NALUTypeIDRWRADL coded slice of an IDR picture
+	NALUTypeIDRWRADL NALUType = 19
+	//NALUTypeIDRNLP coded slice of an IDR picture without leading pictures
+	NALUTypeIDRNLP NALUType = 20
+	//NALUTypeCRA coded slice of a CRA picture
+	NALUTypeCRA NALUType = 21
+	//NALUTypeIRAPMax last reserved IRAP type
+	NALUTypeIRAPMax NALUType = 23
+	//NALUTypeVPS video parameter set
+	NALUTypeVPS NALUType = 32
+	//NALUTypeSPS sequence parameter set
+	NALUTypeSPS NALUType = 33
+	//NALUTypePPS picture parameter set
+	NALUTypePPS NALUType = 34
+	//NALUTypeAUD access unit delimiter
+	NALUTypeAUD NALUType = 35
+	//NALUTypePrefixSEI prefix supplemental enhancement information
+	NALUTypePrefixSEI NALUType = 39
+	//NALUTypeAP aggregation packet
+	NALUTypeAP NALUType = 48
+	//NALUTypeFU fragmentation unit
+	NALUTypeFU NALUType = 49
+	//NALUTypePACI payload content information
+	NALUTypePACI NALUType = 50
+)
+
+//NALUHeaderLength length of h265 nal unit header
+const NALUHeaderLength = 2
+
+//TypeOf nal unit type of nalu
+func TypeOf(nalu []byte) NALUType {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return NALUType(nalu[0] >> 1 & 0x3f)
+}
+
+//IsIRAP if nal unit type is intra random access point
+func IsIRAP(naluType NALUType) bool {
+	return naluType >= NALUTypeBLAWLP && naluType <= NALUTypeIRAPMax
+}
+
+//IsKeyframe if access unit contains an IRAP slice
+func IsKeyframe(nalus [][]byte) bool {
+	for _, nalu := range nalus {
+		if IsIRAP(TypeOf(nalu)) {
+			return true
+		}
+	}
+	return false
+}
+
+//StartsKeyframe if rtp payload(rfc7798) starts a keyframe,that is a vps or an IRAP slice,
+//an aggregation packet containing one,or the first fragment of an IRAP slice
+func StartsKeyframe(payload []byte, donlPresent bool) bool {
+	if len(payload) < NALUHeaderLength {
+		return false
+	}
+	switch naluType := TypeOf(payload); naluType {
+	case NALUTypeAP:
+		offset := NALUHeaderLength
+		if donlPresent {
+			offset += 2
+		}
+		for first := true; ; first = false {
+			if !first && donlPresent {
+				offset++
+			}
+			if offset+2 >= len(payload) {
+				return false
+			}
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if naluType := TypeOf(payload[offset:]); naluType == NALUTypeVPS || IsIRAP(naluType) {
+				return true
+			}
+			offset += size
+		}
+	case NALUTypeFU:
+		return len(payload) > NALUHeaderLength && payload[2]&0x80 != 0 &&
+			IsIRAP(NALUType(payload[2]&0x3f))
+	default:
+		return naluType == NALUTypeVPS || IsIRAP(naluType)
+	}
+}
+
+//parseSprop parse base64 nal units of sprop-vps/sps/pps,return the first
+func parseSprop(name, value string, naluType NALUType) ([]byte, error) {
+	for _, item := range strings.Split(value, ",") {
+		if item == "" {
+			continue
+		}
+		nalu, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			return nil, fmt.Errorf("%v %v invalid:%v", name, item, err)
+		}
+		if TypeOf(nalu) == naluType {
+			return nalu, nil
+		}
+	}
+	return nil, fmt.Errorf("%v %v has no nal unit of type %v", name, value, naluType)
+}
+
+//ParseSpropParameterSets parse sprop-vps,sprop-sps and sprop-pps of fmtp(rfc7798 7.1)
+func ParseSpropParameterSets(spropVPS, spropSPS, spropPPS string) (vps, sps, pps []byte, err error) {
+	if vps, err = parseSprop("sprop-vps", spropVPS, NALUTypeVPS); err != nil {
+		return nil, nil, nil, err
+	}
+	if sps, err = parseSprop("sprop-sps", spropSPS, NALUTypeSPS); err != nil {
+		return nil, nil, nil, err
+	}
+	if pps, err = parseSprop("sprop-pps", spropPPS, NALUTypePPS); err != nil {
+		return nil, nil, nil, err
+	}
+	return vps, sps, pps, nil
+}
@@ -0,0 +1,144 @@
+package h265
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//DefaultMTU default max size of rtp packet
+const DefaultMTU = 1400
+
+//Packetizer split access units into rtp packets(rfc7798 without DONL),
+//small nal units are aggregated by AP,large ones fragmented by FU
+type Packetizer struct {
+	MTU            int    // max size of rtp packet including header
+	PayloadType    uint8  // rtp payload type
+	SSRC           uint32 // rtp ssrc
+	SequenceNumber uint16 // sequence number of next packet
+}
+
+//NewPacketizer make a h265 packetizer with random ssrc and sequence number
+func NewPacketizer(mtu int, payloadType uint8, ssrc uint32) *Packetizer {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	if ssrc == 0 {
+		ssrc = rand.Uint32()
+	}
+	return &Packetizer{
+		MTU:            mtu,
+		PayloadType:    payloadType,
+		SSRC:           ssrc,
+		SequenceNumber: uint16(rand.Uint32()),
+	}
+}
+
+//newPacket make a packet with next sequence number
+func (packetizer *Packetizer) newPacket(timestamp uint32, payload []byte) *rtp.Packet {
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        rtp.Version,
+			PayloadType:    packetizer.PayloadType,
+			SequenceNumber: packetizer.SequenceNumber,
+			Timestamp:      timestamp,
+			SSRC:           packetizer.SSRC,
+		},
+		Payload: payload,
+	}
+	packetizer.SequenceNumber++
+	return packet
+}
+
+//Packetize split nal units of one access unit into rtp packets,
+//marker bit is set on the last packet
+func (packetizer *Packetizer) Packetize(nalus [][]byte, timestamp uint32) ([]*rtp.Packet, error) {
+	maxPayload := packetizer.MTU - rtp.HeaderLength
+	if maxPayload < NALUHeaderLength+2 {
+		return nil, fmt.Errorf("mtu %v too small", packetizer.MTU)
+	}
+	packets := make([]*rtp.Packet, 0, len(nalus))
+	for index := 0; index < len(nalus); {
+		nalu := nalus[index]
+		if len(nalu) < NALUHeaderLength {
+			index++
+			continue
+		}
+		if len(nalu) > maxPayload {
+			packets = append(packets, packetizer.fragment(nalu, timestamp, maxPayload)...)
+			index++
+			continue
+		}
+		// aggregate following small nal units
+		aggregated := 1
+		size := NALUHeaderLength + 2 + len(nalu)
+		for next := index + 1; next < len(nalus); next++ {
+			if len(nalus[next]) < NALUHeaderLength ||
+				size+2+len(nalus[next]) > maxPayload {
+				break
+			}
+			size += 2 + len(nalus[next])
+			aggregated++
+		}
+		if aggregated == 1 {
+			packets = append(packets, packetizer.newPacket(timestamp, nalu))
+			index++
+			continue
+		}
+		payload := make([]byte, NALUHeaderLength, size)
+		layerID, temporalID := byte(0x3f), byte(0x07)
+		for _, aggregatedNALU := range nalus[index : index+aggregated] {
+			// payload header takes the lowest layer id and temporal id
+			if id := (aggregatedNALU[0]&0x01)<<5 | aggregatedNALU[1]>>3; id < layerID {
+				layerID = id
+			}
+			if id := aggregatedNALU[1] & 0x07; id < temporalID {
+				temporalID = id
+			}
+			var length [2]byte
+			binary.BigEndian.PutUint16(length[:], uint16(len(aggregatedNALU)))
+			payload = append(payload, length[:]...)
+			payload = append(payload, aggregatedNALU...)
+		}
+		payload[0] = byte(NALUTypeAP)<<1 | layerID>>5
+		payload[1] = layerID<<3 | temporalID
+		packets = append(packets, packetizer.newPacket(timestamp, payload))
+		index += aggregated
+	}
+	if len(packets) > 0 {
+		packets[len(packets)-1].Marker = true
+	}
+	return packets, nil
+}
+
+//fragment split a large nal unit into FU packets
+func (packetizer *Packetizer) fragment(nalu []byte,
+	timestamp uint32, maxPayload int) []*rtp.Packet {
+	payloadHeader := [2]byte{nalu[0]&0x81 | byte(NALUTypeFU)<<1, nalu[1]}
+	naluType := byte(TypeOf(nalu))
+	data := nalu[NALUHeaderLength:]
+	maxFragment := maxPayload - NALUHeaderLength - 1
+	packets := make([]*rtp.Packet, 0, len(data)/maxFragment+1)
+	for offset := 0; offset < len(data); offset += maxFragment {
+		end := offset + maxFragment
+		if end > len(data) {
+			end = len(data)
+		}
+		fuHeader := naluType
+		if offset == 0 {
+			fuHeader |= 0x80
+		}
+		if end == len(data) {
+			fuHeader |= 0x40
+		}
+		payload := make([]byte, NALUHeaderLength+1+end-offset)
+		payload[0] = payloadHeader[0]
+		payload[1] = payloadHeader[1]
+		payload[2] = fuHeader
+		copy(payload[3:], data[offset:end])
+		packets = append(packets, packetizer.newPacket(timestamp, payload))
+	}
+	return packets
+}
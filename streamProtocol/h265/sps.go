@@ -0,0 +1,113 @@
+package h265
+
+import (
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/bits"
+)
+
+//ProfileTierLevel general profile_tier_level fields(ITU-T H.265 7.3.3)
+type ProfileTierLevel struct {
+	ProfileSpace          uint8
+	TierFlag              bool
+	ProfileIdc            uint8
+	ProfileCompatibility  uint32
+	ConstraintIndicator   uint64 // 48 bits
+	LevelIdc              uint8
+	MaxSubLayersMinus1    uint8
+	TemporalIDNestingFlag bool
+}
+
+//SPS fields of sequence parameter set needed by muxers(ITU-T H.265 7.3.2.2)
+type SPS struct {
+	ProfileTierLevel
+	ChromaFormatIdc      uint32
+	BitDepthLumaMinus8   uint32
+	BitDepthChromaMinus8 uint32
+	Width                int // picture width after conformance window
+	Height               int // picture height after conformance window
+}
+
+//ParseSPS parse sequence parameter set nal unit
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if TypeOf(nalu) != NALUTypeSPS || len(nalu) <= NALUHeaderLength {
+		return nil, fmt.Errorf("nal unit is not sps")
+	}
+	reader := bits.NewReader(bits.RemoveEmulationPrevention(nalu[NALUHeaderLength:]))
+	sps := new(SPS)
+	// errors are checked once at the end,
+	// a reader out of range keeps returning errors
+	var err error
+	readBits := func(count int) uint64 {
+		var value uint64
+		if err == nil {
+			value, err = reader.ReadBits(count)
+		}
+		return value
+	}
+	readUE := func() uint32 {
+		var value uint32
+		if err == nil {
+			value, err = reader.ReadUE()
+		}
+		return value
+	}
+	readBits(4) // sps_video_parameter_set_id
+	sps.MaxSubLayersMinus1 = uint8(readBits(3))
+	sps.TemporalIDNestingFlag = readBits(1) == 1
+	sps.ProfileSpace = uint8(readBits(2))
+	sps.TierFlag = readBits(1) == 1
+	sps.ProfileIdc = uint8(readBits(5))
+	sps.ProfileCompatibility = uint32(readBits(32))
+	sps.ConstraintIndicator = readBits(48)
+	sps.LevelIdc = uint8(readBits(8))
+	subLayerProfilePresent := make([]bool, sps.MaxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, sps.MaxSubLayersMinus1)
+	for i := range subLayerProfilePresent {
+		subLayerProfilePresent[i] = readBits(1) == 1
+		subLayerLevelPresent[i] = readBits(1) == 1
+	}
+	if sps.MaxSubLayersMinus1 > 0 {
+		for i := sps.MaxSubLayersMinus1; i < 8; i++ {
+			readBits(2) // reserved_zero_2bits
+		}
+	}
+	for i := range subLayerProfilePresent {
+		if subLayerProfilePresent[i] {
+			readBits(32)
+			readBits(56)
+		}
+		if subLayerLevelPresent[i] {
+			readBits(8)
+		}
+	}
+	readUE() // sps_seq_parameter_set_id
+	separateColourPlane := false
+	if sps.ChromaFormatIdc = readUE(); sps.ChromaFormatIdc == 3 {
+		separateColourPlane = readBits(1) == 1
+	}
+	width := readUE()
+	height := readUE()
+	var left, right, top, bottom uint32
+	if readBits(1) == 1 {
+		// conformance_window_flag
+		left, right, top, bottom = readUE(), readUE(), readUE(), readUE()
+	}
+	sps.BitDepthLumaMinus8 = readUE()
+	sps.BitDepthChromaMinus8 = readUE()
+	if err != nil {
+		return nil, fmt.Errorf("ParseSPS error:%v", err)
+	}
+	subWidth, subHeight := uint32(1), uint32(1)
+	if !separateColourPlane {
+		if sps.ChromaFormatIdc == 1 || sps.ChromaFormatIdc == 2 {
+			subWidth = 2
+		}
+		if sps.ChromaFormatIdc == 1 {
+			subHeight = 2
+		}
+	}
+	sps.Width = int(width - subWidth*(left+right))
+	sps.Height = int(height - subHeight*(top+bottom))
+	return sps, nil
+}
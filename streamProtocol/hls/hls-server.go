@@ -0,0 +1,252 @@
+package hls
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtsp"
+)
+
+// default values are used by Server
+var (
+	//SegmentCount segments kept in memory and listed in playlist
+	SegmentCount = 7
+	//SegmentDuration a new segment begins at the first keyframe after it
+	SegmentDuration = 2 * time.Second
+	//PlaylistWaitTimeout max time a playlist request waits for the first segment
+	PlaylistWaitTimeout = 15 * time.Second
+	//MuxerIdleTimeout muxer of a resource path is closed if no request in it
+	MuxerIdleTimeout = time.Minute
+	//LowLatency serve low latency hls with partial segments and blocking playlist reloads
+	LowLatency = false
+	//PartDuration max duration of partial segments in low latency mode
+	PartDuration = 200 * time.Millisecond
+	//SegmentFormat container format of segments,low latency needs FormatFMP4
+	SegmentFormat = FormatFMP4
+)
+
+/*
+Server hls server of streams published to RtspServer,
+playlist of resource path /live/test is at /live/test/index.m3u8,
+muxer of a resource path starts at its first request,
+low latency hls is served if LowLatency is set when muxer starts
+*/
+type Server struct {
+	RtspServer  *rtsp.Server
+	httpServer  *http.Server
+	muxers      map[string]*Muxer // muxers by resource path
+	muxersMutex sync.Mutex
+	stop        chan struct{}
+}
+
+//Start start a hls server listening at address
+func (server *Server) Start(address string) error {
+	server.stop = make(chan struct{})
+	server.httpServer = &http.Server{Addr: address, Handler: server}
+	go server.checkMuxersLoop()
+	fmt.Println("Start hls listening at ", address)
+	if err := server.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("ListenAndServe error:%v", err)
+	}
+	return nil
+}
+
+//Stop close http server and all muxers
+func (server *Server) Stop() error {
+	close(server.stop)
+	err := server.httpServer.Close()
+	server.muxersMutex.Lock()
+	defer server.muxersMutex.Unlock()
+	for resourcePath, muxer := range server.muxers {
+		muxer.Close()
+		delete(server.muxers, resourcePath)
+	}
+	return err
+}
+
+//ServeHTTP serve playlist,init segments,media segments and partial segments
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	dir, name := path.Split(request.URL.Path)
+	resourcePath := strings.TrimSuffix(dir, "/")
+	muxer, err := server.getMuxer(resourcePath)
+	if err != nil {
+		fmt.Printf("hls getMuxer error:%v\n", err)
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	muxer.touch()
+	switch {
+	case name == "index.m3u8":
+		if !muxer.WaitReady(PlaylistWaitTimeout, request.Context().Done()) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if status := server.waitPlaylist(muxer, request); status != http.StatusOK {
+			writer.WriteHeader(status)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Write(muxer.Playlist(request.URL.Query().Get("_HLS_skip") == "YES"))
+	case strings.HasPrefix(name, "init") && strings.HasSuffix(name, ".mp4"):
+		id, err := strconv.ParseUint(name[len("init"):len(name)-len(".mp4")], 10, 64)
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		init := muxer.FindInit(id)
+		if init == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "video/mp4")
+		writer.Write(init.Data)
+	case strings.HasPrefix(name, "segment") && strings.HasSuffix(name, muxer.Format.Extension()):
+		sequence, err := strconv.ParseUint(
+			name[len("segment"):len(name)-len(muxer.Format.Extension())], 10, 64)
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		segment := muxer.FindSegment(sequence)
+		if segment == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if muxer.Format == FormatMPEGTS {
+			writer.Header().Set("Content-Type", "video/mp2t")
+		} else {
+			writer.Header().Set("Content-Type", "video/iso.segment")
+		}
+		writer.Write(segment.Data)
+	case strings.HasPrefix(name, "part") && strings.HasSuffix(name, ".m4s"):
+		part := server.waitPart(muxer, request, name[len("part"):len(name)-len(".m4s")])
+		if part == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "video/iso.segment")
+		writer.Write(part.Data)
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//waitPlaylist block playlist request with _HLS_msn and _HLS_part until the segment
+//or part is complete,return http status of response
+func (server *Server) waitPlaylist(muxer *Muxer, request *http.Request) int {
+	query := request.URL.Query()
+	if muxer.PartDuration == 0 || query.Get("_HLS_msn") == "" {
+		return http.StatusOK
+	}
+	sequence, err := strconv.ParseUint(query.Get("_HLS_msn"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	index := -1
+	if value := query.Get("_HLS_part"); value != "" {
+		if index, err = strconv.Atoi(value); err != nil || index < 0 {
+			return http.StatusBadRequest
+		}
+	}
+	if sequence > muxer.LastSequence()+2 {
+		return http.StatusBadRequest
+	}
+	timeout := 3 * time.Duration(muxer.TargetDuration()) * time.Second
+	if !muxer.WaitPart(sequence, index, timeout, request.Context().Done()) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+//waitPart find part named {sequence}.{index},block until it is complete if it
+//is the preload hint or later
+func (server *Server) waitPart(muxer *Muxer, request *http.Request, name string) *Part {
+	fields := strings.Split(name, ".")
+	if len(fields) != 2 {
+		return nil
+	}
+	sequence, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	index, err := strconv.Atoi(fields[1])
+	if err != nil || index < 0 || sequence > muxer.LastSequence()+2 {
+		return nil
+	}
+	timeout := 3 * time.Duration(muxer.TargetDuration()) * time.Second
+	muxer.WaitPart(sequence, index, timeout, request.Context().Done())
+	return muxer.FindPart(sequence, index)
+}
+
+//getMuxer get muxer of resource path,start one if session is published
+func (server *Server) getMuxer(resourcePath string) (*Muxer, error) {
+	session := server.RtspServer.FindPublished(resourcePath)
+	server.muxersMutex.Lock()
+	defer server.muxersMutex.Unlock()
+	if muxer, ok := server.muxers[resourcePath]; ok {
+		if muxer.Session == session {
+			return muxer, nil
+		}
+		// pusher is gone or republished
+		muxer.Close()
+		delete(server.muxers, resourcePath)
+	}
+	if session == nil {
+		return nil, fmt.Errorf("resource path %v not published", resourcePath)
+	}
+	partDuration := time.Duration(0)
+	if LowLatency && SegmentFormat == FormatFMP4 {
+		partDuration = PartDuration
+	}
+	muxer, err := NewMuxer(session, SegmentCount, SegmentFormat, SegmentDuration, partDuration)
+	if err != nil {
+		return nil, fmt.Errorf("NewMuxer error:%v", err)
+	}
+	if err := muxer.Start(); err != nil {
+		return nil, fmt.Errorf("Muxer Start error:%v", err)
+	}
+	if server.muxers == nil {
+		server.muxers = make(map[string]*Muxer)
+	}
+	server.muxers[resourcePath] = muxer
+	return muxer, nil
+}
+
+//checkMuxers close muxers whose pusher is gone or not requested in MuxerIdleTimeout
+func (server *Server) checkMuxers() {
+	server.muxersMutex.Lock()
+	defer server.muxersMutex.Unlock()
+	for resourcePath, muxer := range server.muxers {
+		if muxer.idleTime() < MuxerIdleTimeout && server.RtspServer.FindPublished(resourcePath) == muxer.Session {
+			continue
+		}
+		fmt.Printf("close hls muxer of %v\n", resourcePath)
+		muxer.Close()
+		delete(server.muxers, resourcePath)
+	}
+}
+
+//checkMuxersLoop call checkMuxers periodically until Stop
+func (server *Server) checkMuxersLoop() {
+	ticker := time.NewTicker(SegmentDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			server.checkMuxers()
+		case <-server.stop:
+			return
+		}
+	}
+}
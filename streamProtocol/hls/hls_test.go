@@ -0,0 +1,273 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/internal/nettest"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/darunshen/go/streamProtocol/ts"
+)
+
+const testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAKKzZQHgCJ+XARAAAAwAEAAADAPA8YMZY,aOvjyyLA\r\n" +
+	"a=control:streamid=0\r\n"
+
+//get serve request of url by server
+func get(server *Server, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	return recorder
+}
+
+//startPusher start a rtsp server and push testSdp to /live/test,
+//the rtsp server is stopped by the caller
+func startPusher(t *testing.T) (*Server, *rtsp.Client) {
+	rtspServer := &rtsp.Server{}
+	address := nettest.Start(t, rtspServer)
+	server := &Server{RtspServer: rtspServer}
+	if response := get(server, "/live/test/index.m3u8"); response.Code != http.StatusNotFound {
+		t.Errorf("unpublished path response %v, want 404", response.Code)
+	}
+	pusher, err := rtsp.NewClient(fmt.Sprintf("rtsp://%v/live/test", address), rtsp.TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	return server, pusher
+}
+
+//pushFrames push video frames [from,to) at 30 fps,keyframe every second
+func pushFrames(t *testing.T, pusher *rtsp.Client, from, to int) {
+	pushKeyframesEvery(t, pusher, from, to, 30)
+}
+
+//pushKeyframesEvery push video frames [from,to) at 30 fps,keyframe every interval frames
+func pushKeyframesEvery(t *testing.T, pusher *rtsp.Client, from, to, interval int) {
+	packetizer := h264.NewPacketizer(1200, 96, 1)
+	for index := from; index < to; index++ {
+		nalu := make([]byte, 2000)
+		nalu[0], nalu[1] = 0x41, byte(index)
+		if index%interval == 0 {
+			nalu[0] = 0x65
+		}
+		packets, err := packetizer.Packetize([][]byte{nalu}, uint32(index*3000))
+		if err != nil {
+			t.Fatalf("Packetize error:%v", err)
+		}
+		for _, packet := range packets {
+			data, _ := packet.Marshal()
+			if err := pusher.PushPackage(0, rtsp.RtpPackage, data); err != nil {
+				t.Fatalf("PushPackage error:%v", err)
+			}
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	server, pusher := startPusher(t)
+	defer server.RtspServer.Stop()
+	defer pusher.Close()
+	SegmentCount, SegmentDuration, LowLatency = 3, time.Second, false
+	muxer, err := server.getMuxer("/live/test")
+	if err != nil {
+		t.Fatalf("getMuxer error:%v", err)
+	}
+	defer muxer.Close()
+
+	pushFrames(t, pusher, 0, 150)
+	deadline := time.Now().Add(5 * time.Second)
+	for muxer.FindSegment(3) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	response := get(server, "/live/test/index.m3u8")
+	if response.Code != http.StatusOK {
+		t.Fatalf("playlist response %v", response.Code)
+	}
+	playlist := response.Body.String()
+	for _, line := range []string{"#EXT-X-TARGETDURATION:1\n", "#EXT-X-MEDIA-SEQUENCE:1\n",
+		"#EXT-X-MAP:URI=\"init0.mp4\"\n", "#EXTINF:1.000,\nsegment3.m4s\n"} {
+		if !strings.Contains(playlist, line) {
+			t.Errorf("playlist has no %q:\n%v", line, playlist)
+		}
+	}
+	if strings.Contains(playlist, "segment0.m4s") {
+		t.Errorf("segment0 not removed from window:\n%v", playlist)
+	}
+	response = get(server, "/live/test/init0.mp4")
+	if response.Code != http.StatusOK || !bytes.Contains(response.Body.Bytes(), []byte("avcC")) {
+		t.Errorf("init response %v", response.Code)
+	}
+	response = get(server, "/live/test/segment3.m4s")
+	if response.Code != http.StatusOK || !bytes.Contains(response.Body.Bytes(), []byte("moof")) {
+		t.Errorf("segment response %v", response.Code)
+	}
+	if response := get(server, "/live/test/segment0.m4s"); response.Code != http.StatusNotFound {
+		t.Errorf("removed segment response %v, want 404", response.Code)
+	}
+}
+
+func TestMPEGTS(t *testing.T) {
+	server, pusher := startPusher(t)
+	defer server.RtspServer.Stop()
+	defer pusher.Close()
+	SegmentCount, SegmentDuration, SegmentFormat = 3, time.Second, FormatMPEGTS
+	defer func() { SegmentFormat = FormatFMP4 }()
+	muxer, err := server.getMuxer("/live/test")
+	if err != nil {
+		t.Fatalf("getMuxer error:%v", err)
+	}
+	defer muxer.Close()
+
+	pushFrames(t, pusher, 0, 150)
+	deadline := time.Now().Add(5 * time.Second)
+	for muxer.FindSegment(3) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	response := get(server, "/live/test/index.m3u8")
+	playlist := response.Body.String()
+	if response.Code != http.StatusOK || !strings.Contains(playlist, "#EXT-X-VERSION:3\n") ||
+		!strings.Contains(playlist, "#EXTINF:1.000,\nsegment3.ts\n") ||
+		strings.Contains(playlist, "EXT-X-MAP") {
+		t.Fatalf("playlist %v:\n%v", response.Code, playlist)
+	}
+	response = get(server, "/live/test/segment3.ts")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "video/mp2t" {
+		t.Fatalf("segment response %v", response.Code)
+	}
+	demuxer := ts.NewDemuxer(response.Body)
+	frames := 0
+	for {
+		frame, err := demuxer.ReadFrame()
+		if err != nil {
+			break
+		}
+		if frames == 0 && (!frame.RandomAccess || frame.DTS != 90*ts.ClockRate/30) {
+			t.Errorf("first frame of segment = %+v", frame)
+		}
+		frames++
+	}
+	if frames != 30 {
+		t.Errorf("segment has %v frames, want 30", frames)
+	}
+}
+
+func TestLowLatency(t *testing.T) {
+	server, pusher := startPusher(t)
+	defer server.RtspServer.Stop()
+	defer pusher.Close()
+	SegmentCount, SegmentDuration = 7, time.Second
+	LowLatency, PartDuration = true, 200*time.Millisecond
+	defer func() { LowLatency = false }()
+	muxer, err := server.getMuxer("/live/test")
+	if err != nil {
+		t.Fatalf("getMuxer error:%v", err)
+	}
+	defer muxer.Close()
+
+	pushFrames(t, pusher, 0, 300)
+	deadline := time.Now().Add(5 * time.Second)
+	for muxer.FindPart(9, 3) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	response := get(server, "/live/test/index.m3u8")
+	if response.Code != http.StatusOK {
+		t.Fatalf("playlist response %v", response.Code)
+	}
+	playlist := response.Body.String()
+	for _, line := range []string{"#EXT-X-VERSION:9\n", "#EXT-X-PART-INF:PART-TARGET=0.200\n",
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600,CAN-SKIP-UNTIL=6.0\n",
+		"#EXT-X-MEDIA-SEQUENCE:2\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"part9.0.m4s\",INDEPENDENT=YES\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"part9.3.m4s\"\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part9.4.m4s\"\n"} {
+		if !strings.Contains(playlist, line) {
+			t.Errorf("playlist has no %q:\n%v", line, playlist)
+		}
+	}
+	if strings.Contains(playlist, "part2.0.m4s") {
+		t.Errorf("parts of old segment listed:\n%v", playlist)
+	}
+	response = get(server, "/live/test/index.m3u8?_HLS_skip=YES")
+	if playlist := response.Body.String(); !strings.Contains(playlist, "#EXT-X-SKIP:SKIPPED-SEGMENTS=2\n") ||
+		strings.Contains(playlist, "segment3.m4s") || !strings.Contains(playlist, "segment4.m4s") {
+		t.Errorf("delta playlist wrong:\n%v", playlist)
+	}
+	if response := get(server, "/live/test/index.m3u8?_HLS_msn=20"); response.Code != http.StatusBadRequest {
+		t.Errorf("far future msn response %v, want 400", response.Code)
+	}
+
+	// blocking reload and preload hint part return once part 9.4 is complete
+	playlists := make(chan *httptest.ResponseRecorder)
+	go func() { playlists <- get(server, "/live/test/index.m3u8?_HLS_msn=9&_HLS_part=4") }()
+	parts := make(chan *httptest.ResponseRecorder)
+	go func() { parts <- get(server, "/live/test/part9.4.m4s") }()
+	select {
+	case <-playlists:
+		t.Fatalf("playlist returned before part is complete")
+	case <-time.After(100 * time.Millisecond):
+	}
+	pushFrames(t, pusher, 300, 302)
+	response = <-playlists
+	if response.Code != http.StatusOK ||
+		!strings.Contains(response.Body.String(), "URI=\"part9.4.m4s\"") {
+		t.Errorf("blocking playlist %v:\n%v", response.Code, response.Body.String())
+	}
+	response = <-parts
+	if response.Code != http.StatusOK || !bytes.Contains(response.Body.Bytes(), []byte("moof")) {
+		t.Errorf("preload hint part response %v", response.Code)
+	}
+}
+
+func TestFixedTargetDuration(t *testing.T) {
+	defer func() { SegmentFormat = FormatFMP4 }()
+	for _, format := range []Format{FormatFMP4, FormatMPEGTS} {
+		server, pusher := startPusher(t)
+		SegmentCount, SegmentDuration, SegmentFormat = 10, time.Second, format
+		muxer, err := server.getMuxer("/live/test")
+		if err != nil {
+			t.Fatalf("getMuxer error:%v", err)
+		}
+		// keyframe interval grows from 1 second to 3 seconds after the first segment
+		pushFrames(t, pusher, 0, 60)
+		pushKeyframesEvery(t, pusher, 60, 200, 90)
+		deadline := time.Now().Add(5 * time.Second)
+		for muxer.FindSegment(5) == nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		playlist := get(server, "/live/test/index.m3u8").Body.String()
+		if !strings.Contains(playlist, "#EXT-X-TARGETDURATION:1\n") || !strings.Contains(playlist, "segment5") {
+			t.Errorf("format %v playlist:\n%v", format, playlist)
+		}
+		if strings.Contains(playlist, "#EXT-X-INDEPENDENT-SEGMENTS") {
+			t.Errorf("format %v split segments announced independent:\n%v", format, playlist)
+		}
+		for _, line := range strings.Split(playlist, "\n") {
+			var duration float64
+			if _, err := fmt.Sscanf(line, "#EXTINF:%f,", &duration); err == nil && duration > 1 {
+				t.Errorf("format %v segment of %v seconds over target duration", format, duration)
+			}
+		}
+		muxer.Close()
+		pusher.Close()
+		server.RtspServer.Stop()
+	}
+}
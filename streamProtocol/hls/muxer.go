@@ -0,0 +1,496 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtsp"
+)
+
+//Format container format of segments
+type Format int
+
+const (
+	//FormatFMP4 fragmented mp4 segments with init segments
+	FormatFMP4 Format = iota
+	//FormatMPEGTS mpeg-ts segments,low latency is not supported
+	FormatMPEGTS
+)
+
+//Extension file extension of segments
+func (format Format) Extension() string {
+	if format == FormatMPEGTS {
+		return ".ts"
+	}
+	return ".m4s"
+}
+
+//Init an init segment referenced by media segments
+type Init struct {
+	ID   uint64 // index in uri init{ID}.mp4
+	Data []byte // ftyp and moov
+}
+
+//Part a partial segment of low latency hls,one moof and mdat pair
+type Part struct {
+	Index       int           // index in segment,uri is part{Sequence}.{Index}.m4s
+	Duration    time.Duration // duration of primary track
+	Independent bool          // if it begins with a keyframe
+	Data        []byte
+}
+
+//Segment a media segment held in memory
+type Segment struct {
+	Sequence    uint64        // media sequence number,index in uri segment{Sequence}.m4s or .ts
+	Init        *Init         // init segment needed to play it,nil for mpeg-ts
+	Duration    time.Duration // duration of primary track
+	Independent bool          // if it begins with a keyframe
+	Data        []byte        // moof and mdat pairs,or ts packets
+	Parts       []*Part       // partial segments in low latency mode
+}
+
+//Muxer keep a rolling window of hls segments made from frames of a pusher-pullers-session
+type Muxer struct {
+	Session         *rtsp.PusherPullersSession // muxed session
+	SegmentCount    int                        // max segments in playlist
+	Format          Format                     // container format of segments
+	SegmentDuration time.Duration              // a new segment begins at the first keyframe after it
+	PartDuration    time.Duration              // part target duration,0 if not low latency
+	segmenter       segmenter                  // rtsp.FMP4Muxer or rtsp.TSMuxer
+	mutex           sync.Mutex                 // provide fields below's atom
+	segments        []*Segment                 // complete segments,oldest first
+	current         *Segment                   // segment being muxed,nil before the first keyframe
+	init            *Init                      // init segment of current
+	nextSequence    uint64                     // sequence number of the next segment
+	targetDuration  int                        // target duration in seconds,0 before the first segment completes
+	updated         chan struct{}              // closed and renewed when a segment or part completes
+	lastRequest     time.Time                  // time of the last http request
+}
+
+//segmenter muxer of frames of session
+type segmenter interface {
+	Start() error
+	Close()
+}
+
+/*
+NewMuxer make a muxer of session,segments begin at keyframes after segmentDuration,
+partial segments are made if partDuration is not 0 and format is FormatFMP4,
+target duration is fixed by segmentDuration and the first segment,longer
+segments after it are split at frames which may not be keyframes
+*/
+func NewMuxer(session *rtsp.PusherPullersSession, segmentCount int, format Format,
+	segmentDuration, partDuration time.Duration) (*Muxer, error) {
+	muxer := &Muxer{
+		Session:         session,
+		SegmentCount:    segmentCount,
+		Format:          format,
+		SegmentDuration: segmentDuration,
+		updated:         make(chan struct{}),
+		lastRequest:     time.Now(),
+	}
+	if format == FormatMPEGTS {
+		tsMuxer, err := rtsp.NewTSMuxer(session, "hls")
+		if err != nil {
+			return nil, err
+		}
+		tsMuxer.SegmentDuration = segmentDuration
+		tsMuxer.OnSegment = muxer.onTSSegment
+		tsMuxer.OnData = muxer.onTSData
+		muxer.segmenter = tsMuxer
+		return muxer, nil
+	}
+	fmp4Muxer, err := rtsp.NewFMP4Muxer(session, "hls")
+	if err != nil {
+		return nil, err
+	}
+	fmp4Muxer.SegmentDuration = segmentDuration
+	fmp4Muxer.FragmentDuration = segmentDuration
+	if partDuration != 0 {
+		fmp4Muxer.FragmentDuration = partDuration
+		muxer.PartDuration = partDuration
+	}
+	fmp4Muxer.OnSegment = muxer.onSegment
+	fmp4Muxer.OnFragment = muxer.onFragment
+	muxer.segmenter = fmp4Muxer
+	return muxer, nil
+}
+
+//Start subscribe frames of session
+func (muxer *Muxer) Start() error {
+	return muxer.segmenter.Start()
+}
+
+//Close unsubscribe frames of session
+func (muxer *Muxer) Close() {
+	muxer.segmenter.Close()
+}
+
+//onSegment complete current segment and begin a new one
+func (muxer *Muxer) onSegment(init []byte) error {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	muxer.completeSegment()
+	if muxer.init == nil || !bytes.Equal(muxer.init.Data, init) {
+		id := uint64(0)
+		if muxer.init != nil {
+			id = muxer.init.ID + 1
+		}
+		muxer.init = &Init{ID: id, Data: init}
+	}
+	muxer.current = &Segment{Sequence: muxer.nextSequence, Init: muxer.init}
+	muxer.nextSequence++
+	return nil
+}
+
+//onFragment append fragment to current segment,as a part in low latency mode
+func (muxer *Muxer) onFragment(fragment *rtsp.MP4Fragment) error {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	if muxer.current == nil {
+		return fmt.Errorf("hls muxer has no segment")
+	}
+	if len(muxer.current.Data) == 0 {
+		muxer.current.Independent = fragment.Independent
+	}
+	muxer.current.Data = append(muxer.current.Data, fragment.Data...)
+	muxer.current.Duration += fragment.Duration
+	if muxer.PartDuration != 0 {
+		// only a frame longer than part target makes a longer fragment
+		duration := fragment.Duration
+		if duration > muxer.PartDuration {
+			duration = muxer.PartDuration
+		}
+		muxer.current.Parts = append(muxer.current.Parts, &Part{
+			Index:       len(muxer.current.Parts),
+			Duration:    duration,
+			Independent: fragment.Independent,
+			Data:        fragment.Data,
+		})
+		muxer.notify()
+	}
+	return nil
+}
+
+//onTSSegment complete current segment with its duration and begin a new one
+func (muxer *Muxer) onTSSegment(duration time.Duration, independent bool) error {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	if muxer.current != nil {
+		muxer.current.Duration = duration
+	}
+	muxer.completeSegment()
+	muxer.current = &Segment{Sequence: muxer.nextSequence, Independent: independent}
+	muxer.nextSequence++
+	return nil
+}
+
+//onTSData append packets to current segment
+func (muxer *Muxer) onTSData(data []byte) error {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	if muxer.current == nil {
+		return fmt.Errorf("hls muxer has no segment")
+	}
+	muxer.current.Data = append(muxer.current.Data, data...)
+	return nil
+}
+
+//completeSegment move current segment into window and wake up waiters,
+//the first one fixes target duration,mutex must be held
+func (muxer *Muxer) completeSegment() {
+	if muxer.current == nil || len(muxer.current.Data) == 0 {
+		return
+	}
+	if muxer.targetDuration == 0 {
+		muxer.setTargetDuration(muxer.current.Duration)
+	}
+	muxer.segments = append(muxer.segments, muxer.current)
+	if len(muxer.segments) > muxer.SegmentCount {
+		muxer.segments = muxer.segments[len(muxer.segments)-muxer.SegmentCount:]
+	}
+	muxer.current = nil
+	muxer.notify()
+}
+
+//notify wake up waiters,mutex must be held
+func (muxer *Muxer) notify() {
+	close(muxer.updated)
+	muxer.updated = make(chan struct{})
+}
+
+//touch record time of http request
+func (muxer *Muxer) touch() {
+	muxer.mutex.Lock()
+	muxer.lastRequest = time.Now()
+	muxer.mutex.Unlock()
+}
+
+//idleTime time since the last http request
+func (muxer *Muxer) idleTime() time.Duration {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	return time.Since(muxer.lastRequest)
+}
+
+//wait wait until ready returns true,ready is called with mutex held,
+//return false on timeout or cancel
+func (muxer *Muxer) wait(timeout time.Duration,
+	cancel <-chan struct{}, ready func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		muxer.mutex.Lock()
+		isReady, updated := ready(), muxer.updated
+		muxer.mutex.Unlock()
+		if isReady {
+			return true
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return false
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+//WaitReady wait until a segment is complete,return false on timeout or cancel
+func (muxer *Muxer) WaitReady(timeout time.Duration, cancel <-chan struct{}) bool {
+	return muxer.wait(timeout, cancel, func() bool {
+		return len(muxer.segments) != 0
+	})
+}
+
+//LastSequence sequence number of the last segment in playlist,including the one being muxed
+func (muxer *Muxer) LastSequence() uint64 {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	return muxer.lastSequence()
+}
+
+//lastSequence see LastSequence,mutex must be held
+func (muxer *Muxer) lastSequence() uint64 {
+	if muxer.current != nil {
+		return muxer.current.Sequence
+	}
+	if len(muxer.segments) != 0 {
+		return muxer.segments[len(muxer.segments)-1].Sequence
+	}
+	return 0
+}
+
+//hasPart if segment sequence is complete,or its part index is complete when index >= 0,
+//mutex must be held
+func (muxer *Muxer) hasPart(sequence uint64, index int) bool {
+	if len(muxer.segments) != 0 && muxer.segments[len(muxer.segments)-1].Sequence >= sequence {
+		return true
+	}
+	if muxer.current == nil || index < 0 {
+		return false
+	}
+	if muxer.current.Sequence == sequence {
+		return len(muxer.current.Parts) > index
+	}
+	return muxer.current.Sequence > sequence
+}
+
+//WaitPart blocking playlist reload,wait until segment sequence is complete,
+//or its part index is complete when index >= 0
+func (muxer *Muxer) WaitPart(sequence uint64, index int,
+	timeout time.Duration, cancel <-chan struct{}) bool {
+	return muxer.wait(timeout, cancel, func() bool {
+		return muxer.hasPart(sequence, index)
+	})
+}
+
+/*
+setTargetDuration fix target duration by SegmentDuration and duration of the
+first segment,which is a keyframe interval or a multiple of it,segmenter
+splits segments longer than it,as target duration never changes(rfc8216 6.2.1),
+mutex must be held
+*/
+func (muxer *Muxer) setTargetDuration(first time.Duration) {
+	if first < muxer.SegmentDuration {
+		first = muxer.SegmentDuration
+	}
+	muxer.targetDuration = int(math.Ceil(first.Seconds()))
+	if muxer.targetDuration < 1 {
+		muxer.targetDuration = 1
+	}
+	maxDuration := time.Duration(muxer.targetDuration) * time.Second
+	switch segmenter := muxer.segmenter.(type) {
+	case *rtsp.FMP4Muxer:
+		segmenter.MaxSegmentDuration = maxDuration
+	case *rtsp.TSMuxer:
+		segmenter.MaxSegmentDuration = maxDuration
+	}
+}
+
+//TargetDuration target duration of playlist in seconds
+func (muxer *Muxer) TargetDuration() int {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	return muxer.targetDurationOrDefault()
+}
+
+//targetDurationOrDefault target duration,or SegmentDuration in seconds before
+//the first segment completes,mutex must be held
+func (muxer *Muxer) targetDurationOrDefault() int {
+	if muxer.targetDuration != 0 {
+		return muxer.targetDuration
+	}
+	if duration := int(math.Ceil(muxer.SegmentDuration.Seconds())); duration > 1 {
+		return duration
+	}
+	return 1
+}
+
+//Playlist media playlist of segments in window,
+//if skip,segments before the skip boundary are skipped in low latency mode
+func (muxer *Muxer) Playlist(skip bool) []byte {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	targetDuration := muxer.targetDurationOrDefault()
+	lowLatency := muxer.PartDuration != 0
+	buffer := new(bytes.Buffer)
+	switch {
+	case lowLatency:
+		buffer.WriteString("#EXTM3U\n#EXT-X-VERSION:9\n")
+	case muxer.Format == FormatMPEGTS:
+		buffer.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	default:
+		buffer.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	}
+	fmt.Fprintf(buffer, "#EXT-X-TARGETDURATION:%v\n", targetDuration)
+	skipUntil := time.Duration(6*targetDuration) * time.Second
+	if lowLatency {
+		partTarget := muxer.PartDuration.Seconds()
+		fmt.Fprintf(buffer,
+			"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f,CAN-SKIP-UNTIL=%.1f\n",
+			3*partTarget, skipUntil.Seconds())
+		fmt.Fprintf(buffer, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	}
+	if len(muxer.segments) != 0 {
+		fmt.Fprintf(buffer, "#EXT-X-MEDIA-SEQUENCE:%v\n", muxer.segments[0].Sequence)
+	}
+	if muxer.independent() {
+		buffer.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+
+	// position of segment is its start time relative to the end of playlist
+	var total time.Duration
+	for _, segment := range muxer.segments {
+		total += segment.Duration
+	}
+	if muxer.current != nil && lowLatency {
+		total += muxer.current.Duration
+	}
+	segments, position := muxer.segments, total
+	if skip && lowLatency {
+		skipped := 0
+		for ; skipped < len(segments) && position > skipUntil; skipped++ {
+			position -= segments[skipped].Duration
+		}
+		segments = segments[skipped:]
+		fmt.Fprintf(buffer, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%v\n", skipped)
+	}
+	partsFrom := time.Duration(3*targetDuration) * time.Second
+	var init *Init
+	for _, segment := range segments {
+		if segment.Init != init && segment.Init != nil {
+			init = segment.Init
+			fmt.Fprintf(buffer, "#EXT-X-MAP:URI=\"init%v.mp4\"\n", init.ID)
+		}
+		if lowLatency && position <= partsFrom+segment.Duration {
+			writeParts(buffer, segment)
+		}
+		fmt.Fprintf(buffer, "#EXTINF:%.3f,\nsegment%v%v\n",
+			segment.Duration.Seconds(), segment.Sequence, muxer.Format.Extension())
+		position -= segment.Duration
+	}
+	if !lowLatency {
+		return buffer.Bytes()
+	}
+	sequence, index := muxer.nextSequence, 0
+	if current := muxer.current; current != nil {
+		if current.Init != init {
+			fmt.Fprintf(buffer, "#EXT-X-MAP:URI=\"init%v.mp4\"\n", current.Init.ID)
+		}
+		writeParts(buffer, current)
+		sequence, index = current.Sequence, len(current.Parts)
+	}
+	fmt.Fprintf(buffer, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part%v.%v.m4s\"\n", sequence, index)
+	return buffer.Bytes()
+}
+
+//independent if segments in playlist all begin with keyframes,
+//they don't if split for target duration,mutex must be held
+func (muxer *Muxer) independent() bool {
+	for _, segment := range muxer.segments {
+		if !segment.Independent {
+			return false
+		}
+	}
+	return muxer.current == nil || muxer.PartDuration == 0 ||
+		len(muxer.current.Data) == 0 || muxer.current.Independent
+}
+
+//writeParts write EXT-X-PART tags of segment
+func writeParts(buffer *bytes.Buffer, segment *Segment) {
+	for _, part := range segment.Parts {
+		fmt.Fprintf(buffer, "#EXT-X-PART:DURATION=%.3f,URI=\"part%v.%v.m4s\"",
+			part.Duration.Seconds(), segment.Sequence, part.Index)
+		if part.Independent {
+			buffer.WriteString(",INDEPENDENT=YES")
+		}
+		buffer.WriteString("\n")
+	}
+}
+
+//FindInit find init segment referenced by segments in window
+func (muxer *Muxer) FindInit(id uint64) *Init {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	if muxer.current != nil && muxer.current.Init != nil && muxer.current.Init.ID == id {
+		return muxer.current.Init
+	}
+	for _, segment := range muxer.segments {
+		if segment.Init != nil && segment.Init.ID == id {
+			return segment.Init
+		}
+	}
+	return nil
+}
+
+//FindSegment find complete segment in window
+func (muxer *Muxer) FindSegment(sequence uint64) *Segment {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	for _, segment := range muxer.segments {
+		if segment.Sequence == sequence {
+			return segment
+		}
+	}
+	return nil
+}
+
+//FindPart find part of segment in window
+func (muxer *Muxer) FindPart(sequence uint64, index int) *Part {
+	muxer.mutex.Lock()
+	defer muxer.mutex.Unlock()
+	segments := muxer.segments
+	if muxer.current != nil {
+		segments = append(segments[:len(segments):len(segments)], muxer.current)
+	}
+	for _, segment := range segments {
+		if segment.Sequence == sequence && index >= 0 && index < len(segment.Parts) {
+			return segment.Parts[index]
+		}
+	}
+	return nil
+}
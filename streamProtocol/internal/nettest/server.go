@@ -0,0 +1,30 @@
+//Package nettest start servers of tests on free local ports
+package nettest
+
+import (
+	"net"
+	"testing"
+)
+
+//Server server serving connections of listener until it's stopped
+type Server interface {
+	Serve(listener net.Listener) error
+}
+
+//Listen listen tcp on a free local port
+func Listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error:%v", err)
+	}
+	return listener
+}
+
+//Start serve server on a free local port and return its address,
+//the port is listened before Start returns so it can be dialed at once,
+//server should be stopped by the caller
+func Start(t *testing.T, server Server) string {
+	listener := Listen(t)
+	go server.Serve(listener)
+	return listener.Addr().String()
+}
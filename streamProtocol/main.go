@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
+	"time"
 
+	"github.com/darunshen/go/streamProtocol/hls"
+	"github.com/darunshen/go/streamProtocol/rtmp"
 	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/darunshen/go/streamProtocol/webrtc"
 )
 
 func init() {
@@ -19,10 +24,102 @@ const (
 	PushChannelBuffer int = 1
 	//PullChannelBuffer puller channel buffer size
 	PullChannelBuffer int = 1
+	//RecordPath path template of recorded fmp4 files,empty to disable recording,
+	//{path},{date} and {time} are replaced
+	RecordPath string = ""
+	//MediaPath directory of mp4 files played on demand by rtsp url paths,empty to disable
+	MediaPath string = ""
+	//SessionTimeout seconds before rtsp sessions without requests or rtcp are closed,0 to disable
+	SessionTimeout int = 60
+	//AuthPath json file of rtsp credentials and permissions,empty to disable authentication
+	AuthPath string = ""
+	//RTSPSAddress listening address of rtsps,empty to disable rtsp over tls
+	RTSPSAddress string = ""
+	//TLSCertPath pem certificate file of rtsps
+	TLSCertPath string = "server.crt"
+	//TLSKeyPath pem private key file of rtsps
+	TLSKeyPath string = "server.key"
+	//GopCacheBytes max bytes of video packages since the latest keyframe kept for new pullers,
+	//0 to disable gop cache
+	GopCacheBytes int = 8 << 20
+	//PullerQueueSize max rtp packages queued for a slow puller of a track
+	PullerQueueSize int = 1024
+	//PullerDropPolicy what to drop when the queue of a puller is full,
+	//rtsp.DropOldest,rtsp.DropUntilKeyframe or rtsp.DropDisconnect
+	PullerDropPolicy = rtsp.DropOldest
+	//PullerMaxDrops drops before a puller is disconnected by rtsp.DropDisconnect
+	PullerMaxDrops int = 100
+	//MulticastIPRange cidr of multicast groups for rtsp multicast pullers,empty to disable multicast
+	MulticastIPRange string = "239.255.0.0/16"
+	//MulticastRtpPort rtp port of multicast groups,rtcp port is the next one
+	MulticastRtpPort int = 5004
+	//MulticastTTL time to live of multicast packages
+	MulticastTTL int = 16
+	//HLSAddress listening address of hls server,empty to disable hls
+	HLSAddress string = "0.0.0.0:8080"
+	//HLSLowLatency serve low latency hls with partial segments
+	HLSLowLatency bool = false
+	//RTMPAddress listening address of rtmp server,empty to disable rtmp publishing
+	RTMPAddress string = "0.0.0.0:1935"
+	//WHEPAddress listening address of whep and whip server,empty to disable webrtc
+	WHEPAddress string = "0.0.0.0:8889"
 )
 
 func main() {
+	rtsp.ReadBufferSize = ReadBuffer
+	rtsp.WriteBufferSize = WriteBuffer
+	rtsp.PushChannelBufferSize = PushChannelBuffer
+	rtsp.PullChannelBufferSize = PullChannelBuffer
+	rtsp.RecordPathTemplate = RecordPath
+	rtsp.MediaDirectory = MediaPath
+	rtsp.SessionTimeout = time.Duration(SessionTimeout) * time.Second
+	rtsp.GopCacheMaxBytes = GopCacheBytes
+	rtsp.PullerQueueSize = PullerQueueSize
+	rtsp.PullerDropPolicy = PullerDropPolicy
+	rtsp.PullerMaxDrops = PullerMaxDrops
+	rtsp.MulticastIPRange = MulticastIPRange
+	rtsp.MulticastRtpPort = MulticastRtpPort
+	rtsp.MulticastTTL = MulticastTTL
+	if AuthPath != "" {
+		auth, err := rtsp.LoadAuthenticator(AuthPath)
+		if err != nil {
+			log.Fatalf("LoadAuthenticator error:%v", err)
+		}
+		rtsp.Auth = auth
+	}
 	rtspServer := rtsp.Server{}
-	rtspServer.Start("0.0.0.0:2333",
-		ReadBuffer, WriteBuffer, PushChannelBuffer, PullChannelBuffer)
+	if RTSPSAddress != "" {
+		certificate, err := tls.LoadX509KeyPair(TLSCertPath, TLSKeyPath)
+		if err != nil {
+			log.Fatalf("LoadX509KeyPair error:%v", err)
+		}
+		rtspServer.TLSAddress = RTSPSAddress
+		rtspServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	if HLSAddress != "" {
+		hls.LowLatency = HLSLowLatency
+		hlsServer := hls.Server{RtspServer: &rtspServer}
+		go func() {
+			if err := hlsServer.Start(HLSAddress); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	if RTMPAddress != "" {
+		rtmpServer := rtmp.Server{RtspServer: &rtspServer}
+		go func() {
+			if err := rtmpServer.Start(RTMPAddress); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	if WHEPAddress != "" {
+		whepServer := webrtc.Server{RtspServer: &rtspServer}
+		go func() {
+			if err := whepServer.Start(WHEPAddress); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	rtspServer.Start("0.0.0.0:2333")
 }
@@ -0,0 +1,69 @@
+package mp4
+
+import "encoding/binary"
+
+//boxWriter write boxes(ISO 14496-12 4.2) into buffer,
+//size of a box is filled when the box ends
+type boxWriter struct {
+	buffer []byte
+	starts []int // offsets of boxes not ended
+}
+
+//startBox begin a box of boxType
+func (writer *boxWriter) startBox(boxType string) {
+	writer.starts = append(writer.starts, len(writer.buffer))
+	writer.write32(0)
+	writer.buffer = append(writer.buffer, boxType[:4]...)
+}
+
+//startFullBox begin a full box with version and 24 bits flags
+func (writer *boxWriter) startFullBox(boxType string, version uint8, flags uint32) {
+	writer.startBox(boxType)
+	writer.write32(uint32(version)<<24 | flags&0xffffff)
+}
+
+//endBox end the last started box and fill its size
+func (writer *boxWriter) endBox() {
+	start := writer.starts[len(writer.starts)-1]
+	writer.starts = writer.starts[:len(writer.starts)-1]
+	binary.BigEndian.PutUint32(writer.buffer[start:], uint32(len(writer.buffer)-start))
+}
+
+func (writer *boxWriter) write8(value uint8) {
+	writer.buffer = append(writer.buffer, value)
+}
+
+func (writer *boxWriter) write16(value uint16) {
+	writer.buffer = append(writer.buffer, byte(value>>8), byte(value))
+}
+
+func (writer *boxWriter) write24(value uint32) {
+	writer.buffer = append(writer.buffer, byte(value>>16), byte(value>>8), byte(value))
+}
+
+func (writer *boxWriter) write32(value uint32) {
+	writer.buffer = append(writer.buffer,
+		byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+func (writer *boxWriter) write64(value uint64) {
+	writer.write32(uint32(value >> 32))
+	writer.write32(uint32(value))
+}
+
+func (writer *boxWriter) writeBytes(data []byte) {
+	writer.buffer = append(writer.buffer, data...)
+}
+
+func (writer *boxWriter) writeZeros(count int) {
+	for i := 0; i < count; i++ {
+		writer.buffer = append(writer.buffer, 0)
+	}
+}
+
+//writeMatrix write unity transformation matrix
+func (writer *boxWriter) writeMatrix() {
+	for _, value := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		writer.write32(value)
+	}
+}
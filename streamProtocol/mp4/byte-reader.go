@@ -0,0 +1,74 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//byteReader read big endian fields of box body,
+//err is set and zero values are returned once data runs out
+type byteReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+//take next count bytes,nil if out of range
+func (reader *byteReader) take(count int) []byte {
+	if reader.err != nil {
+		return nil
+	}
+	if count < 0 || reader.offset+count > len(reader.data) {
+		reader.err = fmt.Errorf("%v bytes at %v out of range %v",
+			count, reader.offset, len(reader.data))
+		return nil
+	}
+	data := reader.data[reader.offset : reader.offset+count]
+	reader.offset += count
+	return data
+}
+
+func (reader *byteReader) skip(count int) {
+	reader.take(count)
+}
+
+func (reader *byteReader) read8() uint8 {
+	if data := reader.take(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+func (reader *byteReader) read16() uint16 {
+	if data := reader.take(2); data != nil {
+		return binary.BigEndian.Uint16(data)
+	}
+	return 0
+}
+
+func (reader *byteReader) read32() uint32 {
+	if data := reader.take(4); data != nil {
+		return binary.BigEndian.Uint32(data)
+	}
+	return 0
+}
+
+func (reader *byteReader) read64() uint64 {
+	if data := reader.take(8); data != nil {
+		return binary.BigEndian.Uint64(data)
+	}
+	return 0
+}
+
+//readBytes copy of next count bytes
+func (reader *byteReader) readBytes(count int) []byte {
+	if data := reader.take(count); data != nil {
+		return append([]byte(nil), data...)
+	}
+	return nil
+}
+
+//rest bytes not read
+func (reader *byteReader) rest() []byte {
+	return reader.take(len(reader.data) - reader.offset)
+}
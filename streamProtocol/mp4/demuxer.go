@@ -0,0 +1,752 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//maxBoxSize max size of moov or moof box read into memory
+const maxBoxSize = 64 * 1024 * 1024
+
+//maxSamples max samples of a track,over 77 hours of 60 fps video
+const maxSamples = 1 << 24
+
+//SampleInfo position and timing of a sample in file
+type SampleInfo struct {
+	DecodeTime        uint64 // in track timescale
+	Duration          uint32 // in track timescale
+	CompositionOffset int32  // presentation time minus decode time
+	IsSync            bool   // if sample is a sync(key) sample
+	Offset            int64  // offset of sample data in file
+	Size              uint32 // size of sample data
+}
+
+//DemuxTrack track of a file with samples in decode order
+type DemuxTrack struct {
+	Track
+	LengthSize int    // size of nal unit lengths in video samples
+	Duration   uint64 // end of the last sample in track timescale
+	Samples    []*SampleInfo
+}
+
+//Demuxer read tracks and samples of a progressive or fragmented mp4 file,
+//tracks with codecs not supported are skipped
+type Demuxer struct {
+	Tracks   []*DemuxTrack
+	reader   io.ReaderAt
+	size     int64                     // size of file
+	defaults map[uint32]*trackDefaults // sample defaults from trex by track id
+}
+
+//trackDefaults default values of samples in fragments(ISO 14496-12 8.8.3)
+type trackDefaults struct {
+	duration, size, flags uint32
+}
+
+//NewDemuxer read boxes of file with size bytes,samples are read by ReadSample
+func NewDemuxer(reader io.ReaderAt, size int64) (*Demuxer, error) {
+	demuxer := &Demuxer{
+		reader:   reader,
+		size:     size,
+		defaults: make(map[uint32]*trackDefaults),
+	}
+	hasMoov := false
+	for offset := int64(0); offset+8 <= size; {
+		header := make([]byte, 16)
+		if _, err := reader.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("read box header error:%v", err)
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		boxType := string(header[4:8])
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := reader.ReadAt(header[8:], offset+8); err != nil {
+				return nil, fmt.Errorf("read box header error:%v", err)
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || offset+boxSize > size {
+			return nil, fmt.Errorf("box %v size %v out of range", boxType, boxSize)
+		}
+		if boxType == "moov" || boxType == "moof" {
+			if boxSize > maxBoxSize {
+				return nil, fmt.Errorf("box %v size %v too large", boxType, boxSize)
+			}
+			data := make([]byte, boxSize-headerSize)
+			if _, err := reader.ReadAt(data, offset+headerSize); err != nil {
+				return nil, fmt.Errorf("read box %v error:%v", boxType, err)
+			}
+			var err error
+			if boxType == "moov" {
+				hasMoov = true
+				err = demuxer.parseMoov(data)
+			} else if hasMoov {
+				err = demuxer.parseMoof(data, offset)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		offset += boxSize
+	}
+	if !hasMoov {
+		return nil, fmt.Errorf("moov box not found")
+	}
+	if len(demuxer.Tracks) == 0 {
+		return nil, fmt.Errorf("no track of supported codecs")
+	}
+	for _, track := range demuxer.Tracks {
+		for _, sample := range track.Samples {
+			if end := sample.DecodeTime + uint64(sample.Duration); end > track.Duration {
+				track.Duration = end
+			}
+		}
+	}
+	return demuxer, nil
+}
+
+//ReadSample read data of sample
+func (demuxer *Demuxer) ReadSample(sample *SampleInfo) ([]byte, error) {
+	if sample.Offset < 0 || sample.Offset+int64(sample.Size) > demuxer.size {
+		return nil, fmt.Errorf("sample of %v bytes at %v out of file", sample.Size, sample.Offset)
+	}
+	data := make([]byte, sample.Size)
+	if _, err := demuxer.reader.ReadAt(data, sample.Offset); err != nil {
+		return nil, fmt.Errorf("read sample error:%v", err)
+	}
+	return data, nil
+}
+
+//findTrack find track by id
+func (demuxer *Demuxer) findTrack(id uint32) *DemuxTrack {
+	for _, track := range demuxer.Tracks {
+		if track.ID == id {
+			return track
+		}
+	}
+	return nil
+}
+
+//parseMoov get tracks and sample defaults of fragments
+func (demuxer *Demuxer) parseMoov(data []byte) error {
+	return walkBoxes(data, func(boxType string, body []byte) error {
+		switch boxType {
+		case "trak":
+			track, err := parseTrak(body)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				demuxer.Tracks = append(demuxer.Tracks, track)
+			}
+		case "mvex":
+			return walkBoxes(body, func(boxType string, body []byte) error {
+				if boxType != "trex" {
+					return nil
+				}
+				reader := &byteReader{data: body}
+				reader.skip(4) // version and flags
+				id := reader.read32()
+				reader.skip(4) // default_sample_description_index
+				defaults := &trackDefaults{
+					duration: reader.read32(),
+					size:     reader.read32(),
+					flags:    reader.read32(),
+				}
+				if reader.err != nil {
+					return fmt.Errorf("trex error:%v", reader.err)
+				}
+				demuxer.defaults[id] = defaults
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+//parseMoof append samples of track fragments,moofOffset is offset of moof in file
+func (demuxer *Demuxer) parseMoof(data []byte, moofOffset int64) error {
+	return walkBoxes(data, func(boxType string, body []byte) error {
+		if boxType != "traf" {
+			return nil
+		}
+		return demuxer.parseTraf(body, moofOffset)
+	})
+}
+
+//parseTraf append samples of a track fragment(ISO 14496-12 8.8.7 and 8.8.8)
+func (demuxer *Demuxer) parseTraf(data []byte, moofOffset int64) error {
+	var (
+		track        *DemuxTrack
+		defaults     trackDefaults
+		baseOffset   = moofOffset
+		decodeTime   uint64
+		hasTfdt      bool
+		nextOffset   int64
+		firstTrun    = true
+		headerParsed bool
+	)
+	return walkBoxes(data, func(boxType string, body []byte) error {
+		reader := &byteReader{data: body}
+		versionFlags := reader.read32()
+		version, flags := versionFlags>>24, versionFlags&0xffffff
+		switch boxType {
+		case "tfhd":
+			id := reader.read32()
+			if track = demuxer.findTrack(id); track == nil {
+				return nil
+			}
+			if trex, ok := demuxer.defaults[id]; ok {
+				defaults = *trex
+			}
+			if flags&0x000001 != 0 {
+				baseOffset = int64(reader.read64())
+			}
+			if flags&0x000002 != 0 {
+				reader.skip(4) // sample_description_index
+			}
+			if flags&0x000008 != 0 {
+				defaults.duration = reader.read32()
+			}
+			if flags&0x000010 != 0 {
+				defaults.size = reader.read32()
+			}
+			if flags&0x000020 != 0 {
+				defaults.flags = reader.read32()
+			}
+			headerParsed = true
+		case "tfdt":
+			if version == 1 {
+				decodeTime = reader.read64()
+			} else {
+				decodeTime = uint64(reader.read32())
+			}
+			hasTfdt = true
+		case "trun":
+			if !headerParsed || track == nil {
+				return nil
+			}
+			if !hasTfdt && len(track.Samples) > 0 {
+				last := track.Samples[len(track.Samples)-1]
+				decodeTime = last.DecodeTime + uint64(last.Duration)
+			}
+			hasTfdt = true
+			count := reader.read32()
+			if count > uint32(len(body)) && flags&0x000f00 != 0 ||
+				uint64(len(track.Samples))+uint64(count) > maxSamples {
+				return fmt.Errorf("trun sample count %v out of range", count)
+			}
+			offset := nextOffset
+			if flags&0x000001 != 0 {
+				offset = baseOffset + int64(int32(reader.read32()))
+			} else if firstTrun {
+				offset = baseOffset
+			}
+			firstTrun = false
+			firstFlags, hasFirstFlags := uint32(0), flags&0x000004 != 0
+			if hasFirstFlags {
+				firstFlags = reader.read32()
+			}
+			for i := uint32(0); i < count && reader.err == nil; i++ {
+				sample := &SampleInfo{
+					DecodeTime: decodeTime,
+					Duration:   defaults.duration,
+					Offset:     offset,
+					Size:       defaults.size,
+				}
+				sampleFlags := defaults.flags
+				if flags&0x000100 != 0 {
+					sample.Duration = reader.read32()
+				}
+				if flags&0x000200 != 0 {
+					sample.Size = reader.read32()
+				}
+				if flags&0x000400 != 0 {
+					sampleFlags = reader.read32()
+				} else if i == 0 && hasFirstFlags {
+					sampleFlags = firstFlags
+				}
+				if flags&0x000800 != 0 {
+					sample.CompositionOffset = int32(reader.read32())
+				}
+				// sample_is_non_sync_sample
+				sample.IsSync = sampleFlags&0x00010000 == 0
+				track.Samples = append(track.Samples, sample)
+				decodeTime += uint64(sample.Duration)
+				offset += int64(sample.Size)
+			}
+			nextOffset = offset
+		}
+		if reader.err != nil {
+			return fmt.Errorf("%v error:%v", boxType, reader.err)
+		}
+		return nil
+	})
+}
+
+//sampleTable boxes of stbl needed to make samples
+type sampleTable struct {
+	stts, ctts, stsc, stsz, stz2, stco, co64, stss []byte
+	hasStss                                        bool
+}
+
+//parseTrak get track and samples of trak,nil if codec not supported
+func parseTrak(data []byte) (*DemuxTrack, error) {
+	track := new(DemuxTrack)
+	table := new(sampleTable)
+	var (
+		sampleEntry []byte
+		handler     func(boxType string, body []byte) error
+	)
+	handler = func(boxType string, body []byte) error {
+		reader := &byteReader{data: body}
+		switch boxType {
+		case "tkhd":
+			if reader.read8() == 1 {
+				reader.skip(3 + 16)
+			} else {
+				reader.skip(3 + 8)
+			}
+			track.ID = reader.read32()
+		case "mdia", "minf", "stbl":
+			return walkBoxes(body, handler)
+		case "mdhd":
+			if reader.read8() == 1 {
+				reader.skip(3 + 16)
+			} else {
+				reader.skip(3 + 8)
+			}
+			track.TimeScale = reader.read32()
+		case "stsd":
+			reader.skip(4 + 4) // version,flags and entry_count
+			sampleEntry = reader.rest()
+		case "stts":
+			table.stts = body
+		case "ctts":
+			table.ctts = body
+		case "stsc":
+			table.stsc = body
+		case "stsz":
+			table.stsz = body
+		case "stz2":
+			table.stz2 = body
+		case "stco":
+			table.stco = body
+		case "co64":
+			table.co64 = body
+		case "stss":
+			table.stss, table.hasStss = body, true
+		}
+		return reader.err
+	}
+	if err := walkBoxes(data, handler); err != nil {
+		return nil, fmt.Errorf("trak error:%v", err)
+	}
+	if track.TimeScale == 0 {
+		return nil, fmt.Errorf("track %v has no timescale", track.ID)
+	}
+	supported, err := parseSampleEntry(track, sampleEntry)
+	if err != nil {
+		return nil, fmt.Errorf("track %v sample entry error:%v", track.ID, err)
+	}
+	if !supported {
+		return nil, nil
+	}
+	if track.Samples, err = table.samples(); err != nil {
+		return nil, fmt.Errorf("track %v sample table error:%v", track.ID, err)
+	}
+	return track, nil
+}
+
+//parseSampleEntry get codec and its config from the first sample entry,
+//false if codec not supported
+func parseSampleEntry(track *DemuxTrack, data []byte) (bool, error) {
+	if len(data) < 8 {
+		return false, fmt.Errorf("sample entry not found")
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 8 || size > len(data) {
+		return false, fmt.Errorf("sample entry size %v out of range", size)
+	}
+	entryType, entry := string(data[4:8]), data[8:size]
+	reader := &byteReader{data: entry}
+	switch entryType {
+	case "avc1", "hvc1", "hev1":
+		reader.skip(78) // VisualSampleEntry fields
+		track.Codec = CodecH264
+		configType := "avcC"
+		if entryType != "avc1" {
+			track.Codec, configType = CodecH265, "hvcC"
+		}
+		children := reader.rest()
+		if reader.err != nil {
+			return false, reader.err
+		}
+		var config []byte
+		walkBoxes(children, func(boxType string, body []byte) error {
+			if boxType == configType && config == nil {
+				config = body
+			}
+			return nil
+		})
+		if config == nil {
+			return false, fmt.Errorf("%v not found", configType)
+		}
+		if track.Codec == CodecH264 {
+			return true, track.parseAvcC(config)
+		}
+		return true, track.parseHvcC(config)
+	case "mp4a", "Opus":
+		reader.skip(8) // reserved and data_reference_index
+		version := reader.read16()
+		reader.skip(6)
+		track.ChannelCount = int(reader.read16())
+		reader.skip(6)
+		track.SampleRate = int(reader.read32() >> 16)
+		switch version {
+		case 1:
+			reader.skip(16)
+		case 2:
+			reader.skip(36)
+		}
+		children := reader.rest()
+		if reader.err != nil {
+			return false, reader.err
+		}
+		if entryType == "Opus" {
+			track.Codec = CodecOpus
+			walkBoxes(children, func(boxType string, body []byte) error {
+				if boxType == "dOps" && len(body) >= 8 {
+					track.ChannelCount = int(body[1])
+					track.SampleRate = int(binary.BigEndian.Uint32(body[4:]))
+				}
+				return nil
+			})
+			return true, nil
+		}
+		track.Codec = CodecAAC
+		walkBoxes(children, func(boxType string, body []byte) error {
+			if boxType == "esds" && track.AudioConfig == nil {
+				track.AudioConfig = parseEsds(body)
+			}
+			return nil
+		})
+		if track.AudioConfig == nil {
+			return false, fmt.Errorf("aac config not found in esds")
+		}
+		return true, nil
+	}
+	fmt.Printf("mp4 track %v sample entry %v not support\n", track.ID, entryType)
+	return false, nil
+}
+
+//parseAvcC get parameter sets and nalu length size from avcC
+func (track *DemuxTrack) parseAvcC(data []byte) error {
+	reader := &byteReader{data: data}
+	reader.skip(4) // version,profile,compatibility,level
+	track.LengthSize = int(reader.read8()&0x03) + 1
+	for _, parameterSets := range []*[]byte{&track.SPS, &track.PPS} {
+		count := int(reader.read8())
+		if parameterSets == &track.SPS {
+			count &= 0x1f
+		}
+		for i := 0; i < count && reader.err == nil; i++ {
+			nalu := reader.readBytes(int(reader.read16()))
+			if *parameterSets == nil {
+				*parameterSets = nalu
+			}
+		}
+	}
+	if reader.err != nil {
+		return fmt.Errorf("avcC error:%v", reader.err)
+	}
+	if track.SPS == nil || track.PPS == nil {
+		return fmt.Errorf("avcC lack sps or pps")
+	}
+	return nil
+}
+
+//parseHvcC get parameter sets and nalu length size from hvcC
+func (track *DemuxTrack) parseHvcC(data []byte) error {
+	reader := &byteReader{data: data}
+	reader.skip(21) // fields before lengthSizeMinusOne
+	track.LengthSize = int(reader.read8()&0x03) + 1
+	arrays := int(reader.read8())
+	for i := 0; i < arrays && reader.err == nil; i++ {
+		naluType := reader.read8() & 0x3f
+		count := int(reader.read16())
+		for j := 0; j < count && reader.err == nil; j++ {
+			nalu := reader.readBytes(int(reader.read16()))
+			switch {
+			case naluType == 32 && track.VPS == nil:
+				track.VPS = nalu
+			case naluType == 33 && track.SPS == nil:
+				track.SPS = nalu
+			case naluType == 34 && track.PPS == nil:
+				track.PPS = nalu
+			}
+		}
+	}
+	if reader.err != nil {
+		return fmt.Errorf("hvcC error:%v", reader.err)
+	}
+	if track.VPS == nil || track.SPS == nil || track.PPS == nil {
+		return fmt.Errorf("hvcC lack vps,sps or pps")
+	}
+	return nil
+}
+
+//parseEsds get DecoderSpecificInfo in esds(ISO 14496-1 7.2.6),nil if not found
+func parseEsds(data []byte) []byte {
+	reader := &byteReader{data: data}
+	reader.skip(4) // version and flags
+	for reader.err == nil {
+		tag := reader.read8()
+		length := 0
+		for i := 0; i < 4; i++ {
+			value := reader.read8()
+			length = length<<7 | int(value&0x7f)
+			if value&0x80 == 0 {
+				break
+			}
+		}
+		switch tag {
+		case 0x03: // ES_Descriptor
+			reader.skip(2)
+			flags := reader.read8()
+			if flags&0x80 != 0 {
+				reader.skip(2)
+			}
+			if flags&0x40 != 0 {
+				reader.skip(int(reader.read8()))
+			}
+			if flags&0x20 != 0 {
+				reader.skip(2)
+			}
+		case 0x04: // DecoderConfigDescriptor
+			reader.skip(13)
+		case 0x05: // DecoderSpecificInfo
+			return reader.readBytes(length)
+		default:
+			reader.skip(length)
+		}
+	}
+	return nil
+}
+
+//samples make samples from sample table(ISO 14496-12 8.6 and 8.7)
+func (table *sampleTable) samples() ([]*SampleInfo, error) {
+	sizes, err := table.sampleSizes()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]*SampleInfo, len(sizes))
+	for index, size := range sizes {
+		samples[index] = &SampleInfo{Size: size, IsSync: !table.hasStss}
+	}
+	reader := &byteReader{data: table.stts}
+	reader.skip(4)
+	decodeTime, index := uint64(0), 0
+	for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+		count, delta := reader.read32(), reader.read32()
+		for ; count > 0 && index < len(samples); count-- {
+			samples[index].DecodeTime = decodeTime
+			samples[index].Duration = delta
+			decodeTime += uint64(delta)
+			index++
+		}
+	}
+	if reader.err != nil {
+		return nil, fmt.Errorf("stts error:%v", reader.err)
+	}
+	if table.ctts != nil {
+		reader, index = &byteReader{data: table.ctts}, 0
+		reader.skip(4)
+		for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+			count, offset := reader.read32(), int32(reader.read32())
+			for ; count > 0 && index < len(samples); count-- {
+				samples[index].CompositionOffset = offset
+				index++
+			}
+		}
+		if reader.err != nil {
+			return nil, fmt.Errorf("ctts error:%v", reader.err)
+		}
+	}
+	if table.hasStss {
+		reader = &byteReader{data: table.stss}
+		reader.skip(4)
+		for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+			if number := reader.read32(); number >= 1 && int(number) <= len(samples) {
+				samples[number-1].IsSync = true
+			}
+		}
+		if reader.err != nil {
+			return nil, fmt.Errorf("stss error:%v", reader.err)
+		}
+	}
+	return samples, table.setOffsets(samples)
+}
+
+//sampleSizes sizes of samples from stsz or stz2
+func (table *sampleTable) sampleSizes() ([]uint32, error) {
+	if table.stsz == nil && table.stz2 == nil {
+		return nil, fmt.Errorf("stsz not found")
+	}
+	var sizes []uint32
+	if table.stsz != nil {
+		reader := &byteReader{data: table.stsz}
+		reader.skip(4)
+		sampleSize, count := reader.read32(), reader.read32()
+		if reader.err == nil && (count > uint32(len(table.stsz)) && sampleSize == 0 ||
+			uint64(count) > table.sampleCount()) {
+			return nil, fmt.Errorf("stsz sample count %v out of range", count)
+		}
+		sizes = make([]uint32, 0, count)
+		for i := uint32(0); i < count && reader.err == nil; i++ {
+			if sampleSize != 0 {
+				sizes = append(sizes, sampleSize)
+			} else {
+				sizes = append(sizes, reader.read32())
+			}
+		}
+		if reader.err != nil {
+			return nil, fmt.Errorf("stsz error:%v", reader.err)
+		}
+		return sizes, nil
+	}
+	reader := &byteReader{data: table.stz2}
+	reader.skip(4 + 3)
+	fieldSize, count := reader.read8(), reader.read32()
+	if reader.err == nil && (count > uint32(len(table.stz2))*2 ||
+		uint64(count) > table.sampleCount()) {
+		return nil, fmt.Errorf("stz2 sample count %v out of range", count)
+	}
+	sizes = make([]uint32, 0, count)
+	value := uint8(0) // byte of two 4 bits sizes
+	for i := uint32(0); i < count && reader.err == nil; i++ {
+		switch fieldSize {
+		case 4:
+			if i%2 == 0 {
+				value = reader.read8()
+				sizes = append(sizes, uint32(value>>4))
+			} else {
+				sizes = append(sizes, uint32(value&0x0f))
+			}
+		case 8:
+			sizes = append(sizes, uint32(reader.read8()))
+		case 16:
+			sizes = append(sizes, uint32(reader.read16()))
+		default:
+			return nil, fmt.Errorf("stz2 field size %v invalid", fieldSize)
+		}
+	}
+	if reader.err != nil {
+		return nil, fmt.Errorf("stz2 error:%v", reader.err)
+	}
+	return sizes, nil
+}
+
+//sampleCount number of samples timed by stts,not over maxSamples
+func (table *sampleTable) sampleCount() uint64 {
+	reader := &byteReader{data: table.stts}
+	reader.skip(4)
+	total := uint64(0)
+	for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+		total += uint64(reader.read32())
+		reader.skip(4)
+	}
+	if total > maxSamples {
+		return maxSamples
+	}
+	return total
+}
+
+//setOffsets set offsets of samples from chunk offsets and samples per chunk
+func (table *sampleTable) setOffsets(samples []*SampleInfo) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	chunkOffsets := make([]int64, 0)
+	if table.stco != nil {
+		reader := &byteReader{data: table.stco}
+		reader.skip(4)
+		for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+			chunkOffsets = append(chunkOffsets, int64(reader.read32()))
+		}
+		if reader.err != nil {
+			return fmt.Errorf("stco error:%v", reader.err)
+		}
+	} else if table.co64 != nil {
+		reader := &byteReader{data: table.co64}
+		reader.skip(4)
+		for entries := reader.read32(); entries > 0 && reader.err == nil; entries-- {
+			chunkOffsets = append(chunkOffsets, int64(reader.read64()))
+		}
+		if reader.err != nil {
+			return fmt.Errorf("co64 error:%v", reader.err)
+		}
+	}
+	reader := &byteReader{data: table.stsc}
+	reader.skip(4)
+	type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+	entries := make([]stscEntry, 0)
+	for count := reader.read32(); count > 0 && reader.err == nil; count-- {
+		entry := stscEntry{reader.read32(), reader.read32()}
+		reader.skip(4) // sample_description_index
+		entries = append(entries, entry)
+	}
+	if reader.err != nil {
+		return fmt.Errorf("stsc error:%v", reader.err)
+	}
+	index := 0
+	for entryIndex, entry := range entries {
+		lastChunk := uint32(len(chunkOffsets))
+		if entryIndex+1 < len(entries) {
+			lastChunk = entries[entryIndex+1].firstChunk - 1
+		}
+		for chunk := entry.firstChunk; chunk >= 1 && chunk <= lastChunk &&
+			int(chunk) <= len(chunkOffsets); chunk++ {
+			offset := chunkOffsets[chunk-1]
+			for i := uint32(0); i < entry.samplesPerChunk && index < len(samples); i++ {
+				samples[index].Offset = offset
+				offset += int64(samples[index].Size)
+				index++
+			}
+		}
+	}
+	if index != len(samples) {
+		return fmt.Errorf("chunks have %v of %v samples", index, len(samples))
+	}
+	return nil
+}
+
+//walkBoxes call handler with type and body of each box in data
+func walkBoxes(data []byte, handler func(boxType string, body []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("box header truncated")
+		}
+		size, headerSize := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return fmt.Errorf("box header truncated")
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return fmt.Errorf("box %s size %v out of range", data[4:8], size)
+		}
+		if err := handler(string(data[4:8]), data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package mp4
+
+import "encoding/binary"
+
+const (
+	//SampleFlagsSync flags of a sample not depending on others
+	SampleFlagsSync uint32 = 0x02000000
+	//SampleFlagsNonSync flags of a sample depending on others
+	SampleFlagsNonSync uint32 = 0x01010000
+)
+
+//Sample media sample in a fragment
+type Sample struct {
+	Duration          uint32 // in track timescale
+	CompositionOffset int32  // presentation time minus decode time
+	IsSync            bool   // if sample is a sync(key) sample
+	Data              []byte // length prefixed nal units for video
+}
+
+//FragmentTrack samples of a track in a fragment
+type FragmentTrack struct {
+	ID             uint32 // track id in init segment
+	BaseDecodeTime uint64 // decode time of the first sample
+	Samples        []*Sample
+}
+
+//MarshalFragment make moof and mdat of a fragment,
+//tracks without samples are skipped
+func MarshalFragment(sequenceNumber uint32, tracks []*FragmentTrack) []byte {
+	writer := new(boxWriter)
+	writer.startBox("moof")
+	writer.startFullBox("mfhd", 0, 0)
+	writer.write32(sequenceNumber)
+	writer.endBox()
+	dataOffsetPositions := make([]int, 0, len(tracks))
+	mdatSize := 8
+	for _, track := range tracks {
+		if len(track.Samples) == 0 {
+			continue
+		}
+		writer.startBox("traf")
+		writer.startFullBox("tfhd", 0, 0x020000) // default-base-is-moof
+		writer.write32(track.ID)
+		writer.endBox()
+		writer.startFullBox("tfdt", 1, 0)
+		writer.write64(track.BaseDecodeTime)
+		writer.endBox()
+		// data-offset,sample-duration,sample-size,sample-flags,
+		// sample-composition-time-offset present
+		writer.startFullBox("trun", 1, 0x000f01)
+		writer.write32(uint32(len(track.Samples)))
+		dataOffsetPositions = append(dataOffsetPositions, len(writer.buffer))
+		writer.write32(uint32(mdatSize))
+		for _, sample := range track.Samples {
+			writer.write32(sample.Duration)
+			writer.write32(uint32(len(sample.Data)))
+			if sample.IsSync {
+				writer.write32(SampleFlagsSync)
+			} else {
+				writer.write32(SampleFlagsNonSync)
+			}
+			writer.write32(uint32(sample.CompositionOffset))
+			mdatSize += len(sample.Data)
+		}
+		writer.endBox()
+		writer.endBox()
+	}
+	writer.endBox()
+	// data offsets are relative to moof
+	moofSize := len(writer.buffer)
+	for _, position := range dataOffsetPositions {
+		offset := binary.BigEndian.Uint32(writer.buffer[position:])
+		binary.BigEndian.PutUint32(writer.buffer[position:], offset+uint32(moofSize))
+	}
+	writer.startBox("mdat")
+	for _, track := range tracks {
+		for _, sample := range track.Samples {
+			writer.writeBytes(sample.Data)
+		}
+	}
+	writer.endBox()
+	return writer.buffer
+}
@@ -0,0 +1,319 @@
+package mp4
+
+import (
+	"fmt"
+
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/h265"
+)
+
+//Codec codec of track
+type Codec int
+
+const (
+	//CodecH264 h264 video in avc1 sample entry
+	CodecH264 Codec = 0
+	//CodecH265 h265 video in hvc1 sample entry
+	CodecH265 Codec = 1
+	//CodecAAC aac audio in mp4a sample entry
+	CodecAAC Codec = 2
+	//CodecOpus opus audio in Opus sample entry
+	CodecOpus Codec = 3
+)
+
+//IsVideo if codec is a video codec
+func (codec Codec) IsVideo() bool {
+	return codec == CodecH264 || codec == CodecH265
+}
+
+//Track track info in init segment
+type Track struct {
+	ID            uint32 // track id,starts from 1
+	TimeScale     uint32 // units of a second in timestamps
+	Codec         Codec
+	VPS, SPS, PPS []byte // parameter sets of video,VPS only for h265
+	SampleRate    int    // audio sample rate
+	ChannelCount  int    // audio channels
+	AudioConfig   []byte // AudioSpecificConfig of aac
+}
+
+//MarshalInit make init segment(ftyp and moov) of fragmented mp4
+func MarshalInit(tracks []*Track) ([]byte, error) {
+	writer := new(boxWriter)
+	writer.startBox("ftyp")
+	writer.writeBytes([]byte("iso5"))
+	writer.write32(512)
+	writer.writeBytes([]byte("iso5iso6mp41"))
+	writer.endBox()
+	writer.startBox("moov")
+	writer.startFullBox("mvhd", 0, 0)
+	writer.write32(0)    // creation_time
+	writer.write32(0)    // modification_time
+	writer.write32(1000) // timescale
+	writer.write32(0)    // duration
+	writer.write32(0x00010000)
+	writer.write16(0x0100)
+	writer.writeZeros(10)
+	writer.writeMatrix()
+	writer.writeZeros(24)
+	nextTrackID := uint32(1)
+	for _, track := range tracks {
+		if track.ID >= nextTrackID {
+			nextTrackID = track.ID + 1
+		}
+	}
+	writer.write32(nextTrackID)
+	writer.endBox()
+	for _, track := range tracks {
+		if err := writeTrak(writer, track); err != nil {
+			return nil, fmt.Errorf("track %v error:%v", track.ID, err)
+		}
+	}
+	writer.startBox("mvex")
+	for _, track := range tracks {
+		writer.startFullBox("trex", 0, 0)
+		writer.write32(track.ID)
+		writer.write32(1) // default_sample_description_index
+		writer.write32(0) // default_sample_duration
+		writer.write32(0) // default_sample_size
+		writer.write32(0) // default_sample_flags
+		writer.endBox()
+	}
+	writer.endBox()
+	writer.endBox()
+	return writer.buffer, nil
+}
+
+//writeTrak write trak box of track
+func writeTrak(writer *boxWriter, track *Track) error {
+	width, height := 0, 0
+	switch track.Codec {
+	case CodecH264:
+		sps, err := h264.ParseSPS(track.SPS)
+		if err != nil {
+			return err
+		}
+		width, height = sps.Width, sps.Height
+	case CodecH265:
+		sps, err := h265.ParseSPS(track.SPS)
+		if err != nil {
+			return err
+		}
+		width, height = sps.Width, sps.Height
+	}
+	writer.startBox("trak")
+	writer.startFullBox("tkhd", 0, 3) // track_enabled,track_in_movie
+	writer.write32(0)                 // creation_time
+	writer.write32(0)                 // modification_time
+	writer.write32(track.ID)
+	writer.write32(0) // reserved
+	writer.write32(0) // duration
+	writer.writeZeros(8)
+	writer.write16(0) // layer
+	writer.write16(0) // alternate_group
+	if track.Codec.IsVideo() {
+		writer.write16(0)
+	} else {
+		writer.write16(0x0100)
+	}
+	writer.write16(0)
+	writer.writeMatrix()
+	writer.write32(uint32(width) << 16)
+	writer.write32(uint32(height) << 16)
+	writer.endBox()
+	writer.startBox("mdia")
+	writer.startFullBox("mdhd", 0, 0)
+	writer.write32(0) // creation_time
+	writer.write32(0) // modification_time
+	writer.write32(track.TimeScale)
+	writer.write32(0)      // duration
+	writer.write16(0x55c4) // language und
+	writer.write16(0)
+	writer.endBox()
+	writer.startFullBox("hdlr", 0, 0)
+	writer.write32(0)
+	if track.Codec.IsVideo() {
+		writer.writeBytes([]byte("vide"))
+		writer.writeZeros(12)
+		writer.writeBytes([]byte("VideoHandler\x00"))
+	} else {
+		writer.writeBytes([]byte("soun"))
+		writer.writeZeros(12)
+		writer.writeBytes([]byte("SoundHandler\x00"))
+	}
+	writer.endBox()
+	writer.startBox("minf")
+	if track.Codec.IsVideo() {
+		writer.startFullBox("vmhd", 0, 1)
+		writer.writeZeros(8)
+	} else {
+		writer.startFullBox("smhd", 0, 0)
+		writer.writeZeros(4)
+	}
+	writer.endBox()
+	writer.startBox("dinf")
+	writer.startFullBox("dref", 0, 0)
+	writer.write32(1)
+	writer.startFullBox("url ", 0, 1) // media data in the same file
+	writer.endBox()
+	writer.endBox()
+	writer.endBox()
+	writer.startBox("stbl")
+	writer.startFullBox("stsd", 0, 0)
+	writer.write32(1)
+	if err := writeSampleEntry(writer, track, width, height); err != nil {
+		return err
+	}
+	writer.endBox()
+	for _, boxType := range []string{"stts", "stsc", "stco"} {
+		writer.startFullBox(boxType, 0, 0)
+		writer.write32(0) // entry_count
+		writer.endBox()
+	}
+	writer.startFullBox("stsz", 0, 0)
+	writer.write32(0) // sample_size
+	writer.write32(0) // sample_count
+	writer.endBox()
+	writer.endBox()
+	writer.endBox()
+	writer.endBox()
+	writer.endBox()
+	return nil
+}
+
+//writeSampleEntry write sample entry and codec config box of track
+func writeSampleEntry(writer *boxWriter, track *Track, width, height int) error {
+	switch track.Codec {
+	case CodecH264:
+		if len(track.SPS) < 4 || len(track.PPS) == 0 {
+			return fmt.Errorf("h264 parameter sets missing")
+		}
+		writeVisualSampleEntry(writer, "avc1", width, height)
+		writer.startBox("avcC")
+		writer.write8(1)                  // configurationVersion
+		writer.writeBytes(track.SPS[1:4]) // profile,compatibility,level
+		writer.write8(0xff)               // lengthSizeMinusOne = 3
+		writer.write8(0xe1)               // one sps
+		writer.write16(uint16(len(track.SPS)))
+		writer.writeBytes(track.SPS)
+		writer.write8(1) // one pps
+		writer.write16(uint16(len(track.PPS)))
+		writer.writeBytes(track.PPS)
+		writer.endBox()
+		writer.endBox()
+	case CodecH265:
+		if len(track.VPS) == 0 || len(track.PPS) == 0 {
+			return fmt.Errorf("h265 parameter sets missing")
+		}
+		sps, err := h265.ParseSPS(track.SPS)
+		if err != nil {
+			return err
+		}
+		writeVisualSampleEntry(writer, "hvc1", width, height)
+		writer.startBox("hvcC")
+		writer.write8(1) // configurationVersion
+		profile := sps.ProfileSpace<<6 | sps.ProfileIdc
+		if sps.TierFlag {
+			profile |= 0x20
+		}
+		writer.write8(profile)
+		writer.write32(sps.ProfileCompatibility)
+		writer.write16(uint16(sps.ConstraintIndicator >> 32))
+		writer.write32(uint32(sps.ConstraintIndicator))
+		writer.write8(sps.LevelIdc)
+		writer.write16(0xf000) // min_spatial_segmentation_idc
+		writer.write8(0xfc)    // parallelismType
+		writer.write8(0xfc | uint8(sps.ChromaFormatIdc))
+		writer.write8(0xf8 | uint8(sps.BitDepthLumaMinus8))
+		writer.write8(0xf8 | uint8(sps.BitDepthChromaMinus8))
+		writer.write16(0) // avgFrameRate
+		nesting := uint8(0)
+		if sps.TemporalIDNestingFlag {
+			nesting = 1
+		}
+		// numTemporalLayers,temporalIdNested,lengthSizeMinusOne = 3
+		writer.write8((sps.MaxSubLayersMinus1+1)<<3 | nesting<<2 | 0x03)
+		writer.write8(3) // numOfArrays
+		for _, nalu := range [][]byte{track.VPS, track.SPS, track.PPS} {
+			writer.write8(0x80 | uint8(h265.TypeOf(nalu)))
+			writer.write16(1)
+			writer.write16(uint16(len(nalu)))
+			writer.writeBytes(nalu)
+		}
+		writer.endBox()
+		writer.endBox()
+	case CodecAAC:
+		if len(track.AudioConfig) == 0 {
+			return fmt.Errorf("aac config missing")
+		}
+		writeAudioSampleEntry(writer, "mp4a", track)
+		writer.startFullBox("esds", 0, 0)
+		// ES_Descriptor,DecoderConfigDescriptor,DecoderSpecificInfo,SLConfigDescriptor
+		writer.write8(0x03)
+		writer.write8(uint8(23 + len(track.AudioConfig)))
+		writer.write16(uint16(track.ID))
+		writer.write8(0)
+		writer.write8(0x04)
+		writer.write8(uint8(15 + len(track.AudioConfig)))
+		writer.write8(0x40) // objectTypeIndication,mpeg4 audio
+		writer.write8(0x15) // streamType audio
+		writer.write24(0)   // bufferSizeDB
+		writer.write32(0)   // maxBitrate
+		writer.write32(0)   // avgBitrate
+		writer.write8(0x05)
+		writer.write8(uint8(len(track.AudioConfig)))
+		writer.writeBytes(track.AudioConfig)
+		writer.write8(0x06)
+		writer.write8(1)
+		writer.write8(0x02)
+		writer.endBox()
+		writer.endBox()
+	case CodecOpus:
+		writeAudioSampleEntry(writer, "Opus", track)
+		writer.startBox("dOps")
+		writer.write8(0) // Version
+		writer.write8(uint8(track.ChannelCount))
+		writer.write16(0) // PreSkip
+		writer.write32(uint32(track.SampleRate))
+		writer.write16(0) // OutputGain
+		writer.write8(0)  // ChannelMappingFamily
+		writer.endBox()
+		writer.endBox()
+	default:
+		return fmt.Errorf("codec %v not support", track.Codec)
+	}
+	return nil
+}
+
+//writeVisualSampleEntry begin a visual sample entry,ended by caller
+func writeVisualSampleEntry(writer *boxWriter, boxType string, width, height int) {
+	writer.startBox(boxType)
+	writer.writeZeros(6)
+	writer.write16(1) // data_reference_index
+	writer.writeZeros(16)
+	writer.write16(uint16(width))
+	writer.write16(uint16(height))
+	writer.write32(0x00480000) // horizresolution 72 dpi
+	writer.write32(0x00480000) // vertresolution 72 dpi
+	writer.write32(0)
+	writer.write16(1) // frame_count
+	writer.writeZeros(32)
+	writer.write16(0x0018) // depth
+	writer.write16(0xffff) // pre_defined = -1
+}
+
+//writeAudioSampleEntry begin an audio sample entry,ended by caller
+func writeAudioSampleEntry(writer *boxWriter, boxType string, track *Track) {
+	writer.startBox(boxType)
+	writer.writeZeros(6)
+	writer.write16(1) // data_reference_index
+	writer.writeZeros(8)
+	writer.write16(uint16(track.ChannelCount))
+	writer.write16(16) // samplesize
+	writer.writeZeros(4)
+	if track.SampleRate < 1<<16 {
+		writer.write32(uint32(track.SampleRate) << 16)
+	} else {
+		writer.write32(0)
+	}
+}
@@ -0,0 +1,281 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+//boxTypes types of boxes directly in data
+func boxTypes(t *testing.T, data []byte) []string {
+	types := make([]string, 0)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("box header truncated")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("box %s size %v invalid", data[4:8], size)
+		}
+		types = append(types, string(data[4:8]))
+		data = data[size:]
+	}
+	return types
+}
+
+//findBox find the first box of path like moov/trak
+func findBox(data []byte, path ...string) []byte {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if string(data[4:8]) == path[0] {
+			if len(path) == 1 {
+				return data[:size]
+			}
+			return findBox(data[8:size], path[1:]...)
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func TestMarshalInit(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5,
+		0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0,
+		0x3c, 0x60, 0xc6, 0x58}
+	init, err := MarshalInit([]*Track{
+		{ID: 1, TimeScale: 90000, Codec: CodecH264, SPS: sps, PPS: []byte{0x68, 0xeb}},
+		{ID: 2, TimeScale: 44100, Codec: CodecAAC, SampleRate: 44100, ChannelCount: 2,
+			AudioConfig: []byte{0x12, 0x10}},
+	})
+	if err != nil {
+		t.Fatalf("MarshalInit error:%v", err)
+	}
+	if types := boxTypes(t, init); len(types) != 2 || types[0] != "ftyp" || types[1] != "moov" {
+		t.Fatalf("init boxes = %v", types)
+	}
+	moov := findBox(init, "moov")
+	if types := boxTypes(t, moov[8:]); len(types) != 4 || types[3] != "mvex" {
+		t.Errorf("moov boxes = %v", types)
+	}
+	tkhd := findBox(moov[8:], "trak", "tkhd")
+	if width := binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16; width != 1920 {
+		t.Errorf("tkhd width = %v, want 1920", width)
+	}
+	if avcC := findBox(moov[8:], "trak", "mdia", "minf", "stbl", "stsd"); !bytes.Contains(avcC, sps) {
+		t.Errorf("stsd has no sps")
+	}
+	if _, err := MarshalInit([]*Track{{ID: 1, Codec: CodecH264}}); err == nil {
+		t.Errorf("MarshalInit without sps should fail")
+	}
+}
+
+func TestMarshalFragment(t *testing.T) {
+	fragment := MarshalFragment(1, []*FragmentTrack{
+		{ID: 1, BaseDecodeTime: 3000, Samples: []*Sample{
+			{Duration: 3000, IsSync: true, Data: []byte{0, 0, 0, 1, 0x65}},
+			{Duration: 3000, Data: []byte{0, 0, 0, 1, 0x41}},
+		}},
+		{ID: 2},
+		{ID: 3, Samples: []*Sample{{Duration: 1024, IsSync: true, Data: []byte{0x21}}}},
+	})
+	if types := boxTypes(t, fragment); len(types) != 2 || types[0] != "moof" || types[1] != "mdat" {
+		t.Fatalf("fragment boxes = %v", types)
+	}
+	moof := findBox(fragment, "moof")
+	if types := boxTypes(t, moof[8:]); len(types) != 3 {
+		t.Fatalf("moof boxes = %v, want mfhd and two traf", types)
+	}
+	trun := findBox(moof[8:], "traf", "trun")
+	dataOffset := binary.BigEndian.Uint32(trun[16:])
+	if !bytes.Equal(fragment[dataOffset:dataOffset+5], []byte{0, 0, 0, 1, 0x65}) {
+		t.Errorf("trun data_offset %v not point to first sample", dataOffset)
+	}
+	if binary.BigEndian.Uint32(trun[12:]) != 2 {
+		t.Errorf("trun sample_count wrong")
+	}
+}
+
+//testSPS sps of 1920x1080 h264 high profile
+var testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5,
+	0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0,
+	0x3c, 0x60, 0xc6, 0x58}
+
+func TestDemuxFragmented(t *testing.T) {
+	tracks := []*Track{
+		{ID: 1, TimeScale: 90000, Codec: CodecH264, SPS: testSPS, PPS: []byte{0x68, 0xeb}},
+		{ID: 2, TimeScale: 44100, Codec: CodecAAC, SampleRate: 44100, ChannelCount: 2,
+			AudioConfig: []byte{0x12, 0x10}},
+	}
+	file, err := MarshalInit(tracks)
+	if err != nil {
+		t.Fatalf("MarshalInit error:%v", err)
+	}
+	file = append(file, MarshalFragment(1, []*FragmentTrack{
+		{ID: 1, Samples: []*Sample{
+			{Duration: 3000, IsSync: true, Data: []byte{0, 0, 0, 1, 0x65}},
+			{Duration: 3000, CompositionOffset: 3000, Data: []byte{0, 0, 0, 1, 0x41}},
+		}},
+		{ID: 2, Samples: []*Sample{{Duration: 1024, IsSync: true, Data: []byte{0x21}}}},
+	})...)
+	file = append(file, MarshalFragment(2, []*FragmentTrack{
+		{ID: 1, BaseDecodeTime: 6000, Samples: []*Sample{
+			{Duration: 3000, IsSync: true, Data: []byte{0, 0, 0, 1, 0x65, 0x88}},
+		}},
+	})...)
+	demuxer, err := NewDemuxer(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("NewDemuxer error:%v", err)
+	}
+	if len(demuxer.Tracks) != 2 {
+		t.Fatalf("tracks = %v, want 2", len(demuxer.Tracks))
+	}
+	video, audio := demuxer.Tracks[0], demuxer.Tracks[1]
+	if video.Codec != CodecH264 || !bytes.Equal(video.SPS, testSPS) || video.LengthSize != 4 ||
+		video.TimeScale != 90000 {
+		t.Errorf("video track = %+v", video.Track)
+	}
+	if audio.Codec != CodecAAC || !bytes.Equal(audio.AudioConfig, []byte{0x12, 0x10}) ||
+		audio.SampleRate != 44100 || audio.ChannelCount != 2 {
+		t.Errorf("audio track = %+v", audio.Track)
+	}
+	if len(video.Samples) != 3 || video.Duration != 9000 {
+		t.Fatalf("video samples = %v,duration = %v", len(video.Samples), video.Duration)
+	}
+	for index, want := range []struct {
+		decodeTime uint64
+		offset     int32
+		sync       bool
+		data       []byte
+	}{
+		{0, 0, true, []byte{0, 0, 0, 1, 0x65}},
+		{3000, 3000, false, []byte{0, 0, 0, 1, 0x41}},
+		{6000, 0, true, []byte{0, 0, 0, 1, 0x65, 0x88}},
+	} {
+		sample := video.Samples[index]
+		data, err := demuxer.ReadSample(sample)
+		if err != nil {
+			t.Fatalf("ReadSample error:%v", err)
+		}
+		if sample.DecodeTime != want.decodeTime || sample.CompositionOffset != want.offset ||
+			sample.IsSync != want.sync || !bytes.Equal(data, want.data) {
+			t.Errorf("sample %v = %+v,data %x", index, sample, data)
+		}
+	}
+	if data, err := demuxer.ReadSample(audio.Samples[0]); err != nil || !bytes.Equal(data, []byte{0x21}) {
+		t.Errorf("audio sample = %x,error %v", data, err)
+	}
+}
+
+func TestDemuxProgressive(t *testing.T) {
+	track := &Track{ID: 1, TimeScale: 48000, Codec: CodecAAC, SampleRate: 48000,
+		ChannelCount: 1, AudioConfig: []byte{0x11, 0x88}}
+	samples := [][]byte{{1}, {2, 2}, {3, 3, 3}, {4, 4, 4, 4}, {5}}
+	writer := new(boxWriter)
+	writer.startBox("ftyp")
+	writer.writeBytes([]byte("isom"))
+	writer.write32(0)
+	writer.endBox()
+	writer.startBox("mdat")
+	mdatOffset := len(writer.buffer)
+	for _, sample := range samples {
+		writer.writeBytes(sample)
+	}
+	writer.endBox()
+	writer.startBox("moov")
+	writer.startBox("trak")
+	writer.startFullBox("tkhd", 0, 3)
+	writer.writeZeros(8)
+	writer.write32(track.ID)
+	writer.writeZeros(72)
+	writer.endBox()
+	writer.startBox("mdia")
+	writer.startFullBox("mdhd", 0, 0)
+	writer.writeZeros(8)
+	writer.write32(track.TimeScale)
+	writer.writeZeros(8)
+	writer.endBox()
+	writer.startBox("minf")
+	writer.startBox("stbl")
+	writer.startFullBox("stsd", 0, 0)
+	writer.write32(1)
+	if err := writeSampleEntry(writer, track, 0, 0); err != nil {
+		t.Fatalf("writeSampleEntry error:%v", err)
+	}
+	writer.endBox()
+	writer.startFullBox("stts", 0, 0)
+	writer.write32(2)
+	writer.write32(4)
+	writer.write32(1024)
+	writer.write32(1)
+	writer.write32(960)
+	writer.endBox()
+	// chunks of 2,2 and 1 samples
+	writer.startFullBox("stsc", 0, 0)
+	writer.write32(2)
+	for _, value := range []uint32{1, 2, 1, 3, 1, 1} {
+		writer.write32(value)
+	}
+	writer.endBox()
+	writer.startFullBox("stsz", 0, 0)
+	writer.write32(0)
+	writer.write32(uint32(len(samples)))
+	for _, sample := range samples {
+		writer.write32(uint32(len(sample)))
+	}
+	writer.endBox()
+	writer.startFullBox("co64", 0, 0)
+	writer.write32(3)
+	for _, offset := range []int{0, 3, 10} {
+		writer.write64(uint64(mdatOffset + offset))
+	}
+	writer.endBox()
+	writer.startFullBox("stss", 0, 0)
+	writer.write32(1)
+	writer.write32(3)
+	writer.endBox()
+	for i := 0; i < 5; i++ {
+		writer.endBox()
+	}
+	file := writer.buffer
+	demuxer, err := NewDemuxer(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("NewDemuxer error:%v", err)
+	}
+	audio := demuxer.Tracks[0]
+	if audio.Codec != CodecAAC || audio.ChannelCount != 1 || audio.Duration != 4*1024+960 {
+		t.Fatalf("track = %+v,duration %v", audio.Track, audio.Duration)
+	}
+	for index, sample := range audio.Samples {
+		data, err := demuxer.ReadSample(sample)
+		if err != nil || !bytes.Equal(data, samples[index]) {
+			t.Errorf("sample %v = %x,error %v", index, data, err)
+		}
+		if sample.IsSync != (index == 2) || sample.DecodeTime != uint64(index*1024) {
+			t.Errorf("sample %v = %+v", index, sample)
+		}
+	}
+	if _, err := NewDemuxer(bytes.NewReader(file[:mdatOffset]), int64(mdatOffset)); err == nil {
+		t.Errorf("NewDemuxer of truncated file should fail")
+	}
+}
+
+func TestDemuxOversizedCount(t *testing.T) {
+	stts := new(boxWriter)
+	stts.writeZeros(4)
+	stts.write32(1)
+	stts.write32(5)
+	stts.write32(1024)
+	stsz := new(boxWriter)
+	stsz.writeZeros(4)
+	stsz.write32(1)
+	stsz.write32(0xFFFFFFFF)
+	table := &sampleTable{stts: stts.buffer, stsz: stsz.buffer}
+	if _, err := table.sampleSizes(); err == nil {
+		t.Errorf("sampleSizes of stsz count over stts should fail")
+	}
+	file := make([]byte, 100)
+	demuxer := &Demuxer{reader: bytes.NewReader(file), size: int64(len(file))}
+	if _, err := demuxer.ReadSample(&SampleInfo{Offset: 50, Size: 0xFFFFFFFF}); err == nil {
+		t.Errorf("ReadSample past end of file should fail")
+	}
+}
@@ -0,0 +1,108 @@
+package opus
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//ClockRate rtp clock rate of opus is always 48000(rfc7587 4.1)
+const ClockRate = 48000
+
+//MaxPacketDuration max duration of an opus packet(rfc6716 3.2.5)
+const MaxPacketDuration = 120 * time.Millisecond
+
+//frameDurations frame duration of toc config(rfc6716 3.1),in 1/10 ms
+var frameDurations = [32]int{
+	100, 200, 400, 600, 100, 200, 400, 600, 100, 200, 400, 600, // SILK
+	100, 200, 100, 200, // Hybrid
+	25, 50, 100, 200, 25, 50, 100, 200, 25, 50, 100, 200, 25, 50, 100, 200, // CELT
+}
+
+//FrameCount frames in opus packet from toc byte code
+func FrameCount(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, fmt.Errorf("empty opus packet")
+	}
+	switch packet[0] & 0x03 {
+	case 0:
+		return 1, nil
+	case 1, 2:
+		return 2, nil
+	default:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("opus code 3 packet has no frame count byte")
+		}
+		return int(packet[1] & 0x3f), nil
+	}
+}
+
+//PacketDuration duration of opus packet from toc byte
+func PacketDuration(packet []byte) (time.Duration, error) {
+	count, err := FrameCount(packet)
+	if err != nil {
+		return 0, err
+	}
+	duration := time.Duration(count*frameDurations[packet[0]>>3]) * 100 * time.Microsecond
+	if duration > MaxPacketDuration {
+		return 0, fmt.Errorf("opus packet duration %v exceeds %v", duration, MaxPacketDuration)
+	}
+	return duration, nil
+}
+
+//Packet an opus packet with rtp timestamp
+type Packet struct {
+	Data      []byte // opus packet
+	Timestamp uint32 // rtp timestamp
+	Samples   int    // samples in 48khz
+}
+
+//Depacketize get opus packet from rtp packet,one opus packet per rtp packet
+func Depacketize(packet *rtp.Packet) (*Packet, error) {
+	duration, err := PacketDuration(packet.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{
+		Data:      append([]byte(nil), packet.Payload...),
+		Timestamp: packet.Timestamp,
+		Samples:   int(duration * ClockRate / time.Second),
+	}, nil
+}
+
+//Packetizer put opus packets into rtp packets
+type Packetizer struct {
+	PayloadType    uint8  // rtp payload type
+	SSRC           uint32 // rtp ssrc
+	SequenceNumber uint16 // sequence number of next packet
+}
+
+//NewPacketizer make an opus packetizer with random ssrc and sequence number
+func NewPacketizer(payloadType uint8, ssrc uint32) *Packetizer {
+	if ssrc == 0 {
+		ssrc = rand.Uint32()
+	}
+	return &Packetizer{
+		PayloadType:    payloadType,
+		SSRC:           ssrc,
+		SequenceNumber: uint16(rand.Uint32()),
+	}
+}
+
+//Packetize put an opus packet into a rtp packet
+func (packetizer *Packetizer) Packetize(data []byte, timestamp uint32) *rtp.Packet {
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        rtp.Version,
+			PayloadType:    packetizer.PayloadType,
+			SequenceNumber: packetizer.SequenceNumber,
+			Timestamp:      timestamp,
+			SSRC:           packetizer.SSRC,
+		},
+		Payload: data,
+	}
+	packetizer.SequenceNumber++
+	return packet
+}
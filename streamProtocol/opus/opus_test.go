@@ -0,0 +1,34 @@
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketDuration(t *testing.T) {
+	cases := []struct {
+		packet   []byte
+		duration time.Duration
+	}{
+		{[]byte{0xfc}, 20 * time.Millisecond},       // CELT 20ms,1 frame
+		{[]byte{0x0d}, 40 * time.Millisecond},       // SILK 20ms,2 frames
+		{[]byte{0x83, 0x04}, 10 * time.Millisecond}, // CELT 2.5ms,4 frames
+		{[]byte{0x1b, 0x03}, 0},                     // SILK 60ms,3 frames exceeds max
+	}
+	for _, c := range cases {
+		duration, err := PacketDuration(c.packet)
+		if c.duration == 0 {
+			if err == nil {
+				t.Errorf("PacketDuration(%x) should fail", c.packet)
+			}
+			continue
+		}
+		if err != nil || duration != c.duration {
+			t.Errorf("PacketDuration(%x) = %v,%v, want %v", c.packet, duration, err, c.duration)
+		}
+	}
+	packet, err := Depacketize(NewPacketizer(111, 1).Packetize([]byte{0xfc, 0x01}, 960))
+	if err != nil || packet.Samples != 960 || packet.Timestamp != 960 {
+		t.Errorf("Depacketize = %+v,%v", packet, err)
+	}
+}
@@ -0,0 +1,147 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	//FormatGenericNACK generic nack of RTPFB(rfc4585 6.2.1)
+	FormatGenericNACK uint8 = 1
+	//FormatTransportCC transport-wide congestion control of RTPFB
+	FormatTransportCC uint8 = 15
+	//FormatPLI picture loss indication of PSFB(rfc4585 6.3.1)
+	FormatPLI uint8 = 1
+	//FormatSLI slice loss indication of PSFB(rfc4585 6.3.2)
+	FormatSLI uint8 = 2
+	//FormatFIR full intra request of PSFB(rfc5104 4.3.1)
+	FormatFIR uint8 = 4
+	//FormatAFB application layer feedback of PSFB,like REMB
+	FormatAFB uint8 = 15
+)
+
+//Feedback common part of RTPFB and PSFB(rfc4585 6.1)
+type Feedback struct {
+	Format     uint8  // feedback message type
+	SenderSSRC uint32 // ssrc of packet sender
+	MediaSSRC  uint32 // ssrc of media source
+	FCI        []byte // feedback control information
+}
+
+//unmarshal parse feedback with packet type
+func (feedback *Feedback) unmarshal(buf []byte, packetType PacketType) error {
+	header, err := checkHeader(buf, packetType, HeaderLength+8)
+	if err != nil {
+		return err
+	}
+	feedback.Format = header.Count
+	feedback.SenderSSRC = binary.BigEndian.Uint32(buf[4:])
+	feedback.MediaSSRC = binary.BigEndian.Uint32(buf[8:])
+	feedback.FCI = buf[12:]
+	return nil
+}
+
+//marshal marshal feedback with packet type
+func (feedback *Feedback) marshal(packetType PacketType) ([]byte, error) {
+	if len(feedback.FCI)%4 != 0 {
+		return nil, fmt.Errorf("feedback fci length %v not multiple of 4", len(feedback.FCI))
+	}
+	buf, err := newPacketBuffer(HeaderLength+8+len(feedback.FCI),
+		int(feedback.Format), packetType)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(buf[4:], feedback.SenderSSRC)
+	binary.BigEndian.PutUint32(buf[8:], feedback.MediaSSRC)
+	copy(buf[12:], feedback.FCI)
+	return buf, nil
+}
+
+//TransportLayerFeedback generic rtp feedback(RTPFB)
+type TransportLayerFeedback struct {
+	Feedback
+}
+
+//Unmarshal parse RTPFB
+func (feedback *TransportLayerFeedback) Unmarshal(buf []byte) error {
+	return feedback.unmarshal(buf, TypeTransportLayerFeedback)
+}
+
+//Marshal marshal RTPFB
+func (feedback *TransportLayerFeedback) Marshal() ([]byte, error) {
+	return feedback.marshal(TypeTransportLayerFeedback)
+}
+
+//NackPair one generic nack fci entry
+type NackPair struct {
+	PacketID    uint16 // first lost packet's sequence number
+	LostPackets uint16 // bitmask of following lost packets
+}
+
+//SequenceNumbers all lost sequence numbers in this nack pair
+func (pair NackPair) SequenceNumbers() []uint16 {
+	numbers := []uint16{pair.PacketID}
+	for i := uint16(0); i < 16; i++ {
+		if pair.LostPackets&(1<<i) != 0 {
+			numbers = append(numbers, pair.PacketID+i+1)
+		}
+	}
+	return numbers
+}
+
+//Nacks parse generic nack fci
+func (feedback *TransportLayerFeedback) Nacks() ([]NackPair, error) {
+	if feedback.Format != FormatGenericNACK {
+		return nil, fmt.Errorf("RTPFB format %v is not generic nack", feedback.Format)
+	}
+	nacks := make([]NackPair, len(feedback.FCI)/4)
+	for i := range nacks {
+		nacks[i].PacketID = binary.BigEndian.Uint16(feedback.FCI[4*i:])
+		nacks[i].LostPackets = binary.BigEndian.Uint16(feedback.FCI[4*i+2:])
+	}
+	return nacks, nil
+}
+
+//NewGenericNACK make generic nack feedback
+func NewGenericNACK(senderSSRC, mediaSSRC uint32, nacks []NackPair) *TransportLayerFeedback {
+	fci := make([]byte, 4*len(nacks))
+	for i, nack := range nacks {
+		binary.BigEndian.PutUint16(fci[4*i:], nack.PacketID)
+		binary.BigEndian.PutUint16(fci[4*i+2:], nack.LostPackets)
+	}
+	return &TransportLayerFeedback{Feedback{
+		Format:     FormatGenericNACK,
+		SenderSSRC: senderSSRC,
+		MediaSSRC:  mediaSSRC,
+		FCI:        fci,
+	}}
+}
+
+//PayloadSpecificFeedback payload-specific feedback(PSFB)
+type PayloadSpecificFeedback struct {
+	Feedback
+}
+
+//Unmarshal parse PSFB
+func (feedback *PayloadSpecificFeedback) Unmarshal(buf []byte) error {
+	return feedback.unmarshal(buf, TypePayloadSpecificFeedback)
+}
+
+//Marshal marshal PSFB
+func (feedback *PayloadSpecificFeedback) Marshal() ([]byte, error) {
+	return feedback.marshal(TypePayloadSpecificFeedback)
+}
+
+//IfKeyframeRequest if this feedback asks for a keyframe(PLI or FIR)
+func (feedback *PayloadSpecificFeedback) IfKeyframeRequest() bool {
+	return feedback.Format == FormatPLI || feedback.Format == FormatFIR
+}
+
+//NewPictureLossIndication make PLI feedback
+func NewPictureLossIndication(senderSSRC, mediaSSRC uint32) *PayloadSpecificFeedback {
+	return &PayloadSpecificFeedback{Feedback{
+		Format:     FormatPLI,
+		SenderSSRC: senderSSRC,
+		MediaSSRC:  mediaSSRC,
+	}}
+}
@@ -0,0 +1,27 @@
+package rtcp
+
+import (
+	"time"
+)
+
+//ntpEpochOffset seconds from 1900-01-01 to 1970-01-01
+const ntpEpochOffset = 2208988800
+
+//NTPTime convert time to 64-bit ntp timestamp
+func NTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+//NTPToTime convert 64-bit ntp timestamp to time
+func NTPToTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanoseconds))
+}
+
+//NTPMiddle the middle 32 bits of 64-bit ntp timestamp,used as LSR
+func NTPMiddle(ntp uint64) uint32 {
+	return uint32(ntp >> 16)
+}
@@ -0,0 +1,139 @@
+package rtcp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	//maxDropout max forward jump of sequence number still treated as in order
+	maxDropout = 3000
+	//maxMisorder max backward jump of sequence number treated as reordering
+	maxMisorder = 100
+	//sequenceModulo sequence number cycle
+	sequenceModulo = 1 << 16
+)
+
+//ReceptionStatistics statistics of received rtp packets from one source,
+//to generate reception report blocks(rfc3550 appendix A.1,A.3,A.8)
+type ReceptionStatistics struct {
+	SSRC          uint32 // source ssrc,updated by the latest packet
+	ClockRate     int    // rtp clock rate of the source
+	mutex         sync.Mutex
+	initialized   bool
+	maxSeq        uint16
+	cycles        uint32
+	baseSeq       uint32
+	badSeq        uint32
+	received      uint32
+	expectedPrior uint32
+	receivedPrior uint32
+	transit       int64
+	jitter        float64
+	lastSR        uint32
+	lastSRTime    time.Time
+}
+
+//NewReceptionStatistics make reception statistics for source of clockRate
+func NewReceptionStatistics(clockRate int) *ReceptionStatistics {
+	return &ReceptionStatistics{ClockRate: clockRate, badSeq: sequenceModulo + 1}
+}
+
+//UpdatePacket update statistics with a received rtp packet's header fields
+func (stats *ReceptionStatistics) UpdatePacket(ssrc uint32,
+	sequenceNumber uint16, timestamp uint32, arrival time.Time) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	if !stats.initialized || ssrc != stats.SSRC {
+		stats.initialized = true
+		stats.SSRC = ssrc
+		stats.resetSequence(sequenceNumber)
+		stats.maxSeq = sequenceNumber - 1
+		stats.expectedPrior = 0
+		stats.receivedPrior = 0
+		stats.jitter = 0
+		stats.transit = 0
+	}
+	delta := sequenceNumber - stats.maxSeq
+	switch {
+	case delta < maxDropout:
+		// in order,with permissible gap
+		if sequenceNumber < stats.maxSeq {
+			stats.cycles += sequenceModulo
+		}
+		stats.maxSeq = sequenceNumber
+	case delta <= sequenceModulo-maxMisorder:
+		// the sequence number made a very large jump
+		if uint32(sequenceNumber) == stats.badSeq {
+			// two sequential packets,assume the other side restarted
+			stats.resetSequence(sequenceNumber)
+		} else {
+			stats.badSeq = (uint32(sequenceNumber) + 1) & (sequenceModulo - 1)
+			return
+		}
+	default:
+		// duplicate or reordered packet
+	}
+	stats.received++
+	if stats.ClockRate > 0 {
+		arrivalUnits := arrival.UnixNano() * int64(stats.ClockRate) / int64(time.Second)
+		transit := arrivalUnits - int64(timestamp)
+		if stats.transit != 0 {
+			d := transit - stats.transit
+			if d < 0 {
+				d = -d
+			}
+			stats.jitter += (float64(d) - stats.jitter) / 16
+		}
+		stats.transit = transit
+	}
+}
+
+//resetSequence start counting from sequenceNumber
+func (stats *ReceptionStatistics) resetSequence(sequenceNumber uint16) {
+	stats.baseSeq = uint32(sequenceNumber)
+	stats.maxSeq = sequenceNumber
+	stats.badSeq = sequenceModulo + 1
+	stats.cycles = 0
+	stats.received = 0
+	stats.receivedPrior = 0
+	stats.expectedPrior = 0
+}
+
+//UpdateSenderReport record the last sender report from source
+func (stats *ReceptionStatistics) UpdateSenderReport(ntpTime uint64, arrival time.Time) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.lastSR = NTPMiddle(ntpTime)
+	stats.lastSRTime = arrival
+}
+
+//Report make a reception report block,intervals are counted from last report
+func (stats *ReceptionStatistics) Report(now time.Time) (ReceptionReport, bool) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	if !stats.initialized {
+		return ReceptionReport{}, false
+	}
+	extendedMax := stats.cycles + uint32(stats.maxSeq)
+	expected := extendedMax - stats.baseSeq + 1
+	expectedInterval := expected - stats.expectedPrior
+	receivedInterval := stats.received - stats.receivedPrior
+	stats.expectedPrior = expected
+	stats.receivedPrior = stats.received
+	lostInterval := int64(expectedInterval) - int64(receivedInterval)
+	report := ReceptionReport{
+		SSRC:               stats.SSRC,
+		TotalLost:          int32(int64(expected) - int64(stats.received)),
+		LastSequenceNumber: extendedMax,
+		Jitter:             uint32(stats.jitter),
+		LastSenderReport:   stats.lastSR,
+	}
+	if expectedInterval != 0 && lostInterval > 0 {
+		report.FractionLost = uint8(lostInterval << 8 / int64(expectedInterval))
+	}
+	if !stats.lastSRTime.IsZero() {
+		report.Delay = uint32(now.Sub(stats.lastSRTime) * 65536 / time.Second)
+	}
+	return report, true
+}
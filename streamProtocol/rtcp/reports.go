@@ -0,0 +1,156 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//receptionReportLength length of one reception report block
+const receptionReportLength = 24
+
+//ReceptionReport reception report block in SR/RR(rfc3550 6.4.1)
+type ReceptionReport struct {
+	SSRC               uint32 // source this report is about
+	FractionLost       uint8  // fraction lost since last report,in 1/256
+	TotalLost          int32  // cumulative number of packets lost,24-bit signed
+	LastSequenceNumber uint32 // extended highest sequence number received
+	Jitter             uint32 // interarrival jitter in timestamp units
+	LastSenderReport   uint32 // middle 32 bits of last SR's ntp timestamp
+	Delay              uint32 // delay since last SR,in 1/65536 seconds
+}
+
+//unmarshal parse reception report block
+func (report *ReceptionReport) unmarshal(buf []byte) {
+	report.SSRC = binary.BigEndian.Uint32(buf[0:])
+	report.FractionLost = buf[4]
+	totalLost := uint32(buf[5])<<16 | uint32(buf[6])<<8 | uint32(buf[7])
+	if totalLost&0x800000 != 0 {
+		totalLost |= 0xff000000
+	}
+	report.TotalLost = int32(totalLost)
+	report.LastSequenceNumber = binary.BigEndian.Uint32(buf[8:])
+	report.Jitter = binary.BigEndian.Uint32(buf[12:])
+	report.LastSenderReport = binary.BigEndian.Uint32(buf[16:])
+	report.Delay = binary.BigEndian.Uint32(buf[20:])
+}
+
+//marshalTo marshal reception report block into buf
+func (report *ReceptionReport) marshalTo(buf []byte) {
+	binary.BigEndian.PutUint32(buf[0:], report.SSRC)
+	buf[4] = report.FractionLost
+	totalLost := report.TotalLost
+	if totalLost > 0x7fffff {
+		totalLost = 0x7fffff
+	} else if totalLost < -0x800000 {
+		totalLost = -0x800000
+	}
+	buf[5] = byte(totalLost >> 16)
+	buf[6] = byte(totalLost >> 8)
+	buf[7] = byte(totalLost)
+	binary.BigEndian.PutUint32(buf[8:], report.LastSequenceNumber)
+	binary.BigEndian.PutUint32(buf[12:], report.Jitter)
+	binary.BigEndian.PutUint32(buf[16:], report.LastSenderReport)
+	binary.BigEndian.PutUint32(buf[20:], report.Delay)
+}
+
+//SenderReport sender report(rfc3550 6.4.1)
+type SenderReport struct {
+	SSRC              uint32            // sender's ssrc
+	NTPTime           uint64            // wallclock time when this report was sent
+	RTPTime           uint32            // rtp timestamp of the same time as NTPTime
+	PacketCount       uint32            // sender's packet count
+	OctetCount        uint32            // sender's payload octet count
+	Reports           []ReceptionReport // reception report blocks
+	ProfileExtensions []byte            // profile-specific extensions
+}
+
+//Unmarshal parse sender report
+func (report *SenderReport) Unmarshal(buf []byte) error {
+	header, err := checkHeader(buf, TypeSenderReport, HeaderLength+24)
+	if err != nil {
+		return err
+	}
+	report.SSRC = binary.BigEndian.Uint32(buf[4:])
+	report.NTPTime = binary.BigEndian.Uint64(buf[8:])
+	report.RTPTime = binary.BigEndian.Uint32(buf[16:])
+	report.PacketCount = binary.BigEndian.Uint32(buf[20:])
+	report.OctetCount = binary.BigEndian.Uint32(buf[24:])
+	offset := HeaderLength + 24
+	if len(buf) < offset+int(header.Count)*receptionReportLength {
+		return fmt.Errorf("sender report too short for %v reports", header.Count)
+	}
+	report.Reports = make([]ReceptionReport, header.Count)
+	for i := range report.Reports {
+		report.Reports[i].unmarshal(buf[offset:])
+		offset += receptionReportLength
+	}
+	report.ProfileExtensions = buf[offset:]
+	return nil
+}
+
+//Marshal marshal sender report
+func (report *SenderReport) Marshal() ([]byte, error) {
+	size := HeaderLength + 24 + len(report.Reports)*receptionReportLength +
+		len(report.ProfileExtensions)
+	buf, err := newPacketBuffer(size, len(report.Reports), TypeSenderReport)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(buf[4:], report.SSRC)
+	binary.BigEndian.PutUint64(buf[8:], report.NTPTime)
+	binary.BigEndian.PutUint32(buf[16:], report.RTPTime)
+	binary.BigEndian.PutUint32(buf[20:], report.PacketCount)
+	binary.BigEndian.PutUint32(buf[24:], report.OctetCount)
+	offset := HeaderLength + 24
+	for i := range report.Reports {
+		report.Reports[i].marshalTo(buf[offset:])
+		offset += receptionReportLength
+	}
+	copy(buf[offset:], report.ProfileExtensions)
+	return buf, nil
+}
+
+//ReceiverReport receiver report(rfc3550 6.4.2)
+type ReceiverReport struct {
+	SSRC              uint32            // receiver's ssrc
+	Reports           []ReceptionReport // reception report blocks
+	ProfileExtensions []byte            // profile-specific extensions
+}
+
+//Unmarshal parse receiver report
+func (report *ReceiverReport) Unmarshal(buf []byte) error {
+	header, err := checkHeader(buf, TypeReceiverReport, HeaderLength+4)
+	if err != nil {
+		return err
+	}
+	report.SSRC = binary.BigEndian.Uint32(buf[4:])
+	offset := HeaderLength + 4
+	if len(buf) < offset+int(header.Count)*receptionReportLength {
+		return fmt.Errorf("receiver report too short for %v reports", header.Count)
+	}
+	report.Reports = make([]ReceptionReport, header.Count)
+	for i := range report.Reports {
+		report.Reports[i].unmarshal(buf[offset:])
+		offset += receptionReportLength
+	}
+	report.ProfileExtensions = buf[offset:]
+	return nil
+}
+
+//Marshal marshal receiver report
+func (report *ReceiverReport) Marshal() ([]byte, error) {
+	size := HeaderLength + 4 + len(report.Reports)*receptionReportLength +
+		len(report.ProfileExtensions)
+	buf, err := newPacketBuffer(size, len(report.Reports), TypeReceiverReport)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(buf[4:], report.SSRC)
+	offset := HeaderLength + 4
+	for i := range report.Reports {
+		report.Reports[i].marshalTo(buf[offset:])
+		offset += receptionReportLength
+	}
+	copy(buf[offset:], report.ProfileExtensions)
+	return buf, nil
+}
@@ -0,0 +1,207 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//PacketType rtcp packet type(rfc3550 12.1,rfc4585 6.1)
+type PacketType uint8
+
+const (
+	//TypeSenderReport sender report
+	TypeSenderReport PacketType = 200
+	//TypeReceiverReport receiver report
+	TypeReceiverReport PacketType = 201
+	//TypeSourceDescription source description
+	TypeSourceDescription PacketType = 202
+	//TypeGoodbye goodbye
+	TypeGoodbye PacketType = 203
+	//TypeApplicationDefined application-defined
+	TypeApplicationDefined PacketType = 204
+	//TypeTransportLayerFeedback generic rtp feedback(RTPFB)
+	TypeTransportLayerFeedback PacketType = 205
+	//TypePayloadSpecificFeedback payload-specific feedback(PSFB)
+	TypePayloadSpecificFeedback PacketType = 206
+)
+
+const (
+	//Version rtcp version
+	Version uint8 = 2
+	//HeaderLength length of rtcp common header
+	HeaderLength int = 4
+	//MaxCount max value of count field in header
+	MaxCount int = 31
+)
+
+//Header rtcp common header
+type Header struct {
+	Padding bool       // if padding at the end of packet
+	Count   uint8      // report count,source count,subtype or feedback format
+	Type    PacketType // packet type
+	Length  uint16     // length in 32-bit words minus one
+}
+
+//Packet one rtcp packet in compound packet
+type Packet interface {
+	//Unmarshal parse packet from buf including common header
+	Unmarshal(buf []byte) error
+	//Marshal marshal packet including common header
+	Marshal() ([]byte, error)
+}
+
+//Unmarshal parse common header from buf
+func (header *Header) Unmarshal(buf []byte) error {
+	if len(buf) < HeaderLength {
+		return fmt.Errorf("rtcp header too short:%v", len(buf))
+	}
+	if version := buf[0] >> 6; version != Version {
+		return fmt.Errorf("rtcp version %v not support", version)
+	}
+	header.Padding = buf[0]&0x20 != 0
+	header.Count = buf[0] & 0x1f
+	header.Type = PacketType(buf[1])
+	header.Length = binary.BigEndian.Uint16(buf[2:])
+	return nil
+}
+
+//MarshalTo marshal common header into buf
+func (header *Header) MarshalTo(buf []byte) error {
+	if len(buf) < HeaderLength {
+		return fmt.Errorf("buffer too short for rtcp header")
+	}
+	if int(header.Count) > MaxCount {
+		return fmt.Errorf("rtcp count %v too large", header.Count)
+	}
+	buf[0] = Version<<6 | header.Count
+	if header.Padding {
+		buf[0] |= 0x20
+	}
+	buf[1] = byte(header.Type)
+	binary.BigEndian.PutUint16(buf[2:], header.Length)
+	return nil
+}
+
+//newPacketBuffer make buffer of size(multiple of 4) with common header written
+func newPacketBuffer(size int, count int, packetType PacketType) ([]byte, error) {
+	if size%4 != 0 {
+		return nil, fmt.Errorf("rtcp packet size %v not multiple of 4", size)
+	}
+	if count > MaxCount {
+		return nil, fmt.Errorf("rtcp count %v too large", count)
+	}
+	buf := make([]byte, size)
+	header := Header{
+		Count:  uint8(count),
+		Type:   packetType,
+		Length: uint16(size/4 - 1),
+	}
+	return buf, header.MarshalTo(buf)
+}
+
+//checkHeader parse common header and check packet type and length
+func checkHeader(buf []byte, packetType PacketType, minLength int) (Header, error) {
+	var header Header
+	if err := header.Unmarshal(buf); err != nil {
+		return header, err
+	}
+	if header.Type != packetType {
+		return header, fmt.Errorf("rtcp packet type %v is not %v", header.Type, packetType)
+	}
+	if len(buf) != 4*(int(header.Length)+1) {
+		return header, fmt.Errorf("rtcp packet length %v not match header length %v",
+			len(buf), header.Length)
+	}
+	if len(buf) < minLength {
+		return header, fmt.Errorf("rtcp packet type %v too short:%v", packetType, len(buf))
+	}
+	return header, nil
+}
+
+//RawPacket rtcp packet of unknown type
+type RawPacket []byte
+
+//Unmarshal keep buf as raw packet
+func (packet *RawPacket) Unmarshal(buf []byte) error {
+	var header Header
+	if err := header.Unmarshal(buf); err != nil {
+		return err
+	}
+	*packet = buf
+	return nil
+}
+
+//Marshal return raw packet
+func (packet RawPacket) Marshal() ([]byte, error) {
+	return packet, nil
+}
+
+//Unmarshal parse a compound rtcp packet(rfc3550 6.1)
+func Unmarshal(buf []byte) ([]Packet, error) {
+	packets := make([]Packet, 0, 2)
+	for offset := 0; offset < len(buf); {
+		var header Header
+		if err := header.Unmarshal(buf[offset:]); err != nil {
+			return nil, err
+		}
+		length := 4 * (int(header.Length) + 1)
+		if offset+length > len(buf) {
+			return nil, fmt.Errorf("rtcp packet length %v out of range", length)
+		}
+		data := buf[offset : offset+length]
+		if header.Padding {
+			// padding is only allowed in the last packet
+			if offset+length != len(buf) {
+				return nil, fmt.Errorf("rtcp padding not in the last packet")
+			}
+			paddingSize := int(data[length-1])
+			if paddingSize == 0 || paddingSize%4 != 0 || paddingSize > length-HeaderLength {
+				return nil, fmt.Errorf("rtcp padding size %v invalid", paddingSize)
+			}
+			data = append([]byte(nil), data[:length-paddingSize]...)
+			data[0] &^= 0x20
+			binary.BigEndian.PutUint16(data[2:], uint16(len(data)/4-1))
+		}
+		var packet Packet
+		switch header.Type {
+		case TypeSenderReport:
+			packet = new(SenderReport)
+		case TypeReceiverReport:
+			packet = new(ReceiverReport)
+		case TypeSourceDescription:
+			packet = new(SourceDescription)
+		case TypeGoodbye:
+			packet = new(Goodbye)
+		case TypeApplicationDefined:
+			packet = new(ApplicationDefined)
+		case TypeTransportLayerFeedback:
+			packet = new(TransportLayerFeedback)
+		case TypePayloadSpecificFeedback:
+			packet = new(PayloadSpecificFeedback)
+		default:
+			packet = new(RawPacket)
+		}
+		if err := packet.Unmarshal(data); err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+		offset += length
+	}
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("empty rtcp packet")
+	}
+	return packets, nil
+}
+
+//Marshal marshal packets into a compound rtcp packet
+func Marshal(packets []Packet) ([]byte, error) {
+	buf := make([]byte, 0, 128)
+	for _, packet := range packets {
+		data, err := packet.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
@@ -0,0 +1,116 @@
+package rtcp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompoundRoundTrip(t *testing.T) {
+	packets := []Packet{
+		&SenderReport{
+			SSRC:        0x11223344,
+			NTPTime:     0xda8bd1fcdddda05a,
+			RTPTime:     0xaaf4edd5,
+			PacketCount: 1,
+			OctetCount:  2,
+			Reports: []ReceptionReport{{
+				SSRC:               0xbc5e9a40,
+				FractionLost:       1,
+				TotalLost:          -2,
+				LastSequenceNumber: 0x46e1,
+				Jitter:             273,
+				LastSenderReport:   0x9f36432,
+				Delay:              150137,
+			}},
+		},
+		NewCNAME(0x11223344, "streamProtocol"),
+		&Goodbye{Sources: []uint32{0x11223344}, Reason: "bye"},
+		&ApplicationDefined{SubType: 1, SSRC: 2, Name: "TEST", Data: []byte{1, 2, 3, 4}},
+		NewGenericNACK(1, 2, []NackPair{{PacketID: 100, LostPackets: 0x5}}),
+		NewPictureLossIndication(1, 2),
+	}
+	raw, err := Marshal(packets)
+	if err != nil {
+		t.Fatalf("Marshal error:%v", err)
+	}
+	parsed, err := Unmarshal(raw)
+	if err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	if len(parsed) != len(packets) {
+		t.Fatalf("parsed %v packets, want %v", len(parsed), len(packets))
+	}
+	for i := range packets {
+		want, _ := packets[i].Marshal()
+		got, _ := parsed[i].Marshal()
+		if !bytes.Equal(want, got) {
+			t.Errorf("packet %v = %x, want %x", i, got, want)
+		}
+	}
+	if sdes := parsed[1].(*SourceDescription); sdes.Chunks[0].Items[0].Text != "streamProtocol" {
+		t.Errorf("cname = %v", sdes.Chunks[0].Items[0].Text)
+	}
+	nacks, err := parsed[4].(*TransportLayerFeedback).Nacks()
+	if err != nil || !reflect.DeepEqual(nacks[0].SequenceNumbers(), []uint16{100, 101, 103}) {
+		t.Errorf("nacks = %v,%v", nacks, err)
+	}
+	if !parsed[5].(*PayloadSpecificFeedback).IfKeyframeRequest() {
+		t.Errorf("PLI should be keyframe request")
+	}
+}
+
+func TestReceiverReportUnmarshal(t *testing.T) {
+	raw := []byte{
+		0x81, 0xc9, 0x00, 0x07, // RR,1 report,length 7
+		0x90, 0x2f, 0x9e, 0x2e, // ssrc
+		0xbc, 0x5e, 0x9a, 0x40, // report ssrc
+		0x00, 0x00, 0x00, 0x00, // fraction lost,total lost
+		0x00, 0x00, 0x46, 0xe1, // last sequence number
+		0x00, 0x00, 0x01, 0x11, // jitter
+		0x09, 0xf3, 0x64, 0x32, // lsr
+		0x00, 0x02, 0x4a, 0x79, // dlsr
+	}
+	packets, err := Unmarshal(raw)
+	if err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	report, ok := packets[0].(*ReceiverReport)
+	if !ok || report.SSRC != 0x902f9e2e || len(report.Reports) != 1 ||
+		report.Reports[0].Jitter != 0x111 || report.Reports[0].Delay != 0x24a79 {
+		t.Errorf("receiver report = %+v", packets[0])
+	}
+	for _, bad := range [][]byte{raw[:6], append([]byte{0x40}, raw[1:]...), raw[:28]} {
+		if _, err := Unmarshal(bad); err == nil {
+			t.Errorf("Unmarshal(%x) should fail", bad)
+		}
+	}
+}
+
+func TestReceptionStatistics(t *testing.T) {
+	stats := NewReceptionStatistics(90000)
+	now := time.Now()
+	for _, seq := range []uint16{65533, 65534, 65535, 1, 2} {
+		stats.UpdatePacket(1, seq, uint32(seq)*3000, now)
+		now = now.Add(33 * time.Millisecond)
+	}
+	stats.UpdateSenderReport(NTPTime(now), now)
+	report, ok := stats.Report(now.Add(time.Second))
+	if !ok {
+		t.Fatalf("Report not ready")
+	}
+	if report.LastSequenceNumber != 1<<16+2 || report.TotalLost != 1 || report.FractionLost != 256/6 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.Delay != 65536 {
+		t.Errorf("delay = %v, want 65536", report.Delay)
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1600000000, 500000000)
+	if got := NTPToTime(NTPTime(now)); got.Sub(now) > time.Microsecond || now.Sub(got) > time.Microsecond {
+		t.Errorf("NTPToTime(NTPTime(%v)) = %v", now, got)
+	}
+}
@@ -0,0 +1,224 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//SDESType sdes item type(rfc3550 6.5)
+type SDESType uint8
+
+const (
+	//SDESEnd end of item list
+	SDESEnd SDESType = 0
+	//SDESCNAME canonical end-point identifier
+	SDESCNAME SDESType = 1
+	//SDESName user name
+	SDESName SDESType = 2
+	//SDESEmail electronic mail address
+	SDESEmail SDESType = 3
+	//SDESPhone phone number
+	SDESPhone SDESType = 4
+	//SDESLocation geographic user location
+	SDESLocation SDESType = 5
+	//SDESTool application or tool name
+	SDESTool SDESType = 6
+	//SDESNote notice/status
+	SDESNote SDESType = 7
+	//SDESPrivate private extensions
+	SDESPrivate SDESType = 8
+)
+
+//SourceDescriptionItem one sdes item
+type SourceDescriptionItem struct {
+	Type SDESType
+	Text string
+}
+
+//SourceDescriptionChunk items of one source
+type SourceDescriptionChunk struct {
+	Source uint32
+	Items  []SourceDescriptionItem
+}
+
+//SourceDescription source description(rfc3550 6.5)
+type SourceDescription struct {
+	Chunks []SourceDescriptionChunk
+}
+
+//NewCNAME make a source description with only CNAME item
+func NewCNAME(source uint32, cname string) *SourceDescription {
+	return &SourceDescription{Chunks: []SourceDescriptionChunk{{
+		Source: source,
+		Items:  []SourceDescriptionItem{{Type: SDESCNAME, Text: cname}},
+	}}}
+}
+
+//Unmarshal parse source description
+func (description *SourceDescription) Unmarshal(buf []byte) error {
+	header, err := checkHeader(buf, TypeSourceDescription, HeaderLength)
+	if err != nil {
+		return err
+	}
+	description.Chunks = make([]SourceDescriptionChunk, 0, header.Count)
+	offset := HeaderLength
+	for i := 0; i < int(header.Count); i++ {
+		if offset+4 > len(buf) {
+			return fmt.Errorf("sdes chunk %v out of range", i)
+		}
+		chunk := SourceDescriptionChunk{Source: binary.BigEndian.Uint32(buf[offset:])}
+		offset += 4
+		for {
+			if offset >= len(buf) {
+				return fmt.Errorf("sdes chunk %v not terminated", i)
+			}
+			itemType := SDESType(buf[offset])
+			if itemType == SDESEnd {
+				// null items pad the chunk to 32-bit boundary
+				offset = (offset + 4) / 4 * 4
+				break
+			}
+			if offset+2 > len(buf) || offset+2+int(buf[offset+1]) > len(buf) {
+				return fmt.Errorf("sdes item out of range")
+			}
+			length := int(buf[offset+1])
+			chunk.Items = append(chunk.Items, SourceDescriptionItem{
+				Type: itemType,
+				Text: string(buf[offset+2 : offset+2+length]),
+			})
+			offset += 2 + length
+		}
+		description.Chunks = append(description.Chunks, chunk)
+	}
+	return nil
+}
+
+//Marshal marshal source description
+func (description *SourceDescription) Marshal() ([]byte, error) {
+	size := HeaderLength
+	for _, chunk := range description.Chunks {
+		chunkSize := 4
+		for _, item := range chunk.Items {
+			if len(item.Text) > 255 {
+				return nil, fmt.Errorf("sdes item text too long:%v", len(item.Text))
+			}
+			chunkSize += 2 + len(item.Text)
+		}
+		// at least one null octet terminates the list
+		size += (chunkSize + 4) / 4 * 4
+	}
+	buf, err := newPacketBuffer(size, len(description.Chunks), TypeSourceDescription)
+	if err != nil {
+		return nil, err
+	}
+	offset := HeaderLength
+	for _, chunk := range description.Chunks {
+		binary.BigEndian.PutUint32(buf[offset:], chunk.Source)
+		offset += 4
+		for _, item := range chunk.Items {
+			buf[offset] = byte(item.Type)
+			buf[offset+1] = byte(len(item.Text))
+			offset += 2 + copy(buf[offset+2:], item.Text)
+		}
+		offset = (offset + 4) / 4 * 4
+	}
+	return buf, nil
+}
+
+//Goodbye goodbye(rfc3550 6.6)
+type Goodbye struct {
+	Sources []uint32
+	Reason  string
+}
+
+//Unmarshal parse goodbye
+func (goodbye *Goodbye) Unmarshal(buf []byte) error {
+	header, err := checkHeader(buf, TypeGoodbye, HeaderLength)
+	if err != nil {
+		return err
+	}
+	offset := HeaderLength + 4*int(header.Count)
+	if offset > len(buf) {
+		return fmt.Errorf("goodbye too short for %v sources", header.Count)
+	}
+	goodbye.Sources = make([]uint32, header.Count)
+	for i := range goodbye.Sources {
+		goodbye.Sources[i] = binary.BigEndian.Uint32(buf[HeaderLength+4*i:])
+	}
+	goodbye.Reason = ""
+	if offset < len(buf) {
+		length := int(buf[offset])
+		if offset+1+length > len(buf) {
+			return fmt.Errorf("goodbye reason out of range")
+		}
+		goodbye.Reason = string(buf[offset+1 : offset+1+length])
+	}
+	return nil
+}
+
+//Marshal marshal goodbye
+func (goodbye *Goodbye) Marshal() ([]byte, error) {
+	if len(goodbye.Reason) > 255 {
+		return nil, fmt.Errorf("goodbye reason too long:%v", len(goodbye.Reason))
+	}
+	size := HeaderLength + 4*len(goodbye.Sources)
+	if goodbye.Reason != "" {
+		size += (1 + len(goodbye.Reason) + 3) / 4 * 4
+	}
+	buf, err := newPacketBuffer(size, len(goodbye.Sources), TypeGoodbye)
+	if err != nil {
+		return nil, err
+	}
+	offset := HeaderLength
+	for _, source := range goodbye.Sources {
+		binary.BigEndian.PutUint32(buf[offset:], source)
+		offset += 4
+	}
+	if goodbye.Reason != "" {
+		buf[offset] = byte(len(goodbye.Reason))
+		copy(buf[offset+1:], goodbye.Reason)
+	}
+	return buf, nil
+}
+
+//ApplicationDefined application-defined packet(rfc3550 6.7)
+type ApplicationDefined struct {
+	SubType uint8
+	SSRC    uint32
+	Name    string // 4 ascii characters
+	Data    []byte // length is multiple of 4
+}
+
+//Unmarshal parse application-defined packet
+func (application *ApplicationDefined) Unmarshal(buf []byte) error {
+	header, err := checkHeader(buf, TypeApplicationDefined, HeaderLength+8)
+	if err != nil {
+		return err
+	}
+	application.SubType = header.Count
+	application.SSRC = binary.BigEndian.Uint32(buf[4:])
+	application.Name = string(buf[8:12])
+	application.Data = buf[12:]
+	return nil
+}
+
+//Marshal marshal application-defined packet
+func (application *ApplicationDefined) Marshal() ([]byte, error) {
+	if len(application.Name) != 4 {
+		return nil, fmt.Errorf("application-defined name %q should be 4 characters",
+			application.Name)
+	}
+	if len(application.Data)%4 != 0 {
+		return nil, fmt.Errorf("application-defined data length %v not multiple of 4",
+			len(application.Data))
+	}
+	buf, err := newPacketBuffer(HeaderLength+8+len(application.Data),
+		int(application.SubType), TypeApplicationDefined)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(buf[4:], application.SSRC)
+	copy(buf[8:], application.Name)
+	copy(buf[12:], application.Data)
+	return buf, nil
+}
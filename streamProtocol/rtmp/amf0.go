@@ -0,0 +1,231 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+//amf0 type markers(amf0 spec 2.1)
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0a
+	amf0Date        = 0x0b
+	amf0LongString  = 0x0c
+)
+
+//Property a property of amf0 object
+type Property struct {
+	Name  string
+	Value interface{}
+}
+
+/*
+Object amf0 object or ecma array,properties keep their order,
+values in amf0 are float64,bool,string,Object,[]interface{} or nil
+*/
+type Object []Property
+
+//Get value of property name,nil if not found
+func (object Object) Get(name string) interface{} {
+	for _, property := range object {
+		if property.Name == name {
+			return property.Value
+		}
+	}
+	return nil
+}
+
+//GetString string value of property name,empty if not a string
+func (object Object) GetString(name string) string {
+	value, _ := object.Get(name).(string)
+	return value
+}
+
+//MarshalAMF0 encode values in amf0,integers are encoded as numbers
+func MarshalAMF0(values ...interface{}) ([]byte, error) {
+	data := make([]byte, 0, 128)
+	for _, value := range values {
+		var err error
+		if data, err = appendAMF0(data, value); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+//appendAMF0 append value encoded in amf0 to data
+func appendAMF0(data []byte, value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return append(data, amf0Null), nil
+	case float64:
+		data = append(data, amf0Number, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(data[len(data)-8:], math.Float64bits(value))
+		return data, nil
+	case int:
+		return appendAMF0(data, float64(value))
+	case uint32:
+		return appendAMF0(data, float64(value))
+	case bool:
+		if value {
+			return append(data, amf0Boolean, 1), nil
+		}
+		return append(data, amf0Boolean, 0), nil
+	case string:
+		if len(value) > 0xffff {
+			data = append(data, amf0LongString, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(data[len(data)-4:], uint32(len(value)))
+			return append(data, value...), nil
+		}
+		return appendAMF0String(append(data, amf0String), value), nil
+	case Object:
+		data = append(data, amf0Object)
+		for _, property := range value {
+			data = appendAMF0String(data, property.Name)
+			var err error
+			if data, err = appendAMF0(data, property.Value); err != nil {
+				return nil, err
+			}
+		}
+		return append(data, 0, 0, amf0ObjectEnd), nil
+	case []interface{}:
+		data = append(data, amf0StrictArray, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], uint32(len(value)))
+		for _, item := range value {
+			var err error
+			if data, err = appendAMF0(data, item); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("amf0 type %T not support", value)
+}
+
+//appendAMF0String append utf-8 string with 16 bits length,without marker
+func appendAMF0String(data []byte, value string) []byte {
+	data = append(data, byte(len(value)>>8), byte(len(value)))
+	return append(data, value...)
+}
+
+//UnmarshalAMF0 decode all values in data,ecma arrays are decoded as Object
+func UnmarshalAMF0(data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0, 4)
+	for len(data) > 0 {
+		value, size, err := parseAMF0(data)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+		data = data[size:]
+	}
+	return values, nil
+}
+
+//parseAMF0 decode a value,return it with its encoded size
+func parseAMF0(data []byte) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("amf0 value missing")
+	}
+	switch data[0] {
+	case amf0Number:
+		if len(data) < 9 {
+			return nil, 0, fmt.Errorf("amf0 number too short")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+	case amf0Boolean:
+		if len(data) < 2 {
+			return nil, 0, fmt.Errorf("amf0 boolean too short")
+		}
+		return data[1] != 0, 2, nil
+	case amf0String:
+		value, size, err := parseAMF0String(data[1:])
+		return value, 1 + size, err
+	case amf0LongString:
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("amf0 long string too short")
+		}
+		length := int(binary.BigEndian.Uint32(data[1:]))
+		if length < 0 || 5+length > len(data) {
+			return nil, 0, fmt.Errorf("amf0 long string length %v out of range", length)
+		}
+		return string(data[5 : 5+length]), 5 + length, nil
+	case amf0Null, amf0Undefined:
+		return nil, 1, nil
+	case amf0Object:
+		object, size, err := parseAMF0Properties(data[1:])
+		return object, 1 + size, err
+	case amf0ECMAArray:
+		// associative count is only a hint,properties end with object end marker
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("amf0 ecma array too short")
+		}
+		object, size, err := parseAMF0Properties(data[5:])
+		return object, 5 + size, err
+	case amf0StrictArray:
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("amf0 strict array too short")
+		}
+		count := int(binary.BigEndian.Uint32(data[1:]))
+		offset := 5
+		items := make([]interface{}, 0, 4)
+		for i := 0; i < count; i++ {
+			item, size, err := parseAMF0(data[offset:])
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			offset += size
+		}
+		return items, offset, nil
+	case amf0Date:
+		// milliseconds and time zone
+		if len(data) < 11 {
+			return nil, 0, fmt.Errorf("amf0 date too short")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 11, nil
+	}
+	return nil, 0, fmt.Errorf("amf0 type marker %v not support", data[0])
+}
+
+//parseAMF0String decode utf-8 string with 16 bits length
+func parseAMF0String(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, fmt.Errorf("amf0 string too short")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if 2+length > len(data) {
+		return "", 0, fmt.Errorf("amf0 string length %v out of range", length)
+	}
+	return string(data[2 : 2+length]), 2 + length, nil
+}
+
+//parseAMF0Properties decode properties until object end marker
+func parseAMF0Properties(data []byte) (Object, int, error) {
+	object := make(Object, 0, 8)
+	offset := 0
+	for {
+		name, size, err := parseAMF0String(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += size
+		if name == "" && offset < len(data) && data[offset] == amf0ObjectEnd {
+			return object, offset + 1, nil
+		}
+		value, size, err := parseAMF0(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += size
+		object = append(object, Property{Name: name, Value: value})
+	}
+}
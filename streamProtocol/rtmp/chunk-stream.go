@@ -0,0 +1,291 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//DefaultChunkSize chunk size before Set Chunk Size message(rtmp spec 5.4.1)
+const DefaultChunkSize = 128
+
+//MaxMessageSize max payload size of messages read
+const MaxMessageSize = 16 << 20
+
+//MaxPendingSize max payload size of partial messages of all chunk streams read
+const MaxPendingSize = 2 * MaxMessageSize
+
+//MessageType rtmp message type id
+type MessageType uint8
+
+const (
+	//MessageSetChunkSize protocol control message,set max chunk size
+	MessageSetChunkSize MessageType = 1
+	//MessageAbort protocol control message,discard partial message of chunk stream
+	MessageAbort MessageType = 2
+	//MessageAcknowledgement protocol control message,bytes received so far
+	MessageAcknowledgement MessageType = 3
+	//MessageUserControl user control message,like stream begin
+	MessageUserControl MessageType = 4
+	//MessageWindowAckSize protocol control message,window between acknowledgements
+	MessageWindowAckSize MessageType = 5
+	//MessageSetPeerBandwidth protocol control message,limit output bandwidth of peer
+	MessageSetPeerBandwidth MessageType = 6
+	//MessageAudio audio message,payload is flv audio tag body
+	MessageAudio MessageType = 8
+	//MessageVideo video message,payload is flv video tag body
+	MessageVideo MessageType = 9
+	//MessageDataAMF3 data message in amf3,starts with a format byte
+	MessageDataAMF3 MessageType = 15
+	//MessageCommandAMF3 command message in amf3,starts with a format byte
+	MessageCommandAMF3 MessageType = 17
+	//MessageDataAMF0 data message in amf0,like onMetaData
+	MessageDataAMF0 MessageType = 18
+	//MessageCommandAMF0 command message in amf0,like connect and publish
+	MessageCommandAMF0 MessageType = 20
+)
+
+//Message a rtmp message assembled from chunks
+type Message struct {
+	Type      MessageType
+	StreamID  uint32 // message stream id,0 for net connection
+	Timestamp uint32 // in milliseconds
+	Payload   []byte
+}
+
+//chunkStream state of a chunk stream for header compression
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32 // timestamp delta of type 1,2 headers
+	length    int
+	msgType   MessageType
+	streamID  uint32
+	extended  bool   // if the last header has extended timestamp
+	payload   []byte // partial payload of message being assembled
+}
+
+//ChunkReader read messages from chunk stream(rtmp spec 5.3)
+type ChunkReader struct {
+	reader    io.Reader
+	chunkSize int
+	streams   map[uint32]*chunkStream // by chunk stream id
+	pending   int                     // payload size of partial messages
+	buffer    [16]byte
+}
+
+//NewChunkReader make a chunk reader with DefaultChunkSize
+func NewChunkReader(reader io.Reader) *ChunkReader {
+	return &ChunkReader{
+		reader:    reader,
+		chunkSize: DefaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+//SetChunkSize set chunk size from peer's Set Chunk Size message
+func (reader *ChunkReader) SetChunkSize(size int) error {
+	if size <= 0 || size > MaxMessageSize {
+		return fmt.Errorf("chunk size %v invalid", size)
+	}
+	reader.chunkSize = size
+	return nil
+}
+
+//Abort discard partial message of chunk stream from peer's Abort message
+func (reader *ChunkReader) Abort(chunkStreamID uint32) {
+	if stream, ok := reader.streams[chunkStreamID]; ok {
+		reader.pending -= len(stream.payload)
+		stream.payload = nil
+	}
+}
+
+//ReadMessage read chunks until a message is complete
+func (reader *ChunkReader) ReadMessage() (*Message, error) {
+	for {
+		message, err := reader.readChunk()
+		if err != nil || message != nil {
+			return message, err
+		}
+	}
+}
+
+//readChunk read a chunk,return message if it is the last chunk of message
+func (reader *ChunkReader) readChunk() (*Message, error) {
+	buffer := reader.buffer[:]
+	if _, err := io.ReadFull(reader.reader, buffer[:1]); err != nil {
+		return nil, err
+	}
+	format := buffer[0] >> 6
+	chunkStreamID := uint32(buffer[0] & 0x3f)
+	switch chunkStreamID {
+	case 0:
+		if _, err := io.ReadFull(reader.reader, buffer[:1]); err != nil {
+			return nil, err
+		}
+		chunkStreamID = 64 + uint32(buffer[0])
+	case 1:
+		if _, err := io.ReadFull(reader.reader, buffer[:2]); err != nil {
+			return nil, err
+		}
+		chunkStreamID = 64 + uint32(buffer[0]) + uint32(buffer[1])<<8
+	}
+	stream, ok := reader.streams[chunkStreamID]
+	if !ok {
+		if format != 0 {
+			return nil, fmt.Errorf("chunk stream %v begins with header type %v",
+				chunkStreamID, format)
+		}
+		stream = new(chunkStream)
+		reader.streams[chunkStreamID] = stream
+	}
+	headerSizes := [4]int{11, 7, 3, 0}
+	header := buffer[:headerSizes[format]]
+	if _, err := io.ReadFull(reader.reader, header); err != nil {
+		return nil, err
+	}
+	if format != 3 {
+		if stream.payload != nil {
+			return nil, fmt.Errorf("chunk stream %v message header before message end",
+				chunkStreamID)
+		}
+		timestamp := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+		stream.extended = timestamp == 0xffffff
+		if format <= 1 {
+			stream.length = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+			stream.msgType = MessageType(header[6])
+		}
+		if format == 0 {
+			stream.streamID = binary.LittleEndian.Uint32(header[7:])
+		}
+		if stream.extended {
+			if _, err := io.ReadFull(reader.reader, buffer[:4]); err != nil {
+				return nil, err
+			}
+			timestamp = binary.BigEndian.Uint32(buffer)
+		}
+		if format == 0 {
+			stream.timestamp, stream.delta = timestamp, 0
+		} else {
+			stream.timestamp += timestamp
+			stream.delta = timestamp
+		}
+	} else {
+		if stream.extended {
+			// type 3 chunks repeat extended timestamp of the last header
+			if _, err := io.ReadFull(reader.reader, buffer[:4]); err != nil {
+				return nil, err
+			}
+		}
+		if stream.payload == nil {
+			// a new message with the same header as the last one
+			stream.timestamp += stream.delta
+		}
+	}
+	if stream.length > MaxMessageSize {
+		return nil, fmt.Errorf("message size %v out of range", stream.length)
+	}
+	size := stream.length - len(stream.payload)
+	if size > reader.chunkSize {
+		size = reader.chunkSize
+	}
+	if reader.pending+size > MaxPendingSize {
+		return nil, fmt.Errorf("partial messages over %v bytes", MaxPendingSize)
+	}
+	// payload grows with chunks received,not by length of header
+	offset := len(stream.payload)
+	stream.payload = append(stream.payload, make([]byte, size)...)
+	if _, err := io.ReadFull(reader.reader, stream.payload[offset:]); err != nil {
+		return nil, err
+	}
+	reader.pending += size
+	if len(stream.payload) < stream.length {
+		return nil, nil
+	}
+	reader.pending -= len(stream.payload)
+	message := &Message{
+		Type:      stream.msgType,
+		StreamID:  stream.streamID,
+		Timestamp: stream.timestamp,
+		Payload:   stream.payload,
+	}
+	stream.payload = nil
+	return message, nil
+}
+
+//ChunkWriter write messages as chunks,every message begins with a type 0 header
+type ChunkWriter struct {
+	writer    io.Writer
+	chunkSize int
+	header    []byte
+}
+
+//NewChunkWriter make a chunk writer with DefaultChunkSize
+func NewChunkWriter(writer io.Writer) *ChunkWriter {
+	return &ChunkWriter{writer: writer, chunkSize: DefaultChunkSize}
+}
+
+//SetChunkSize set chunk size after Set Chunk Size message is sent
+func (writer *ChunkWriter) SetChunkSize(size int) error {
+	if size <= 0 || size > MaxMessageSize {
+		return fmt.Errorf("chunk size %v invalid", size)
+	}
+	writer.chunkSize = size
+	return nil
+}
+
+//WriteMessage split message into chunks of chunk stream
+func (writer *ChunkWriter) WriteMessage(chunkStreamID uint32, message *Message) error {
+	if chunkStreamID < 2 || chunkStreamID > 65599 {
+		return fmt.Errorf("chunk stream id %v invalid", chunkStreamID)
+	}
+	if len(message.Payload) > 0xffffff {
+		return fmt.Errorf("message size %v out of range", len(message.Payload))
+	}
+	extended := message.Timestamp >= 0xffffff
+	payload := message.Payload
+	for first := true; first || len(payload) > 0; first = false {
+		header := writer.header[:0]
+		format := byte(3)
+		if first {
+			format = 0
+		}
+		switch {
+		case chunkStreamID < 64:
+			header = append(header, format<<6|byte(chunkStreamID))
+		case chunkStreamID < 320:
+			header = append(header, format<<6, byte(chunkStreamID-64))
+		default:
+			header = append(header, format<<6|1,
+				byte(chunkStreamID-64), byte((chunkStreamID-64)>>8))
+		}
+		if first {
+			timestamp := message.Timestamp
+			if extended {
+				timestamp = 0xffffff
+			}
+			length := len(message.Payload)
+			header = append(header,
+				byte(timestamp>>16), byte(timestamp>>8), byte(timestamp),
+				byte(length>>16), byte(length>>8), byte(length),
+				byte(message.Type), 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(header[len(header)-4:], message.StreamID)
+		}
+		if extended {
+			header = append(header, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(header[len(header)-4:], message.Timestamp)
+		}
+		writer.header = header
+		size := len(payload)
+		if size > writer.chunkSize {
+			size = writer.chunkSize
+		}
+		if _, err := writer.writer.Write(header); err != nil {
+			return fmt.Errorf("rtmp write error:%v", err)
+		}
+		if _, err := writer.writer.Write(payload[:size]); err != nil {
+			return fmt.Errorf("rtmp write error:%v", err)
+		}
+		payload = payload[size:]
+	}
+	return nil
+}
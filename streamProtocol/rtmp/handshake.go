@@ -0,0 +1,39 @@
+package rtmp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+//handshake constants(rtmp spec 5.2)
+const (
+	handshakeVersion = 3
+	handshakeSize    = 1536
+)
+
+//serverHandshake do simple handshake as server:read C0 C1,write S0 S1 S2,read C2,
+//S2 echoes C1 so clients expecting digest handshake accept it too
+func serverHandshake(conn io.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(conn, c0c1); err != nil {
+		return fmt.Errorf("read C0 C1 error:%v", err)
+	}
+	if c0c1[0] != handshakeVersion {
+		return fmt.Errorf("rtmp version %v not support", c0c1[0])
+	}
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = handshakeVersion
+	// time and zero are left 0
+	if _, err := rand.Read(s0s1s2[9 : 1+handshakeSize]); err != nil {
+		return fmt.Errorf("rand error:%v", err)
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+	if _, err := conn.Write(s0s1s2); err != nil {
+		return fmt.Errorf("write S0 S1 S2 error:%v", err)
+	}
+	if _, err := io.ReadFull(conn, c0c1[1:]); err != nil {
+		return fmt.Errorf("read C2 error:%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package rtmp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/flv"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+)
+
+//PlayerCheckInterval interval of checking if the stream played is still published
+var PlayerCheckInterval = time.Second
+
+//player a rtmp client playing a published stream,
+//frames of the stream are muxed into flv tags and sent as media messages
+type player struct {
+	session      *Session
+	streamID     uint32 // message stream id of play command
+	resourcePath string
+	published    *rtsp.PusherPullersSession
+	muxer        *rtsp.FLVMuxer
+	stop         chan struct{}
+}
+
+//newPlayer make a player of published session
+func newPlayer(session *Session, streamID uint32, resourcePath string,
+	published *rtsp.PusherPullersSession) (*player, error) {
+	muxer, err := rtsp.NewFLVMuxer(published, "rtmp-"+session.ID)
+	if err != nil {
+		return nil, err
+	}
+	player := &player{
+		session:      session,
+		streamID:     streamID,
+		resourcePath: resourcePath,
+		published:    published,
+		muxer:        muxer,
+		stop:         make(chan struct{}),
+	}
+	muxer.OnTag = player.writeTag
+	return player, nil
+}
+
+//start send metadata and begin muxing
+func (player *player) start() error {
+	metadata := Object{}
+	if player.muxer.HasVideo() {
+		metadata = append(metadata, Property{Name: "videocodecid", Value: flv.VideoCodecAVC})
+	}
+	if player.muxer.HasAudio() {
+		metadata = append(metadata, Property{Name: "audiocodecid", Value: flv.SoundFormatAAC})
+	}
+	if err := player.session.writeData(player.streamID, "onMetaData", metadata); err != nil {
+		return err
+	}
+	if err := player.muxer.Start(); err != nil {
+		return fmt.Errorf("FLVMuxer Start error:%v", err)
+	}
+	go player.watch()
+	return nil
+}
+
+//writeTag send flv tag as a media message
+func (player *player) writeTag(tag *flv.Tag) error {
+	chunkStreamID := uint32(chunkStreamVideo)
+	if tag.Type == flv.TagTypeAudio {
+		chunkStreamID = chunkStreamAudio
+	}
+	return player.session.writeMessages(chunkStreamID, &Message{
+		Type:      MessageType(tag.Type),
+		StreamID:  player.streamID,
+		Timestamp: tag.Timestamp,
+		Payload:   tag.Data,
+	})
+}
+
+//watch close connection when the stream is unpublished or republished
+func (player *player) watch() {
+	ticker := time.NewTicker(PlayerCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if player.session.server.RtspServer.FindPublished(
+				player.resourcePath) == player.published {
+				continue
+			}
+			if err := player.session.writeStatus(player.streamID, "status",
+				"NetStream.Play.UnpublishNotify",
+				player.resourcePath+" is now unpublished"); err != nil {
+				fmt.Printf("writeStatus error:%v\n", err)
+			}
+			player.session.conn.Close()
+			return
+		case <-player.stop:
+			return
+		}
+	}
+}
+
+//close stop muxing
+func (player *player) close() {
+	close(player.stop)
+	player.muxer.Close()
+}
@@ -0,0 +1,221 @@
+package rtmp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/flv"
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+)
+
+//rtp payload types and clock rate of tracks in generated sdp
+const (
+	videoPayloadType = 96
+	audioPayloadType = 97
+	videoClockRate   = 90000
+)
+
+/*
+publisher a stream published by rtmp client,it is registered in rtsp server
+at the first coded frame after sequence headers of tracks announced by
+onMetaData,or of tracks whose sequence headers arrived if no metadata
+*/
+type publisher struct {
+	server           *rtsp.Server
+	resourcePath     string
+	id               string // stands for rtsp session id of pusher
+	expectVideo      bool   // if onMetaData has avc video
+	expectAudio      bool   // if onMetaData has aac audio
+	sps, pps         []byte
+	audioConfig      *aac.AudioSpecificConfig
+	audioConfigBytes []byte
+	session          *rtsp.PusherPullersSession // nil before registered
+	video, audio     *rtsp.PusherPullersPair    // tracks of session,nil if absent
+	audioStarted     bool
+	audioTimestamp   uint32 // rtp timestamp of the next aac frame
+}
+
+//newPublisher make a publisher of resourcePath in server
+func newPublisher(server *rtsp.Server, resourcePath, id string) *publisher {
+	return &publisher{server: server, resourcePath: resourcePath, id: id}
+}
+
+//setMetadata get tracks from onMetaData,codec ids are numbers or fourcc strings
+func (publisher *publisher) setMetadata(metadata Object) {
+	switch codec := metadata.Get("videocodecid").(type) {
+	case float64:
+		publisher.expectVideo = codec == flv.VideoCodecAVC
+	case string:
+		publisher.expectVideo = codec == "avc1"
+	}
+	switch codec := metadata.Get("audiocodecid").(type) {
+	case float64:
+		publisher.expectAudio = codec == flv.SoundFormatAAC
+	case string:
+		publisher.expectAudio = codec == "mp4a"
+	}
+}
+
+//ready if sequence headers of tracks expected have arrived
+func (publisher *publisher) ready() bool {
+	hasVideo, hasAudio := publisher.sps != nil, publisher.audioConfig != nil
+	return (hasVideo || hasAudio) &&
+		(hasVideo || !publisher.expectVideo) && (hasAudio || !publisher.expectAudio)
+}
+
+//writeVideo publish h264 frame of video tag,timestamp is in milliseconds
+func (publisher *publisher) writeVideo(timestamp uint32, tag *flv.VideoTag) error {
+	if tag.CodecID != flv.VideoCodecAVC {
+		return fmt.Errorf("video codec %v not support", tag.CodecID)
+	}
+	switch tag.PacketType {
+	case flv.PacketTypeSequenceHeader:
+		sps, pps, err := h264.ParseDecoderConfig(tag.Data)
+		if err != nil {
+			return fmt.Errorf("ParseDecoderConfig error:%v", err)
+		}
+		publisher.sps, publisher.pps = sps, pps
+		return nil
+	case flv.PacketTypeRaw:
+	default:
+		return nil
+	}
+	if err := publisher.register(timestamp); err != nil || publisher.video == nil {
+		return err
+	}
+	nalus, err := h264.AVCCToNALUs(tag.Data)
+	if err != nil {
+		return fmt.Errorf("AVCCToNALUs error:%v", err)
+	}
+	isKeyframe := tag.FrameType == flv.FrameTypeKey || h264.IsKeyframe(nalus)
+	if isKeyframe {
+		hasSPS := false
+		for _, nalu := range nalus {
+			if h264.TypeOf(nalu) == h264.NALUTypeSPS {
+				hasSPS = true
+			}
+		}
+		// parameter sets of sequence header are not repeated in frames
+		if !hasSPS {
+			nalus = append([][]byte{publisher.sps, publisher.pps}, nalus...)
+		}
+	}
+	pts := int64(timestamp) + int64(tag.CompositionTime)
+	return publisher.video.PublishFrame(&rtsp.Frame{
+		MediaType:  rtsp.MediaVideo,
+		Codec:      "H264",
+		ClockRate:  videoClockRate,
+		Timestamp:  uint32(pts * videoClockRate / 1000),
+		Units:      nalus,
+		IsKeyframe: isKeyframe,
+	})
+}
+
+//writeAudio publish aac frame of audio tag,timestamp is in milliseconds
+func (publisher *publisher) writeAudio(timestamp uint32, tag *flv.AudioTag) error {
+	if tag.SoundFormat != flv.SoundFormatAAC {
+		return fmt.Errorf("sound format %v not support", tag.SoundFormat)
+	}
+	switch tag.PacketType {
+	case flv.PacketTypeSequenceHeader:
+		config := new(aac.AudioSpecificConfig)
+		if err := config.Unmarshal(tag.Data); err != nil {
+			return err
+		}
+		publisher.audioConfig = config
+		publisher.audioConfigBytes = tag.Data
+		return nil
+	case flv.PacketTypeRaw:
+	default:
+		return nil
+	}
+	if err := publisher.register(timestamp); err != nil || publisher.audio == nil {
+		return err
+	}
+	config := publisher.audioConfig
+	rtpTimestamp := uint32(uint64(timestamp) * uint64(config.SampleRate) / 1000)
+	// millisecond timestamps are rounded,keep frames contiguous if they are close
+	if delta := int32(rtpTimestamp - publisher.audioTimestamp); publisher.audioStarted &&
+		delta < int32(config.FrameLength/2) && delta > -int32(config.FrameLength/2) {
+		rtpTimestamp = publisher.audioTimestamp
+	}
+	publisher.audioStarted = true
+	publisher.audioTimestamp = rtpTimestamp + uint32(config.FrameLength)
+	return publisher.audio.PublishFrame(&rtsp.Frame{
+		MediaType: rtsp.MediaAudio,
+		Codec:     "MPEG4-GENERIC",
+		ClockRate: config.SampleRate,
+		Timestamp: rtpTimestamp,
+		Units:     [][]byte{tag.Data},
+		Duration:  uint32(config.FrameLength),
+	})
+}
+
+//register publish session with tracks of sequence headers if ready,
+//frames before it are dropped
+func (publisher *publisher) register(timestamp uint32) error {
+	if publisher.session != nil || !publisher.ready() {
+		return nil
+	}
+	session, err := publisher.server.Publish(publisher.resourcePath,
+		publisher.sdp(), publisher.id, nil)
+	if err != nil {
+		return fmt.Errorf("Publish error:%v", err)
+	}
+	publisher.session = session
+	now := time.Now()
+	for _, track := range session.Tracks {
+		switch track.MediaType {
+		case rtsp.MediaVideo:
+			publisher.video = track
+		case rtsp.MediaAudio:
+			publisher.audio = track
+		}
+		// all tracks share the clock of rtmp timestamps
+		track.SetTimeReference(now,
+			uint32(uint64(timestamp)*uint64(track.ClockRate)/1000))
+	}
+	fmt.Printf("rtmp stream %v published,sdp:\n%v", publisher.resourcePath,
+		*session.SdpContent)
+	return nil
+}
+
+//sdp make sdp of tracks from sequence headers
+func (publisher *publisher) sdp() string {
+	var builder strings.Builder
+	builder.WriteString("v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=rtmp stream\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n")
+	index := 0
+	if publisher.sps != nil {
+		fmt.Fprintf(&builder, "m=video 0 RTP/AVP %v\r\n"+
+			"a=rtpmap:%v H264/%v\r\n"+
+			"a=fmtp:%v packetization-mode=1;profile-level-id=%v;sprop-parameter-sets=%v\r\n"+
+			"a=control:streamid=%v\r\n",
+			videoPayloadType, videoPayloadType, videoClockRate, videoPayloadType,
+			h264.ProfileLevelID(publisher.sps),
+			h264.SpropParameterSets(publisher.sps, publisher.pps), index)
+		index++
+	}
+	if config := publisher.audioConfig; config != nil {
+		fmt.Fprintf(&builder, "m=audio 0 RTP/AVP %v\r\n"+
+			"a=rtpmap:%v MPEG4-GENERIC/%v/%v\r\n"+
+			"a=fmtp:%v profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;"+
+			"indexdeltalength=3;config=%x\r\n"+
+			"a=control:streamid=%v\r\n",
+			audioPayloadType, audioPayloadType, config.SampleRate, config.ChannelCount,
+			audioPayloadType, publisher.audioConfigBytes, index)
+	}
+	return builder.String()
+}
+
+//close unpublish session,or release resource path if not registered
+func (publisher *publisher) close() error {
+	publisher.session = nil
+	return publisher.server.Unpublish(publisher.resourcePath, publisher.id)
+}
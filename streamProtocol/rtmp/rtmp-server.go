@@ -0,0 +1,524 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/flv"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/teris-io/shortid"
+)
+
+// default values are used by Server
+var (
+	//ChunkSize chunk size of messages sent to clients
+	ChunkSize = 4096
+	//WindowAckSize acknowledgement window and peer bandwidth announced to clients
+	WindowAckSize = 2500000
+	//ReadTimeout session is closed if nothing is read from client in it
+	ReadTimeout = 10 * time.Second
+)
+
+//chunk stream ids of messages sent by server
+const (
+	chunkStreamControl = 2
+	chunkStreamCommand = 3
+	chunkStreamData    = 5
+	chunkStreamAudio   = 6
+	chunkStreamVideo   = 7
+)
+
+//publishStreamID message stream id returned by createStream
+const publishStreamID = 1
+
+//user control event types(rtmp spec 7.1.7)
+const (
+	eventStreamBegin = 0
+)
+
+/*
+Server rtmp server,streams published to rtmp://host/app/stream are registered
+in RtspServer as resource path /app/stream with a generated sdp,
+so rtsp pullers,hls and recorder can consume them as rtsp announced ones,
+streams published to RtspServer can be played at rtmp://host/app/stream too
+*/
+type Server struct {
+	RtspServer *rtsp.Server
+	listener   net.Listener
+	conns      map[net.Conn]struct{} // connections of sessions,closed by Stop
+	stopped    bool                  // if Stop called
+	mutex      sync.Mutex            // provide listener,conns and stopped's atom
+	sessions   sync.WaitGroup        // goroutines of sessions,waited by Stop
+}
+
+//Start start a rtmp server listening at address
+func (server *Server) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen tcp failed : %v", err)
+	}
+	fmt.Println("Start rtmp listening at ", address)
+	return server.Serve(listener)
+}
+
+//Serve accept rtmp sessions from listener until Stop
+func (server *Server) Serve(listener net.Listener) error {
+	server.mutex.Lock()
+	if server.stopped {
+		server.mutex.Unlock()
+		return listener.Close()
+	}
+	server.listener = listener
+	server.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if server.isStopped() {
+				return nil
+			}
+			fmt.Println("Accept failed : ", err)
+			continue
+		}
+		if !server.addConn(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer server.removeConn(conn)
+			if err := server.StartSession(conn); err != nil {
+				fmt.Printf("rtmp session error:%v\n", err)
+			}
+		}()
+	}
+}
+
+//Stop stop listening,close connections of sessions and wait for them to be closed
+func (server *Server) Stop() error {
+	server.mutex.Lock()
+	server.stopped = true
+	listener, conns := server.listener, server.conns
+	server.listener, server.conns = nil, nil
+	server.mutex.Unlock()
+	var returnErr error
+	if listener != nil {
+		returnErr = listener.Close()
+	}
+	for conn := range conns {
+		conn.Close()
+	}
+	server.sessions.Wait()
+	return returnErr
+}
+
+//isStopped if Stop called
+func (server *Server) isStopped() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.stopped
+}
+
+//addConn keep connection of a new session for Stop,false if stopped
+func (server *Server) addConn(conn net.Conn) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.stopped {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[net.Conn]struct{})
+	}
+	server.conns[conn] = struct{}{}
+	server.sessions.Add(1)
+	return true
+}
+
+//removeConn forget connection of a closed session
+func (server *Server) removeConn(conn net.Conn) {
+	server.mutex.Lock()
+	delete(server.conns, conn)
+	server.mutex.Unlock()
+	server.sessions.Done()
+}
+
+//StartSession serve a rtmp client until connection closed
+func (server *Server) StartSession(conn net.Conn) error {
+	fmt.Println("start rtmp session from ", conn.RemoteAddr().String())
+	counter := &countingReader{reader: bufio.NewReader(conn)}
+	writer := bufio.NewWriter(conn)
+	session := &Session{
+		ID:         shortid.MustGenerate(),
+		conn:       conn,
+		server:     server,
+		counter:    counter,
+		reader:     NewChunkReader(counter),
+		writer:     NewChunkWriter(writer),
+		bufio:      writer,
+		peerWindow: uint32(WindowAckSize),
+	}
+	defer session.Close()
+	conn.SetDeadline(time.Now().Add(ReadTimeout))
+	if err := serverHandshake(struct {
+		io.Reader
+		io.Writer
+	}{counter, conn}); err != nil {
+		return fmt.Errorf("handshake error:%v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	for {
+		if session.player == nil {
+			conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+		} else {
+			// players may send nothing while playing
+			conn.SetReadDeadline(time.Time{})
+		}
+		message, err := session.reader.ReadMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("ReadMessage error:%v", err)
+		}
+		if err := session.processMessage(message); err != nil {
+			return err
+		}
+		if err := session.acknowledge(); err != nil {
+			return err
+		}
+	}
+}
+
+//countingReader count bytes read for acknowledgements
+type countingReader struct {
+	reader io.Reader
+	count  uint32
+}
+
+//Read read and count
+func (reader *countingReader) Read(data []byte) (int, error) {
+	number, err := reader.reader.Read(data)
+	reader.count += uint32(number)
+	return number, err
+}
+
+//Session a rtmp connection with client
+type Session struct {
+	ID           string // stands for rtsp session id when publishing
+	App          string // app of connect command
+	conn         net.Conn
+	server       *Server
+	counter      *countingReader
+	reader       *ChunkReader
+	writer       *ChunkWriter
+	bufio        *bufio.Writer
+	writeMutex   sync.Mutex // provide writer's atom,players write in muxer goroutine
+	peerWindow   uint32     // window size from client,acknowledge after it
+	acknowledged uint32     // bytes read when the last acknowledgement sent
+	publisher    *publisher // nil if not publishing
+	player       *player    // nil if not playing
+}
+
+//Close close connection,then unpublish or stop playing stream
+func (session *Session) Close() error {
+	// close connection first,so writes blocked in player return
+	err := session.conn.Close()
+	if session.publisher != nil {
+		if err := session.publisher.close(); err != nil {
+			fmt.Printf("unpublish error:%v\n", err)
+		}
+		session.publisher = nil
+	}
+	if session.player != nil {
+		session.player.close()
+		session.player = nil
+	}
+	return err
+}
+
+//acknowledge send acknowledgement if bytes read exceed window
+func (session *Session) acknowledge() error {
+	if session.peerWindow == 0 || session.counter.count-session.acknowledged < session.peerWindow {
+		return nil
+	}
+	session.acknowledged = session.counter.count
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, session.acknowledged)
+	return session.writeMessages(chunkStreamControl,
+		&Message{Type: MessageAcknowledgement, Payload: payload})
+}
+
+//processMessage handle protocol control,command,data and media messages
+func (session *Session) processMessage(message *Message) error {
+	switch message.Type {
+	case MessageSetChunkSize:
+		if len(message.Payload) < 4 {
+			return fmt.Errorf("set chunk size message too short")
+		}
+		return session.reader.SetChunkSize(
+			int(binary.BigEndian.Uint32(message.Payload) & 0x7fffffff))
+	case MessageAbort:
+		if len(message.Payload) >= 4 {
+			session.reader.Abort(binary.BigEndian.Uint32(message.Payload))
+		}
+	case MessageWindowAckSize:
+		if len(message.Payload) >= 4 {
+			session.peerWindow = binary.BigEndian.Uint32(message.Payload)
+		}
+	case MessageCommandAMF0, MessageCommandAMF3:
+		payload := message.Payload
+		if message.Type == MessageCommandAMF3 && len(payload) > 0 {
+			// format byte,values are still in amf0
+			payload = payload[1:]
+		}
+		values, err := UnmarshalAMF0(payload)
+		if err != nil {
+			return fmt.Errorf("command error:%v", err)
+		}
+		return session.processCommand(message, values)
+	case MessageDataAMF0, MessageDataAMF3:
+		payload := message.Payload
+		if message.Type == MessageDataAMF3 && len(payload) > 0 {
+			payload = payload[1:]
+		}
+		values, err := UnmarshalAMF0(payload)
+		if err != nil {
+			fmt.Printf("data message error:%v\n", err)
+			return nil
+		}
+		session.processData(values)
+	case MessageVideo:
+		if session.publisher == nil {
+			return nil
+		}
+		tag := new(flv.VideoTag)
+		if err := tag.Unmarshal(message.Payload); err != nil {
+			return err
+		}
+		return session.publisher.writeVideo(message.Timestamp, tag)
+	case MessageAudio:
+		if session.publisher == nil {
+			return nil
+		}
+		tag := new(flv.AudioTag)
+		if err := tag.Unmarshal(message.Payload); err != nil {
+			return err
+		}
+		return session.publisher.writeAudio(message.Timestamp, tag)
+	}
+	return nil
+}
+
+//processCommand handle commands of net connection and net stream
+func (session *Session) processCommand(message *Message, values []interface{}) error {
+	if len(values) < 2 {
+		return fmt.Errorf("command has no name or transaction id")
+	}
+	name, _ := values[0].(string)
+	transactionID, _ := values[1].(float64)
+	fmt.Printf("rtmp session %v command %v\n", session.ID, name)
+	switch name {
+	case "connect":
+		if len(values) < 3 {
+			return fmt.Errorf("connect command has no command object")
+		}
+		object, _ := values[2].(Object)
+		session.App = strings.Trim(object.GetString("app"), "/")
+		if index := strings.Index(session.App, "?"); index >= 0 {
+			session.App = session.App[:index]
+		}
+		return session.connect(transactionID)
+	case "releaseStream", "FCPublish":
+		return session.writeCommand(0, "_result", transactionID, nil)
+	case "createStream":
+		return session.writeCommand(0, "_result", transactionID, nil, publishStreamID)
+	case "publish":
+		if len(values) < 4 {
+			return fmt.Errorf("publish command has no stream name")
+		}
+		streamName, _ := values[3].(string)
+		return session.publish(message.StreamID, streamName)
+	case "play":
+		if len(values) < 4 {
+			return fmt.Errorf("play command has no stream name")
+		}
+		streamName, _ := values[3].(string)
+		return session.play(message.StreamID, streamName)
+	case "FCUnpublish", "deleteStream", "closeStream":
+		if session.publisher != nil {
+			err := session.publisher.close()
+			session.publisher = nil
+			return err
+		}
+		if session.player != nil {
+			session.player.close()
+			session.player = nil
+		}
+	}
+	return nil
+}
+
+//processData handle onMetaData of publisher
+func (session *Session) processData(values []interface{}) {
+	if len(values) > 0 && values[0] == "@setDataFrame" {
+		values = values[1:]
+	}
+	if len(values) < 2 || values[0] != "onMetaData" || session.publisher == nil {
+		return
+	}
+	if metadata, ok := values[1].(Object); ok {
+		session.publisher.setMetadata(metadata)
+	}
+}
+
+//connect accept connect command
+func (session *Session) connect(transactionID float64) error {
+	window := make([]byte, 4)
+	binary.BigEndian.PutUint32(window, uint32(WindowAckSize))
+	chunkSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunkSize, uint32(ChunkSize))
+	if err := session.writeMessages(chunkStreamControl,
+		&Message{Type: MessageWindowAckSize, Payload: window},
+		// dynamic limit type
+		&Message{Type: MessageSetPeerBandwidth, Payload: append(window, 2)},
+		&Message{Type: MessageSetChunkSize, Payload: chunkSize}); err != nil {
+		return err
+	}
+	if err := session.writer.SetChunkSize(ChunkSize); err != nil {
+		return err
+	}
+	return session.writeCommand(0, "_result", transactionID,
+		Object{
+			{Name: "fmsVer", Value: "FMS/3,0,1,123"},
+			{Name: "capabilities", Value: 31},
+		},
+		Object{
+			{Name: "level", Value: "status"},
+			{Name: "code", Value: "NetConnection.Connect.Success"},
+			{Name: "description", Value: "Connection succeeded."},
+			{Name: "objectEncoding", Value: 0},
+		})
+}
+
+//resourcePath resource path of stream name in app,query of stream name is ignored
+func (session *Session) resourcePath(streamName string) string {
+	if index := strings.Index(streamName, "?"); index >= 0 {
+		streamName = streamName[:index]
+	}
+	if session.App == "" {
+		return "/" + strings.Trim(streamName, "/")
+	}
+	return "/" + session.App + "/" + strings.Trim(streamName, "/")
+}
+
+//publish accept publish command if resource path of app and stream name is free,
+//the path is reserved until publisher registers or closes
+func (session *Session) publish(streamID uint32, streamName string) error {
+	resourcePath := session.resourcePath(streamName)
+	if session.publisher != nil || session.player != nil || streamName == "" ||
+		session.server.RtspServer.Reserve(resourcePath, session.ID) != nil {
+		if err := session.writeStatus(streamID, "error", "NetStream.Publish.BadName",
+			resourcePath+" already published"); err != nil {
+			return err
+		}
+		return fmt.Errorf("resource path %v already used", resourcePath)
+	}
+	session.publisher = newPublisher(session.server.RtspServer, resourcePath, session.ID)
+	if err := session.writeStreamBegin(streamID); err != nil {
+		return err
+	}
+	return session.writeStatus(streamID, "status", "NetStream.Publish.Start",
+		resourcePath+" is now published")
+}
+
+//play accept play command if resource path of app and stream name is published
+func (session *Session) play(streamID uint32, streamName string) error {
+	resourcePath := session.resourcePath(streamName)
+	published := session.server.RtspServer.FindPublished(resourcePath)
+	if published == nil || session.publisher != nil || session.player != nil {
+		if err := session.writeStatus(streamID, "error", "NetStream.Play.StreamNotFound",
+			resourcePath+" not found"); err != nil {
+			return err
+		}
+		return fmt.Errorf("resource path %v not published", resourcePath)
+	}
+	player, err := newPlayer(session, streamID, resourcePath, published)
+	if err != nil {
+		session.writeStatus(streamID, "error", "NetStream.Play.Failed", err.Error())
+		return err
+	}
+	if err := session.writeStreamBegin(streamID); err != nil {
+		return err
+	}
+	if err := session.writeStatus(streamID, "status", "NetStream.Play.Reset",
+		"playing and resetting "+resourcePath); err != nil {
+		return err
+	}
+	if err := session.writeStatus(streamID, "status", "NetStream.Play.Start",
+		"started playing "+resourcePath); err != nil {
+		return err
+	}
+	if err := session.writeData(streamID, "|RtmpSampleAccess", true, true); err != nil {
+		return err
+	}
+	session.player = player
+	return player.start()
+}
+
+//writeStreamBegin send stream begin event
+func (session *Session) writeStreamBegin(streamID uint32) error {
+	event := make([]byte, 6)
+	binary.BigEndian.PutUint16(event, eventStreamBegin)
+	binary.BigEndian.PutUint32(event[2:], streamID)
+	return session.writeMessages(chunkStreamControl,
+		&Message{Type: MessageUserControl, Payload: event})
+}
+
+//writeData send amf0 data message
+func (session *Session) writeData(streamID uint32, values ...interface{}) error {
+	payload, err := MarshalAMF0(values...)
+	if err != nil {
+		return err
+	}
+	return session.writeMessages(chunkStreamData,
+		&Message{Type: MessageDataAMF0, StreamID: streamID, Payload: payload})
+}
+
+//writeStatus send onStatus command of net stream
+func (session *Session) writeStatus(streamID uint32, level, code, description string) error {
+	return session.writeCommand(streamID, "onStatus", 0, nil, Object{
+		{Name: "level", Value: level},
+		{Name: "code", Value: code},
+		{Name: "description", Value: description},
+	})
+}
+
+//writeCommand send amf0 command
+func (session *Session) writeCommand(streamID uint32, name string,
+	transactionID float64, values ...interface{}) error {
+	payload, err := MarshalAMF0(append([]interface{}{name, transactionID}, values...)...)
+	if err != nil {
+		return err
+	}
+	return session.writeMessages(chunkStreamCommand,
+		&Message{Type: MessageCommandAMF0, StreamID: streamID, Payload: payload})
+}
+
+//writeMessages write messages to chunk stream and flush
+func (session *Session) writeMessages(chunkStreamID uint32, messages ...*Message) error {
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	for _, message := range messages {
+		if err := session.writer.WriteMessage(chunkStreamID, message); err != nil {
+			return err
+		}
+	}
+	if err := session.bufio.Flush(); err != nil {
+		return fmt.Errorf("rtmp write error:%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,558 @@
+package rtmp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/flv"
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/internal/nettest"
+	"github.com/darunshen/go/streamProtocol/rtp"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb}
+	// AAC-LC 44100Hz stereo
+	testAudioConfig = []byte{0x12, 0x10}
+)
+
+func TestAMF0(t *testing.T) {
+	object := Object{
+		{Name: "app", Value: "live"},
+		{Name: "audioCodecs", Value: float64(3575)},
+		{Name: "fpad", Value: false},
+		{Name: "nested", Value: Object{{Name: "level", Value: "status"}}},
+	}
+	data, err := MarshalAMF0("connect", 1, object, nil, []interface{}{"a", 2.5})
+	if err != nil {
+		t.Fatalf("MarshalAMF0 error:%v", err)
+	}
+	values, err := UnmarshalAMF0(data)
+	if err != nil {
+		t.Fatalf("UnmarshalAMF0 error:%v", err)
+	}
+	if len(values) != 5 || values[0] != "connect" || values[1] != float64(1) ||
+		values[3] != nil {
+		t.Fatalf("UnmarshalAMF0 = %v", values)
+	}
+	parsed := values[2].(Object)
+	if parsed.GetString("app") != "live" || parsed.Get("fpad") != false ||
+		parsed.Get("nested").(Object).GetString("level") != "status" {
+		t.Errorf("object = %v", parsed)
+	}
+	if items := values[4].([]interface{}); len(items) != 2 || items[1] != 2.5 {
+		t.Errorf("strict array = %v", items)
+	}
+	// ecma array of onMetaData
+	ecma := []byte{amf0ECMAArray, 0, 0, 0, 1, 0, 5, 'w', 'i', 'd', 't', 'h'}
+	ecma, _ = appendAMF0(ecma, 1920)
+	ecma = append(ecma, 0, 0, amf0ObjectEnd)
+	if values, err := UnmarshalAMF0(ecma); err != nil ||
+		values[0].(Object).Get("width") != float64(1920) {
+		t.Errorf("ecma array = %v,%v", values, err)
+	}
+}
+
+func TestChunkStream(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewChunkWriter(buffer)
+	writer.SetChunkSize(100)
+	messages := []*Message{
+		{Type: MessageVideo, StreamID: 1, Timestamp: 40, Payload: make([]byte, 250)},
+		{Type: MessageAudio, StreamID: 1, Timestamp: 0x1000000, Payload: make([]byte, 150)},
+		{Type: MessageCommandAMF0, Payload: []byte{}},
+	}
+	for index, message := range messages {
+		for i := range message.Payload {
+			message.Payload[i] = byte(i + index)
+		}
+		if err := writer.WriteMessage(uint32(4+index*100), message); err != nil {
+			t.Fatalf("WriteMessage error:%v", err)
+		}
+	}
+	// type 3 header of a new message repeats the delta of type 1 header
+	buffer.Write([]byte{0x44, 0, 0, 20, 0, 0, 1, byte(MessageVideo), 7})
+	buffer.Write([]byte{0xc4, 8})
+	reader := NewChunkReader(buffer)
+	reader.SetChunkSize(100)
+	for _, message := range messages {
+		read, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage error:%v", err)
+		}
+		if read.Type != message.Type || read.StreamID != message.StreamID ||
+			read.Timestamp != message.Timestamp || !bytes.Equal(read.Payload, message.Payload) {
+			t.Errorf("ReadMessage = %+v, want %+v", read, message)
+		}
+	}
+	for _, timestamp := range []uint32{60, 80} {
+		if read, err := reader.ReadMessage(); err != nil || read.Timestamp != timestamp ||
+			read.StreamID != 1 {
+			t.Errorf("ReadMessage = %+v,%v, want timestamp %v", read, err, timestamp)
+		}
+	}
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage error = %v, want EOF", err)
+	}
+}
+
+func TestChunkStreamMemory(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	reader := NewChunkReader(buffer)
+	reader.SetChunkSize(1)
+	// headers of max length followed by a chunk of a byte each
+	for chunkStreamID := 0; chunkStreamID < 40; chunkStreamID++ {
+		buffer.Write([]byte{0x01, byte(chunkStreamID), byte(chunkStreamID >> 8),
+			0, 0, 0, 0xff, 0xff, 0xff, byte(MessageVideo), 1, 0, 0, 0, 0})
+		if _, err := reader.ReadMessage(); err != io.EOF {
+			t.Fatalf("ReadMessage error = %v, want EOF", err)
+		}
+	}
+	for _, stream := range reader.streams {
+		if len(stream.payload) != 1 || cap(stream.payload) > 64 {
+			t.Fatalf("payload of %v bytes allocated for a byte", cap(stream.payload))
+		}
+	}
+	// chunks short of a byte of two messages go over MaxPendingSize
+	reader.SetChunkSize(0xffffff - 2)
+	buffer.Write([]byte{0xc1, 0, 0})
+	buffer.Write(make([]byte, 0xffffff-2))
+	buffer.Write([]byte{0xc1, 1, 0})
+	if _, err := reader.ReadMessage(); err == nil || err == io.EOF {
+		t.Errorf("ReadMessage error = %v, want partial messages too large", err)
+	}
+}
+
+//testClient rtmp client for publishing in tests
+type testClient struct {
+	conn   net.Conn
+	reader *ChunkReader
+	writer *ChunkWriter
+}
+
+//dialClient connect to app of rtmp server
+func dialClient(t *testing.T, address, app string) *testClient {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = handshakeVersion
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	if _, err := conn.Write(c0c1); err != nil {
+		t.Fatalf("write C0 C1 error:%v", err)
+	}
+	if _, err := io.ReadFull(conn, s0s1s2); err != nil {
+		t.Fatalf("read S0 S1 S2 error:%v", err)
+	}
+	if _, err := conn.Write(s0s1s2[1 : 1+handshakeSize]); err != nil {
+		t.Fatalf("write C2 error:%v", err)
+	}
+	client := &testClient{conn: conn, reader: NewChunkReader(conn), writer: NewChunkWriter(conn)}
+	client.command(t, 0, "connect", 1, Object{{Name: "app", Value: app}})
+	client.waitCommand(t, "_result")
+	return client
+}
+
+//command send amf0 command
+func (client *testClient) command(t *testing.T, streamID uint32, name string,
+	transactionID float64, values ...interface{}) {
+	payload, err := MarshalAMF0(append([]interface{}{name, transactionID}, values...)...)
+	if err != nil {
+		t.Fatalf("MarshalAMF0 error:%v", err)
+	}
+	client.send(t, &Message{Type: MessageCommandAMF0, StreamID: streamID, Payload: payload})
+}
+
+//send write message in chunk stream 4
+func (client *testClient) send(t *testing.T, message *Message) {
+	if err := client.writer.WriteMessage(4, message); err != nil {
+		t.Fatalf("WriteMessage error:%v", err)
+	}
+}
+
+//waitCommand read messages until command name,return its values
+func (client *testClient) waitCommand(t *testing.T, name string) []interface{} {
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message, err := client.reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage error:%v", err)
+		}
+		switch message.Type {
+		case MessageSetChunkSize:
+			client.reader.SetChunkSize(int(message.Payload[3]) | int(message.Payload[2])<<8)
+		case MessageCommandAMF0:
+			values, err := UnmarshalAMF0(message.Payload)
+			if err != nil {
+				t.Fatalf("UnmarshalAMF0 error:%v", err)
+			}
+			if values[0] == name {
+				return values
+			}
+		}
+	}
+}
+
+//readMedia read messages until an audio or video message
+func (client *testClient) readMedia(t *testing.T) *Message {
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message, err := client.reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage error:%v", err)
+		}
+		switch message.Type {
+		case MessageSetChunkSize:
+			client.reader.SetChunkSize(int(message.Payload[3]) | int(message.Payload[2])<<8)
+		case MessageAudio, MessageVideo:
+			return message
+		}
+	}
+}
+
+//publish publish stream and send metadata and sequence headers
+func (client *testClient) publish(t *testing.T, stream string) []interface{} {
+	client.command(t, 0, "createStream", 2, nil)
+	client.waitCommand(t, "_result")
+	client.command(t, publishStreamID, "publish", 3, nil, stream, "live")
+	return client.waitCommand(t, "onStatus")
+}
+
+//sendHeaders send metadata and sequence headers
+func (client *testClient) sendHeaders(t *testing.T) {
+	metadata, _ := MarshalAMF0("@setDataFrame", "onMetaData", Object{
+		{Name: "videocodecid", Value: flv.VideoCodecAVC},
+		{Name: "audiocodecid", Value: flv.SoundFormatAAC},
+	})
+	client.send(t, &Message{Type: MessageDataAMF0, StreamID: publishStreamID, Payload: metadata})
+	config, _ := h264.MarshalDecoderConfig(testSPS, testPPS)
+	client.send(t, &Message{Type: MessageVideo, StreamID: publishStreamID,
+		Payload: (&flv.VideoTag{FrameType: flv.FrameTypeKey, CodecID: flv.VideoCodecAVC,
+			PacketType: flv.PacketTypeSequenceHeader, Data: config}).Marshal()})
+	client.send(t, &Message{Type: MessageAudio, StreamID: publishStreamID,
+		Payload: (&flv.AudioTag{SoundFormat: flv.SoundFormatAAC, SoundRate: 3, SoundSize: 1,
+			SoundType: 1, PacketType: flv.PacketTypeSequenceHeader,
+			Data: testAudioConfig}).Marshal()})
+}
+
+//sendFrames send video frames [from,to) at 25 fps and an aac frame after each
+func (client *testClient) sendFrames(t *testing.T, from, to int) {
+	for index := from; index < to; index++ {
+		frameType, nalu := uint8(flv.FrameTypeInter), []byte{0x41, byte(index)}
+		if index%25 == 0 {
+			frameType, nalu = flv.FrameTypeKey, []byte{0x65, byte(index)}
+		}
+		client.send(t, &Message{Type: MessageVideo, StreamID: publishStreamID,
+			Timestamp: uint32(index * 40),
+			Payload: (&flv.VideoTag{FrameType: frameType, CodecID: flv.VideoCodecAVC,
+				PacketType: flv.PacketTypeRaw,
+				Data:       h264.NALUsToAVCC([][]byte{nalu})}).Marshal()})
+		client.send(t, &Message{Type: MessageAudio, StreamID: publishStreamID,
+			Timestamp: uint32(index * 40),
+			Payload: (&flv.AudioTag{SoundFormat: flv.SoundFormatAAC, SoundRate: 3, SoundSize: 1,
+				SoundType: 1, PacketType: flv.PacketTypeRaw, Data: []byte{0x21, byte(index)}}).Marshal()})
+	}
+}
+
+//startServers start a rtsp server and a rtmp server on free local ports,
+//they are stopped by stopServers
+func startServers(t *testing.T) (*Server, string, string) {
+	rtspServer := &rtsp.Server{}
+	rtspAddress := nettest.Start(t, rtspServer)
+	server := &Server{RtspServer: rtspServer}
+	return server, rtspAddress, nettest.Start(t, server)
+}
+
+//stopServers stop servers of startServers
+func stopServers(server *Server) {
+	server.Stop()
+	server.RtspServer.Stop()
+}
+
+func TestPublish(t *testing.T) {
+	server, rtspAddress, rtmpAddress := startServers(t)
+	defer stopServers(server)
+	client := dialClient(t, rtmpAddress, "live")
+	defer client.conn.Close()
+	status := client.publish(t, "test?token=1")
+	if code := status[3].(Object).GetString("code"); code != "NetStream.Publish.Start" {
+		t.Fatalf("publish status %v", code)
+	}
+	client.sendHeaders(t)
+	client.sendFrames(t, 0, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	session := server.RtspServer.FindPublished("/live/test")
+	for ; session == nil && time.Now().Before(deadline); session = server.RtspServer.FindPublished("/live/test") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if session == nil {
+		t.Fatalf("stream not published")
+	}
+	for _, line := range []string{"a=rtpmap:96 H264/90000",
+		"sprop-parameter-sets=" + h264.SpropParameterSets(testSPS, testPPS),
+		"a=rtpmap:97 MPEG4-GENERIC/44100/2", "config=1210"} {
+		if !strings.Contains(*session.SdpContent, line) {
+			t.Errorf("sdp has no %v:\n%v", line, *session.SdpContent)
+		}
+	}
+	frames := make(chan *rtsp.Frame, 100)
+	if err := session.Tracks[0].AddFrameHandler("test", func(frame *rtsp.Frame) {
+		frames <- frame
+	}); err != nil {
+		t.Fatalf("AddFrameHandler error:%v", err)
+	}
+
+	// rtsp puller watches rtmp publisher
+	puller, err := rtsp.NewClient(fmt.Sprintf("rtsp://%v/live/test", rtspAddress),
+		rtsp.TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	received := make(chan int, 100)
+	puller.OnPackage = func(trackIndex int, packageType rtsp.PackageType,
+		data rtsp.RtpRtcpPackage) {
+		if packageType == rtsp.RtpPackage {
+			select {
+			case received <- trackIndex:
+			default:
+			}
+		}
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	if err := puller.StartPull(); err != nil {
+		t.Fatalf("StartPull error:%v", err)
+	}
+	client.sendFrames(t, 1, 26)
+	tracks := make(map[int]bool)
+	for len(tracks) < 2 {
+		select {
+		case index := <-received:
+			tracks[index] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("puller received packages of tracks %v, want 2 tracks", tracks)
+		}
+	}
+	for index := 1; index <= 25; index++ {
+		select {
+		case frame := <-frames:
+			if frame.Timestamp != uint32(index*3600) {
+				t.Errorf("frame %v timestamp %v, want %v", index, frame.Timestamp, index*3600)
+			}
+			if index == 25 && (!frame.IsKeyframe || len(frame.Units) != 3 ||
+				!bytes.Equal(frame.Units[0], testSPS)) {
+				t.Errorf("keyframe = %+v, want parameter sets before idr", frame)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("frame %v not received", index)
+		}
+	}
+
+	// the same stream can not be published twice
+	other := dialClient(t, rtmpAddress, "live")
+	defer other.conn.Close()
+	if code := other.publish(t, "test")[3].(Object).GetString("code"); code != "NetStream.Publish.BadName" {
+		t.Errorf("second publish status %v", code)
+	}
+
+	client.conn.Close()
+	for server.RtspServer.FindPublished("/live/test") != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.RtspServer.FindPublished("/live/test") != nil {
+		t.Errorf("stream not unpublished after publisher closed")
+	}
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	server, _, rtmpAddress := startServers(t)
+	defer stopServers(server)
+	clients := []*testClient{dialClient(t, rtmpAddress, "live"), dialClient(t, rtmpAddress, "live")}
+	codes := make(chan string, len(clients))
+	for _, client := range clients {
+		defer client.conn.Close()
+		client.command(t, 0, "createStream", 2, nil)
+		client.waitCommand(t, "_result")
+	}
+	// both publish before any sequence header registers the stream
+	for _, client := range clients {
+		go func(client *testClient) {
+			client.command(t, publishStreamID, "publish", 3, nil, "test", "live")
+			codes <- client.waitCommand(t, "onStatus")[3].(Object).GetString("code")
+		}(client)
+	}
+	started, refused := 0, 0
+	for range clients {
+		switch code := <-codes; code {
+		case "NetStream.Publish.Start":
+			started++
+		case "NetStream.Publish.BadName":
+			refused++
+		default:
+			t.Errorf("publish status %v", code)
+		}
+	}
+	if started != 1 || refused != 1 {
+		t.Fatalf("%v publishers started,%v refused, want 1 and 1", started, refused)
+	}
+
+	// the path is released if publisher closes before registered
+	for _, client := range clients {
+		client.conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client := dialClient(t, rtmpAddress, "live")
+		code := client.publish(t, "test")[3].(Object).GetString("code")
+		if code == "NetStream.Publish.Start" {
+			// Stop closes sessions of clients still connected
+			server.Stop()
+			client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("read after Stop error:%v, want eof", err)
+			}
+			client.conn.Close()
+			break
+		}
+		client.conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("path not released after publisher closed,status %v", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAKKzZQHgCJw==,aOvjyw==\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=fmtp:97 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;" +
+	"indexdeltalength=3;config=1210\r\n" +
+	"a=control:streamid=1\r\n"
+
+//pushFrames push video frames [from,to) at 25 fps with an aac frame after each
+func pushFrames(t *testing.T, pusher *rtsp.Client, from, to int) {
+	videoPacketizer := h264.NewPacketizer(1200, 96, 1)
+	audioPacketizer := aac.NewPacketizer(1200, 97, 2)
+	for index := from; index < to; index++ {
+		nalu := []byte{0x41, byte(index)}
+		if index%25 == 0 {
+			nalu[0] = 0x65
+		}
+		packets, err := videoPacketizer.Packetize([][]byte{nalu}, uint32(index*3600))
+		if err != nil {
+			t.Fatalf("Packetize error:%v", err)
+		}
+		audioPackets, err := audioPacketizer.Packetize([]byte{0x21, byte(index)},
+			uint32(index*1764))
+		if err != nil {
+			t.Fatalf("Packetize error:%v", err)
+		}
+		for trackIndex, packets := range [][]*rtp.Packet{packets, audioPackets} {
+			for _, packet := range packets {
+				data, _ := packet.Marshal()
+				if err := pusher.PushPackage(trackIndex, rtsp.RtpPackage, data); err != nil {
+					t.Fatalf("PushPackage error:%v", err)
+				}
+			}
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	PlayerCheckInterval = 50 * time.Millisecond
+	server, rtspAddress, rtmpAddress := startServers(t)
+	defer stopServers(server)
+	pusher, err := rtsp.NewClient(fmt.Sprintf("rtsp://%v/live/test", rtspAddress),
+		rtsp.TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+
+	client := dialClient(t, rtmpAddress, "live")
+	defer client.conn.Close()
+	client.command(t, 0, "createStream", 2, nil)
+	client.waitCommand(t, "_result")
+	client.command(t, publishStreamID, "play", 3, nil, "missing")
+	if code := client.waitCommand(t, "onStatus")[3].(Object).GetString("code"); code != "NetStream.Play.StreamNotFound" {
+		t.Errorf("play status of missing stream %v", code)
+	}
+	client = dialClient(t, rtmpAddress, "live")
+	defer client.conn.Close()
+	client.command(t, 0, "createStream", 2, nil)
+	client.waitCommand(t, "_result")
+	client.command(t, publishStreamID, "play", 3, nil, "test")
+	for _, want := range []string{"NetStream.Play.Reset", "NetStream.Play.Start"} {
+		if code := client.waitCommand(t, "onStatus")[3].(Object).GetString("code"); code != want {
+			t.Fatalf("play status %v, want %v", code, want)
+		}
+	}
+
+	pushFrames(t, pusher, 0, 25)
+	message := client.readMedia(t)
+	audioHeader := new(flv.AudioTag)
+	if err := audioHeader.Unmarshal(message.Payload); err != nil ||
+		message.Type != MessageAudio || audioHeader.PacketType != flv.PacketTypeSequenceHeader ||
+		!bytes.Equal(audioHeader.Data, testAudioConfig) {
+		t.Fatalf("first message %+v, want aac sequence header", message)
+	}
+	message = client.readMedia(t)
+	videoHeader := new(flv.VideoTag)
+	if err := videoHeader.Unmarshal(message.Payload); err != nil ||
+		message.Type != MessageVideo || videoHeader.PacketType != flv.PacketTypeSequenceHeader {
+		t.Fatalf("second message %+v, want avc sequence header", message)
+	}
+	if sps, pps, err := h264.ParseDecoderConfig(videoHeader.Data); err != nil ||
+		!bytes.Equal(sps, testSPS) || !bytes.Equal(pps, testPPS) {
+		t.Errorf("ParseDecoderConfig = %x,%x,%v", sps, pps, err)
+	}
+	keyframe := new(flv.VideoTag)
+	message = client.readMedia(t)
+	if err := keyframe.Unmarshal(message.Payload); err != nil || message.Timestamp != 0 ||
+		keyframe.FrameType != flv.FrameTypeKey || keyframe.PacketType != flv.PacketTypeRaw {
+		t.Fatalf("third message %+v, want keyframe", message)
+	}
+	if nalus, err := h264.AVCCToNALUs(keyframe.Data); err != nil ||
+		!bytes.Equal(nalus[len(nalus)-1], []byte{0x65, 0}) {
+		t.Errorf("keyframe nalus = %x,%v", nalus, err)
+	}
+	for {
+		message = client.readMedia(t)
+		if message.Type == MessageVideo {
+			break
+		}
+	}
+	if message.Timestamp != 40 {
+		t.Errorf("second video frame timestamp %v, want 40", message.Timestamp)
+	}
+
+	pusher.Close()
+	if code := client.waitCommand(t, "onStatus")[3].(Object).GetString("code"); code != "NetStream.Play.UnpublishNotify" {
+		t.Errorf("status after unpublished %v", code)
+	}
+}
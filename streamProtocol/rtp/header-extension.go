@@ -0,0 +1,164 @@
+package rtp
+
+import (
+	"fmt"
+)
+
+const (
+	//ExtensionProfileOneByte one-byte header extension profile(rfc8285 4.2)
+	ExtensionProfileOneByte uint16 = 0xBEDE
+	//ExtensionProfileTwoByte two-byte header extension profile(rfc8285 4.3),
+	//the low 4 bits are appbits
+	ExtensionProfileTwoByte uint16 = 0x1000
+)
+
+//Extension one header extension element,
+//for profiles not defined by rfc8285 ID is 0 and Payload is the whole extension data
+type Extension struct {
+	ID      uint8
+	Payload []byte
+}
+
+//isOneByte if profile is one-byte header extension
+func isOneByte(profile uint16) bool {
+	return profile == ExtensionProfileOneByte
+}
+
+//isTwoByte if profile is two-byte header extension
+func isTwoByte(profile uint16) bool {
+	return profile&0xfff0 == ExtensionProfileTwoByte
+}
+
+//unmarshalExtensions parse extension elements,payloads share memory with buf
+func unmarshalExtensions(profile uint16, buf []byte) ([]Extension, error) {
+	if !isOneByte(profile) && !isTwoByte(profile) {
+		return []Extension{{ID: 0, Payload: buf}}, nil
+	}
+	extensions := make([]Extension, 0, 2)
+	for offset := 0; offset < len(buf); {
+		if buf[offset] == 0 {
+			// padding
+			offset++
+			continue
+		}
+		var id uint8
+		var length int
+		if isOneByte(profile) {
+			id = buf[offset] >> 4
+			length = int(buf[offset]&0x0f) + 1
+			if id == 15 {
+				// reserved id,stop parsing
+				break
+			}
+			offset++
+		} else {
+			if offset+1 >= len(buf) {
+				return nil, fmt.Errorf("two-byte extension element too short")
+			}
+			id = buf[offset]
+			length = int(buf[offset+1])
+			offset += 2
+		}
+		if offset+length > len(buf) {
+			return nil, fmt.Errorf("extension element %v length %v out of range", id, length)
+		}
+		extensions = append(extensions, Extension{ID: id, Payload: buf[offset : offset+length]})
+		offset += length
+	}
+	return extensions, nil
+}
+
+//extensionsSize size of extension elements including padding to 32-bit
+func extensionsSize(profile uint16, extensions []Extension) int {
+	size := 0
+	for _, extension := range extensions {
+		switch {
+		case isOneByte(profile):
+			size += 1 + len(extension.Payload)
+		case isTwoByte(profile):
+			size += 2 + len(extension.Payload)
+		default:
+			size += len(extension.Payload)
+		}
+	}
+	return (size + 3) / 4 * 4
+}
+
+//marshalExtensions marshal extension elements into buf with zero padding
+func marshalExtensions(profile uint16, extensions []Extension, buf []byte) error {
+	offset := 0
+	for _, extension := range extensions {
+		switch {
+		case isOneByte(profile):
+			if extension.ID < 1 || extension.ID > 14 {
+				return fmt.Errorf("one-byte extension id %v invalid", extension.ID)
+			}
+			if len(extension.Payload) < 1 || len(extension.Payload) > 16 {
+				return fmt.Errorf("one-byte extension length %v invalid", len(extension.Payload))
+			}
+			buf[offset] = extension.ID<<4 | uint8(len(extension.Payload)-1)
+			offset++
+		case isTwoByte(profile):
+			if extension.ID < 1 {
+				return fmt.Errorf("two-byte extension id %v invalid", extension.ID)
+			}
+			if len(extension.Payload) > 255 {
+				return fmt.Errorf("two-byte extension length %v invalid", len(extension.Payload))
+			}
+			buf[offset] = extension.ID
+			buf[offset+1] = uint8(len(extension.Payload))
+			offset += 2
+		}
+		offset += copy(buf[offset:], extension.Payload)
+	}
+	for ; offset < len(buf); offset++ {
+		buf[offset] = 0
+	}
+	return nil
+}
+
+//GetExtension get payload of extension element by id,nil if not found
+func (header *Header) GetExtension(id uint8) []byte {
+	if !header.Extension {
+		return nil
+	}
+	for _, extension := range header.Extensions {
+		if extension.ID == id {
+			return extension.Payload
+		}
+	}
+	return nil
+}
+
+//SetExtension set payload of extension element by id,
+//one-byte profile is used if no extension before
+func (header *Header) SetExtension(id uint8, payload []byte) error {
+	if !header.Extension {
+		header.Extension = true
+		header.ExtensionProfile = ExtensionProfileOneByte
+		header.Extensions = nil
+	}
+	switch {
+	case isOneByte(header.ExtensionProfile):
+		if id < 1 || id > 14 {
+			return fmt.Errorf("one-byte extension id %v invalid", id)
+		}
+		if len(payload) < 1 || len(payload) > 16 {
+			return fmt.Errorf("one-byte extension length %v invalid", len(payload))
+		}
+	case isTwoByte(header.ExtensionProfile):
+		if id < 1 || len(payload) > 255 {
+			return fmt.Errorf("two-byte extension id %v or length %v invalid", id, len(payload))
+		}
+	default:
+		return fmt.Errorf("extension profile %#x not support elements", header.ExtensionProfile)
+	}
+	for i := range header.Extensions {
+		if header.Extensions[i].ID == id {
+			header.Extensions[i].Payload = payload
+			return nil
+		}
+	}
+	header.Extensions = append(header.Extensions, Extension{ID: id, Payload: payload})
+	return nil
+}
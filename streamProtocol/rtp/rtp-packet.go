@@ -0,0 +1,240 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	//Version rtp version
+	Version uint8 = 2
+	//HeaderLength length of rtp fixed header
+	HeaderLength int = 12
+	//MaxCSRCCount max number of csrc in header
+	MaxCSRCCount int = 15
+)
+
+//Header rtp header(rfc3550 5.1) with header extension
+type Header struct {
+	Version          uint8       // rtp version,should be 2
+	Padding          bool        // if padding at the end of payload
+	Extension        bool        // if header extension exists
+	Marker           bool        // marker bit,end of frame for video
+	PayloadType      uint8       // payload type
+	SequenceNumber   uint16      // sequence number
+	Timestamp        uint32      // rtp timestamp
+	SSRC             uint32      // synchronization source
+	CSRC             []uint32    // contributing sources
+	ExtensionProfile uint16      // defined by profile,0xBEDE/0x100X for rfc8285
+	Extensions       []Extension // header extension elements
+}
+
+//Packet rtp packet
+type Packet struct {
+	Header
+	Payload     []byte // payload,shares memory with the parsed buffer
+	PaddingSize uint8  // padding length including the last count byte
+	/*
+		Raw the buffer Unmarshal parsed from,
+		not updated when fields are changed,use Marshal instead
+	*/
+	Raw []byte
+}
+
+//Unmarshal parse rtp header from buf,return header length
+func (header *Header) Unmarshal(buf []byte) (int, error) {
+	if len(buf) < HeaderLength {
+		return 0, fmt.Errorf("rtp header too short:%v", len(buf))
+	}
+	header.Version = buf[0] >> 6
+	if header.Version != Version {
+		return 0, fmt.Errorf("rtp version %v not support", header.Version)
+	}
+	header.Padding = buf[0]&0x20 != 0
+	header.Extension = buf[0]&0x10 != 0
+	csrcCount := int(buf[0] & 0x0f)
+	header.Marker = buf[1]&0x80 != 0
+	header.PayloadType = buf[1] & 0x7f
+	header.SequenceNumber = binary.BigEndian.Uint16(buf[2:])
+	header.Timestamp = binary.BigEndian.Uint32(buf[4:])
+	header.SSRC = binary.BigEndian.Uint32(buf[8:])
+	offset := HeaderLength
+	if len(buf) < offset+4*csrcCount {
+		return 0, fmt.Errorf("rtp header too short for %v csrc", csrcCount)
+	}
+	header.CSRC = nil
+	if csrcCount > 0 {
+		header.CSRC = make([]uint32, csrcCount)
+		for i := range header.CSRC {
+			header.CSRC[i] = binary.BigEndian.Uint32(buf[offset:])
+			offset += 4
+		}
+	}
+	header.ExtensionProfile = 0
+	header.Extensions = nil
+	if header.Extension {
+		if len(buf) < offset+4 {
+			return 0, fmt.Errorf("rtp header too short for extension")
+		}
+		header.ExtensionProfile = binary.BigEndian.Uint16(buf[offset:])
+		extensionLength := 4 * int(binary.BigEndian.Uint16(buf[offset+2:]))
+		offset += 4
+		if len(buf) < offset+extensionLength {
+			return 0, fmt.Errorf("rtp header too short for extension length %v",
+				extensionLength)
+		}
+		extensions, err := unmarshalExtensions(header.ExtensionProfile,
+			buf[offset:offset+extensionLength])
+		if err != nil {
+			return 0, err
+		}
+		header.Extensions = extensions
+		offset += extensionLength
+	}
+	return offset, nil
+}
+
+//Unmarshal parse rtp packet from buf,payload and extensions share memory with buf
+func (packet *Packet) Unmarshal(buf []byte) error {
+	headerLength, err := packet.Header.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+	end := len(buf)
+	packet.PaddingSize = 0
+	if packet.Padding {
+		if end <= headerLength {
+			return fmt.Errorf("rtp padding bit set but no payload")
+		}
+		packet.PaddingSize = buf[end-1]
+		if packet.PaddingSize == 0 || int(packet.PaddingSize) > end-headerLength {
+			return fmt.Errorf("rtp padding size %v invalid", packet.PaddingSize)
+		}
+		end -= int(packet.PaddingSize)
+	}
+	packet.Payload = buf[headerLength:end]
+	packet.Raw = buf
+	return nil
+}
+
+//MarshalSize size of marshaled header
+func (header *Header) MarshalSize() int {
+	size := HeaderLength + 4*len(header.CSRC)
+	if header.Extension {
+		size += 4 + extensionsSize(header.ExtensionProfile, header.Extensions)
+	}
+	return size
+}
+
+//MarshalTo marshal header into buf,return written length
+func (header *Header) MarshalTo(buf []byte) (int, error) {
+	if len(header.CSRC) > MaxCSRCCount {
+		return 0, fmt.Errorf("rtp csrc count %v too large", len(header.CSRC))
+	}
+	size := header.MarshalSize()
+	if len(buf) < size {
+		return 0, fmt.Errorf("buffer too short for rtp header:%v<%v", len(buf), size)
+	}
+	buf[0] = header.Version<<6 | uint8(len(header.CSRC))
+	if header.Padding {
+		buf[0] |= 0x20
+	}
+	if header.Extension {
+		buf[0] |= 0x10
+	}
+	buf[1] = header.PayloadType & 0x7f
+	if header.Marker {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:], header.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:], header.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:], header.SSRC)
+	offset := HeaderLength
+	for _, csrc := range header.CSRC {
+		binary.BigEndian.PutUint32(buf[offset:], csrc)
+		offset += 4
+	}
+	if header.Extension {
+		extensionLength := extensionsSize(header.ExtensionProfile, header.Extensions)
+		binary.BigEndian.PutUint16(buf[offset:], header.ExtensionProfile)
+		binary.BigEndian.PutUint16(buf[offset+2:], uint16(extensionLength/4))
+		offset += 4
+		if err := marshalExtensions(header.ExtensionProfile, header.Extensions,
+			buf[offset:offset+extensionLength]); err != nil {
+			return 0, err
+		}
+		offset += extensionLength
+	}
+	return offset, nil
+}
+
+//MarshalSize size of marshaled packet
+func (packet *Packet) MarshalSize() int {
+	size := packet.Header.MarshalSize() + len(packet.Payload)
+	if packet.Padding {
+		size += int(packet.PaddingSize)
+	}
+	return size
+}
+
+//MarshalTo marshal packet into buf,return written length
+func (packet *Packet) MarshalTo(buf []byte) (int, error) {
+	if packet.Padding && packet.PaddingSize == 0 {
+		return 0, fmt.Errorf("rtp padding bit set but padding size is 0")
+	}
+	size := packet.MarshalSize()
+	if len(buf) < size {
+		return 0, fmt.Errorf("buffer too short for rtp packet:%v<%v", len(buf), size)
+	}
+	offset, err := packet.Header.MarshalTo(buf)
+	if err != nil {
+		return 0, err
+	}
+	offset += copy(buf[offset:], packet.Payload)
+	if packet.Padding {
+		for i := 0; i < int(packet.PaddingSize)-1; i++ {
+			buf[offset+i] = 0
+		}
+		buf[size-1] = packet.PaddingSize
+	}
+	return size, nil
+}
+
+//Marshal marshal packet into a new buffer
+func (packet *Packet) Marshal() ([]byte, error) {
+	buf := make([]byte, packet.MarshalSize())
+	if _, err := packet.MarshalTo(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+//Bytes the packet data to send,Raw if packet is parsed and not changed
+func (packet *Packet) Bytes() ([]byte, error) {
+	if packet.Raw != nil {
+		return packet.Raw, nil
+	}
+	return packet.Marshal()
+}
+
+//Clone deep copy of packet,Raw is not kept
+func (packet *Packet) Clone() *Packet {
+	clone := &Packet{
+		Header:      packet.Header,
+		PaddingSize: packet.PaddingSize,
+		Payload:     append([]byte(nil), packet.Payload...),
+	}
+	if packet.CSRC != nil {
+		clone.CSRC = append([]uint32(nil), packet.CSRC...)
+	}
+	if packet.Extensions != nil {
+		clone.Extensions = make([]Extension, len(packet.Extensions))
+		for i, extension := range packet.Extensions {
+			clone.Extensions[i] = Extension{
+				ID:      extension.ID,
+				Payload: append([]byte(nil), extension.Payload...),
+			}
+		}
+	}
+	return clone
+}
@@ -0,0 +1,99 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketUnmarshal(t *testing.T) {
+	raw := []byte{
+		0xb1, 0xe0, 0x12, 0x34, // V=2,P,X,CC=1,M,PT=96,seq
+		0x00, 0x00, 0x10, 0x00, // timestamp
+		0xde, 0xad, 0xbe, 0xef, // ssrc
+		0x00, 0x00, 0x00, 0x01, // csrc
+		0xbe, 0xde, 0x00, 0x01, // one-byte extension,1 word
+		0x10, 0xaa, 0x00, 0x00, // id=1 len=1,padding
+		0x65, 0x01, 0x02, // payload
+		0x00, 0x02, // padding
+	}
+	packet := new(Packet)
+	if err := packet.Unmarshal(raw); err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	if !packet.Marker || packet.PayloadType != 96 || packet.SequenceNumber != 0x1234 ||
+		packet.Timestamp != 0x1000 || packet.SSRC != 0xdeadbeef {
+		t.Errorf("header fields wrong:%+v", packet.Header)
+	}
+	if len(packet.CSRC) != 1 || packet.CSRC[0] != 1 {
+		t.Errorf("csrc = %v", packet.CSRC)
+	}
+	if ext := packet.GetExtension(1); !bytes.Equal(ext, []byte{0xaa}) {
+		t.Errorf("extension 1 = %v", ext)
+	}
+	if !bytes.Equal(packet.Payload, []byte{0x65, 0x01, 0x02}) || packet.PaddingSize != 2 {
+		t.Errorf("payload = %v,padding = %v", packet.Payload, packet.PaddingSize)
+	}
+	if &packet.Payload[0] != &raw[24] {
+		t.Errorf("payload is copied")
+	}
+	marshaled, err := packet.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal error:%v", err)
+	}
+	if !bytes.Equal(marshaled, raw) {
+		t.Errorf("Marshal = %x, want %x", marshaled, raw)
+	}
+}
+
+func TestPacketTwoByteExtension(t *testing.T) {
+	packet := &Packet{
+		Header: Header{
+			Version:          Version,
+			PayloadType:      111,
+			SequenceNumber:   65535,
+			Timestamp:        960,
+			SSRC:             1,
+			Extension:        true,
+			ExtensionProfile: ExtensionProfileTwoByte,
+		},
+		Payload: []byte{1, 2, 3, 4},
+	}
+	if err := packet.SetExtension(20, make([]byte, 20)); err != nil {
+		t.Fatalf("SetExtension error:%v", err)
+	}
+	if err := packet.SetExtension(3, nil); err != nil {
+		t.Fatalf("SetExtension error:%v", err)
+	}
+	raw, err := packet.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal error:%v", err)
+	}
+	if len(raw)%4 != 0 {
+		t.Errorf("marshaled length %v not aligned", len(raw))
+	}
+	parsed := new(Packet)
+	if err := parsed.Unmarshal(raw); err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	if len(parsed.Extensions) != 2 || len(parsed.GetExtension(20)) != 20 ||
+		parsed.GetExtension(3) == nil || len(parsed.GetExtension(3)) != 0 {
+		t.Errorf("extensions = %v", parsed.Extensions)
+	}
+	if !bytes.Equal(parsed.Payload, packet.Payload) {
+		t.Errorf("payload = %v", parsed.Payload)
+	}
+}
+
+func TestPacketUnmarshalError(t *testing.T) {
+	for _, raw := range [][]byte{
+		{0x80, 0x60, 0x00},
+		{0x40, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x81, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x90, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0xbe, 0xde, 0x00, 0x02},
+		{0xa0, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x05},
+	} {
+		if err := new(Packet).Unmarshal(raw); err == nil {
+			t.Errorf("Unmarshal(%x) should fail", raw)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// authentication settings,requests are not authenticated if Auth is nil
+var (
+	//Auth credentials and permissions of rtsp requests
+	Auth *Authenticator = nil
+)
+
+//Permission kind of access to resource paths
+type Permission string
+
+const (
+	//PermissionRead DESCRIBE and PLAY,SETUP of pullers
+	PermissionRead Permission = "read"
+	//PermissionPublish ANNOUNCE and RECORD,SETUP of pushers
+	PermissionPublish Permission = "publish"
+)
+
+//AuthUser credentials and permissions of a user,
+//patterns are of path.Match,and a pattern ending with /** matches all paths under it
+type AuthUser struct {
+	Name     string   `json:"name"`     // user name,empty for requests without credentials
+	Password string   `json:"password"` // password in plain text
+	Read     []string `json:"read"`     // patterns of resource paths allowed to read
+	Publish  []string `json:"publish"`  // patterns of resource paths allowed to publish
+}
+
+/*
+Authenticator rfc2617 digest and basic authentication of rtsp requests,
+loaded from a json file like:
+
+	{
+		"realm": "streamProtocol",
+		"basic": false,
+		"users": [
+			{"name": "admin", "password": "secret", "read": ["/**"], "publish": ["/**"]},
+			{"name": "", "read": ["/public/*"]}
+		]
+	}
+*/
+type Authenticator struct {
+	Realm string      `json:"realm"` // realm in challenges
+	Basic bool        `json:"basic"` // if basic authentication is allowed besides digest
+	Users []*AuthUser `json:"users"` // users,the one without name is for anonymous requests
+}
+
+//LoadAuthenticator load credentials and permissions from json file
+func LoadAuthenticator(filePath string) (*Authenticator, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile error:%v", err)
+	}
+	auth := new(Authenticator)
+	if err := json.Unmarshal(data, auth); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error:%v", err)
+	}
+	if auth.Realm == "" {
+		auth.Realm = "streamProtocol"
+	}
+	for _, user := range auth.Users {
+		for _, pattern := range append(append([]string{}, user.Read...), user.Publish...) {
+			if _, err := path.Match(pattern, "/"); err != nil {
+				return nil, fmt.Errorf("pattern %v of user %v error:%v", pattern, user.Name, err)
+			}
+		}
+	}
+	return auth, nil
+}
+
+//Challenge WWW-Authenticate headers of 401 response,
+//digest is the last one for clients taking only one
+func (auth *Authenticator) Challenge(nonce string) string {
+	challenge := ""
+	if auth.Basic {
+		challenge += fmt.Sprintf("WWW-Authenticate: Basic realm=\"%v\"\r\n", auth.Realm)
+	}
+	return challenge + fmt.Sprintf("WWW-Authenticate: Digest realm=\"%v\", nonce=\"%v\"\r\n",
+		auth.Realm, nonce)
+}
+
+//Authenticate find user of Authorization header of request to requestURL,
+//the anonymous user if no credentials,nil if credentials invalid or user not found
+func (auth *Authenticator) Authenticate(method, requestURL, authorization, nonce string) *AuthUser {
+	scheme, credentials := authorization, ""
+	if index := strings.Index(authorization, " "); index >= 0 {
+		scheme, credentials = authorization[:index], strings.TrimSpace(authorization[index+1:])
+	}
+	switch {
+	case authorization == "":
+		return auth.user("")
+	case strings.EqualFold(scheme, "Basic") && auth.Basic:
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return nil
+		}
+		items := strings.SplitN(string(decoded), ":", 2)
+		if user := auth.user(items[0]); user != nil && items[0] != "" && len(items) == 2 &&
+			subtle.ConstantTimeCompare([]byte(user.Password), []byte(items[1])) == 1 {
+			return user
+		}
+	case strings.EqualFold(scheme, "Digest"):
+		params := authParams(credentials)
+		user := auth.user(params["username"])
+		// credentials of other requests are not replayed to this one
+		if user == nil || params["username"] == "" || params["realm"] != auth.Realm ||
+			params["nonce"] != nonce || params["uri"] != requestURL {
+			return nil
+		}
+		response := digestResponse(user.Name, auth.Realm, user.Password, method, params)
+		if subtle.ConstantTimeCompare([]byte(response), []byte(params["response"])) == 1 {
+			return user
+		}
+	}
+	return nil
+}
+
+//user find user by name
+func (auth *Authenticator) user(name string) *AuthUser {
+	for _, user := range auth.Users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+//Permitted if user has permission to resource path
+func (user *AuthUser) Permitted(permission Permission, resourcePath string) bool {
+	patterns := user.Read
+	if permission == PermissionPublish {
+		patterns = user.Publish
+	}
+	for _, pattern := range patterns {
+		if matchPath(pattern, resourcePath) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchPath if resource path matches pattern of path.Match,
+//pattern ending with /** matches all paths under it
+func matchPath(pattern, resourcePath string) bool {
+	resourcePath = path.Clean("/" + resourcePath)
+	if strings.HasSuffix(pattern, "/**") {
+		prefix := strings.TrimSuffix(pattern, "**")
+		return resourcePath+"/" == prefix || strings.HasPrefix(resourcePath, prefix)
+	}
+	matched, _ := path.Match(pattern, resourcePath)
+	return matched
+}
+
+//authParams parse parameters of digest credentials or challenge
+func authParams(value string) map[string]string {
+	params := make(map[string]string)
+	for _, matcher := range regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|([^\s,]+))`).
+		FindAllStringSubmatch(value, -1) {
+		params[strings.ToLower(matcher[1])] = matcher[2] + matcher[3]
+	}
+	return params
+}
+
+//digestResponse response of rfc2617 digest,with qop auth if qop is in params
+func digestResponse(username, realm, password, method string, params map[string]string) string {
+	hash := func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash(username + ":" + realm + ":" + password)
+	ha2 := hash(method + ":" + params["uri"])
+	if params["qop"] != "" {
+		return hash(strings.Join([]string{ha1, params["nonce"], params["nc"],
+			params["cnonce"], params["qop"], ha2}, ":"))
+	}
+	return hash(ha1 + ":" + params["nonce"] + ":" + ha2)
+}
+
+//authorizationPattern Authorization header line of rtsp message
+var authorizationPattern = regexp.MustCompile(`(?im)^(Authorization:)[^\r\n]*`)
+
+//redactAuthorization hide credentials of rtsp message text for printing
+func redactAuthorization(message string) string {
+	return authorizationPattern.ReplaceAllString(message, "$1 ***")
+}
+
+//newNonce random nonce of digest challenge
+func newNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("rand.Read error:%v", err))
+	}
+	return hex.EncodeToString(nonce)
+}
+
+//authorize check credentials and permission of request,
+//set 401 or 403 to response if not authorized
+func (session *NetSession) authorize(inputPackage *Package) bool {
+	if Auth == nil {
+		return true
+	}
+	var (
+		permission   Permission
+		resourcePath = session.RtspURL.Path
+	)
+	switch inputPackage.Method {
+	case ANNOUNCE, RECORD:
+		permission = PermissionPublish
+	case DESCRIBE, PLAY:
+		permission = PermissionRead
+	case SETUP:
+		permission = PermissionRead
+		if session.SessionType == PusherClient {
+			permission = PermissionPublish
+		}
+		if pps, found, _ := session.findPusherPullersSession(resourcePath); pps != nil {
+			resourcePath = found
+		}
+	default:
+		// other methods only act on resources already authorized
+		return true
+	}
+	if (inputPackage.Method == PLAY || inputPackage.Method == RECORD) &&
+		session.ReourcePath != "" {
+		resourcePath = session.ReourcePath
+	}
+	if session.nonce == "" {
+		session.nonce = newNonce()
+	}
+	user := Auth.Authenticate(inputPackage.Method, inputPackage.URL,
+		inputPackage.RtspHeaderMap["Authorization"], session.nonce)
+	if user != nil && user.Permitted(permission, resourcePath) {
+		return true
+	}
+	if user == nil || user.Name == "" {
+		inputPackage.ResponseInfo.Error = Unauthorized
+		inputPackage.ResponseInfo.Authenticate = Auth.Challenge(session.nonce)
+	} else {
+		inputPackage.ResponseInfo.Error = Forbidden
+	}
+	fmt.Printf("%v %v of %v not authorized\n", inputPackage.Method, resourcePath, session.ID)
+	return false
+}
+
+//authorization Authorization header answering the challenge of server
+func (client *Client) authorization(method, requestURL string) string {
+	scheme := strings.SplitN(client.authChallenge, " ", 2)[0]
+	if strings.EqualFold(scheme, "Basic") {
+		return "Basic " + base64.StdEncoding.EncodeToString(
+			[]byte(client.Username+":"+client.Password))
+	}
+	params := authParams(client.authChallenge)
+	params["uri"] = requestURL
+	if strings.Contains(params["qop"], "auth") {
+		client.nonceCount++
+		params["qop"], params["nc"] = "auth", fmt.Sprintf("%08x", client.nonceCount)
+		params["cnonce"] = newNonce()
+	} else {
+		delete(params, "qop")
+	}
+	authorization := fmt.Sprintf(
+		"Digest username=\"%v\", realm=\"%v\", nonce=\"%v\", uri=\"%v\", response=\"%v\"",
+		client.Username, params["realm"], params["nonce"], requestURL,
+		digestResponse(client.Username, params["realm"], client.Password, method, params))
+	if params["qop"] != "" {
+		authorization += fmt.Sprintf(", qop=%v, nc=%v, cnonce=\"%v\"",
+			params["qop"], params["nc"], params["cnonce"])
+	}
+	if opaque, ok := params["opaque"]; ok {
+		authorization += fmt.Sprintf(", opaque=\"%v\"", opaque)
+	}
+	return authorization
+}
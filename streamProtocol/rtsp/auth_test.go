@@ -0,0 +1,146 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDigestResponse(t *testing.T) {
+	// example of rfc2617 3.5
+	params := map[string]string{
+		"nonce":  "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		"uri":    "/dir/index.html",
+		"qop":    "auth",
+		"nc":     "00000001",
+		"cnonce": "0a4f113b",
+	}
+	if response := digestResponse("Mufasa", "testrealm@host.com", "Circle Of Life",
+		"GET", params); response != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("digestResponse = %v", response)
+	}
+	cases := map[string]bool{
+		"/live/**:/live":         true,
+		"/live/**:/live/a/b":     true,
+		"/live/**:/lived":        false,
+		"/live/*:/live/a":        true,
+		"/live/*:/live/a/b":      false,
+		"/**:/any/path":          true,
+		"/live/cam?:/live/cam1/": true,
+	}
+	for value, want := range cases {
+		items := strings.SplitN(value, ":", 2)
+		if matchPath(items[0], items[1]) != want {
+			t.Errorf("matchPath(%v,%v) != %v", items[0], items[1], want)
+		}
+	}
+}
+
+func TestDigestURI(t *testing.T) {
+	auth := &Authenticator{Realm: "streamProtocol",
+		Users: []*AuthUser{{Name: "admin", Password: "secret"}}}
+	client := &Client{Username: "admin", Password: "secret",
+		authChallenge: auth.Challenge("nonce")[len("WWW-Authenticate: "):]}
+	authorization := client.authorization(DESCRIBE, "rtsp://host/live/a")
+	if auth.Authenticate(DESCRIBE, "rtsp://host/live/a", authorization, "nonce") == nil {
+		t.Errorf("digest of request url not authenticated")
+	}
+	if auth.Authenticate(DESCRIBE, "rtsp://host/live/b", authorization, "nonce") != nil {
+		t.Errorf("digest of rtsp://host/live/a authenticated for rtsp://host/live/b")
+	}
+	request := "DESCRIBE rtsp://host/live/a RTSP/1.0\r\nauthorization: " +
+		authorization + "\r\nCSeq: 2\r\n\r\n"
+	if redacted := redactAuthorization(request); strings.Contains(redacted, "response=") ||
+		!strings.Contains(redacted, "CSeq: 2\r\n") {
+		t.Errorf("redactAuthorization = %q", redacted)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("TempDir error:%v", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "auth.json")
+	config := `{"basic": true, "users": [
+		{"name": "admin", "password": "secret", "read": ["/**"], "publish": ["/**"]},
+		{"name": "viewer", "password": "view", "read": ["/live/**"]},
+		{"name": "", "read": ["/public/*"]}]}`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("WriteFile error:%v", err)
+	}
+	if Auth, err = LoadAuthenticator(configPath); err != nil {
+		t.Fatalf("LoadAuthenticator error:%v", err)
+	}
+	defer func() { Auth = nil }()
+	server, address := startTestServer(t)
+	defer server.Stop()
+
+	dial := func(userInfo, resourcePath string) *Client {
+		client, err := NewClient(fmt.Sprintf("rtsp://%v%v%v", userInfo, address, resourcePath),
+			TransportTCP)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		if err := client.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		return client
+	}
+	anonymous := dial("", "/live/test")
+	defer anonymous.Close()
+	response, err := anonymous.Announce(testSdp)
+	if err == nil || response.StatusCode() != 401 ||
+		!strings.HasPrefix(response.RtspHeaderMap["WWW-Authenticate"], "Digest realm=\"streamProtocol\"") {
+		t.Fatalf("Announce without credentials = %v,%v, want 401", response, err)
+	}
+	wrong := dial("admin:wrong@", "/live/test")
+	defer wrong.Close()
+	if response, err := wrong.Announce(testSdp); err == nil || response.StatusCode() != 401 {
+		t.Errorf("Announce with wrong password = %v,%v, want 401", response, err)
+	}
+
+	for _, resourcePath := range []string{"/live/test", "/public/test"} {
+		pusher := dial("admin:secret@", resourcePath)
+		defer pusher.Close()
+		if strings.Contains(pusher.RtspURL.String(), "secret") {
+			t.Errorf("credentials in request url %v", pusher.RtspURL)
+		}
+		if err := pusher.StartPush(testSdp); err != nil {
+			t.Fatalf("StartPush %v error:%v", resourcePath, err)
+		}
+	}
+
+	viewer := dial("viewer:view@", "/live/test")
+	defer viewer.Close()
+	if err := viewer.StartPull(); err != nil {
+		t.Errorf("StartPull of viewer error:%v", err)
+	}
+	publisher := dial("viewer:view@", "/live/other")
+	defer publisher.Close()
+	if response, err := publisher.Announce(testSdp); err == nil || response.StatusCode() != 403 {
+		t.Errorf("Announce of viewer = %v,%v, want 403", response, err)
+	}
+	public := dial("", "/public/test")
+	defer public.Close()
+	if err := public.StartPull(); err != nil {
+		t.Errorf("StartPull of public path error:%v", err)
+	}
+	if response, err := anonymous.Describe(); err == nil || response.StatusCode() != 401 {
+		t.Errorf("Describe without credentials = %v,%v, want 401", response, err)
+	}
+
+	basic := dial("", "/live/test")
+	defer basic.Close()
+	response, err = basic.request(DESCRIBE, basic.RtspURL.String(), map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("viewer:view")),
+	}, nil)
+	if err != nil {
+		t.Errorf("Describe with basic authentication error:%v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package rtsp
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/flv"
+	"github.com/darunshen/go/streamProtocol/h264"
+)
+
+//FLVMuxer subscribe h264 and aac frames of a pusher-pullers-session and turn
+//them into flv tags,tags begin with sequence headers and a keyframe of video
+//track,or an audio frame if no video,OnTag is called in muxer goroutine
+type FLVMuxer struct {
+	OnTag     func(tag *flv.Tag) error // tag is not reused
+	video     *flvTrack                // nil if no h264 track
+	audio     *flvTrack                // nil if no aac track
+	tracks    []*flvTrack              // tracks by index of frame queue
+	queue     *frameQueue
+	started   bool      // if sequence headers are written
+	startTime time.Time // arrival time of the first frame muxed
+}
+
+//flvTrack a track being muxed
+type flvTrack struct {
+	clock         frameClock
+	sps, pps      []byte // parameter sets of the last video sequence header
+	headerWritten bool   // if video sequence header is written
+	config        []byte // AudioSpecificConfig of aac
+	duration      uint32 // samples of an aac frame
+}
+
+//NewFLVMuxer make a muxer of the first h264 track and the first aac track in
+//session,handlerID should be unique among frame handlers of the session
+func NewFLVMuxer(session *PusherPullersSession, handlerID string) (*FLVMuxer, error) {
+	muxer := new(FLVMuxer)
+	pairs := make([]*PusherPullersPair, 0, 2)
+	for _, pair := range session.Tracks {
+		track := &flvTrack{clock: frameClock{clockRate: uint32(pair.ClockRate)}}
+		switch codec := pair.codec.(type) {
+		case *h264Codec:
+			if muxer.video != nil {
+				continue
+			}
+			track.sps, track.pps = codec.SPS, codec.PPS
+			muxer.video = track
+		case *aacCodec:
+			if muxer.audio != nil {
+				continue
+			}
+			track.config = codec.ConfigBytes
+			track.duration = uint32(codec.Config.FrameLength)
+			muxer.audio = track
+		default:
+			continue
+		}
+		muxer.tracks = append(muxer.tracks, track)
+		pairs = append(pairs, pair)
+	}
+	if len(muxer.tracks) == 0 {
+		return nil, fmt.Errorf("NewFLVMuxer error: no h264 or aac track")
+	}
+	muxer.queue = newFrameQueue(handlerID, pairs)
+	return muxer, nil
+}
+
+//Start subscribe frames of tracks and begin muxing
+func (muxer *FLVMuxer) Start() error {
+	return muxer.queue.start(muxer.writeFrame, func() {})
+}
+
+//Close unsubscribe frames and mux frames left
+func (muxer *FLVMuxer) Close() {
+	muxer.queue.close()
+}
+
+//HasVideo if h264 track is muxed
+func (muxer *FLVMuxer) HasVideo() bool {
+	return muxer.video != nil
+}
+
+//HasAudio if aac track is muxed
+func (muxer *FLVMuxer) HasAudio() bool {
+	return muxer.audio != nil
+}
+
+//writeFrame turn frame into tags,sequence headers are written before the first
+//frame and when parameter sets of video change
+func (muxer *FLVMuxer) writeFrame(item *queuedFrame) {
+	track, frame := muxer.tracks[item.index], item.frame
+	if !muxer.started {
+		if (track == muxer.video && !frame.IsKeyframe) ||
+			(track == muxer.audio && muxer.video != nil) {
+			return
+		}
+		muxer.started = true
+		muxer.startTime = item.arrival
+		if muxer.audio != nil {
+			if err := muxer.writeTag(&flv.Tag{Type: flv.TagTypeAudio,
+				Data: muxer.audioTag(flv.PacketTypeSequenceHeader, muxer.audio.config)}); err != nil {
+				fmt.Printf("FLVMuxer write audio sequence header error:%v\n", err)
+			}
+		}
+	}
+	decodeTime := track.clock.decode(frame.Timestamp, item.arrival, muxer.startTime)
+	timestamp := uint32(decodeTime * 1000 / uint64(track.clock.clockRate))
+	if track == muxer.audio {
+		for index, unit := range frame.Units {
+			if err := muxer.writeTag(&flv.Tag{Type: flv.TagTypeAudio,
+				Timestamp: timestamp + uint32(uint64(index)*uint64(track.duration)*1000/
+					uint64(track.clock.clockRate)),
+				Data: muxer.audioTag(flv.PacketTypeRaw, unit)}); err != nil {
+				fmt.Printf("FLVMuxer write audio error:%v\n", err)
+			}
+		}
+		return
+	}
+	if frame.IsKeyframe {
+		if err := muxer.updateSequenceHeader(track, frame, timestamp); err != nil {
+			fmt.Printf("FLVMuxer write video sequence header error:%v\n", err)
+		}
+	}
+	frameType := uint8(flv.FrameTypeInter)
+	if frame.IsKeyframe {
+		frameType = flv.FrameTypeKey
+	}
+	if err := muxer.writeTag(&flv.Tag{Type: flv.TagTypeVideo, Timestamp: timestamp,
+		Data: (&flv.VideoTag{
+			FrameType:  frameType,
+			CodecID:    flv.VideoCodecAVC,
+			PacketType: flv.PacketTypeRaw,
+			Data:       h264.NALUsToAVCC(frame.Units),
+		}).Marshal()}); err != nil {
+		fmt.Printf("FLVMuxer write video error:%v\n", err)
+	}
+}
+
+//updateSequenceHeader write video sequence header at the first keyframe
+//and when keyframe has new parameter sets
+func (muxer *FLVMuxer) updateSequenceHeader(track *flvTrack,
+	frame *Frame, timestamp uint32) error {
+	sps, pps := track.sps, track.pps
+	for _, nalu := range frame.Units {
+		switch h264.TypeOf(nalu) {
+		case h264.NALUTypeSPS:
+			sps = nalu
+		case h264.NALUTypePPS:
+			pps = nalu
+		}
+	}
+	if track.headerWritten && bytes.Equal(sps, track.sps) && bytes.Equal(pps, track.pps) {
+		return nil
+	}
+	config, err := h264.MarshalDecoderConfig(sps, pps)
+	if err != nil {
+		return err
+	}
+	track.sps, track.pps, track.headerWritten = sps, pps, true
+	return muxer.writeTag(&flv.Tag{Type: flv.TagTypeVideo, Timestamp: timestamp,
+		Data: (&flv.VideoTag{
+			FrameType:  flv.FrameTypeKey,
+			CodecID:    flv.VideoCodecAVC,
+			PacketType: flv.PacketTypeSequenceHeader,
+			Data:       config,
+		}).Marshal()})
+}
+
+//audioTag make aac audio tag body,sound rate and type are fixed for aac
+func (muxer *FLVMuxer) audioTag(packetType uint8, data []byte) []byte {
+	return (&flv.AudioTag{
+		SoundFormat: flv.SoundFormatAAC,
+		SoundRate:   3,
+		SoundSize:   1,
+		SoundType:   1,
+		PacketType:  packetType,
+		Data:        data,
+	}).Marshal()
+}
+
+//writeTag pass tag to OnTag
+func (muxer *FLVMuxer) writeTag(tag *flv.Tag) error {
+	if muxer.OnTag == nil {
+		return nil
+	}
+	return muxer.OnTag(tag)
+}
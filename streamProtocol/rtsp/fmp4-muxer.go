@@ -0,0 +1,296 @@
+package rtsp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/h265"
+	"github.com/darunshen/go/streamProtocol/mp4"
+)
+
+//MP4Fragment a moof and mdat pair made by FMP4Muxer
+type MP4Fragment struct {
+	Data        []byte        // moof and mdat
+	Duration    time.Duration // duration of primary track samples
+	Independent bool          // if it begins with a sync sample of primary track
+}
+
+//FMP4Muxer subscribe frames of a pusher-pullers-session and turn them into
+//fragmented mp4,every segment begins with init segment and a keyframe of the
+//first video track(primary track),or the first audio track if no video,
+//callbacks are called in muxer goroutine
+type FMP4Muxer struct {
+	SegmentDuration    time.Duration            // a new segment begins at the first sync frame after it
+	MaxSegmentDuration time.Duration            // a new segment begins at any frame before it is exceeded,0 if no limit
+	FragmentDuration   time.Duration            // a fragment is made before primary track buffered more than it
+	OnSegment          func(init []byte) error  // a new segment begins,frames are dropped if error
+	OnFragment         func(*MP4Fragment) error // a fragment of current segment is made
+	tracks             []*fmp4Track             // tracks with supported codecs
+	primary            *fmp4Track               // track deciding fragments and segments
+	queue              *frameQueue              // frames of tracks
+	segmentOpen        bool                     // if OnSegment succeeded
+	startTime          time.Time                // arrival time of the first frame muxed
+	segmentStart       uint64                   // decode time of primary track when segment began
+	sequenceNumber     uint32                   // sequence number of the last fragment
+}
+
+//fmp4Track a track being muxed
+type fmp4Track struct {
+	pair              *PusherPullersPair
+	track             *mp4.Track
+	inSegment         bool // if track is in current segment
+	clock             frameClock
+	pending           *mp4.Sample
+	pendingDecodeTime uint64 // duration of pending sample is known when next comes
+	lastDuration      uint32 // duration of the last completed sample
+	samples           []*mp4.Sample
+	baseDecodeTime    uint64 // decode time of samples[0]
+}
+
+//NewFMP4Muxer make a muxer of tracks with supported codecs in session,
+//handlerID should be unique among frame handlers of the session
+func NewFMP4Muxer(session *PusherPullersSession, handlerID string) (*FMP4Muxer, error) {
+	muxer := &FMP4Muxer{
+		SegmentDuration:  time.Hour,
+		FragmentDuration: time.Second,
+	}
+	pairs := make([]*PusherPullersPair, 0, len(session.Tracks))
+	for _, pair := range session.Tracks {
+		track := &mp4.Track{
+			ID:        uint32(pair.Index + 1),
+			TimeScale: uint32(pair.ClockRate),
+		}
+		switch codec := pair.codec.(type) {
+		case *h264Codec:
+			track.Codec = mp4.CodecH264
+			track.SPS, track.PPS = codec.SPS, codec.PPS
+		case *h265Codec:
+			track.Codec = mp4.CodecH265
+			track.VPS, track.SPS, track.PPS = codec.VPS, codec.SPS, codec.PPS
+		case *aacCodec:
+			track.Codec = mp4.CodecAAC
+			track.AudioConfig = codec.ConfigBytes
+			track.SampleRate = codec.Config.SampleRate
+			track.ChannelCount = codec.Config.ChannelCount
+		case *opusCodec:
+			track.Codec = mp4.CodecOpus
+			track.SampleRate = pair.ClockRate
+			track.ChannelCount = codec.Channels
+		default:
+			continue
+		}
+		if track.TimeScale == 0 {
+			continue
+		}
+		fmp4Track := &fmp4Track{pair: pair, track: track,
+			clock: frameClock{clockRate: track.TimeScale}}
+		muxer.tracks = append(muxer.tracks, fmp4Track)
+		pairs = append(pairs, pair)
+		if muxer.primary == nil ||
+			(!muxer.primary.track.Codec.IsVideo() && track.Codec.IsVideo()) {
+			muxer.primary = fmp4Track
+		}
+	}
+	if len(muxer.tracks) == 0 {
+		return nil, fmt.Errorf("NewFMP4Muxer error: no track can be muxed")
+	}
+	muxer.queue = newFrameQueue(handlerID, pairs)
+	return muxer, nil
+}
+
+//Start subscribe frames of tracks and begin muxing
+func (muxer *FMP4Muxer) Start() error {
+	return muxer.queue.start(muxer.writeFrame, muxer.flushAll)
+}
+
+//Close unsubscribe frames,mux frames left and make the last fragment
+func (muxer *FMP4Muxer) Close() {
+	muxer.queue.close()
+}
+
+//IsVideo if primary track is video
+func (muxer *FMP4Muxer) IsVideo() bool {
+	return muxer.primary.track.Codec.IsVideo()
+}
+
+//writeFrame turn frame into samples,make fragment or begin new segment
+//at frames of primary track
+func (muxer *FMP4Muxer) writeFrame(item *queuedFrame) {
+	track, frame := muxer.tracks[item.index], item.frame
+	if frame.IsKeyframe {
+		track.updateParameterSets(frame)
+	}
+	isSync := frame.IsKeyframe || !track.track.Codec.IsVideo()
+	if !muxer.segmentOpen {
+		if track != muxer.primary || !isSync {
+			return
+		}
+		if muxer.startTime.IsZero() {
+			muxer.startTime = item.arrival
+		}
+		if err := muxer.beginSegment(); err != nil {
+			fmt.Printf("FMP4Muxer beginSegment error:%v\n", err)
+			return
+		}
+	}
+	if !track.inSegment {
+		return
+	}
+	if track.track.Codec.IsVideo() {
+		track.addSample(frame.Timestamp, item.arrival, muxer.startTime,
+			h264.NALUsToAVCC(frame.Units), frame.IsKeyframe, 0)
+	} else {
+		for index, unit := range frame.Units {
+			track.addSample(frame.Timestamp+uint32(index)*frame.Duration,
+				item.arrival, muxer.startTime, unit, true, frame.Duration)
+		}
+	}
+	if track != muxer.primary || len(track.samples) == 0 {
+		return
+	}
+	segmentDuration := track.pendingDecodeTime - muxer.segmentStart
+	newSegment := isSync && segmentDuration >= track.clock.timeOf(muxer.SegmentDuration) ||
+		// pending sample would make segment longer than MaxSegmentDuration
+		muxer.MaxSegmentDuration != 0 && segmentDuration != 0 &&
+			segmentDuration+uint64(track.lastDuration) > track.clock.timeOf(muxer.MaxSegmentDuration)
+	// flush before the pending sample makes fragment longer than FragmentDuration
+	if !newSegment && !(track.track.Codec.IsVideo() && frame.IsKeyframe) &&
+		track.pendingDecodeTime-track.baseDecodeTime+uint64(track.lastDuration) <=
+			track.clock.timeOf(muxer.FragmentDuration) {
+		return
+	}
+	if err := muxer.flushFragment(); err != nil {
+		fmt.Printf("FMP4Muxer flushFragment error:%v\n", err)
+	}
+	if newSegment {
+		// pending samples are kept,so no frame is lost between segments
+		muxer.segmentStart = track.pendingDecodeTime
+		if err := muxer.beginSegment(); err != nil {
+			fmt.Printf("FMP4Muxer beginSegment error:%v\n", err)
+		}
+	}
+}
+
+//beginSegment call OnSegment with init segment of tracks whose codec config is known
+func (muxer *FMP4Muxer) beginSegment() error {
+	muxer.segmentOpen = false
+	tracks := make([]*mp4.Track, 0, len(muxer.tracks))
+	for _, track := range muxer.tracks {
+		track.inSegment = !track.track.Codec.IsVideo() || track.track.SPS != nil
+		if track.inSegment {
+			tracks = append(tracks, track.track)
+		} else {
+			track.pending = nil
+			track.samples = nil
+		}
+	}
+	init, err := mp4.MarshalInit(tracks)
+	if err != nil {
+		return fmt.Errorf("MarshalInit error:%v", err)
+	}
+	if muxer.OnSegment != nil {
+		if err := muxer.OnSegment(init); err != nil {
+			return err
+		}
+	}
+	muxer.segmentOpen = true
+	return nil
+}
+
+//flushFragment make a fragment of completed samples of tracks
+func (muxer *FMP4Muxer) flushFragment() error {
+	tracks := make([]*mp4.FragmentTrack, 0, len(muxer.tracks))
+	fragment := new(MP4Fragment)
+	for _, track := range muxer.tracks {
+		if !track.inSegment || len(track.samples) == 0 {
+			continue
+		}
+		if track == muxer.primary {
+			fragment.Independent = track.samples[0].IsSync
+			var duration uint64
+			for _, sample := range track.samples {
+				duration += uint64(sample.Duration)
+			}
+			fragment.Duration = time.Duration(duration * uint64(time.Second) /
+				uint64(track.track.TimeScale))
+		}
+		tracks = append(tracks, &mp4.FragmentTrack{
+			ID:             track.track.ID,
+			BaseDecodeTime: track.baseDecodeTime,
+			Samples:        track.samples,
+		})
+		track.samples = nil
+	}
+	if len(tracks) == 0 || !muxer.segmentOpen {
+		return nil
+	}
+	muxer.sequenceNumber++
+	fragment.Data = mp4.MarshalFragment(muxer.sequenceNumber, tracks)
+	if muxer.OnFragment != nil {
+		return muxer.OnFragment(fragment)
+	}
+	return nil
+}
+
+//flushAll make the last fragment of all samples including pending ones
+func (muxer *FMP4Muxer) flushAll() {
+	for _, track := range muxer.tracks {
+		if track.pending != nil && track.inSegment {
+			if track.pending.Duration == 0 {
+				track.pending.Duration = track.lastDuration
+			}
+			if len(track.samples) == 0 {
+				track.baseDecodeTime = track.pendingDecodeTime
+			}
+			track.samples = append(track.samples, track.pending)
+		}
+		track.pending = nil
+	}
+	if err := muxer.flushFragment(); err != nil {
+		fmt.Printf("FMP4Muxer flushFragment error:%v\n", err)
+	}
+}
+
+//updateParameterSets take parameter sets from keyframe for init segment
+func (track *fmp4Track) updateParameterSets(frame *Frame) {
+	for _, unit := range frame.Units {
+		switch track.track.Codec {
+		case mp4.CodecH264:
+			switch h264.TypeOf(unit) {
+			case h264.NALUTypeSPS:
+				track.track.SPS = unit
+			case h264.NALUTypePPS:
+				track.track.PPS = unit
+			}
+		case mp4.CodecH265:
+			switch h265.TypeOf(unit) {
+			case h265.NALUTypeVPS:
+				track.track.VPS = unit
+			case h265.NALUTypeSPS:
+				track.track.SPS = unit
+			case h265.NALUTypePPS:
+				track.track.PPS = unit
+			}
+		}
+	}
+}
+
+//addSample add sample at rtp timestamp,the pending sample gets its duration,
+//reordered frames get zero duration,duration is used only if no sample follows
+func (track *fmp4Track) addSample(timestamp uint32,
+	arrival, startTime time.Time, data []byte, isSync bool, duration uint32) {
+	decodeTime := track.clock.decode(timestamp, arrival, startTime)
+	if track.pending != nil {
+		track.pending.Duration = uint32(decodeTime - track.pendingDecodeTime)
+		if track.pending.Duration > 0 {
+			track.lastDuration = track.pending.Duration
+		}
+		if len(track.samples) == 0 {
+			track.baseDecodeTime = track.pendingDecodeTime
+		}
+		track.samples = append(track.samples, track.pending)
+	}
+	track.pending = &mp4.Sample{Data: data, IsSync: isSync, Duration: duration}
+	track.pendingDecodeTime = decodeTime
+}
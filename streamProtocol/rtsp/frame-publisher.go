@@ -0,0 +1,153 @@
+package rtsp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
+	"gortc.io/sdp"
+)
+
+/*
+Publish register a pusher-pullers-session of resourcePath for a publisher
+sending frames by PublishFrame(like rtmp publishers) or rtp by PublishPacket
+(like webrtc publishers) instead of rtsp,tracks are made from sdpContent,
+publisherID stands for rtsp session id of pusher,feedback of pullers is sent
+by transport,nil if publisher takes no rtcp,
+pullers can DESCRIBE,SETUP and PLAY it as a session announced by rtsp pusher
+*/
+func (server *Server) Publish(resourcePath, sdpContent, publisherID string,
+	transport PackageTransport) (*PusherPullersSession, error) {
+	pps, err := newPusherPullersSession(resourcePath, sdpContent)
+	if err != nil {
+		return nil, err
+	}
+	for _, ppp := range pps.Tracks {
+		// pusher without connections,only marks the track published
+		ppp.Pusher = &RtpRtcpSession{
+			RtspSessionID:     publisherID,
+			SessionMediaType:  ppp.MediaType,
+			SessionClientType: PusherClient,
+			Transport:         transport,
+		}
+		if err := ppp.StartDispatch(); err != nil {
+			pps.StopSession(&publisherID)
+			return nil, err
+		}
+	}
+	pps.setPublished()
+	// pullers find the session only after its tracks are all set up
+	server.PusherPullersSessionMapMutex.Lock()
+	if server.PusherPullersSessionMap == nil {
+		// published before the server serves,sessions share the map made here
+		server.PusherPullersSessionMap = make(map[string]*PusherPullersSession)
+	}
+	if used, ok := server.PusherPullersSessionMap[resourcePath]; ok &&
+		(used.reservedBy == "" || used.reservedBy != publisherID) {
+		server.PusherPullersSessionMapMutex.Unlock()
+		pps.StopSession(&publisherID)
+		return nil, fmt.Errorf("resource path %v already used", resourcePath)
+	}
+	server.PusherPullersSessionMap[resourcePath] = pps
+	server.PusherPullersSessionMapMutex.Unlock()
+	if err := pps.StartRecord(resourcePath); err != nil {
+		// stream is still forwarded without recording
+		fmt.Printf("StartRecord error:%v\n", err)
+	}
+	return pps, nil
+}
+
+//Reserve keep resourcePath for publisherID until it publishes by Publish or
+//gives up by Unpublish,so other publishers of resourcePath are refused at once
+func (server *Server) Reserve(resourcePath, publisherID string) error {
+	server.PusherPullersSessionMapMutex.Lock()
+	defer server.PusherPullersSessionMapMutex.Unlock()
+	if server.PusherPullersSessionMap == nil {
+		server.PusherPullersSessionMap = make(map[string]*PusherPullersSession)
+	}
+	if _, ok := server.PusherPullersSessionMap[resourcePath]; ok || publisherID == "" {
+		return fmt.Errorf("resource path %v already used", resourcePath)
+	}
+	// unpublished session without tracks,like the one of rtsp ANNOUNCE
+	server.PusherPullersSessionMap[resourcePath] = &PusherPullersSession{reservedBy: publisherID}
+	return nil
+}
+
+//newPusherPullersSession make pusher-pullers-session with tracks of sdpContent
+func newPusherPullersSession(resourcePath, sdpContent string) (*PusherPullersSession, error) {
+	sdpSession, err := sdp.DecodeSession([]byte(sdpContent), nil)
+	if err != nil {
+		return nil, fmt.Errorf("sdp.DecodeSession error:%v", err)
+	}
+	sdpDecoder := sdp.NewDecoder(sdpSession)
+	sdpMessage := new(sdp.Message)
+	if err := sdpDecoder.Decode(sdpMessage); err != nil {
+		return nil, fmt.Errorf("sdpDecoder.Decode error:%v", err)
+	}
+	pps := &PusherPullersSession{SdpMessage: sdpMessage, SdpContent: &sdpContent}
+	if err := pps.SetupTracks(resourcePath); err != nil {
+		return nil, fmt.Errorf("SetupTracks error:%v", err)
+	}
+	return pps, nil
+}
+
+//Unpublish stop pullers of session registered by Publish and remove it,
+//or release resource path reserved by Reserve
+func (server *Server) Unpublish(resourcePath, publisherID string) error {
+	server.PusherPullersSessionMapMutex.Lock()
+	pps, ok := server.PusherPullersSessionMap[resourcePath]
+	if ok && pps.reservedBy != "" && pps.reservedBy == publisherID {
+		// reserved but not published yet
+		delete(server.PusherPullersSessionMap, resourcePath)
+		server.PusherPullersSessionMapMutex.Unlock()
+		return nil
+	}
+	if !ok || len(pps.Tracks) == 0 || pps.Tracks[0].Pusher == nil ||
+		pps.Tracks[0].Pusher.RtspSessionID != publisherID {
+		server.PusherPullersSessionMapMutex.Unlock()
+		return fmt.Errorf("resource path %v not published by %v", resourcePath, publisherID)
+	}
+	delete(server.PusherPullersSessionMap, resourcePath)
+	server.PusherPullersSessionMapMutex.Unlock()
+	var returnErr error
+	if errs := pps.StopSession(&publisherID); len(errs) != 0 {
+		for index, err := range errs {
+			returnErr = fmt.Errorf("%v\nindex = %v,error = %v", returnErr, index, err)
+		}
+	}
+	if err := pps.StopRecord(); err != nil {
+		returnErr = fmt.Errorf("%v\nStopRecord error = %v", returnErr, err)
+	}
+	return returnErr
+}
+
+//PublishPacket forward rtp package of publisher registered by Publish to pullers
+func (session *PusherPullersPair) PublishPacket(packet *rtp.Packet) error {
+	select {
+	case session.rtpPackageChan <- packet:
+		return nil
+	case <-session.lifecycle().Done():
+		return fmt.Errorf("PublishPacket error: track %v stopped", session.Control)
+	}
+}
+
+//PublishRtcp pass rtcp package of publisher registered by Publish to track,
+//sender reports are kept for reports to pullers
+func (session *PusherPullersPair) PublishRtcp(data []byte) error {
+	select {
+	case session.rtcpPackageChan <- data:
+		return nil
+	case <-session.lifecycle().Done():
+		return fmt.Errorf("PublishRtcp error: track %v stopped", session.Control)
+	}
+}
+
+//SetTimeReference map rtp timestamp of track to wallclock for sender reports
+//to pullers,for publishers not sending rtcp
+func (session *PusherPullersPair) SetTimeReference(wallclock time.Time, rtpTimestamp uint32) {
+	session.updateSenderReport(&rtcp.SenderReport{
+		NTPTime: rtcp.NTPTime(wallclock),
+		RTPTime: rtpTimestamp,
+	}, wallclock)
+}
@@ -0,0 +1,131 @@
+package rtsp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//MuxerChannelBufferSize frames waiting to be muxed by a muxer
+var MuxerChannelBufferSize = 1024
+
+//frameQueue subscribe frames of tracks and pass them to a muxer goroutine,
+//so slow muxers never block dispatching to pullers
+type frameQueue struct {
+	handlerID string // id of frame handlers
+	pairs     []*PusherPullersPair
+	frames    chan *queuedFrame
+	stop      chan struct{} // closed by close
+	done      chan struct{} // closed when muxer goroutine exits
+	closeOnce sync.Once     // provide stop's closing once
+}
+
+//queuedFrame frame of pairs[index] with its arrival time
+type queuedFrame struct {
+	index   int
+	frame   *Frame
+	arrival time.Time
+}
+
+//newFrameQueue make a queue of frames of pairs,handlerID should be unique
+//among frame handlers of pairs
+func newFrameQueue(handlerID string, pairs []*PusherPullersPair) *frameQueue {
+	return &frameQueue{
+		handlerID: handlerID,
+		pairs:     pairs,
+		frames:    make(chan *queuedFrame, MuxerChannelBufferSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+//start subscribe frames,call write for each frame in muxer goroutine,
+//flush is called after frames left are written when closing
+func (queue *frameQueue) start(write func(*queuedFrame), flush func()) error {
+	go queue.run(write, flush)
+	for index, pair := range queue.pairs {
+		if err := pair.AddFrameHandler(queue.handlerID,
+			queue.frameHandler(index)); err != nil {
+			queue.close()
+			return fmt.Errorf("AddFrameHandler error:%v", err)
+		}
+	}
+	return nil
+}
+
+//close unsubscribe frames and wait until muxer goroutine exits
+func (queue *frameQueue) close() {
+	queue.closeOnce.Do(func() {
+		for _, pair := range queue.pairs {
+			pair.RemoveFrameHandler(queue.handlerID)
+		}
+		close(queue.stop)
+	})
+	<-queue.done
+}
+
+//frameHandler handler passing frames of pairs[index] to muxer goroutine
+func (queue *frameQueue) frameHandler(index int) FrameHandler {
+	return func(frame *Frame) {
+		select {
+		case queue.frames <- &queuedFrame{index: index, frame: frame, arrival: time.Now()}:
+		case <-queue.stop:
+		default:
+			fmt.Printf("muxer %v is busy,frame dropped\n", queue.handlerID)
+		}
+	}
+}
+
+//run write frames until close
+func (queue *frameQueue) run(write func(*queuedFrame), flush func()) {
+	defer close(queue.done)
+	for {
+		select {
+		case item := <-queue.frames:
+			write(item)
+		case <-queue.stop:
+			for {
+				select {
+				case item := <-queue.frames:
+					write(item)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+//frameClock map rtp timestamps of a track to decode time in clock rate
+type frameClock struct {
+	clockRate     uint32
+	started       bool
+	lastTimestamp uint32 // max rtp timestamp received
+	decodeTime    uint64 // decode time of lastTimestamp
+}
+
+//decode decode time of frame at rtp timestamp,the first frame is placed at its
+//arrival since startTime,decode time never goes back,so reordered frames
+//get the decode time of the latest one
+func (clock *frameClock) decode(timestamp uint32, arrival, startTime time.Time) uint64 {
+	if !clock.started {
+		clock.started = true
+		clock.lastTimestamp = timestamp
+		clock.decodeTime = clock.timeOf(arrival.Sub(startTime))
+	} else if delta := int32(timestamp - clock.lastTimestamp); delta > 0 {
+		clock.lastTimestamp = timestamp
+		clock.decodeTime += uint64(delta)
+	}
+	return clock.decodeTime
+}
+
+//timeOf duration in clock rate
+func (clock *frameClock) timeOf(duration time.Duration) uint64 {
+	return uint64(duration) * uint64(clock.clockRate) / uint64(time.Second)
+}
+
+//durationOf duration of value in clock rate
+func (clock *frameClock) durationOf(value uint64) time.Duration {
+	return time.Duration(value) * time.Second / time.Duration(clock.clockRate)
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+// gop cache settings,gop cache is disabled if GopCacheMaxBytes is 0
+var (
+	//GopCacheMaxBytes max payload bytes cached for a video track
+	GopCacheMaxBytes int = 8 << 20
+	//GopCacheMaxDuration max duration of packages cached for a video track
+	GopCacheMaxDuration = 10 * time.Second
+	//GopCacheMaxPackets max packages cached for a video track,all of them are
+	//replayed to a starting puller at once
+	GopCacheMaxPackets int = 1024
+)
+
+/*
+gopCache rtp packages of a video track since its latest keyframe,replayed to
+a puller when it starts,so it decodes from its first package,packages are kept
+as they were received,then sequence numbers and timestamps of replayed packages
+run on into live ones,the cache is dropped until the next keyframe if the gop
+exceeds GopCacheMaxBytes,GopCacheMaxDuration or GopCacheMaxPackets
+*/
+type gopCache struct {
+	packets   []*rtp.Packet // packages from the first one of keyframe
+	bytes     int           // payload bytes of packets
+	clockRate int           // rtp clock rate for duration of packets
+}
+
+//newGopCache make gop cache for track,nil if its keyframes are unknown
+func newGopCache(ppp *PusherPullersPair) *gopCache {
+	if GopCacheMaxBytes <= 0 || ppp.MediaType != MediaVideo || ppp.codec == nil {
+		return nil
+	}
+	return &gopCache{clockRate: ppp.ClockRate}
+}
+
+//add cache package,keyframe is if it starts a keyframe
+func (cache *gopCache) add(packet *rtp.Packet, keyframe bool) {
+	if keyframe && (len(cache.packets) == 0 ||
+		packet.Timestamp != cache.packets[0].Timestamp) {
+		// slices of the same keyframe share the timestamp
+		cache.reset()
+	} else if len(cache.packets) == 0 {
+		// waiting for keyframe
+		return
+	}
+	cache.packets = append(cache.packets, packet)
+	cache.bytes += len(packet.Payload)
+	if cache.bytes > GopCacheMaxBytes || cache.duration() > GopCacheMaxDuration ||
+		len(cache.packets) > GopCacheMaxPackets {
+		fmt.Printf("gop of %v packages,%v bytes,%v exceeds gop cache\n",
+			len(cache.packets), cache.bytes, cache.duration())
+		cache.reset()
+	}
+}
+
+//duration time from the first cached package to the last
+func (cache *gopCache) duration() time.Duration {
+	if len(cache.packets) == 0 || cache.clockRate <= 0 {
+		return 0
+	}
+	ticks := cache.packets[len(cache.packets)-1].Timestamp - cache.packets[0].Timestamp
+	return time.Duration(int64(ticks) * int64(time.Second) / int64(cache.clockRate))
+}
+
+//reset drop cached packages
+func (cache *gopCache) reset() {
+	cache.packets = nil
+	cache.bytes = 0
+}
+
+//packetsFor packages of packet to send to puller,
+//the cached gop ending with packet if replay for a puller just started
+func (cache *gopCache) packetsFor(puller *RtpRtcpSession, packet *rtp.Packet, replay bool) []*rtp.Packet {
+	if !replay || cache == nil || len(cache.packets) == 0 || cache.packets[len(cache.packets)-1] != packet {
+		return []*rtp.Packet{packet}
+	}
+	fmt.Printf("replay %v cached packages to %v\n", len(cache.packets), puller.RtspSessionID)
+	return cache.packets
+}
+
+//cacheGop add package of pusher to gop cache of track
+func (session *PusherPullersPair) cacheGop(packet *rtp.Packet) {
+	if session.gop != nil {
+		session.gop.add(packet, session.codec.StartsKeyframe(packet))
+	}
+}
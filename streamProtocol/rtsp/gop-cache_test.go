@@ -0,0 +1,122 @@
+package rtsp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestGopCache(t *testing.T) {
+	defer func(maxBytes int) { GopCacheMaxBytes = maxBytes }(GopCacheMaxBytes)
+	GopCacheMaxBytes = 10
+	cache := &gopCache{clockRate: 90000}
+	packet := func(sequence uint16, timestamp uint32, size int) *rtp.Packet {
+		return &rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: sequence, Timestamp: timestamp},
+			Payload: make([]byte, size),
+		}
+	}
+	cache.add(packet(1, 0, 1), false)
+	if len(cache.packets) != 0 {
+		t.Fatalf("cached %v packages before keyframe", len(cache.packets))
+	}
+	cache.add(packet(2, 3000, 1), true)
+	cache.add(packet(3, 3000, 1), true)
+	cache.add(packet(4, 6000, 1), false)
+	if len(cache.packets) != 3 || cache.packets[0].SequenceNumber != 2 || cache.bytes != 3 {
+		t.Fatalf("cached %v packages of %v bytes, want from the keyframe", len(cache.packets), cache.bytes)
+	}
+	cache.add(packet(5, 9000, 2), true)
+	if len(cache.packets) != 1 || cache.packets[0].SequenceNumber != 5 {
+		t.Fatalf("cache not restarted by the next keyframe")
+	}
+	cache.add(packet(6, 12000, 9), false)
+	if len(cache.packets) != 0 {
+		t.Errorf("gop of %v bytes cached, want dropped", cache.bytes)
+	}
+	cache.add(packet(7, 15000, 1), true)
+	cache.add(packet(8, 15000+uint32(GopCacheMaxDuration/time.Second+1)*90000, 1), false)
+	if len(cache.packets) != 0 {
+		t.Errorf("gop of %v cached, want dropped", cache.duration())
+	}
+	cache.add(packet(9, 18000, 0), true)
+	for sequence := uint16(10); len(cache.packets) == int(sequence-9); sequence++ {
+		cache.add(packet(sequence, 18000, 0), false)
+	}
+	if len(cache.packets) != 0 {
+		t.Errorf("gop of over %v packages cached, want dropped", GopCacheMaxPackets)
+	}
+}
+
+func TestGopReplay(t *testing.T) {
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/gop", address)
+	dial := func() *Client {
+		client, err := NewClient(streamURL, TransportTCP)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		if err := client.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		return client
+	}
+	pusher := dial()
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	pull := func() (*Client, chan uint16) {
+		puller := dial()
+		sequences := make(chan uint16, 100)
+		puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+			packet := new(rtp.Packet)
+			if trackIndex == 0 && packageType == RtpPackage && packet.Unmarshal(data) == nil {
+				sequences <- packet.SequenceNumber
+			}
+		}
+		if err := puller.StartPull(); err != nil {
+			t.Fatalf("StartPull error:%v", err)
+		}
+		return puller, sequences
+	}
+	push := func(sequence uint16, timestamp uint32, payload ...byte) {
+		data, _ := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: sequence, Timestamp: timestamp},
+			Payload: payload,
+		}).Marshal()
+		if err := pusher.PushPackage(0, RtpPackage, data); err != nil {
+			t.Fatalf("PushPackage error:%v", err)
+		}
+	}
+	expect := func(sequences chan uint16, want ...uint16) {
+		for _, sequence := range want {
+			select {
+			case received := <-sequences:
+				if received != sequence {
+					t.Fatalf("received package %v, want %v", received, sequence)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("package %v not received", sequence)
+			}
+		}
+	}
+
+	live, liveSequences := pull()
+	defer live.Close()
+	push(1, 0, 0x41, 0x9a)
+	push(2, 3000, 0x67, 0x42)
+	push(3, 3000, 0x65, 0x88)
+	push(4, 6000, 0x41, 0x9a)
+	expect(liveSequences, 1, 2, 3, 4)
+
+	// a new puller starts from the keyframe,then runs on into live packages
+	late, lateSequences := pull()
+	defer late.Close()
+	push(5, 9000, 0x41, 0x9a)
+	expect(lateSequences, 2, 3, 4, 5)
+	expect(liveSequences, 5)
+}
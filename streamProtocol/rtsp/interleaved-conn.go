@@ -0,0 +1,85 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//InterleavedMagic first byte of a binary frame in rtsp tcp connection
+const InterleavedMagic byte = '$'
+
+//InterleavedConn rtsp tcp connection shared by rtsp messages and
+//rtp/rtcp interleaved binary frames(rfc2326 10.12)
+type InterleavedConn struct {
+	Bufio      *bufio.ReadWriter
+	WriteMutex sync.Mutex // keep rtsp responses and frames from interleaving
+}
+
+//InterleavedFrame one $-framed binary package
+type InterleavedFrame struct {
+	Channel int
+	Data    []byte
+}
+
+//WriteFrame write a $-framed binary package into rtsp tcp connection
+func (conn *InterleavedConn) WriteFrame(channel int, data []byte) error {
+	if len(data) > 0xffff {
+		return fmt.Errorf("WriteFrame error: frame too large(%v)", len(data))
+	}
+	header := [4]byte{InterleavedMagic, byte(channel)}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	conn.WriteMutex.Lock()
+	defer conn.WriteMutex.Unlock()
+	if _, err := conn.Bufio.Write(header[:]); err != nil {
+		return fmt.Errorf("WriteFrame's Write header error:%v", err)
+	}
+	if _, err := conn.Bufio.Write(data); err != nil {
+		return fmt.Errorf("WriteFrame's Write data error:%v", err)
+	}
+	if err := conn.Bufio.Flush(); err != nil {
+		return fmt.Errorf("WriteFrame's Flush error:%v", err)
+	}
+	return nil
+}
+
+//WriteString write rtsp message into rtsp tcp connection
+func (conn *InterleavedConn) WriteString(message string) (int, error) {
+	conn.WriteMutex.Lock()
+	defer conn.WriteMutex.Unlock()
+	sendNum, err := conn.Bufio.WriteString(message)
+	if err != nil {
+		return sendNum, err
+	}
+	return sendNum, conn.Bufio.Flush()
+}
+
+//IfNextFrame check if next data in reader is a $-framed binary package
+func IfNextFrame(reader *bufio.Reader) (bool, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	return first[0] == InterleavedMagic, nil
+}
+
+//ReadFrame read a $-framed binary package from reader
+func ReadFrame(reader *bufio.Reader) (*InterleavedFrame, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("ReadFrame's read header error:%v", err)
+	}
+	if header[0] != InterleavedMagic {
+		return nil, fmt.Errorf("ReadFrame error: magic %#x not match", header[0])
+	}
+	frame := &InterleavedFrame{
+		Channel: int(header[1]),
+		Data:    make([]byte, binary.BigEndian.Uint16(header[2:])),
+	}
+	if _, err := io.ReadFull(reader, frame.Data); err != nil {
+		return nil, fmt.Errorf("ReadFrame's read data error:%v", err)
+	}
+	return frame, nil
+}
@@ -0,0 +1,33 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestInterleavedFrame(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	conn := &InterleavedConn{Bufio: bufio.NewReadWriter(
+		bufio.NewReader(buf), bufio.NewWriter(buf))}
+	if err := conn.WriteFrame(1, []byte{0x80, 0x60, 0x00, 0x01}); err != nil {
+		t.Fatalf("WriteFrame error:%v", err)
+	}
+	if _, err := conn.WriteString("OPTIONS rtsp://a/b RTSP/1.0\r\n\r\n"); err != nil {
+		t.Fatalf("WriteString error:%v", err)
+	}
+	reader := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	if ifFrame, err := IfNextFrame(reader); err != nil || !ifFrame {
+		t.Fatalf("IfNextFrame = %v,%v, want true", ifFrame, err)
+	}
+	frame, err := ReadFrame(reader)
+	if err != nil {
+		t.Fatalf("ReadFrame error:%v", err)
+	}
+	if frame.Channel != 1 || !bytes.Equal(frame.Data, []byte{0x80, 0x60, 0x00, 0x01}) {
+		t.Errorf("ReadFrame = %v,%v", frame.Channel, frame.Data)
+	}
+	if ifFrame, err := IfNextFrame(reader); err != nil || ifFrame {
+		t.Errorf("IfNextFrame = %v,%v, want false", ifFrame, err)
+	}
+}
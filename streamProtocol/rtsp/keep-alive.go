@@ -0,0 +1,101 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keep-alive settings,sessions are reaped after SessionTimeout without activity
+var (
+	//SessionTimeout timeout advertised in Session header,
+	//zero to keep sessions until their connections are closed
+	SessionTimeout = 60 * time.Second
+)
+
+//touch record activity of this session at now
+func (session *RtpRtcpSession) touch(now time.Time) {
+	atomic.StoreInt64(&session.lastActive, now.UnixNano())
+}
+
+//LastActive time of the last rtp from pusher or rtcp from puller,
+//zero if none received
+func (session *RtpRtcpSession) LastActive() time.Time {
+	if nanos := atomic.LoadInt64(&session.lastActive); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+//touch record a rtsp request of this session at now
+func (session *NetSession) touch(now time.Time) {
+	atomic.StoreInt64(&session.lastActive, now.UnixNano())
+}
+
+//addRtpRtcpSession remember rtp-rtcp-session set up by this session,
+//whose packages keep this session alive
+func (session *NetSession) addRtpRtcpSession(rrs *RtpRtcpSession) {
+	session.keepAliveMutex.Lock()
+	defer session.keepAliveMutex.Unlock()
+	session.rtpRtcpSessions = append(session.rtpRtcpSessions, rrs)
+}
+
+//idleTime time since the latest rtsp request or package of rtp-rtcp-sessions
+func (session *NetSession) idleTime(now time.Time) time.Duration {
+	lastActive := time.Unix(0, atomic.LoadInt64(&session.lastActive))
+	session.keepAliveMutex.Lock()
+	defer session.keepAliveMutex.Unlock()
+	for _, rrs := range session.rtpRtcpSessions {
+		if active := rrs.LastActive(); active.After(lastActive) {
+			lastActive = active
+		}
+	}
+	return now.Sub(lastActive)
+}
+
+//startKeepAlive close connection of this session if idle longer than SessionTimeout,
+//then the session and its rtp-rtcp-sessions are stopped by CloseSession
+func (session *NetSession) startKeepAlive() {
+	session.touch(time.Now())
+	if SessionTimeout <= 0 {
+		return
+	}
+	timeout, conn := SessionTimeout, session.Conn
+	session.closed = make(chan struct{})
+	go func(closed chan struct{}) {
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case now := <-ticker.C:
+				if idle := session.idleTime(now); idle > timeout {
+					fmt.Printf("rtsp session %v timeout,idle for %v\n", session.ID, idle)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}(session.closed)
+}
+
+//sessionHeader value of Session header with timeout
+func (session *NetSession) sessionHeader() string {
+	if SessionTimeout <= 0 {
+		return session.ID
+	}
+	seconds := (SessionTimeout + time.Second - 1) / time.Second
+	return fmt.Sprintf("%v;timeout=%v", session.ID, int(seconds))
+}
+
+//processParameter GET_PARAMETER and SET_PARAMETER,an empty one is a keep-alive,
+//no parameter is supported yet
+func processParameter(inputPackage *Package) {
+	if strings.TrimSpace(string(inputPackage.Content)) != "" {
+		inputPackage.ResponseInfo.Error = ParameterNotUnderstood
+		return
+	}
+	inputPackage.ResponseInfo.Error = Ok
+}
@@ -0,0 +1,85 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTimeout(t *testing.T) {
+	SessionTimeout = 500 * time.Millisecond
+	defer func() { SessionTimeout = 60 * time.Second }()
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/keepalive", address)
+
+	pusher, err := NewClient(streamURL, TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	response, err := pusher.Options()
+	if err != nil {
+		t.Fatalf("Options error:%v", err)
+	}
+	if !strings.Contains(response.RtspHeaderMap["Public"], GET_PARAMETER) {
+		t.Errorf("Public = %v", response.RtspHeaderMap["Public"])
+	}
+	if !strings.HasSuffix(response.RtspHeaderMap["Session"], ";timeout=1") {
+		t.Errorf("Session = %v, want timeout", response.RtspHeaderMap["Session"])
+	}
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	if _, err := pusher.GetParameter(); err != nil {
+		t.Errorf("GetParameter error:%v", err)
+	}
+	response, err = pusher.request(SET_PARAMETER, streamURL,
+		map[string]string{"Content-Type": "text/parameters"}, []byte("unknown: 1\r\n"))
+	if response == nil || response.StatusCode() != 451 {
+		t.Errorf("SET_PARAMETER unknown parameter = %v,%v, want 451", response, err)
+	}
+
+	puller, err := NewClient(streamURL, TransportUDP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	if err := puller.StartPull(); err != nil {
+		t.Fatalf("StartPull error:%v", err)
+	}
+	server.PusherPullersSessionMapMutex.Lock()
+	track := server.PusherPullersSessionMap["/live/keepalive"].Tracks[0]
+	server.PusherPullersSessionMapMutex.Unlock()
+
+	// pusher kept alive by its packages,puller sends neither requests nor rtcp
+	begin := time.Now()
+	packet := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	deadline := time.After(5 * time.Second)
+	for track.hasSession(puller.SessionID) {
+		if err := pusher.PushPackage(0, RtpPackage, packet); err != nil {
+			t.Fatalf("PushPackage error:%v", err)
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("puller not reaped")
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < SessionTimeout {
+		t.Errorf("puller reaped after %v, before timeout", elapsed)
+	}
+	if _, err := puller.GetParameter(); err == nil {
+		t.Errorf("connection of timeout session not closed")
+	}
+	if _, err := pusher.GetParameter(); err != nil {
+		t.Errorf("pusher reaped:%v", err)
+	}
+}
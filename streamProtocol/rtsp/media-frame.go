@@ -0,0 +1,416 @@
+package rtsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/h265"
+	"github.com/darunshen/go/streamProtocol/opus"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//PacketizerMTU max size of rtp packages made by packetizers
+var PacketizerMTU = h264.DefaultMTU
+
+//Frame an access unit or audio frame assembled from rtp packages
+type Frame struct {
+	MediaType  MediaType // video or audio
+	Codec      string    // encoding name from rtpmap,like H264
+	ClockRate  int       // rtp clock rate
+	Timestamp  uint32    // rtp timestamp
+	Units      [][]byte  // nal units for video,one frame for audio
+	IsKeyframe bool      // if video frame can be decoded independently
+	Duration   uint32    // audio frame duration in clock rate,0 for video
+}
+
+//FrameHandler handle frames of a track,called in dispatch goroutine,
+//so should not block
+type FrameHandler func(frame *Frame)
+
+//trackCodec turn rtp packages into frames and frames into rtp packages
+type trackCodec interface {
+	Depacketize(packet *rtp.Packet) ([]*Frame, error)
+	Packetize(frame *Frame) ([]*rtp.Packet, error)
+	SequenceNumber() uint16                 // sequence number of the next packetized rtp package
+	StartsKeyframe(packet *rtp.Packet) bool // if package starts a keyframe,always false for audio
+}
+
+//newTrackCodec make codec for the payload format,nil if not support
+func newTrackCodec(rtpMap *RtpMap, fmtp map[string]string) (trackCodec, error) {
+	switch rtpMap.EncodingName {
+	case "H264":
+		return newH264Codec(rtpMap, fmtp)
+	case "H265":
+		return newH265Codec(rtpMap, fmtp)
+	case "MPEG4-GENERIC":
+		return newAACCodec(rtpMap, fmtp)
+	case "OPUS":
+		return newOpusCodec(rtpMap)
+	}
+	return nil, nil
+}
+
+//h264Codec h264 payload format(rfc6184)
+type h264Codec struct {
+	depacketizer *h264.Depacketizer
+	packetizer   *h264.Packetizer
+	clockRate    int
+	SPS, PPS     []byte // parameter sets from sdp or in-band
+}
+
+//newH264Codec make h264 codec with parameter sets in fmtp
+func newH264Codec(rtpMap *RtpMap, fmtp map[string]string) (*h264Codec, error) {
+	codec := &h264Codec{
+		depacketizer: h264.NewDepacketizer(),
+		packetizer:   h264.NewPacketizer(PacketizerMTU, uint8(rtpMap.PayloadType), 0),
+		clockRate:    rtpMap.ClockRate,
+	}
+	if mode, ok := fmtp["packetization-mode"]; ok && mode == "2" {
+		return nil, fmt.Errorf("h264 packetization-mode 2 not support")
+	}
+	if sprop, ok := fmtp["sprop-parameter-sets"]; ok {
+		sps, pps, err := h264.ParseSpropParameterSets(sprop)
+		if err != nil {
+			fmt.Printf("ParseSpropParameterSets error:%v\n", err)
+		}
+		codec.SPS, codec.PPS = sps, pps
+	}
+	return codec, nil
+}
+
+//Depacketize assemble access units,parameter sets are put before
+//keyframe if it has no in-band ones
+func (codec *h264Codec) Depacketize(packet *rtp.Packet) ([]*Frame, error) {
+	accessUnits, err := codec.depacketizer.Decode(packet)
+	frames := make([]*Frame, 0, len(accessUnits))
+	for _, accessUnit := range accessUnits {
+		hasSPS, hasPPS := false, false
+		for _, nalu := range accessUnit.NALUs {
+			switch h264.TypeOf(nalu) {
+			case h264.NALUTypeSPS:
+				codec.SPS, hasSPS = nalu, true
+			case h264.NALUTypePPS:
+				codec.PPS, hasPPS = nalu, true
+			}
+		}
+		nalus := accessUnit.NALUs
+		if accessUnit.IsKeyframe && codec.SPS != nil && codec.PPS != nil &&
+			!(hasSPS && hasPPS) {
+			nalus = append([][]byte{codec.SPS, codec.PPS}, nalus...)
+		}
+		frames = append(frames, &Frame{
+			MediaType:  MediaVideo,
+			Codec:      "H264",
+			ClockRate:  codec.clockRate,
+			Timestamp:  accessUnit.Timestamp,
+			Units:      nalus,
+			IsKeyframe: accessUnit.IsKeyframe,
+		})
+	}
+	return frames, err
+}
+
+//Packetize split access unit into rtp packages
+func (codec *h264Codec) Packetize(frame *Frame) ([]*rtp.Packet, error) {
+	return codec.packetizer.Packetize(frame.Units, frame.Timestamp)
+}
+
+//SequenceNumber sequence number of the next packetized rtp package
+func (codec *h264Codec) SequenceNumber() uint16 {
+	return codec.packetizer.SequenceNumber
+}
+
+//StartsKeyframe if package starts a keyframe
+func (codec *h264Codec) StartsKeyframe(packet *rtp.Packet) bool {
+	return h264.StartsKeyframe(packet.Payload)
+}
+
+//h265Codec h265 payload format(rfc7798)
+type h265Codec struct {
+	depacketizer  *h265.Depacketizer
+	packetizer    *h265.Packetizer
+	clockRate     int
+	VPS, SPS, PPS []byte // parameter sets from sdp or in-band
+}
+
+//newH265Codec make h265 codec with parameter sets in fmtp
+func newH265Codec(rtpMap *RtpMap, fmtp map[string]string) (*h265Codec, error) {
+	donlPresent := false
+	if maxDonDiff, ok := fmtp["sprop-max-don-diff"]; ok {
+		value, err := strconv.Atoi(maxDonDiff)
+		if err != nil {
+			return nil, fmt.Errorf("sprop-max-don-diff %v invalid:%v", maxDonDiff, err)
+		}
+		donlPresent = value > 0
+	}
+	codec := &h265Codec{
+		depacketizer: h265.NewDepacketizer(donlPresent),
+		packetizer:   h265.NewPacketizer(PacketizerMTU, uint8(rtpMap.PayloadType), 0),
+		clockRate:    rtpMap.ClockRate,
+	}
+	if _, ok := fmtp["sprop-vps"]; ok {
+		vps, sps, pps, err := h265.ParseSpropParameterSets(
+			fmtp["sprop-vps"], fmtp["sprop-sps"], fmtp["sprop-pps"])
+		if err != nil {
+			fmt.Printf("ParseSpropParameterSets error:%v\n", err)
+		}
+		codec.VPS, codec.SPS, codec.PPS = vps, sps, pps
+	}
+	return codec, nil
+}
+
+//Depacketize assemble access units,parameter sets are put before
+//keyframe if it has no in-band ones
+func (codec *h265Codec) Depacketize(packet *rtp.Packet) ([]*Frame, error) {
+	accessUnits, err := codec.depacketizer.Decode(packet)
+	frames := make([]*Frame, 0, len(accessUnits))
+	for _, accessUnit := range accessUnits {
+		hasVPS, hasSPS, hasPPS := false, false, false
+		for _, nalu := range accessUnit.NALUs {
+			switch h265.TypeOf(nalu) {
+			case h265.NALUTypeVPS:
+				codec.VPS, hasVPS = nalu, true
+			case h265.NALUTypeSPS:
+				codec.SPS, hasSPS = nalu, true
+			case h265.NALUTypePPS:
+				codec.PPS, hasPPS = nalu, true
+			}
+		}
+		nalus := accessUnit.NALUs
+		if accessUnit.IsKeyframe && codec.VPS != nil && codec.SPS != nil &&
+			codec.PPS != nil && !(hasVPS && hasSPS && hasPPS) {
+			nalus = append([][]byte{codec.VPS, codec.SPS, codec.PPS}, nalus...)
+		}
+		frames = append(frames, &Frame{
+			MediaType:  MediaVideo,
+			Codec:      "H265",
+			ClockRate:  codec.clockRate,
+			Timestamp:  accessUnit.Timestamp,
+			Units:      nalus,
+			IsKeyframe: accessUnit.IsKeyframe,
+		})
+	}
+	return frames, err
+}
+
+//Packetize split access unit into rtp packages
+func (codec *h265Codec) Packetize(frame *Frame) ([]*rtp.Packet, error) {
+	return codec.packetizer.Packetize(frame.Units, frame.Timestamp)
+}
+
+//SequenceNumber sequence number of the next packetized rtp package
+func (codec *h265Codec) SequenceNumber() uint16 {
+	return codec.packetizer.SequenceNumber
+}
+
+//StartsKeyframe if package starts a keyframe
+func (codec *h265Codec) StartsKeyframe(packet *rtp.Packet) bool {
+	return h265.StartsKeyframe(packet.Payload, codec.depacketizer.DONLPresent)
+}
+
+//aacCodec aac in mpeg4-generic payload format(rfc3640)
+type aacCodec struct {
+	depacketizer *aac.Depacketizer
+	packetizer   *aac.Packetizer
+	clockRate    int
+	Config       *aac.AudioSpecificConfig // from config of fmtp
+	ConfigBytes  []byte                   // raw AudioSpecificConfig
+}
+
+//newAACCodec make aac codec with AU-header lengths and config in fmtp
+func newAACCodec(rtpMap *RtpMap, fmtp map[string]string) (*aacCodec, error) {
+	if mode := strings.ToLower(fmtp["mode"]); mode != "aac-hbr" && mode != "aac-lbr" {
+		return nil, fmt.Errorf("mpeg4-generic mode %v not support", fmtp["mode"])
+	}
+	lengths := make(map[string]int)
+	for _, name := range []string{"sizelength", "indexlength", "indexdeltalength"} {
+		value, ok := fmtp[name]
+		if !ok {
+			continue
+		}
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > 32 {
+			return nil, fmt.Errorf("%v %v invalid", name, value)
+		}
+		lengths[name] = length
+	}
+	if lengths["sizelength"] == 0 {
+		return nil, fmt.Errorf("mpeg4-generic sizelength missing")
+	}
+	config, configBytes, err := aac.ParseConfig(fmtp["config"])
+	if err != nil {
+		return nil, fmt.Errorf("mpeg4-generic config error:%v", err)
+	}
+	return &aacCodec{
+		depacketizer: aac.NewDepacketizer(lengths["sizelength"],
+			lengths["indexlength"], lengths["indexdeltalength"], config.FrameLength),
+		packetizer:  aac.NewPacketizer(PacketizerMTU, uint8(rtpMap.PayloadType), 0),
+		clockRate:   rtpMap.ClockRate,
+		Config:      config,
+		ConfigBytes: configBytes,
+	}, nil
+}
+
+//Depacketize get aac frames from AU-headers and AUs
+func (codec *aacCodec) Depacketize(packet *rtp.Packet) ([]*Frame, error) {
+	accessUnits, err := codec.depacketizer.Decode(packet)
+	frames := make([]*Frame, 0, len(accessUnits))
+	for _, accessUnit := range accessUnits {
+		frames = append(frames, &Frame{
+			MediaType: MediaAudio,
+			Codec:     "MPEG4-GENERIC",
+			ClockRate: codec.clockRate,
+			Timestamp: accessUnit.Timestamp,
+			Units:     [][]byte{accessUnit.Data},
+			Duration:  uint32(codec.Config.FrameLength),
+		})
+	}
+	return frames, err
+}
+
+//Packetize put aac frame into rtp packages in AAC-hbr mode
+func (codec *aacCodec) Packetize(frame *Frame) ([]*rtp.Packet, error) {
+	packets := make([]*rtp.Packet, 0, len(frame.Units))
+	for index, unit := range frame.Units {
+		unitPackets, err := codec.packetizer.Packetize(unit,
+			frame.Timestamp+uint32(index*codec.Config.FrameLength))
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, unitPackets...)
+	}
+	return packets, nil
+}
+
+//SequenceNumber sequence number of the next packetized rtp package
+func (codec *aacCodec) SequenceNumber() uint16 {
+	return codec.packetizer.SequenceNumber
+}
+
+//StartsKeyframe always false,every aac frame can be decoded
+func (codec *aacCodec) StartsKeyframe(packet *rtp.Packet) bool {
+	return false
+}
+
+//opusCodec opus payload format(rfc7587)
+type opusCodec struct {
+	packetizer *opus.Packetizer
+	Channels   int // channels from rtpmap,always 2 as rfc7587 requires
+}
+
+//newOpusCodec make opus codec
+func newOpusCodec(rtpMap *RtpMap) (*opusCodec, error) {
+	if rtpMap.ClockRate != opus.ClockRate {
+		return nil, fmt.Errorf("opus clock rate %v invalid", rtpMap.ClockRate)
+	}
+	return &opusCodec{
+		packetizer: opus.NewPacketizer(uint8(rtpMap.PayloadType), 0),
+		Channels:   rtpMap.Channels,
+	}, nil
+}
+
+//Depacketize get the opus packet in rtp package
+func (codec *opusCodec) Depacketize(packet *rtp.Packet) ([]*Frame, error) {
+	opusPacket, err := opus.Depacketize(packet)
+	if err != nil {
+		return nil, err
+	}
+	return []*Frame{{
+		MediaType: MediaAudio,
+		Codec:     "OPUS",
+		ClockRate: opus.ClockRate,
+		Timestamp: opusPacket.Timestamp,
+		Units:     [][]byte{opusPacket.Data},
+		Duration:  uint32(opusPacket.Samples),
+	}}, nil
+}
+
+//Packetize put each opus packet of frame into a rtp package
+func (codec *opusCodec) Packetize(frame *Frame) ([]*rtp.Packet, error) {
+	packets := make([]*rtp.Packet, 0, len(frame.Units))
+	timestamp := frame.Timestamp
+	for _, unit := range frame.Units {
+		duration, err := opus.PacketDuration(unit)
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, codec.packetizer.Packetize(unit, timestamp))
+		timestamp += uint32(duration * opus.ClockRate / time.Second)
+	}
+	return packets, nil
+}
+
+//SequenceNumber sequence number of the next packetized rtp package
+func (codec *opusCodec) SequenceNumber() uint16 {
+	return codec.packetizer.SequenceNumber
+}
+
+//StartsKeyframe always false,every opus packet can be decoded
+func (codec *opusCodec) StartsKeyframe(packet *rtp.Packet) bool {
+	return false
+}
+
+//AddFrameHandler add handler of frames assembled from pusher's rtp packages
+func (session *PusherPullersPair) AddFrameHandler(id string, handler FrameHandler) error {
+	if session.codec == nil {
+		return fmt.Errorf("AddFrameHandler error: payload format not support")
+	}
+	session.frameHandlersMutex.Lock()
+	defer session.frameHandlersMutex.Unlock()
+	if session.frameHandlers == nil {
+		session.frameHandlers = make(map[string]FrameHandler)
+	}
+	session.frameHandlers[id] = handler
+	return nil
+}
+
+//RemoveFrameHandler remove handler added by AddFrameHandler
+func (session *PusherPullersPair) RemoveFrameHandler(id string) {
+	session.frameHandlersMutex.Lock()
+	defer session.frameHandlersMutex.Unlock()
+	delete(session.frameHandlers, id)
+}
+
+//depacketize assemble frames from pusher's rtp package,then pass to handlers
+func (session *PusherPullersPair) depacketize(packet *rtp.Packet) {
+	if session.codec == nil {
+		return
+	}
+	session.frameHandlersMutex.Lock()
+	handlers := make([]FrameHandler, 0, len(session.frameHandlers))
+	for _, handler := range session.frameHandlers {
+		handlers = append(handlers, handler)
+	}
+	session.frameHandlersMutex.Unlock()
+	frames, err := session.codec.Depacketize(packet)
+	if err != nil {
+		fmt.Printf("Depacketize error:%v\n", err)
+	}
+	for _, frame := range frames {
+		for _, handler := range handlers {
+			handler(frame)
+		}
+	}
+}
+
+//PublishFrame packetize frame into rtp packages and dispatch to pullers,
+//for publishers not sending rtp
+func (session *PusherPullersPair) PublishFrame(frame *Frame) error {
+	if session.codec == nil {
+		return fmt.Errorf("PublishFrame error: payload format not support")
+	}
+	packets, err := session.codec.Packetize(frame)
+	if err != nil {
+		return fmt.Errorf("Packetize error:%v", err)
+	}
+	for _, packet := range packets {
+		if err := session.PublishPacket(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
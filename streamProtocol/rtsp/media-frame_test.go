@@ -0,0 +1,88 @@
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/h265"
+)
+
+func TestH264FrameHandler(t *testing.T) {
+	codec, err := newTrackCodec(&RtpMap{PayloadType: 96, EncodingName: "H264", ClockRate: 90000},
+		map[string]string{"sprop-parameter-sets": "Z0IAH+kCgPZA,aM48gA=="})
+	if err != nil {
+		t.Fatalf("newTrackCodec error:%v", err)
+	}
+	pair := &PusherPullersPair{codec: codec}
+	frames := make([]*Frame, 0)
+	if err := pair.AddFrameHandler("test", func(frame *Frame) {
+		frames = append(frames, frame)
+	}); err != nil {
+		t.Fatalf("AddFrameHandler error:%v", err)
+	}
+	idr := make([]byte, 4000)
+	idr[0] = 0x65
+	packets, _ := h264.NewPacketizer(1200, 96, 1).Packetize([][]byte{idr}, 3000)
+	for _, packet := range packets {
+		pair.depacketize(packet)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("got %v frames, want 1", len(frames))
+	}
+	frame := frames[0]
+	if !frame.IsKeyframe || frame.Timestamp != 3000 || len(frame.Units) != 3 ||
+		h264.TypeOf(frame.Units[0]) != h264.NALUTypeSPS {
+		t.Errorf("frame = %+v, want keyframe with sdp parameter sets", frame)
+	}
+	pair.RemoveFrameHandler("test")
+	pair.depacketize(packets[0])
+	if len(frames) != 1 {
+		t.Errorf("handler called after RemoveFrameHandler")
+	}
+}
+
+func TestH265Frame(t *testing.T) {
+	codec, err := newTrackCodec(&RtpMap{PayloadType: 96, EncodingName: "H265", ClockRate: 90000},
+		map[string]string{
+			"sprop-vps": "QAEMAf//AWAAAAMAkAAAAwAAAwB4mZgJ",
+			"sprop-sps": "QgEBAWAAAAMAkAAAAwAAAwB4oAPAgBDlmWZJMrwBAAADAAEAAAMAHgg=",
+			"sprop-pps": "RAHBcrRiQA==",
+		})
+	if err != nil {
+		t.Fatalf("newTrackCodec error:%v", err)
+	}
+	idr := make([]byte, 4000)
+	idr[0], idr[1] = 0x26, 0x01
+	packets, _ := h265.NewPacketizer(1200, 96, 1).Packetize([][]byte{idr}, 3000)
+	frames := make([]*Frame, 0)
+	for _, packet := range packets {
+		packetFrames, err := codec.Depacketize(packet)
+		if err != nil {
+			t.Fatalf("Depacketize error:%v", err)
+		}
+		frames = append(frames, packetFrames...)
+	}
+	if len(frames) != 1 || !frames[0].IsKeyframe || len(frames[0].Units) != 4 ||
+		h265.TypeOf(frames[0].Units[0]) != h265.NALUTypeVPS {
+		t.Errorf("frames = %+v, want keyframe with sdp parameter sets", frames)
+	}
+}
+
+func TestAACFrame(t *testing.T) {
+	codec, err := newTrackCodec(&RtpMap{PayloadType: 97, EncodingName: "MPEG4-GENERIC",
+		ClockRate: 44100, Channels: 2}, map[string]string{"mode": "AAC-hbr", "sizelength": "13",
+		"indexlength": "3", "indexdeltalength": "3", "config": "1210"})
+	if err != nil {
+		t.Fatalf("newTrackCodec error:%v", err)
+	}
+	packets, err := codec.Packetize(&Frame{Timestamp: 2048,
+		Units: [][]byte{{0x21, 0x10}, {0x21, 0x20}}})
+	if err != nil || len(packets) != 2 {
+		t.Fatalf("Packetize = %v,%v", len(packets), err)
+	}
+	frames, err := codec.Depacketize(packets[1])
+	if err != nil || len(frames) != 1 || frames[0].Timestamp != 3072 ||
+		frames[0].Duration != 1024 || frames[0].Units[0][1] != 0x20 {
+		t.Errorf("Depacketize = %+v,%v", frames, err)
+	}
+}
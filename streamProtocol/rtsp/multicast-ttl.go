@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package rtsp
+
+import (
+	"net"
+	"syscall"
+)
+
+//setMulticastTTL set time to live of multicast packages sent by conn
+func setMulticastTTL(conn *net.UDPConn, ttl int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		setErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
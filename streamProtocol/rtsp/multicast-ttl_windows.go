@@ -0,0 +1,22 @@
+package rtsp
+
+import (
+	"net"
+	"syscall"
+)
+
+//setMulticastTTL set time to live of multicast packages sent by conn
+func setMulticastTTL(conn *net.UDPConn, ttl int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		setErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP,
+			syscall.IP_MULTICAST_TTL, ttl)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
@@ -0,0 +1,279 @@
+package rtsp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// multicast settings,multicast SETUP is not supported if MulticastIPRange is empty
+var (
+	//MulticastIPRange cidr of ipv4 multicast groups allocated to tracks
+	MulticastIPRange string = "239.255.0.0/16"
+	//MulticastRtpPort rtp port of multicast groups,rtcp port is the next one
+	MulticastRtpPort int = 5004
+	//MulticastTTL time to live of multicast packages
+	MulticastTTL int = 16
+)
+
+//multicastSenderID rtsp session id of senders of multicast groups
+const multicastSenderID = "multicast"
+
+// allocated multicast groups of all tracks
+var (
+	multicastGroups      = make(map[string]bool)
+	multicastGroupsMutex sync.Mutex
+)
+
+//allocateMulticastIP allocate an unused group from MulticastIPRange
+func allocateMulticastIP() (net.IP, error) {
+	_, ipRange, err := net.ParseCIDR(MulticastIPRange)
+	if err != nil {
+		return nil, fmt.Errorf("multicast ip range %v invalid:%v", MulticastIPRange, err)
+	}
+	first := ipRange.IP.To4()
+	if first == nil || !first.IsMulticast() {
+		return nil, fmt.Errorf("multicast ip range %v is not ipv4 multicast", MulticastIPRange)
+	}
+	multicastGroupsMutex.Lock()
+	defer multicastGroupsMutex.Unlock()
+	// the network address itself is skipped like unicast ranges
+	for ip := nextIP(first); ipRange.Contains(ip); ip = nextIP(ip) {
+		if !multicastGroups[ip.String()] {
+			multicastGroups[ip.String()] = true
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("multicast ip range %v exhausted", MulticastIPRange)
+}
+
+//releaseMulticastIP release group allocated by allocateMulticastIP
+func releaseMulticastIP(ip net.IP) {
+	multicastGroupsMutex.Lock()
+	delete(multicastGroups, ip.String())
+	multicastGroupsMutex.Unlock()
+}
+
+//nextIP the ipv4 address after ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, net.IPv4len)
+	copy(next, ip.To4())
+	for index := len(next) - 1; index >= 0; index-- {
+		next[index]++
+		if next[index] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+/*
+multicastGroup multicast delivery of a track,packages are sent once to the group
+by sender,a puller in the pullers of the track,while any member plays,
+members are rtp-rtcp-sessions of rtsp sessions in the pullers of the track too,
+only for starting,pausing and stopping by rtsp session id
+*/
+type multicastGroup struct {
+	IP       net.IP                     // multicast group address
+	RtpPort  int                        // rtp port of group
+	RtcpPort int                        // rtcp port of group
+	TTL      int                        // time to live of packages
+	ppp      *PusherPullersPair         // track of this group
+	sender   *RtpRtcpSession            // puller sending packages to group
+	rtpConn  *net.UDPConn               // rtp udp connection to group
+	rtcpConn *net.UDPConn               // rtcp udp connection to group
+	listener *net.UDPConn               // joined group receiving rtcp of members
+	members  map[*RtpRtcpSession]string // members and their remote ips
+	mutex    sync.Mutex                 // provide members's atom
+}
+
+//AddMulticastPuller add a puller receiving packages of track from its multicast group,
+//the group is allocated and joined when the first multicast puller arrives
+func (session *PusherPullersSession) AddMulticastPuller(ppp *PusherPullersPair,
+	rtspSessionID, remoteIP string) (*RtpRtcpSession, error) {
+	if !session.Published() || ppp.Pusher == nil {
+		return nil, fmt.Errorf("puller's request's track %v has no pusher", ppp.Control)
+	}
+	if rtspSessionID == "" {
+		return nil, fmt.Errorf("rtsp session id is empty")
+	}
+	ppp.PullersMutex.Lock()
+	defer ppp.PullersMutex.Unlock()
+	if ppp.multicast == nil {
+		group, err := joinMulticastGroup(ppp)
+		if err != nil {
+			return nil, err
+		}
+		ppp.multicast = group
+		ppp.Pullers.PushBack(group.sender)
+	}
+	rrs := &RtpRtcpSession{
+		RtspSessionID:     rtspSessionID,
+		SessionMediaType:  ppp.MediaType,
+		SessionClientType: PullerClient,
+		multicast:         ppp.multicast,
+	}
+	ppp.multicast.mutex.Lock()
+	ppp.multicast.members[rrs] = remoteIP
+	ppp.multicast.mutex.Unlock()
+	ppp.Pullers.PushBack(rrs)
+	return rrs, nil
+}
+
+//joinMulticastGroup allocate a group for track,open connections sending to it
+//and join it for rtcp of members
+func joinMulticastGroup(ppp *PusherPullersPair) (*multicastGroup, error) {
+	ip, err := allocateMulticastIP()
+	if err != nil {
+		return nil, err
+	}
+	group := &multicastGroup{
+		IP:       ip,
+		RtpPort:  MulticastRtpPort,
+		RtcpPort: MulticastRtpPort + 1,
+		TTL:      MulticastTTL,
+		ppp:      ppp,
+		members:  make(map[*RtpRtcpSession]string),
+	}
+	if err := group.open(); err != nil {
+		group.close()
+		return nil, err
+	}
+	group.sender = &RtpRtcpSession{Transport: group}
+	if err := group.sender.StartRtpRtcpSession(
+		PullerClient, ppp.MediaType, nil, multicastSenderID); err != nil {
+		group.close()
+		return nil, err
+	}
+	group.sender.feedbackHandler = ppp.forwardFeedback
+	go group.receiveRtcp()
+	fmt.Printf("multicast group %v:%v-%v joined for %v track %v\n",
+		group.IP, group.RtpPort, group.RtcpPort, ppp.MediaType, ppp.Index)
+	return group, nil
+}
+
+//open open connections of group
+func (group *multicastGroup) open() error {
+	var err error
+	if group.rtpConn, err = net.DialUDP("udp4", nil,
+		&net.UDPAddr{IP: group.IP, Port: group.RtpPort}); err != nil {
+		return fmt.Errorf("DialUDP error:%v", err)
+	}
+	if group.rtcpConn, err = net.DialUDP("udp4", nil,
+		&net.UDPAddr{IP: group.IP, Port: group.RtcpPort}); err != nil {
+		return fmt.Errorf("DialUDP error:%v", err)
+	}
+	for _, conn := range []*net.UDPConn{group.rtpConn, group.rtcpConn} {
+		if err := setMulticastTTL(conn, group.TTL); err != nil {
+			return fmt.Errorf("setMulticastTTL error:%v", err)
+		}
+	}
+	if group.listener, err = net.ListenMulticastUDP("udp4", nil,
+		&net.UDPAddr{IP: group.IP, Port: group.RtcpPort}); err != nil {
+		return fmt.Errorf("ListenMulticastUDP error:%v", err)
+	}
+	return nil
+}
+
+//close leave group and release it
+func (group *multicastGroup) close() {
+	for _, conn := range []*net.UDPConn{group.rtpConn, group.rtcpConn, group.listener} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	releaseMulticastIP(group.IP)
+}
+
+//WritePackage send package to group
+func (group *multicastGroup) WritePackage(packageType PackageType, data []byte) error {
+	conn := group.rtpConn
+	if packageType == RtcpPackage {
+		conn = group.rtcpConn
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+//receiveRtcp receive rtcp of members until the group is left,
+//members are kept alive by receiver reports from their ips
+func (group *multicastGroup) receiveRtcp() {
+	data := make([]byte, ReadBufferSize)
+	localIP := group.rtcpConn.LocalAddr().(*net.UDPAddr).IP
+	for {
+		number, addr, err := group.listener.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		if addr.IP.Equal(localIP) {
+			// sender reports of this group looped back
+			continue
+		}
+		if err := group.sender.receivePullerRtcp(data[:number], time.Now()); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		group.mutex.Lock()
+		for member, remoteIP := range group.members {
+			if remoteIP == addr.IP.String() {
+				member.touch(time.Now())
+			}
+		}
+		group.mutex.Unlock()
+	}
+}
+
+//update send packages to group while any member plays
+func (group *multicastGroup) update() error {
+	playing := false
+	group.mutex.Lock()
+	for member := range group.members {
+		if member.Playing() {
+			playing = true
+		}
+	}
+	group.mutex.Unlock()
+	sender := group.sender
+	switch {
+	case playing && !sender.Playing():
+		return sender.BeginTransfer(nil, nil)
+	case !playing && sender.Playing():
+		return sender.PauseTransfer()
+	}
+	return nil
+}
+
+//leave remove member from group,the group is left and released
+//when the last member departs
+func (group *multicastGroup) leave(member *RtpRtcpSession) error {
+	group.mutex.Lock()
+	if _, ok := group.members[member]; !ok {
+		group.mutex.Unlock()
+		return nil
+	}
+	delete(group.members, member)
+	empty := len(group.members) == 0
+	group.mutex.Unlock()
+	if !empty {
+		return group.update()
+	}
+	ppp := group.ppp
+	ppp.PullersMutex.Lock()
+	if ppp.multicast == group {
+		ppp.multicast = nil
+	}
+	ppp.PullersMutex.Unlock()
+	group.sender.StopTransfer()
+	group.close()
+	fmt.Printf("multicast group %v left\n", group.IP)
+	return nil
+}
+
+//transport Transport header of SETUP response for multicast puller
+func (group *multicastGroup) transport() string {
+	return "RTP/AVP;multicast;destination=" + group.IP.String() +
+		";port=" + strconv.Itoa(group.RtpPort) + "-" + strconv.Itoa(group.RtcpPort) +
+		";ttl=" + strconv.Itoa(group.TTL)
+}
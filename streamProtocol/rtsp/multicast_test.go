@@ -0,0 +1,108 @@
+package rtsp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMulticastPullers(t *testing.T) {
+	MulticastIPRange, MulticastRtpPort = "239.255.77.0/24", 25004
+	defer func() { MulticastIPRange, MulticastRtpPort = "239.255.0.0/16", 5004 }()
+	if conn, err := net.ListenMulticastUDP("udp4", nil,
+		&net.UDPAddr{IP: net.IPv4(239, 255, 77, 1), Port: MulticastRtpPort}); err != nil {
+		t.Skipf("multicast not available:%v", err)
+	} else {
+		conn.Close()
+	}
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/multicast", address)
+	dial := func(transport ClientTransport) *Client {
+		client, err := NewClient(streamURL, transport)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		if err := client.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		return client
+	}
+	pusher := dial(TransportTCP)
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+
+	received := make([]chan RtpRtcpPackage, 2)
+	pullers := make([]*Client, 2)
+	for index := range pullers {
+		channel := make(chan RtpRtcpPackage, 100)
+		received[index] = channel
+		pullers[index] = dial(TransportMulticast)
+		defer pullers[index].Close()
+		pullers[index].OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+			if trackIndex == 0 && packageType == RtpPackage {
+				channel <- data
+			}
+		}
+		if err := pullers[index].StartPull(); err != nil {
+			t.Fatalf("StartPull error:%v", err)
+		}
+	}
+	server.PusherPullersSessionMapMutex.Lock()
+	track := server.PusherPullersSessionMap["/live/multicast"].Tracks[0]
+	server.PusherPullersSessionMapMutex.Unlock()
+	group := track.multicast
+	if group == nil || !strings.HasPrefix(group.IP.String(), "239.255.77.") {
+		t.Fatalf("multicast group of track = %v", group)
+	}
+	if destination := pullers[1].Tracks[0].RtpUDPConn.LocalAddr().(*net.UDPAddr).Port; destination != group.RtpPort {
+		t.Errorf("puller joined port %v, want %v", destination, group.RtpPort)
+	}
+
+	// one package to the group reaches every member
+	packet := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	deadline := time.After(5 * time.Second)
+	for index, channel := range received {
+	receiving:
+		for sequence := 1; ; sequence++ {
+			packet[3] = byte(sequence)
+			if err := pusher.PushPackage(0, RtpPackage, packet); err != nil {
+				t.Fatalf("PushPackage error:%v", err)
+			}
+			select {
+			case data := <-channel:
+				if data[12] != packet[12] {
+					t.Errorf("puller %v received %v, want %v", index, data, packet)
+				}
+				break receiving
+			case <-time.After(50 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("puller %v not received package", index)
+			}
+		}
+	}
+
+	// the group is left and released after the last member departs
+	if _, err := pullers[0].Teardown(); err != nil {
+		t.Fatalf("Teardown error:%v", err)
+	}
+	if track.multicast != group {
+		t.Errorf("group left with a member remaining")
+	}
+	if _, err := pullers[1].Teardown(); err != nil {
+		t.Fatalf("Teardown error:%v", err)
+	}
+	track.PullersMutex.Lock()
+	left := track.multicast == nil
+	track.PullersMutex.Unlock()
+	multicastGroupsMutex.Lock()
+	allocated := multicastGroups[group.IP.String()]
+	multicastGroupsMutex.Unlock()
+	if !left || allocated {
+		t.Errorf("group %v not released after all members departed", group.IP)
+	}
+}
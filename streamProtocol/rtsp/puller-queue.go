@@ -0,0 +1,217 @@
+package rtsp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//DropPolicy what a puller's queue drops when the puller is too slow to keep up
+type DropPolicy int
+
+const (
+	//DropOldest drop the oldest queued package for the new one
+	DropOldest DropPolicy = 0
+	//DropUntilKeyframe drop queued packages and new ones until the next keyframe,
+	//every package of tracks without keyframes is taken as a keyframe
+	DropUntilKeyframe DropPolicy = 1
+	//DropDisconnect drop new packages,and disconnect the puller after PullerMaxDrops drops
+	DropDisconnect DropPolicy = 2
+)
+
+// puller queue settings,packages for a puller are queued without blocking other pullers
+var (
+	//PullerQueueSize max rtp packages queued for a puller of a track
+	PullerQueueSize int = 1024
+	//PullerDropPolicy what to drop when the queue of a puller is full
+	PullerDropPolicy DropPolicy = DropOldest
+	//PullerMaxDrops drops before a puller is disconnected by DropDisconnect
+	PullerMaxDrops int = 100
+)
+
+//pullerQueue bounded queue of rtp packages for a puller,
+//pushed by dispatch goroutine without blocking and popped by the puller's goroutine
+type pullerQueue struct {
+	packets  []*rtp.Packet // ring buffer of queued packages
+	head     int           // index of the oldest package
+	length   int           // number of queued packages
+	policy   DropPolicy    // what to drop when full
+	maxDrops int           // drops before disconnect by DropDisconnect
+	dropping bool          // if dropping until the next keyframe
+	dropped  uint32        // packages dropped
+	closed   bool          // if closed when puller stops
+	mutex    sync.Mutex    // provide queue's atom
+	ready    chan struct{} // signaled when a package is pushed or queue closed
+}
+
+//newPullerQueue make queue of size packages
+func newPullerQueue(size int, policy DropPolicy, maxDrops int) *pullerQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &pullerQueue{
+		packets:  make([]*rtp.Packet, size),
+		policy:   policy,
+		maxDrops: maxDrops,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+//push queue package,keyframe is if it starts a keyframe,
+//return false if the puller should be disconnected for too many drops
+func (queue *pullerQueue) push(packet *rtp.Packet, keyframe bool) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.closed {
+		return true
+	}
+	if queue.dropping && !keyframe {
+		queue.dropped++
+		return true
+	}
+	queue.dropping = false
+	if queue.length == len(queue.packets) {
+		switch queue.policy {
+		case DropUntilKeyframe:
+			queue.dropped += uint32(queue.length)
+			for queue.length > 0 {
+				queue.shift()
+			}
+			if !keyframe {
+				queue.dropping = true
+				queue.dropped++
+				return true
+			}
+		case DropDisconnect:
+			queue.dropped++
+			return int(queue.dropped) < queue.maxDrops
+		default:
+			queue.shift()
+			queue.dropped++
+		}
+	}
+	queue.packets[(queue.head+queue.length)%len(queue.packets)] = packet
+	queue.length++
+	queue.signal()
+	return true
+}
+
+//replay queue cached gop for a puller just started without drop policy,
+//the queue grows by the gop so that live packages keep their room behind it
+func (queue *pullerQueue) replay(packets []*rtp.Packet) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.closed {
+		return
+	}
+	queue.dropping = false
+	grown := make([]*rtp.Packet, len(queue.packets)+len(packets))
+	for index := 0; index < queue.length; index++ {
+		grown[index] = queue.packets[(queue.head+index)%len(queue.packets)]
+	}
+	copy(grown[queue.length:], packets)
+	queue.packets, queue.head = grown, 0
+	queue.length += len(packets)
+	queue.signal()
+}
+
+//pop wait for the oldest package,nil if queue is closed
+func (queue *pullerQueue) pop() *rtp.Packet {
+	for {
+		queue.mutex.Lock()
+		if queue.closed {
+			queue.mutex.Unlock()
+			return nil
+		}
+		if queue.length > 0 {
+			packet := queue.shift()
+			queue.mutex.Unlock()
+			return packet
+		}
+		queue.mutex.Unlock()
+		<-queue.ready
+	}
+}
+
+//shift remove the oldest package,queue should be locked and not empty
+func (queue *pullerQueue) shift() *rtp.Packet {
+	packet := queue.packets[queue.head]
+	queue.packets[queue.head] = nil
+	queue.head = (queue.head + 1) % len(queue.packets)
+	queue.length--
+	return packet
+}
+
+//signal wake up pop without blocking
+func (queue *pullerQueue) signal() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+//close drop queued packages and wake up pop,queue is nil-safe
+func (queue *pullerQueue) close() {
+	if queue == nil {
+		return
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.closed = true
+	for queue.length > 0 {
+		queue.shift()
+	}
+	queue.signal()
+}
+
+//clear drop queued packages without counting them,queue is nil-safe
+func (queue *pullerQueue) clear() {
+	if queue == nil {
+		return
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for queue.length > 0 {
+		queue.shift()
+	}
+}
+
+//Dropped packages dropped,queue is nil-safe
+func (queue *pullerQueue) Dropped() uint32 {
+	if queue == nil {
+		return 0
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.dropped
+}
+
+//startsKeyframe if package starts a keyframe of track,
+//every package of tracks without keyframes does
+func (session *PusherPullersPair) startsKeyframe(packet *rtp.Packet) bool {
+	if session.codec == nil || session.MediaType != MediaVideo {
+		return true
+	}
+	return session.codec.StartsKeyframe(packet)
+}
+
+//queuePackets queue packages for puller,which is disconnected by too many drops,
+//a replayed gop of several packages is queued whole without drops
+func (session *PusherPullersPair) queuePackets(puller *RtpRtcpSession, packets []*rtp.Packet) {
+	if len(packets) > 1 {
+		puller.rtpQueue.replay(packets)
+		return
+	}
+	for _, packet := range packets {
+		if !puller.rtpQueue.push(packet, session.startsKeyframe(packet)) {
+			fmt.Printf("puller %v of track %v disconnected after %v drops\n",
+				puller.RtspSessionID, session.Index, puller.rtpQueue.Dropped())
+			puller.StopTransfer()
+			if puller.disconnectHandler != nil {
+				puller.disconnectHandler()
+			}
+			return
+		}
+	}
+}
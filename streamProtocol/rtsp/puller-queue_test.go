@@ -0,0 +1,166 @@
+package rtsp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestPullerQueue(t *testing.T) {
+	packets := make([]*rtp.Packet, 8)
+	for index := range packets {
+		packets[index] = &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(index)}}
+	}
+	popAll := func(queue *pullerQueue) []uint16 {
+		sequences := make([]uint16, 0)
+		for queue.length > 0 {
+			sequences = append(sequences, queue.pop().SequenceNumber)
+		}
+		return sequences
+	}
+
+	queue := newPullerQueue(3, DropOldest, 0)
+	for _, packet := range packets[:5] {
+		queue.push(packet, false)
+	}
+	if sequences := popAll(queue); fmt.Sprint(sequences) != "[2 3 4]" || queue.Dropped() != 2 {
+		t.Errorf("DropOldest queued %v,dropped %v", sequences, queue.Dropped())
+	}
+
+	queue = newPullerQueue(3, DropUntilKeyframe, 0)
+	for index, packet := range packets {
+		// keyframes start at 0 and 6
+		queue.push(packet, index == 0 || index == 6)
+	}
+	if sequences := popAll(queue); fmt.Sprint(sequences) != "[6 7]" || queue.Dropped() != 6 {
+		t.Errorf("DropUntilKeyframe queued %v,dropped %v", sequences, queue.Dropped())
+	}
+
+	queue = newPullerQueue(3, DropDisconnect, 2)
+	results := make([]bool, 0)
+	for _, packet := range packets[:5] {
+		results = append(results, queue.push(packet, false))
+	}
+	if fmt.Sprint(results) != "[true true true true false]" {
+		t.Errorf("DropDisconnect results %v", results)
+	}
+	if sequences := popAll(queue); fmt.Sprint(sequences) != "[0 1 2]" {
+		t.Errorf("DropDisconnect queued %v", sequences)
+	}
+
+	closed := make(chan *rtp.Packet)
+	go func() { closed <- queue.pop() }()
+	queue.close()
+	select {
+	case packet := <-closed:
+		if packet != nil {
+			t.Errorf("pop of closed queue = %v", packet)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("pop not woken up by close")
+	}
+}
+
+func TestGopReplayQueue(t *testing.T) {
+	for _, policy := range []DropPolicy{DropOldest, DropUntilKeyframe, DropDisconnect} {
+		// a full gop of default size replayed,then live packages before the puller pops
+		cache := &gopCache{clockRate: 90000}
+		var last *rtp.Packet
+		for sequence := 0; sequence < GopCacheMaxPackets; sequence++ {
+			last = &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(sequence)},
+				Payload: make([]byte, 1200)}
+			cache.add(last, sequence == 0)
+		}
+		puller := &RtpRtcpSession{rtpQueue: newPullerQueue(PullerQueueSize, policy, PullerMaxDrops)}
+		ppp := new(PusherPullersPair)
+		ppp.queuePackets(puller, cache.packetsFor(puller, last, true))
+		for sequence := GopCacheMaxPackets; sequence < GopCacheMaxPackets+PullerQueueSize; sequence++ {
+			ppp.queuePackets(puller, []*rtp.Packet{{Header: rtp.Header{SequenceNumber: uint16(sequence)}}})
+		}
+		if packet := puller.rtpQueue.pop(); packet.SequenceNumber != 0 ||
+			puller.rtpQueue.Dropped() != 0 || puller.rtpQueue.closed {
+			t.Errorf("policy %v popped %v first,dropped %v, want the keyframe", policy,
+				packet.SequenceNumber, puller.rtpQueue.Dropped())
+		}
+	}
+}
+
+//blockedTransport transport of a puller which never finishes writing
+type blockedTransport chan struct{}
+
+func (transport blockedTransport) WritePackage(packageType PackageType, data []byte) error {
+	<-transport
+	return fmt.Errorf("transport closed")
+}
+
+func TestSlowPuller(t *testing.T) {
+	defer func(size int, policy DropPolicy, maxDrops int) {
+		PullerQueueSize, PullerDropPolicy, PullerMaxDrops = size, policy, maxDrops
+	}(PullerQueueSize, PullerDropPolicy, PullerMaxDrops)
+	PullerQueueSize, PullerDropPolicy, PullerMaxDrops = 4, DropDisconnect, 3
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/slow", address)
+	dial := func() *Client {
+		client, err := NewClient(streamURL, TransportTCP)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		if err := client.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		return client
+	}
+	pusher := dial()
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	server.PusherPullersSessionMapMutex.Lock()
+	pps := server.PusherPullersSessionMap["/live/slow"]
+	server.PusherPullersSessionMapMutex.Unlock()
+	transport := make(blockedTransport)
+	defer close(transport)
+	slow, err := pps.AddTransportPuller(pps.Tracks[0], "slow", transport)
+	if err != nil {
+		t.Fatalf("AddTransportPuller error:%v", err)
+	}
+	slowID := "slow"
+	pps.StartSession(&slowID)
+
+	puller := dial()
+	defer puller.Close()
+	received := make(chan uint16, 100)
+	puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+		packet := new(rtp.Packet)
+		if trackIndex == 0 && packageType == RtpPackage && packet.Unmarshal(data) == nil {
+			received <- packet.SequenceNumber
+		}
+	}
+	if err := puller.StartPull(); err != nil {
+		t.Fatalf("StartPull error:%v", err)
+	}
+	// the fast puller gets every package while the slow one is stuck
+	for sequence := uint16(1); sequence <= 20; sequence++ {
+		data, _ := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: sequence, Timestamp: 3000},
+			Payload: []byte{0x41, 0x9a},
+		}).Marshal()
+		if err := pusher.PushPackage(0, RtpPackage, data); err != nil {
+			t.Fatalf("PushPackage error:%v", err)
+		}
+		select {
+		case received := <-received:
+			if received != sequence {
+				t.Fatalf("received package %v, want %v", received, sequence)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("package %v not received,fan-out blocked by slow puller", sequence)
+		}
+	}
+	if stats := slow.Stats(); stats.PacketsDropped < 3 || !slow.Stopped() {
+		t.Errorf("slow puller dropped %v packages,stopped %v", stats.PacketsDropped, slow.Stopped())
+	}
+}
@@ -0,0 +1,38 @@
+package rtsp
+
+import (
+	"fmt"
+	"time"
+)
+
+//PackageTransport transport of a puller or publisher other than udp and rtsp tcp
+//connection,like a webrtc peer connection,rtcp from the puller should be passed to
+//ReceiveRtcp of its rtp-rtcp-session,feedback of pullers is sent to the publisher
+type PackageTransport interface {
+	//WritePackage send rtp or rtcp package to remote end point
+	WritePackage(packageType PackageType, data []byte) error
+}
+
+//AddTransportPuller add a puller to track of this pusher-pullers-session whose
+//packages are sent by transport,it's started,paused and stopped with other
+//tracks of rtspSessionID by StartSession,PauseSession and StopSession
+func (session *PusherPullersSession) AddTransportPuller(ppp *PusherPullersPair,
+	rtspSessionID string, transport PackageTransport) (*RtpRtcpSession, error) {
+	if !session.Published() || ppp.Pusher == nil {
+		return nil, fmt.Errorf("puller's request's track %v has no pusher", ppp.Control)
+	}
+	rrs := &RtpRtcpSession{Transport: transport}
+	if err := rrs.StartRtpRtcpSession(PullerClient, ppp.MediaType, nil, rtspSessionID); err != nil {
+		return nil, err
+	}
+	rrs.feedbackHandler = ppp.forwardFeedback
+	ppp.PullersMutex.Lock()
+	ppp.Pullers.PushBack(rrs)
+	ppp.PullersMutex.Unlock()
+	return rrs, nil
+}
+
+//ReceiveRtcp receive rtcp package from puller by its transport
+func (session *RtpRtcpSession) ReceiveRtcp(data []byte) error {
+	return session.receivePullerRtcp(data, time.Now())
+}
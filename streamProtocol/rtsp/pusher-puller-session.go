@@ -2,84 +2,195 @@ package rtsp
 
 import (
 	"container/list"
+	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
 	"gortc.io/sdp"
 )
 
-//PusherPullersPair one pusher maps multiple pullers
+//PusherPullersPair one pusher maps multiple pullers,stands for a track in sdp
 type PusherPullersPair struct {
 	Pusher          *RtpRtcpSession
 	Pullers         *list.List
 	PullersMutex    sync.Mutex
-	rtpPackageChan  chan RtpRtcpPackage
+	rtpPackageChan  chan *rtp.Packet
 	rtcpPackageChan chan RtpRtcpPackage
-	IfStop          bool
+	Index           int        // index of track in sdp
+	Control         string     // track path relative to resource path,see trackControl
+	MediaType       MediaType  // video,audio or application
+	Media           *sdp.Media // media description of track
+	RtpMap          *RtpMap    // payload format from sdp rtpmap
+	ClockRate       int        // rtp clock rate of this track
+	/*
+		pusher's latest sender report and rtp timestamp,
+		for ntp/rtp timestamp mapping in sender reports to pullers
+	*/
+	lastSenderReport     *rtcp.SenderReport
+	lastSenderReportTime time.Time
+	lastSSRC             uint32
+	lastCNAME            string // cname of pusher's sdes,reused in sender reports to pullers
+	lastRtpTimestamp     uint32
+	lastRtpTime          time.Time
+	reportMutex          sync.Mutex
+	codec                trackCodec              // depacketizer/packetizer,nil if not support
+	frameHandlers        map[string]FrameHandler // handlers of assembled frames
+	frameHandlersMutex   sync.Mutex              // provide frameHandlers's atom
+	multicast            *multicastGroup         // multicast delivery of track,nil if no multicast puller
+	gop                  *gopCache               // packages since the latest keyframe,nil if not cached
+	ctx                  context.Context         // canceled when pusher stops,see lifecycle
+	cancel               context.CancelFunc      // cancel of ctx
+	ctxMutex             sync.Mutex              // provide ctx's atom
 }
 
 //PusherPullersSession session includes pusher and pullers
 type PusherPullersSession struct {
-	PusherPullersPairMap map[MediaType]*PusherPullersPair // has vidio and audio
-	SdpMessage           *sdp.Message                     // sdp info from pusher
-	SdpContent           *string                          // sdp raw content
-	AudioStreamName      *string                          // audio stream name from sdp content
-	VideoStreamName      *string                          // video stream name from sdp content
+	Tracks     []*PusherPullersPair // tracks in sdp order
+	SdpMessage *sdp.Message         // sdp info from pusher
+	SdpContent *string              // sdp raw content
+	Recorder   *Recorder            // records frames into files,nil if not recording
+	published  bool                 // if pusher has set up its tracks,see Published
+	reservedBy string               // publisher id reserving resource path,see Server.Reserve
+	mutex      sync.Mutex           // provide published's atom
 }
 
-//AddRtpRtcpSession add a rtp-rtcp-session to this pusher-pullers-session
+//setPublished mark session published after pusher has set up its tracks
+func (session *PusherPullersSession) setPublished() {
+	session.mutex.Lock()
+	session.published = true
+	session.mutex.Unlock()
+}
+
+//Published if pusher has set up its tracks,tracks and their pushers are not
+//changed after it's true,so they can be read by other goroutines
+func (session *PusherPullersSession) Published() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.published
+}
+
+//SetupTracks make tracks from medias of SdpMessage,
+//resourcePath is used to get track path from absolute control url
+func (session *PusherPullersSession) SetupTracks(resourcePath string) error {
+	if session.SdpMessage == nil || len(session.SdpMessage.Medias) == 0 {
+		return fmt.Errorf("sdp has no media")
+	}
+	tracks := make([]*PusherPullersPair, 0, len(session.SdpMessage.Medias))
+	for index := range session.SdpMessage.Medias {
+		media := &session.SdpMessage.Medias[index]
+		ppp := &PusherPullersPair{
+			Pullers:         list.New(),
+			rtpPackageChan:  make(chan *rtp.Packet, PushChannelBufferSize),
+			rtcpPackageChan: make(chan RtpRtcpPackage, PullChannelBufferSize),
+			Index:           index,
+			Control: trackControl(media, index,
+				len(session.SdpMessage.Medias), resourcePath),
+			MediaType: ParseMediaType(media.Description.Type),
+			Media:     media,
+		}
+		for _, track := range tracks {
+			if track.Control == ppp.Control {
+				return fmt.Errorf("track control %v duplicated", ppp.Control)
+			}
+		}
+		if rtpMap, err := ParseRtpMap(media); err != nil {
+			// application tracks may have no rtpmap,forward them as they are
+			fmt.Printf("ParseRtpMap error:%v\n", err)
+		} else {
+			ppp.RtpMap = rtpMap
+			ppp.ClockRate = rtpMap.ClockRate
+			if ppp.codec, err = newTrackCodec(rtpMap, ParseFmtp(media)); err != nil {
+				// still forward rtp packages,only frames are not available
+				fmt.Printf("newTrackCodec error:%v\n", err)
+				ppp.codec = nil
+			}
+		}
+		ppp.gop = newGopCache(ppp)
+		tracks = append(tracks, ppp)
+	}
+	session.Tracks = tracks
+	return nil
+}
+
+//FindTrack find track whose control matches the track path of SETUP url exactly
+func (session *PusherPullersSession) FindTrack(control string) *PusherPullersPair {
+	for _, track := range session.Tracks {
+		if track.Control == control {
+			return track
+		}
+	}
+	return nil
+}
+
+//AddRtpRtcpSession add a rtp-rtcp-session to track of this pusher-pullers-session,
+//if interleavedInfo is not nil,rtp/rtcp will be transfered in rtsp tcp connection
 func (session *PusherPullersSession) AddRtpRtcpSession(
-	clientType ClientType, mediaType MediaType,
-	rtpPort, rtcpPort, remoteIP *string, rtspSessionID string) error {
-	if session.PusherPullersPairMap == nil {
-		session.PusherPullersPairMap = make(map[MediaType]*PusherPullersPair)
+	clientType ClientType, ppp *PusherPullersPair,
+	rtpPort, rtcpPort, remoteIP *string, rtspSessionID string,
+	interleavedInfo *InterleavedInfo) (*RtpRtcpSession, error) {
+	rrs := new(RtpRtcpSession)
+	if interleavedInfo != nil {
+		rrs.Interleaved = interleavedInfo.Conn
+		rrs.RtpChannel = interleavedInfo.RtpChannel
+		rrs.RtcpChannel = interleavedInfo.RtcpChannel
 	}
 	switch clientType {
 	case PusherClient:
-		if _, ok := session.PusherPullersPairMap[mediaType]; ok {
-			return fmt.Errorf("pusher's request's url resource already used")
-		}
-		ppp := new(PusherPullersPair)
-		ppp.rtpPackageChan = make(chan RtpRtcpPackage, PushChannelBufferSize)
-		ppp.rtcpPackageChan = make(chan RtpRtcpPackage, PullChannelBufferSize)
-		ppp.Pullers = list.New()
-		session.PusherPullersPairMap[mediaType] = ppp
-		rrs := new(RtpRtcpSession)
-		if err := rrs.StartRtpRtcpSession(clientType, mediaType, nil, rtspSessionID); err != nil {
-			return err
+		if ppp.Pusher != nil {
+			return nil, fmt.Errorf("pusher's request's track %v already used", ppp.Control)
 		}
-		if err := ppp.StartDispatch(); err != nil {
-			return err
+		if err := rrs.StartRtpRtcpSession(clientType, ppp.MediaType, nil, rtspSessionID); err != nil {
+			return nil, err
 		}
+		rrs.ReceptionStats = rtcp.NewReceptionStatistics(ppp.ClockRate)
 		ppp.Pusher = rrs
-		*rtpPort = *rrs.RtpServerPort
-		*rtcpPort = *rrs.RtcpServerPort
+		if err := ppp.StartDispatch(); err != nil {
+			return nil, err
+		}
+		if interleavedInfo == nil {
+			*rtpPort = *rrs.RtpServerPort
+			*rtcpPort = *rrs.RtcpServerPort
+		}
 	case PullerClient:
-		ppp, ok := session.PusherPullersPairMap[mediaType]
-		if !ok {
-			return fmt.Errorf("puller's request's url resource not found")
+		if !session.Published() || ppp.Pusher == nil {
+			return nil, fmt.Errorf("puller's request's track %v has no pusher", ppp.Control)
 		}
-		rrs := new(RtpRtcpSession)
-		if err := rrs.StartRtpRtcpSession(clientType, mediaType, &PullerClientInfo{
+		if err := rrs.StartRtpRtcpSession(clientType, ppp.MediaType, &PullerClientInfo{
 			RtpRemotePort:  rtpPort,
 			RtcpRemotePort: rtcpPort,
 			IPRemote:       remoteIP,
 		}, rtspSessionID); err != nil {
-			return err
+			return nil, err
 		}
+		rrs.feedbackHandler = ppp.forwardFeedback
 		ppp.PullersMutex.Lock()
 		ppp.Pullers.PushBack(rrs)
 		ppp.PullersMutex.Unlock()
 	default:
-		return fmt.Errorf("clientType error : not support")
+		return nil, fmt.Errorf("clientType error : not support")
 	}
-	return nil
+	return rrs, nil
+}
+
+//sessionTracks tracks which rtsp session rtspSessionID has set up
+func (session *PusherPullersSession) sessionTracks(rtspSessionID string) []*PusherPullersPair {
+	tracks := make([]*PusherPullersPair, 0, len(session.Tracks))
+	for _, ppp := range session.Tracks {
+		if ppp.hasSession(rtspSessionID) {
+			tracks = append(tracks, ppp)
+		}
+	}
+	return tracks
 }
 
 //StartSession start goroutines(rtp/rtcp) created by rtspSessionID
 func (session *PusherPullersSession) StartSession(rtspSessionID *string) []error {
 	returnErr := make([]error, 0)
-	for _, ppp := range session.PusherPullersPairMap {
+	for _, ppp := range session.sessionTracks(*rtspSessionID) {
 		if err := ppp.Start(rtspSessionID); len(err) != 0 {
 			returnErr = append(returnErr, err...)
 		}
@@ -90,7 +201,7 @@ func (session *PusherPullersSession) StartSession(rtspSessionID *string) []error
 //PauseSession Pause goroutines(rtp/rtcp) created by rtspSessionID
 func (session *PusherPullersSession) PauseSession(rtspSessionID *string) []error {
 	returnErr := make([]error, 0)
-	for _, ppp := range session.PusherPullersPairMap {
+	for _, ppp := range session.sessionTracks(*rtspSessionID) {
 		if err := ppp.Pause(rtspSessionID); len(err) != 0 {
 			returnErr = append(returnErr, err...)
 		}
@@ -101,7 +212,7 @@ func (session *PusherPullersSession) PauseSession(rtspSessionID *string) []error
 //StopSession stop goroutines(rtp/rtcp) created by rtspSessionID
 func (session *PusherPullersSession) StopSession(rtspSessionID *string) []error {
 	returnErr := make([]error, 0)
-	for _, ppp := range session.PusherPullersPairMap {
+	for _, ppp := range session.sessionTracks(*rtspSessionID) {
 		if err := ppp.Stop(rtspSessionID); len(err) != 0 {
 			returnErr = append(returnErr, err...)
 		}
@@ -109,42 +220,131 @@ func (session *PusherPullersSession) StopSession(rtspSessionID *string) []error
 	return returnErr
 }
 
-//StartDispatch begin package(rtp/rtcp) dispatch from pusher to pullers
+//hasSession if pusher or a puller of this track belongs to rtspSessionID
+func (session *PusherPullersPair) hasSession(rtspSessionID string) bool {
+	if session.Pusher == nil {
+		return false
+	}
+	if session.Pusher.RtspSessionID == rtspSessionID {
+		return true
+	}
+	for _, puller := range session.pullers() {
+		if puller.RtspSessionID == rtspSessionID {
+			return true
+		}
+	}
+	return false
+}
+
+//lifecycle context of this track canceled when pusher stops,made on first use
+func (session *PusherPullersPair) lifecycle() context.Context {
+	session.ctxMutex.Lock()
+	defer session.ctxMutex.Unlock()
+	if session.ctx == nil {
+		session.ctx, session.cancel = context.WithCancel(context.Background())
+	}
+	return session.ctx
+}
+
+//Stopped if pusher of this track stopped
+func (session *PusherPullersPair) Stopped() bool {
+	return session.lifecycle().Err() != nil
+}
+
+//stop stop dispatch goroutines of this track
+func (session *PusherPullersPair) stop() {
+	session.lifecycle()
+	session.ctxMutex.Lock()
+	session.cancel()
+	session.ctxMutex.Unlock()
+}
+
+//pullers snapshot of pullers,stopped ones are removed
+func (session *PusherPullersPair) pullers() []*RtpRtcpSession {
+	session.PullersMutex.Lock()
+	defer session.PullersMutex.Unlock()
+	pullers := make([]*RtpRtcpSession, 0, session.Pullers.Len())
+	var next *list.Element
+	for puller := session.Pullers.Front(); puller != nil; puller = next {
+		next = puller.Next()
+		rrs := puller.Value.(*RtpRtcpSession)
+		if rrs.Stopped() {
+			session.Pullers.Remove(puller)
+			fmt.Println("rtp session deleted,rtsp session id =" + rrs.RtspSessionID +
+				",session.Pullers size = " + strconv.Itoa(session.Pullers.Len()))
+			continue
+		}
+		pullers = append(pullers, rrs)
+	}
+	return pullers
+}
+
+//StartDispatch begin package(rtp/rtcp) dispatch from pusher to pullers,
+//and send rtcp reports every ReportInterval
 func (session *PusherPullersPair) StartDispatch() error {
+	done := session.lifecycle().Done()
 	go func() {
-		for !session.IfStop {
-			data := <-session.rtpPackageChan
-			var next *list.Element
-			for puller := session.Pullers.Front(); puller != nil; puller = next {
-				next = puller.Next()
-				if puller.Value.(*RtpRtcpSession).IfStop {
-					session.Pullers.Remove(puller)
-					fmt.Println("rtp session deleted,rtsp session id =" +
-						puller.Value.(*RtpRtcpSession).RtspSessionID +
-						",session.Pullers size = " + string(session.Pullers.Len()))
-				} else {
-					puller.Value.(*RtpRtcpSession).RtpPackageChannel <- &data
+		for {
+			var data *rtp.Packet
+			select {
+			case data = <-session.rtpPackageChan:
+			case <-done:
+				return
+			}
+			arrival := time.Now()
+			if session.Pusher != nil {
+				session.Pusher.countReceived(data, arrival)
+			}
+			session.updateRtpTime(data, arrival)
+			session.depacketize(data)
+			session.cacheGop(data)
+			for _, puller := range session.pullers() {
+				if ok, replay := puller.deliverable(); ok {
+					session.queuePackets(puller, session.gop.packetsFor(puller, data, replay))
 				}
 			}
 		}
 	}()
 	go func() {
-		for !session.IfStop {
-			data := <-session.rtcpPackageChan
-			var next *list.Element
-			for puller := session.Pullers.Front(); puller != nil; puller = next {
-				next = puller.Next()
-				if puller.Value.(*RtpRtcpSession).IfStop {
-					session.Pullers.Remove(puller)
-					fmt.Println("rtcp session deleted,rtsp session id =" +
-						puller.Value.(*RtpRtcpSession).RtspSessionID +
-						",session.Pullers size = " + string(session.Pullers.Len()))
-				} else {
-					puller.Value.(*RtpRtcpSession).RtcpPackageChannel <- &data
+		for {
+			var data RtpRtcpPackage
+			select {
+			case data = <-session.rtcpPackageChan:
+			case <-done:
+				return
+			}
+			data, err := session.filterPusherRtcp(data, time.Now())
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if data == nil {
+				continue
+			}
+			for _, puller := range session.pullers() {
+				if puller.multicast != nil || !puller.Playing() {
+					continue
+				}
+				select {
+				case puller.RtcpPackageChannel <- &data:
+				default:
+					// reports are dropped for slow puller,new ones follow
 				}
 			}
 		}
 	}()
+	go func() {
+		ticker := time.NewTicker(ReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				session.sendReports(now)
+			case <-done:
+				return
+			}
+		}
+	}()
 	return nil
 }
 
@@ -156,16 +356,16 @@ func (session *PusherPullersPair) Start(rtspSessionID *string) []error {
 			session.rtpPackageChan, session.rtcpPackageChan); err != nil {
 			returnErr = append(returnErr, err)
 		}
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if err := puller.Value.(*RtpRtcpSession).BeginTransfer(nil, nil); err != nil {
+		for _, puller := range session.pullers() {
+			if err := puller.BeginTransfer(nil, nil); err != nil {
 				returnErr = append(returnErr, err)
 			}
 		}
 	} else {
 		var find bool = false
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if puller.Value.(*RtpRtcpSession).RtspSessionID == *rtspSessionID {
-				if err := puller.Value.(*RtpRtcpSession).BeginTransfer(nil, nil); err != nil {
+		for _, puller := range session.pullers() {
+			if puller.RtspSessionID == *rtspSessionID {
+				if err := puller.BeginTransfer(nil, nil); err != nil {
 					returnErr = append(returnErr, err)
 				}
 				find = true
@@ -187,16 +387,16 @@ func (session *PusherPullersPair) Pause(rtspSessionID *string) []error {
 		if err := session.Pusher.PauseTransfer(); err != nil {
 			returnErr = append(returnErr, err)
 		}
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if err := puller.Value.(*RtpRtcpSession).PauseTransfer(); err != nil {
+		for _, puller := range session.pullers() {
+			if err := puller.PauseTransfer(); err != nil {
 				returnErr = append(returnErr, err)
 			}
 		}
 	} else {
 		var find bool = false
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if puller.Value.(*RtpRtcpSession).RtspSessionID == *rtspSessionID {
-				if err := puller.Value.(*RtpRtcpSession).PauseTransfer(); err != nil {
+		for _, puller := range session.pullers() {
+			if puller.RtspSessionID == *rtspSessionID {
+				if err := puller.PauseTransfer(); err != nil {
 					returnErr = append(returnErr, err)
 				}
 				find = true
@@ -218,17 +418,17 @@ func (session *PusherPullersPair) Stop(rtspSessionID *string) []error {
 		if err := session.Pusher.StopTransfer(); err != nil {
 			returnErr = append(returnErr, err)
 		}
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if err := puller.Value.(*RtpRtcpSession).StopTransfer(); err != nil {
+		for _, puller := range session.pullers() {
+			if err := puller.StopTransfer(); err != nil {
 				returnErr = append(returnErr, err)
 			}
 		}
-		session.IfStop = true
+		session.stop()
 	} else {
 		var find bool = false
-		for puller := session.Pullers.Front(); puller != nil; puller = puller.Next() {
-			if puller.Value.(*RtpRtcpSession).RtspSessionID == *rtspSessionID {
-				if err := puller.Value.(*RtpRtcpSession).StopTransfer(); err != nil {
+		for _, puller := range session.pullers() {
+			if puller.RtspSessionID == *rtspSessionID {
+				if err := puller.StopTransfer(); err != nil {
 					returnErr = append(returnErr, err)
 				}
 				find = true
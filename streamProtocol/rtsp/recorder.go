@@ -0,0 +1,147 @@
+package rtsp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recording settings,recording is disabled if RecordPathTemplate is empty
+var (
+	/*
+		RecordPathTemplate path of recorded files,
+		{path} is replaced by resource path,{date} by 2006-01-02,{time} by 15-04-05
+	*/
+	RecordPathTemplate string = ""
+	//RecordSegmentDuration a new file is started at the first keyframe after it
+	RecordSegmentDuration = time.Hour
+	//RecordFragmentDuration duration of fragments written into file
+	RecordFragmentDuration = time.Second
+)
+
+//Recorder write frames of a pusher-pullers-session into fragmented mp4 files,
+//every file starts with a keyframe of the first video track
+type Recorder struct {
+	PathTemplate string     // see RecordPathTemplate
+	ResourcePath string     // resource path of recorded session
+	FilePaths    []string   // files written,the last one may be still writing
+	Muxer        *FMP4Muxer // makes segments and fragments written into files
+	file         *os.File
+}
+
+//NewRecorder make a recorder of tracks with supported codecs in session
+func NewRecorder(session *PusherPullersSession, resourcePath string) (*Recorder, error) {
+	muxer, err := NewFMP4Muxer(session, "recorder")
+	if err != nil {
+		return nil, err
+	}
+	muxer.SegmentDuration = RecordSegmentDuration
+	muxer.FragmentDuration = RecordFragmentDuration
+	recorder := &Recorder{
+		PathTemplate: RecordPathTemplate,
+		ResourcePath: resourcePath,
+		Muxer:        muxer,
+	}
+	muxer.OnSegment = recorder.openFile
+	muxer.OnFragment = recorder.writeFragment
+	return recorder, nil
+}
+
+//Start subscribe frames of tracks and begin writing
+func (recorder *Recorder) Start() error {
+	return recorder.Muxer.Start()
+}
+
+//Close write frames left and finalize current file
+func (recorder *Recorder) Close() error {
+	recorder.Muxer.Close()
+	return recorder.closeFile()
+}
+
+//segmentPath file path of segment started at now from PathTemplate
+func (recorder *Recorder) segmentPath(now time.Time) string {
+	path := strings.NewReplacer(
+		"{path}", strings.Trim(recorder.ResourcePath, "/"),
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15-04-05"),
+	).Replace(recorder.PathTemplate)
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+	for index := 1; ; index++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = base + "_" + strconv.Itoa(index) + extension
+	}
+}
+
+//openFile close current file,then create a new one beginning with init segment
+func (recorder *Recorder) openFile(init []byte) error {
+	if err := recorder.closeFile(); err != nil {
+		fmt.Printf("Recorder closeFile error:%v\n", err)
+	}
+	path := recorder.segmentPath(time.Now())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("MkdirAll error:%v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Create error:%v", err)
+	}
+	if _, err := file.Write(init); err != nil {
+		file.Close()
+		return fmt.Errorf("Write init error:%v", err)
+	}
+	fmt.Printf("recording %v to %v\n", recorder.ResourcePath, path)
+	recorder.file = file
+	recorder.FilePaths = append(recorder.FilePaths, path)
+	return nil
+}
+
+//writeFragment append fragment to current file
+func (recorder *Recorder) writeFragment(fragment *MP4Fragment) error {
+	if recorder.file == nil {
+		return nil
+	}
+	if _, err := recorder.file.Write(fragment.Data); err != nil {
+		return fmt.Errorf("Write fragment error:%v", err)
+	}
+	return nil
+}
+
+//closeFile close current file
+func (recorder *Recorder) closeFile() error {
+	if recorder.file == nil {
+		return nil
+	}
+	err := recorder.file.Close()
+	recorder.file = nil
+	return err
+}
+
+//StartRecord start recording session if RecordPathTemplate is set
+func (session *PusherPullersSession) StartRecord(resourcePath string) error {
+	if RecordPathTemplate == "" || session.Recorder != nil {
+		return nil
+	}
+	recorder, err := NewRecorder(session, resourcePath)
+	if err != nil {
+		return err
+	}
+	if err := recorder.Start(); err != nil {
+		return err
+	}
+	session.Recorder = recorder
+	return nil
+}
+
+//StopRecord finalize recording of session
+func (session *PusherPullersSession) StopRecord() error {
+	if session.Recorder == nil {
+		return nil
+	}
+	return session.Recorder.Close()
+}
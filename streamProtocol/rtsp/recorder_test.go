@@ -0,0 +1,119 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//countSamples count samples of each track in fragments of mp4 file
+func countSamples(t *testing.T, data []byte) map[uint32]int {
+	counts := make(map[uint32]int)
+	for index := 0; len(data) >= 8; index++ {
+		size := int(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		if size < 8 || size > len(data) {
+			t.Fatalf("box %v size %v invalid", boxType, size)
+		}
+		if index == 0 && boxType != "ftyp" || index == 1 && boxType != "moov" {
+			t.Fatalf("box %v is %v", index, boxType)
+		}
+		if boxType == "moof" {
+			for traf := data[8:size]; len(traf) >= 8; {
+				trafSize := int(binary.BigEndian.Uint32(traf))
+				if string(traf[4:8]) == "traf" {
+					// tfhd is the first box of traf,trun the third
+					trackID := binary.BigEndian.Uint32(traf[20:])
+					tfdtSize := int(binary.BigEndian.Uint32(traf[24:]))
+					counts[trackID] += int(binary.BigEndian.Uint32(traf[24+tfdtSize+12:]))
+				}
+				traf = traf[trafSize:]
+			}
+		}
+		data = data[size:]
+	}
+	return counts
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatalf("TempDir error:%v", err)
+	}
+	defer os.RemoveAll(dir)
+	videoCodec, err := newTrackCodec(&RtpMap{96, "H264", 90000, 1}, map[string]string{})
+	if err != nil {
+		t.Fatalf("newTrackCodec error:%v", err)
+	}
+	audioCodec, err := newTrackCodec(&RtpMap{97, "MPEG4-GENERIC", 44100, 2},
+		map[string]string{"mode": "AAC-hbr", "sizelength": "13",
+			"indexlength": "3", "indexdeltalength": "3", "config": "1210"})
+	if err != nil {
+		t.Fatalf("newTrackCodec error:%v", err)
+	}
+	pps := &PusherPullersSession{Tracks: []*PusherPullersPair{
+		{Index: 0, MediaType: MediaVideo, ClockRate: 90000, codec: videoCodec},
+		{Index: 1, MediaType: MediaAudio, ClockRate: 44100, codec: audioCodec},
+	}}
+	recorder, err := NewRecorder(pps, "/live/test")
+	if err != nil {
+		t.Fatalf("NewRecorder error:%v", err)
+	}
+	recorder.PathTemplate = filepath.Join(dir, "{path}", "{date}-{time}.mp4")
+	recorder.Muxer.SegmentDuration = 2 * time.Second
+	if err := recorder.Start(); err != nil {
+		t.Fatalf("Start error:%v", err)
+	}
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5,
+		0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0,
+		0x3c, 0x60, 0xc6, 0x58}
+	publish := func(pair *PusherPullersPair, frame *Frame) {
+		packets, err := pair.codec.Packetize(frame)
+		if err != nil {
+			t.Fatalf("Packetize error:%v", err)
+		}
+		for _, packet := range packets {
+			pair.depacketize(packet)
+		}
+	}
+	audioFrames := 0
+	for index := 0; index < 150; index++ {
+		// 30 fps,keyframe every second
+		units := [][]byte{{0x41, byte(index)}}
+		if index%30 == 0 {
+			units = [][]byte{sps, {0x68, 0xeb}, {0x65, byte(index)}}
+		}
+		publish(pps.Tracks[0], &Frame{Timestamp: uint32(index * 3000), Units: units})
+		for (audioFrames+1)*1024*30 <= (index+1)*44100 {
+			publish(pps.Tracks[1], &Frame{Timestamp: uint32(audioFrames * 1024),
+				Units: [][]byte{{0x21, byte(audioFrames)}}})
+			audioFrames++
+		}
+	}
+	if err := pps.Tracks[0].AddFrameHandler("other", func(*Frame) {}); err != nil {
+		t.Fatalf("AddFrameHandler error:%v", err)
+	}
+	recorder.Close()
+	if len(recorder.FilePaths) != 3 {
+		t.Fatalf("recorded %v files, want 3", len(recorder.FilePaths))
+	}
+	total := make(map[uint32]int)
+	for _, path := range recorder.FilePaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile error:%v", err)
+		}
+		for trackID, count := range countSamples(t, data) {
+			total[trackID] += count
+		}
+	}
+	if total[1] != 150 || total[2] != audioFrames {
+		t.Errorf("recorded samples = %v, want 150 video and %v audio", total, audioFrames)
+	}
+	if len(pps.Tracks[0].frameHandlers) != 1 {
+		t.Errorf("recorder frame handler not removed")
+	}
+}
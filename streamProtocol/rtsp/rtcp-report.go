@@ -0,0 +1,307 @@
+package rtsp
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+const (
+	//ReportInterval interval of server-generated rtcp reports
+	ReportInterval = 5 * time.Second
+	//ReportCNAME cname in server-generated rtcp reports
+	ReportCNAME = "streamProtocol"
+)
+
+//TransferStats loss and jitter statistics of one rtp-rtcp-session
+type TransferStats struct {
+	PacketsReceived uint32        // packets received from pusher
+	PacketsSent     uint32        // packets sent to puller
+	OctetsSent      uint32        // payload octets sent to puller
+	FractionLost    uint8         // fraction lost in last report interval,in 1/256
+	TotalLost       int32         // cumulative number of packets lost
+	Jitter          uint32        // interarrival jitter in timestamp units
+	RoundTripTime   time.Duration // round trip time from puller's receiver report
+	PacketsDropped  uint32        // packets dropped for slow puller,see PullerDropPolicy
+}
+
+//Stats get statistics of this session,for pusher it's measured by server,
+//for puller it's from the puller's receiver reports
+func (session *RtpRtcpSession) Stats() TransferStats {
+	session.statsMutex.Lock()
+	defer session.statsMutex.Unlock()
+	stats := session.stats
+	stats.PacketsSent = atomic.LoadUint32(&session.packetsSent)
+	stats.OctetsSent = atomic.LoadUint32(&session.octetsSent)
+	stats.PacketsDropped = session.rtpQueue.Dropped()
+	return stats
+}
+
+//countSent count a packet sent to puller for sender reports
+func (session *RtpRtcpSession) countSent(packet *rtp.Packet) {
+	atomic.AddUint32(&session.packetsSent, 1)
+	atomic.AddUint32(&session.octetsSent, uint32(len(packet.Payload)))
+}
+
+//countReceived count a packet received from pusher for receiver reports
+func (session *RtpRtcpSession) countReceived(packet *rtp.Packet, arrival time.Time) {
+	session.touch(arrival)
+	if session.ReceptionStats == nil {
+		return
+	}
+	session.ReceptionStats.UpdatePacket(packet.SSRC,
+		packet.SequenceNumber, packet.Timestamp, arrival)
+	session.statsMutex.Lock()
+	session.stats.PacketsReceived++
+	session.statsMutex.Unlock()
+}
+
+//receiverReport make compound rtcp(RR+SDES) for pusher
+func (session *RtpRtcpSession) receiverReport(now time.Time) ([]byte, error) {
+	if session.SSRC == 0 {
+		session.SSRC = rand.Uint32()
+	}
+	receiverReport := &rtcp.ReceiverReport{SSRC: session.SSRC}
+	if session.ReceptionStats != nil {
+		if report, ok := session.ReceptionStats.Report(now); ok {
+			receiverReport.Reports = append(receiverReport.Reports, report)
+			session.statsMutex.Lock()
+			session.stats.FractionLost = report.FractionLost
+			session.stats.TotalLost = report.TotalLost
+			session.stats.Jitter = report.Jitter
+			session.statsMutex.Unlock()
+		}
+	}
+	return rtcp.Marshal([]rtcp.Packet{
+		receiverReport, rtcp.NewCNAME(session.SSRC, ReportCNAME)})
+}
+
+//WriteRtcpToPusher send rtcp package to pusher
+func (session *RtpRtcpSession) WriteRtcpToPusher(data []byte) error {
+	if session.Transport != nil {
+		return session.Transport.WritePackage(RtcpPackage, data)
+	}
+	if session.Interleaved != nil {
+		return session.Interleaved.WriteFrame(session.RtcpChannel, data)
+	}
+	session.statsMutex.Lock()
+	addr := session.rtcpPusherAddr
+	session.statsMutex.Unlock()
+	if addr == nil || session.RtcpUDPConnToPusher == nil {
+		return fmt.Errorf("WriteRtcpToPusher error: pusher's rtcp address unknown")
+	}
+	data, err := session.secure.protect(RtcpPackage, data)
+	if err != nil {
+		return err
+	}
+	_, err = session.RtcpUDPConnToPusher.WriteToUDP(data, addr)
+	return err
+}
+
+//receivePullerRtcp process rtcp from puller,
+//keep receiver reports and pass feedback to pusher
+func (session *RtpRtcpSession) receivePullerRtcp(data []byte, arrival time.Time) error {
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("invalid rtcp package from puller:%v", err)
+	}
+	session.touch(arrival)
+	feedback := make([]rtcp.Packet, 0)
+	for _, packet := range packets {
+		switch packet := packet.(type) {
+		case *rtcp.ReceiverReport:
+			for _, report := range packet.Reports {
+				session.updateReceptionReport(report, arrival)
+			}
+		case *rtcp.SenderReport:
+			for _, report := range packet.Reports {
+				session.updateReceptionReport(report, arrival)
+			}
+		case *rtcp.TransportLayerFeedback, *rtcp.PayloadSpecificFeedback:
+			feedback = append(feedback, packet)
+		}
+	}
+	if len(feedback) > 0 && session.feedbackHandler != nil {
+		session.feedbackHandler(feedback)
+	}
+	return nil
+}
+
+//updateReceptionReport keep loss,jitter and rtt from puller's reception report
+func (session *RtpRtcpSession) updateReceptionReport(
+	report rtcp.ReceptionReport, arrival time.Time) {
+	session.statsMutex.Lock()
+	defer session.statsMutex.Unlock()
+	session.stats.FractionLost = report.FractionLost
+	session.stats.TotalLost = report.TotalLost
+	session.stats.Jitter = report.Jitter
+	if report.LastSenderReport != 0 {
+		// rtt = arrival - lsr - dlsr,all in 1/65536 seconds
+		rtt := ntpMiddle(arrival) - report.LastSenderReport - report.Delay
+		session.stats.RoundTripTime = time.Duration(rtt) * time.Second / 65536
+	}
+}
+
+//ntpMiddle the middle 32 bits of ntp timestamp of t
+func ntpMiddle(t time.Time) uint32 {
+	return rtcp.NTPMiddle(rtcp.NTPTime(t))
+}
+
+//updateSenderReport keep pusher's sender report for ntp/rtp timestamp mapping
+func (session *PusherPullersPair) updateSenderReport(
+	report *rtcp.SenderReport, arrival time.Time) {
+	session.reportMutex.Lock()
+	session.lastSenderReport = report
+	session.lastSenderReportTime = arrival
+	session.reportMutex.Unlock()
+	if session.Pusher != nil && session.Pusher.ReceptionStats != nil {
+		session.Pusher.ReceptionStats.UpdateSenderReport(report.NTPTime, arrival)
+	}
+}
+
+//updateRtpTime keep the latest rtp timestamp from pusher,
+//used for ntp/rtp mapping before pusher's sender report arrived
+func (session *PusherPullersPair) updateRtpTime(packet *rtp.Packet, arrival time.Time) {
+	session.reportMutex.Lock()
+	session.lastSSRC = packet.SSRC
+	session.lastRtpTimestamp = packet.Timestamp
+	session.lastRtpTime = arrival
+	session.reportMutex.Unlock()
+}
+
+//senderReport make compound rtcp(SR+SDES) for puller,
+//ntp/rtp mapping is extrapolated from pusher's latest sender report,
+//cname is the pusher's one if it has sent sdes
+func (session *PusherPullersPair) senderReport(
+	puller *RtpRtcpSession, now time.Time) ([]byte, error) {
+	session.reportMutex.Lock()
+	defer session.reportMutex.Unlock()
+	if session.lastRtpTime.IsZero() {
+		return nil, fmt.Errorf("no rtp package from pusher yet")
+	}
+	var (
+		ntpTime  time.Time
+		rtpTime  uint32
+		baseTime time.Time
+	)
+	if session.lastSenderReport != nil {
+		ntpTime = rtcp.NTPToTime(session.lastSenderReport.NTPTime)
+		rtpTime = session.lastSenderReport.RTPTime
+		baseTime = session.lastSenderReportTime
+	} else {
+		rtpTime = session.lastRtpTimestamp
+		baseTime = session.lastRtpTime
+		ntpTime = baseTime
+	}
+	elapsed := now.Sub(baseTime)
+	ntpTime = ntpTime.Add(elapsed)
+	rtpTime += uint32(int64(elapsed) * int64(session.ClockRate) / int64(time.Second))
+	stats := puller.Stats()
+	senderReport := &rtcp.SenderReport{
+		SSRC:        session.lastSSRC,
+		NTPTime:     rtcp.NTPTime(ntpTime),
+		RTPTime:     rtpTime,
+		PacketCount: stats.PacketsSent,
+		OctetCount:  stats.OctetsSent,
+	}
+	cname := session.lastCNAME
+	if cname == "" {
+		cname = ReportCNAME
+	}
+	return rtcp.Marshal([]rtcp.Packet{
+		senderReport, rtcp.NewCNAME(session.lastSSRC, cname)})
+}
+
+//forwardFeedback send pullers' feedback(nack,pli...) to pusher
+func (session *PusherPullersPair) forwardFeedback(packets []rtcp.Packet) {
+	if session.Pusher == nil {
+		return
+	}
+	data, err := rtcp.Marshal(packets)
+	if err != nil {
+		fmt.Printf("forwardFeedback marshal error = %v\n", err)
+		return
+	}
+	if err := session.Pusher.WriteRtcpToPusher(data); err != nil {
+		fmt.Printf("forwardFeedback error = %v\n", err)
+	}
+}
+
+/*
+filterPusherRtcp process rtcp from pusher,keep sender report and cname of sdes
+for sender reports to pullers,return the packets should be forwarded to
+pullers(bye,app),they follow an empty receiver report of pusher's ssrc
+as compound rtcp must start with a report(rfc3550 6.1)
+*/
+func (session *PusherPullersPair) filterPusherRtcp(
+	data RtpRtcpPackage, arrival time.Time) (RtpRtcpPackage, error) {
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rtcp package from pusher:%v", err)
+	}
+	session.reportMutex.Lock()
+	source := session.lastSSRC
+	session.reportMutex.Unlock()
+	forward := make([]rtcp.Packet, 1, len(packets)+1)
+	for _, packet := range packets {
+		switch packet := packet.(type) {
+		case *rtcp.SenderReport:
+			source = packet.SSRC
+			session.updateSenderReport(packet, arrival)
+		case *rtcp.SourceDescription:
+			// sent again with pusher's cname in sender reports
+			session.updateCNAME(packet)
+		case *rtcp.ReceiverReport, *rtcp.TransportLayerFeedback,
+			*rtcp.PayloadSpecificFeedback:
+			// reports and feedback about server's stream,not for pullers
+		default:
+			forward = append(forward, packet)
+		}
+	}
+	if len(forward) == 1 {
+		return nil, nil
+	}
+	forward[0] = &rtcp.ReceiverReport{SSRC: source}
+	return rtcp.Marshal(forward)
+}
+
+//updateCNAME keep the first cname in pusher's sdes
+func (session *PusherPullersPair) updateCNAME(description *rtcp.SourceDescription) {
+	for _, chunk := range description.Chunks {
+		for _, item := range chunk.Items {
+			if item.Type == rtcp.SDESCNAME && item.Text != "" {
+				session.reportMutex.Lock()
+				session.lastCNAME = item.Text
+				session.reportMutex.Unlock()
+				return
+			}
+		}
+	}
+}
+
+//sendReports send receiver report to pusher and sender reports to pullers
+func (session *PusherPullersPair) sendReports(now time.Time) {
+	if session.Pusher != nil && session.Pusher.Playing() {
+		if data, err := session.Pusher.receiverReport(now); err != nil {
+			fmt.Printf("receiverReport error = %v\n", err)
+		} else if err := session.Pusher.WriteRtcpToPusher(data); err != nil {
+			fmt.Printf("send receiver report error = %v\n", err)
+		}
+	}
+	for _, puller := range session.pullers() {
+		if puller.multicast != nil || !puller.Playing() {
+			continue
+		}
+		data, err := session.senderReport(puller, now)
+		if err != nil {
+			continue
+		}
+		if err := puller.writeToPuller(RtcpPackage, data); err != nil {
+			fmt.Printf("send sender report error = %v\n", err)
+		}
+	}
+}
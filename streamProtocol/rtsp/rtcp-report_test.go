@@ -0,0 +1,55 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestSenderReportMapping(t *testing.T) {
+	pair := &PusherPullersPair{ClockRate: 90000}
+	now := time.Now()
+	pusherNTP := now.Add(-time.Hour)
+	data, _ := rtcp.Marshal([]rtcp.Packet{
+		&rtcp.SenderReport{SSRC: 7, NTPTime: rtcp.NTPTime(pusherNTP), RTPTime: 1000},
+		rtcp.NewCNAME(7, "camera"),
+		&rtcp.Goodbye{Sources: []uint32{7}},
+	})
+	forward, err := pair.filterPusherRtcp(data, now)
+	if err != nil {
+		t.Fatalf("filterPusherRtcp error:%v", err)
+	}
+	packets, err := rtcp.Unmarshal(forward)
+	if err != nil || len(packets) != 2 {
+		t.Fatalf("forwarded packets = %v,%v, want receiver report and bye", packets, err)
+	}
+	if report, ok := packets[0].(*rtcp.ReceiverReport); !ok || report.SSRC != 7 {
+		t.Errorf("first forwarded packet = %+v, want receiver report of pusher", packets[0])
+	}
+	if _, ok := packets[1].(*rtcp.Goodbye); !ok {
+		t.Errorf("forwarded packet = %T, want bye", packets[1])
+	}
+	pair.updateRtpTime(&rtp.Packet{Header: rtp.Header{SSRC: 7, Timestamp: 1000}}, now)
+	puller := &RtpRtcpSession{}
+	puller.countSent(&rtp.Packet{Payload: make([]byte, 100)})
+	report, err := pair.senderReport(puller, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("senderReport error:%v", err)
+	}
+	packets, _ = rtcp.Unmarshal(report)
+	senderReport := packets[0].(*rtcp.SenderReport)
+	if senderReport.SSRC != 7 || senderReport.RTPTime != 91000 ||
+		senderReport.PacketCount != 1 || senderReport.OctetCount != 100 {
+		t.Errorf("sender report = %+v", senderReport)
+	}
+	ntp, want := rtcp.NTPToTime(senderReport.NTPTime), pusherNTP.Add(time.Second)
+	if ntp.Sub(want) > time.Millisecond || want.Sub(ntp) > time.Millisecond {
+		t.Errorf("sender report ntp = %v, want %v", ntp, want)
+	}
+	description, ok := packets[1].(*rtcp.SourceDescription)
+	if !ok || description.Chunks[0].Source != 7 || description.Chunks[0].Items[0].Text != "camera" {
+		t.Errorf("sender report sdes = %+v, want pusher's cname", packets[1])
+	}
+}
@@ -1,10 +1,15 @@
 package rtsp
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"regexp"
+	"sync"
 	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
 )
 
 //MediaType the media type of this session
@@ -15,8 +20,22 @@ const (
 	MediaVideo MediaType = 0
 	//MediaAudio stand for audio
 	MediaAudio MediaType = 1
+	//MediaApplication stand for application data,like onvif metadata or klv
+	MediaApplication MediaType = 2
 )
 
+//String media type name as in sdp media description
+func (mediaType MediaType) String() string {
+	switch mediaType {
+	case MediaVideo:
+		return "video"
+	case MediaAudio:
+		return "audio"
+	default:
+		return "application"
+	}
+}
+
 //ClientType rtsp client type(remote end point of connection)func (session *RtpRtcpSession)
 type ClientType int
 
@@ -29,21 +48,51 @@ const (
 
 //RtpRtcpSession a pair of rtp-rtcp sessions
 type RtpRtcpSession struct {
-	RtspSessionID       string               // identification of rtsp session
-	RtpUDPConnToPuller  *net.UDPConn         // rtp udp connection to puller
-	RtcpUDPConnToPuller *net.UDPConn         // rtcp udp connection to puller
-	RtpUDPConnToPusher  *net.UDPConn         // rtp udp connection to pusher
-	RtcpUDPConnToPusher *net.UDPConn         // rtcp udp connection to pusher
-	RtpServerPort       *string              // rtp Server port in udp session
-	RtcpServerPort      *string              // rtcp Server port in udp session
-	SessionMediaType    MediaType            // this session's media type
-	SessionClientType   ClientType           // this session's client type(connected to pusher or puller)
-	RtpPackageChannel   chan *RtpRtcpPackage // rtp packages for puller
-	RtcpPackageChannel  chan *RtpRtcpPackage // rtcp packages for puller
-	IfStop              bool                 // if stop is true,then stop go routines created by this session
-	IfPause             bool                 // if pause transfer
+	RtspSessionID       string                      // identification of rtsp session
+	RtpUDPConnToPuller  *net.UDPConn                // rtp udp connection to puller
+	RtcpUDPConnToPuller *net.UDPConn                // rtcp udp connection to puller
+	RtpUDPConnToPusher  *net.UDPConn                // rtp udp connection to pusher
+	RtcpUDPConnToPusher *net.UDPConn                // rtcp udp connection to pusher
+	RtpServerPort       *string                     // rtp Server port in udp session
+	RtcpServerPort      *string                     // rtcp Server port in udp session
+	SessionMediaType    MediaType                   // this session's media type
+	SessionClientType   ClientType                  // this session's client type(connected to pusher or puller)
+	RtcpPackageChannel  chan *RtpRtcpPackage        // rtcp packages for puller
+	Interleaved         *InterleavedConn            // rtsp tcp connection if rtp/rtcp interleaved,nil if udp
+	Transport           PackageTransport            // transport of puller or publisher other than udp and rtsp tcp connection
+	RtpChannel          int                         // rtp channel in interleaved mode
+	RtcpChannel         int                         // rtcp channel in interleaved mode
+	SSRC                uint32                      // server's ssrc in rtcp reports to pusher
+	ReceptionStats      *rtcp.ReceptionStatistics   // statistics of rtp from pusher,nil for puller
+	rtpPusherChan       chan *rtp.Packet            // rtp packages from interleaved pusher
+	rtcpPusherChan      chan RtpRtcpPackage         // rtcp packages from interleaved pusher
+	rtcpPusherAddr      *net.UDPAddr                // pusher's rtcp address learned from its packages
+	feedbackHandler     func(packets []rtcp.Packet) // handle feedback from puller
+	stats               TransferStats               // statistics for Stats()
+	statsMutex          sync.Mutex                  // provide stats's atom
+	packetsSent         uint32                      // packets sent to puller
+	octetsSent          uint32                      // payload octets sent to puller
+	lastActive          int64                       // unix nano time of the last rtp from pusher or rtcp from puller
+	secure              *srtpContexts               // srtp of udp media,nil if RTP/AVP
+	multicast           *multicastGroup             // group of multicast puller,nil if unicast
+	replayGop           bool                        // if gop cache is to be sent before the next live package
+	state               transferState               // idle,playing or paused
+	stateMutex          sync.Mutex                  // provide state,replayGop and ctx's atom
+	ctx                 context.Context             // canceled by StopTransfer,see lifecycle
+	cancel              context.CancelFunc          // cancel of ctx
+	rtpQueue            *pullerQueue                // rtp packages for puller
+	disconnectHandler   func()                      // close connection of puller dropping too many packages
 }
 
+//transferState state of transfer driven by BeginTransfer and PauseTransfer
+type transferState int
+
+const (
+	transferIdle    transferState = 0 // BeginTransfer not called
+	transferPlaying transferState = 1 // packages are transfered
+	transferPaused  transferState = 2 // packages are discarded
+)
+
 //PackageType package type
 type PackageType int
 
@@ -67,6 +116,12 @@ type PullerClientInfo struct {
 	RtpRemotePort, RtcpRemotePort, IPRemote *string
 }
 
+//InterleavedInfo the info to transfer rtp/rtcp over rtsp tcp connection
+type InterleavedInfo struct {
+	Conn                    *InterleavedConn
+	RtpChannel, RtcpChannel int
+}
+
 //StartRtpRtcpSession Start a pair of rtp-rtcp sessions
 func (session *RtpRtcpSession) StartRtpRtcpSession(
 	clientType ClientType, mediaType MediaType,
@@ -78,8 +133,13 @@ func (session *RtpRtcpSession) StartRtpRtcpSession(
 		return fmt.Errorf("rtsp session id is empty")
 	}
 	session.RtspSessionID = rtspSessionID
-	switch clientType {
-	case PusherClient:
+	switch {
+	case session.Interleaved != nil && clientType == PusherClient:
+		// rtp/rtcp packages come from rtsp tcp connection,no udp server needed
+	case (session.Interleaved != nil || session.Transport != nil) && clientType == PullerClient:
+		session.rtpQueue = newPullerQueue(PullerQueueSize, PullerDropPolicy, PullerMaxDrops)
+		session.RtcpPackageChannel = make(chan *RtpRtcpPackage, PullChannelBufferSize)
+	case clientType == PusherClient:
 		session.RtpUDPConnToPusher, session.RtpServerPort, err =
 			session.startUDPServer()
 		if err != nil {
@@ -90,7 +150,7 @@ func (session *RtpRtcpSession) StartRtpRtcpSession(
 		if err != nil {
 			return fmt.Errorf("startUDPServer failed : %v", err)
 		}
-	case PullerClient:
+	case clientType == PullerClient:
 		if pullerClientInfo == nil {
 			return fmt.Errorf("StartRtpRtcpSession :pullerClientInfo is nil")
 		}
@@ -104,7 +164,7 @@ func (session *RtpRtcpSession) StartRtpRtcpSession(
 		if err != nil {
 			return fmt.Errorf("startUDPClient failed : %v", err)
 		}
-		session.RtpPackageChannel = make(chan *RtpRtcpPackage, PullChannelBufferSize)
+		session.rtpQueue = newPullerQueue(PullerQueueSize, PullerDropPolicy, PullerMaxDrops)
 		session.RtcpPackageChannel = make(chan *RtpRtcpPackage, PullChannelBufferSize)
 	default:
 		return fmt.Errorf("clientType error,not support")
@@ -160,13 +220,14 @@ func (session *RtpRtcpSession) startUDPClient(ip, port *string) (*net.UDPConn, e
 	return udpConnection, nil
 }
 
-//BeginTransfer begin recieving packages from pusher,then push into channel
-func (session *RtpRtcpSession) BeginTransfer(rtpChan, rtcpChan chan RtpRtcpPackage) error {
-	if session.IfPause {
-		session.IfPause = false
+//BeginTransfer begin recieving packages from pusher,then push into channel,
+//or resume a paused transfer
+func (session *RtpRtcpSession) BeginTransfer(
+	rtpChan chan *rtp.Packet, rtcpChan chan RtpRtcpPackage) error {
+	if session.Stopped() {
 		return nil
 	}
-	if session.SessionClientType == PusherClient &&
+	if session.SessionClientType == PusherClient && session.multicast == nil &&
 		(rtpChan == nil || rtcpChan == nil) {
 		return fmt.Errorf(
 			"BeginTransfer failed,PusherClient input channel arg have nil")
@@ -176,97 +237,314 @@ func (session *RtpRtcpSession) BeginTransfer(rtpChan, rtcpChan chan RtpRtcpPacka
 		return fmt.Errorf(
 			"BeginTransfer failed,PullerClient input channel arg not all nil")
 	}
-	var mediaName string
-	if session.SessionMediaType == MediaVideo {
-		mediaName = "video"
-	} else if session.SessionMediaType == MediaAudio {
-		mediaName = "audio"
+	session.stateMutex.Lock()
+	previous := session.state
+	session.state = transferPlaying
+	if previous == transferIdle {
+		session.replayGop = session.SessionClientType == PullerClient
+	}
+	session.stateMutex.Unlock()
+	if session.multicast != nil {
+		// packages are sent by the sender of group
+		return session.multicast.update()
 	}
-	if session.SessionClientType == PusherClient {
+	if previous != transferIdle {
+		return nil
+	}
+	mediaName := session.SessionMediaType.String()
+	done := session.Done()
+	if session.SessionClientType == PusherClient && session.Interleaved != nil {
+		// packages come from rtsp tcp connection,see ReceiveInterleaved
+		session.rtpPusherChan = rtpChan
+		session.rtcpPusherChan = rtcpChan
+	} else if session.SessionClientType == PusherClient && session.RtpUDPConnToPusher != nil {
 		go func() {
 			var num int = 0
 			data := make([]byte, ReadBufferSize)
-			for !session.IfStop {
-				for session.IfPause {
-					time.Sleep(time.Duration(10) * time.Millisecond)
+			for {
+				number, _, err := session.RtpUDPConnToPusher.ReadFromUDP(data)
+				if err != nil {
+					session.printError("error occured when read from pusher = %v\n", err)
+					return
 				}
-				if number, _, err := session.RtpUDPConnToPusher.ReadFromUDP(data); err == nil {
-					buf := make([]byte, number)
-					copy(buf, data)
-					rtpChan <- buf
-					num++
-					fmt.Println(mediaName, "rtp pusher recieved data number =", num)
-				} else {
-					fmt.Printf("error occured when read from pusher = %v\n", err)
+				if !session.Playing() {
+					// packages are discarded while paused
+					continue
+				}
+				buf, err := session.secure.unprotect(RtpPackage, data[:number])
+				if err != nil {
+					fmt.Printf("invalid srtp package from pusher = %v\n", err)
+					continue
+				}
+				buf = append([]byte(nil), buf...)
+				packet := new(rtp.Packet)
+				if err := packet.Unmarshal(buf); err != nil {
+					fmt.Printf("invalid rtp package from pusher = %v\n", err)
+					continue
+				}
+				select {
+				case rtpChan <- packet:
+				case <-done:
 					return
 				}
+				num++
+				fmt.Println(mediaName, "rtp pusher recieved data number =", num)
 			}
 		}()
 		go func() {
 			var num int = 0
 			data := make([]byte, ReadBufferSize)
-			for !session.IfStop {
-				for session.IfPause {
-					time.Sleep(time.Duration(10) * time.Millisecond)
+			for {
+				number, addr, err := session.RtcpUDPConnToPusher.ReadFromUDP(data)
+				if err != nil {
+					session.printError("error occured when read from pusher = %v\n", err)
+					return
 				}
-				if number, _, err := session.RtcpUDPConnToPusher.ReadFromUDP(data); err == nil {
-					buf := make([]byte, number)
-					copy(buf, data)
-					rtcpChan <- buf
-					num++
-					fmt.Println(mediaName, "rtcp pusher recieved data number =", num)
-				} else {
-					fmt.Printf("error occured when read from pusher = %v\n", err)
+				if !session.Playing() {
+					continue
+				}
+				session.statsMutex.Lock()
+				session.rtcpPusherAddr = addr
+				session.statsMutex.Unlock()
+				buf, err := session.secure.unprotect(RtcpPackage, data[:number])
+				if err != nil {
+					fmt.Printf("invalid srtcp package from pusher = %v\n", err)
+					continue
+				}
+				select {
+				case rtcpChan <- append([]byte(nil), buf...):
+				case <-done:
 					return
 				}
+				num++
+				fmt.Println(mediaName, "rtcp pusher recieved data number =", num)
 			}
 		}()
 	}
 	if session.SessionClientType == PullerClient {
 		go func() {
 			var num int = 0
-			for !session.IfStop {
-				for session.IfPause {
-					time.Sleep(time.Duration(10) * time.Millisecond)
+			for {
+				packet := session.rtpQueue.pop()
+				if packet == nil {
+					// queue closed by StopTransfer
+					return
+				}
+				if !session.Playing() {
+					// queued before PauseTransfer
+					continue
+				}
+				data, err := packet.Bytes()
+				if err != nil {
+					fmt.Printf("error occured when marshal rtp package = %v\n", err)
+					continue
 				}
-				data := <-session.RtpPackageChannel
-				if _, err := session.RtpUDPConnToPuller.Write(*data); err != nil {
-					fmt.Printf("error occured when write to puller = %v\n", err)
+				if err := session.writeToPuller(RtpPackage, data); err != nil {
+					session.printError("error occured when write to puller = %v\n", err)
 					return
 				}
-				time.Sleep(time.Duration(10) * time.Millisecond)
+				session.countSent(packet)
 				num++
 				fmt.Println(mediaName, "rtp puller sended data number =", num)
 			}
 		}()
 		go func() {
 			var num int = 0
-			for !session.IfStop {
-				for session.IfPause {
-					time.Sleep(time.Duration(10) * time.Millisecond)
-				}
-				data := <-session.RtcpPackageChannel
-				if _, err := session.RtcpUDPConnToPuller.Write(*data); err != nil {
-					fmt.Printf("error occured when write to puller error = %v\n", err)
+			for {
+				select {
+				case data := <-session.RtcpPackageChannel:
+					if !session.Playing() {
+						continue
+					}
+					if err := session.writeToPuller(RtcpPackage, *data); err != nil {
+						session.printError("error occured when write to puller error = %v\n", err)
+						return
+					}
+					num++
+					fmt.Println(mediaName, "rctp puller sended data number =", num)
+				case <-done:
 					return
 				}
-				time.Sleep(time.Duration(40) * time.Millisecond)
-				num++
-				fmt.Println(mediaName, "rctp puller sended data number =", num)
 			}
 		}()
+		if session.Interleaved == nil && session.Transport == nil {
+			// receiver reports and feedback from puller
+			go func() {
+				data := make([]byte, ReadBufferSize)
+				for {
+					number, err := session.RtcpUDPConnToPuller.Read(data)
+					if err != nil {
+						session.printError("error occured when read from puller = %v\n", err)
+						return
+					}
+					buf, err := session.secure.unprotect(RtcpPackage, data[:number])
+					if err != nil {
+						fmt.Printf("invalid srtcp package from puller = %v\n", err)
+						continue
+					}
+					if err := session.receivePullerRtcp(buf, time.Now()); err != nil {
+						fmt.Println(err)
+					}
+				}
+			}()
+		}
+	}
+	return nil
+}
+
+//writeToPuller write package to puller by its transport,
+//rtsp tcp connection or udp
+func (session *RtpRtcpSession) writeToPuller(packageType PackageType, data []byte) error {
+	channel, udpConn := session.RtpChannel, session.RtpUDPConnToPuller
+	if packageType == RtcpPackage {
+		channel, udpConn = session.RtcpChannel, session.RtcpUDPConnToPuller
+	}
+	switch {
+	case session.Transport != nil:
+		return session.Transport.WritePackage(packageType, data)
+	case session.Interleaved != nil:
+		return session.Interleaved.WriteFrame(channel, data)
+	}
+	data, err := session.secure.protect(packageType, data)
+	if err != nil {
+		return err
 	}
+	_, err = udpConn.Write(data)
+	return err
+}
+
+//EnableSrtp encrypt udp media sent by local key and decrypt media received
+//by remote key,remote key may be nil if unknown,it's called before BeginTransfer
+func (session *RtpRtcpSession) EnableSrtp(localKey, remoteKey []byte) error {
+	contexts, err := newSrtpContexts(localKey, remoteKey)
+	if err != nil {
+		return fmt.Errorf("EnableSrtp error:%v", err)
+	}
+	session.secure = contexts
 	return nil
 }
 
-//PauseTransfer pause this transfer
+//ReceiveInterleaved receive a package from rtsp tcp connection
+//by the channel number of interleaved frame
+func (session *RtpRtcpSession) ReceiveInterleaved(channel int, data []byte) error {
+	if session.SessionClientType != PusherClient {
+		if channel == session.RtcpChannel {
+			return session.receivePullerRtcp(data, time.Now())
+		}
+		return nil
+	}
+	if !session.Playing() {
+		return nil
+	}
+	switch channel {
+	case session.RtpChannel:
+		if session.rtpPusherChan != nil {
+			packet := new(rtp.Packet)
+			if err := packet.Unmarshal(data); err != nil {
+				return fmt.Errorf("ReceiveInterleaved error: invalid rtp package:%v", err)
+			}
+			select {
+			case session.rtpPusherChan <- packet:
+			case <-session.Done():
+			}
+		}
+	case session.RtcpChannel:
+		if session.rtcpPusherChan != nil {
+			select {
+			case session.rtcpPusherChan <- data:
+			case <-session.Done():
+			}
+		}
+	default:
+		return fmt.Errorf("ReceiveInterleaved error: channel %v not belong to this session", channel)
+	}
+	return nil
+}
+
+//PauseTransfer pause this transfer,queued packages are dropped
 func (session *RtpRtcpSession) PauseTransfer() error {
-	session.IfPause = true
+	session.stateMutex.Lock()
+	if session.state == transferPlaying {
+		session.state = transferPaused
+	}
+	session.stateMutex.Unlock()
+	session.rtpQueue.clear()
+	if session.multicast != nil {
+		return session.multicast.update()
+	}
 	return nil
 }
 
-//StopTransfer stop this transfer
+//StopTransfer stop this transfer,goroutines of this session return
+//and its udp connections are closed
 func (session *RtpRtcpSession) StopTransfer() error {
-	session.IfStop = true
+	session.lifecycle()
+	session.stateMutex.Lock()
+	stopped := session.ctx.Err() != nil
+	session.cancel()
+	session.stateMutex.Unlock()
+	if stopped {
+		return nil
+	}
+	session.rtpQueue.close()
+	for _, conn := range []*net.UDPConn{
+		session.RtpUDPConnToPuller, session.RtcpUDPConnToPuller,
+		session.RtpUDPConnToPusher, session.RtcpUDPConnToPusher} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	if session.multicast != nil {
+		return session.multicast.leave(session)
+	}
 	return nil
 }
+
+//lifecycle context of this session canceled by StopTransfer,made on first use
+func (session *RtpRtcpSession) lifecycle() context.Context {
+	session.stateMutex.Lock()
+	defer session.stateMutex.Unlock()
+	if session.ctx == nil {
+		session.ctx, session.cancel = context.WithCancel(context.Background())
+	}
+	return session.ctx
+}
+
+//Done closed when this session is stopped
+func (session *RtpRtcpSession) Done() <-chan struct{} {
+	return session.lifecycle().Done()
+}
+
+//Stopped if StopTransfer called
+func (session *RtpRtcpSession) Stopped() bool {
+	return session.lifecycle().Err() != nil
+}
+
+//Playing if transfer is begun,and not paused or stopped
+func (session *RtpRtcpSession) Playing() bool {
+	ctx := session.lifecycle()
+	session.stateMutex.Lock()
+	defer session.stateMutex.Unlock()
+	return session.state == transferPlaying && ctx.Err() == nil
+}
+
+//deliverable if dispatcher should queue packages for this puller,
+//replay is if the gop cache should be sent before the package
+func (session *RtpRtcpSession) deliverable() (ok, replay bool) {
+	if session.multicast != nil || !session.Playing() {
+		// multicast pullers receive packages from the sender of group
+		return false, false
+	}
+	session.stateMutex.Lock()
+	defer session.stateMutex.Unlock()
+	replay = session.replayGop
+	session.replayGop = false
+	return true, replay
+}
+
+//printError print error of goroutine,errors caused by StopTransfer are expected
+func (session *RtpRtcpSession) printError(format string, err error) {
+	if !session.Stopped() {
+		fmt.Printf(format, err)
+	}
+}
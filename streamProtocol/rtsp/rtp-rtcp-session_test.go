@@ -0,0 +1,86 @@
+package rtsp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+func TestTransferLifecycle(t *testing.T) {
+	pusher := new(RtpRtcpSession)
+	if err := pusher.StartRtpRtcpSession(PusherClient, MediaVideo, nil, "lifecycle"); err != nil {
+		t.Fatalf("StartRtpRtcpSession error:%v", err)
+	}
+	rtpChan := make(chan *rtp.Packet, 10)
+	if err := pusher.BeginTransfer(rtpChan, make(chan RtpRtcpPackage, 10)); err != nil {
+		t.Fatalf("BeginTransfer error:%v", err)
+	}
+	conn, err := net.Dial("udp", "127.0.0.1:"+*pusher.RtpServerPort)
+	if err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer conn.Close()
+	push := func(sequence uint16) {
+		data, _ := (&rtp.Packet{
+			Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: sequence},
+		}).Marshal()
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("Write error:%v", err)
+		}
+	}
+	expect := func(sequence uint16) {
+		select {
+		case packet := <-rtpChan:
+			if packet.SequenceNumber != sequence {
+				t.Fatalf("received package %v, want %v", packet.SequenceNumber, sequence)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("package %v not received", sequence)
+		}
+	}
+
+	push(1)
+	expect(1)
+	if err := pusher.PauseTransfer(); err != nil {
+		t.Fatalf("PauseTransfer error:%v", err)
+	}
+	push(2)
+	select {
+	case packet := <-rtpChan:
+		t.Fatalf("package %v received while paused", packet.SequenceNumber)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := pusher.BeginTransfer(rtpChan, nil); err == nil {
+		t.Errorf("BeginTransfer without rtcp channel succeeded")
+	}
+	if err := pusher.BeginTransfer(rtpChan, make(chan RtpRtcpPackage)); err != nil {
+		t.Fatalf("BeginTransfer error:%v", err)
+	}
+	push(3)
+	expect(3)
+
+	port := *pusher.RtpServerPort
+	if err := pusher.StopTransfer(); err != nil {
+		t.Fatalf("StopTransfer error:%v", err)
+	}
+	select {
+	case <-pusher.Done():
+	default:
+		t.Fatalf("Done not closed by StopTransfer")
+	}
+	if pusher.Playing() {
+		t.Errorf("stopped session is playing")
+	}
+	// udp ports are released at once
+	addr, _ := net.ResolveUDPAddr("udp", ":"+port)
+	released, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("port %v not released:%v", port, err)
+	}
+	released.Close()
+	if err := pusher.StopTransfer(); err != nil {
+		t.Errorf("second StopTransfer error:%v", err)
+	}
+}
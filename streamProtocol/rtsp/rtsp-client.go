@@ -0,0 +1,628 @@
+package rtsp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gortc.io/sdp"
+)
+
+//ClientTransport the transport of rtp/rtcp between client and server
+type ClientTransport int
+
+const (
+	//TransportUDP rtp/rtcp over udp
+	TransportUDP ClientTransport = 0
+	//TransportTCP rtp/rtcp interleaved in rtsp tcp connection
+	TransportTCP ClientTransport = 1
+	//TransportMulticast rtp/rtcp from multicast groups chosen by server,only for pulling
+	TransportMulticast ClientTransport = 2
+)
+
+//DefaultClientTimeout default timeout for waiting rtsp response
+const DefaultClientTimeout = 10 * time.Second
+
+//ClientTrack one media track of client's stream
+type ClientTrack struct {
+	Media           sdp.Media     // media description from sdp
+	Control         string        // absolute control url for SETUP
+	RtpChannel      int           // rtp channel in interleaved mode
+	RtcpChannel     int           // rtcp channel in interleaved mode
+	RtpUDPConn      *net.UDPConn  // local rtp udp connection
+	RtcpUDPConn     *net.UDPConn  // local rtcp udp connection
+	RtpServerAddr   *net.UDPAddr  // server's rtp address,for pushing
+	RtcpServerAddr  *net.UDPAddr  // server's rtcp address,for pushing
+	CryptoKey       []byte        // srtp key encrypting packages sent,RTP/SAVP is used over udp if not nil
+	ServerCryptoKey []byte        // srtp key of server decrypting packages received,nil if not answered
+	secure          *srtpContexts // srtp of udp media,nil if RTP/AVP
+}
+
+//Client rtsp client for pulling streams from and pushing streams to a rtsp server
+type Client struct {
+	RtspURL         *url.URL        // url of the stream
+	Transport       ClientTransport // rtp/rtcp transport,udp,tcp or multicast
+	Timeout         time.Duration   // timeout for waiting response
+	Conn            *net.TCPConn    // rtsp connection to server
+	Bufio           *bufio.ReadWriter
+	InterleavedConn *InterleavedConn // rtsp tcp connection shared with interleaved rtp/rtcp
+	SessionID       string           // session id from server
+	TLSConfig       *tls.Config      // tls config of rtsps urls,nil for default
+	Srtp            bool             // push udp media by RTP/SAVP with keys added to sdp
+	Username        string           // user name for authentication,from url by default
+	Password        string           // password for authentication,from url by default
+	SdpContent      string           // sdp raw content from DESCRIBE or for ANNOUNCE
+	SdpMessage      *sdp.Message     // sdp info of the stream
+	Tracks          []*ClientTrack   // tracks in sdp
+	/*
+		OnPackage called when received rtp/rtcp package from server,
+		it's called in reading goroutines,so should not block
+	*/
+	OnPackage     func(trackIndex int, packageType PackageType, data RtpRtcpPackage)
+	cSeq          int
+	responseChan  chan *Package
+	readErr       error
+	authChallenge string // WWW-Authenticate of the last 401 response
+	nonceCount    uint32 // nonce count of digest authentication with qop
+}
+
+//NewClient create a rtsp client for rawURL
+func NewClient(rawURL string, transport ClientTransport) (*Client, error) {
+	rtspURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse error:%v", err)
+	}
+	if rtspURL.Scheme != "rtsp" && rtspURL.Scheme != "rtsps" {
+		return nil, fmt.Errorf("NewClient error: scheme %v not support", rtspURL.Scheme)
+	}
+	client := &Client{
+		RtspURL:   rtspURL,
+		Transport: transport,
+		Timeout:   DefaultClientTimeout,
+	}
+	if rtspURL.User != nil {
+		// credentials are sent in Authorization header,not in request urls
+		client.Username = rtspURL.User.Username()
+		client.Password, _ = rtspURL.User.Password()
+		rtspURL.User = nil
+	}
+	return client, nil
+}
+
+//Dial connect to rtsp server and start reading responses and interleaved frames
+func (client *Client) Dial() error {
+	host, defaultPort := client.RtspURL.Host, "554"
+	if client.RtspURL.Scheme == "rtsps" {
+		defaultPort = "322"
+	}
+	if client.RtspURL.Port() == "" {
+		host = net.JoinHostPort(client.RtspURL.Hostname(), defaultPort)
+	}
+	conn, err := net.DialTimeout("tcp", host, client.Timeout)
+	if err != nil {
+		return fmt.Errorf("DialTimeout error:%v", err)
+	}
+	client.Conn = conn.(*net.TCPConn)
+	var stream net.Conn = conn
+	if client.RtspURL.Scheme == "rtsps" {
+		config := &tls.Config{}
+		if client.TLSConfig != nil {
+			config = client.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = client.RtspURL.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		conn.SetDeadline(time.Now().Add(client.Timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls Handshake error:%v", err)
+		}
+		conn.SetDeadline(time.Time{})
+		stream = tlsConn
+	}
+	client.Bufio = bufio.NewReadWriter(
+		bufio.NewReaderSize(stream, ReadBufferSize),
+		bufio.NewWriterSize(stream, WriteBufferSize))
+	client.InterleavedConn = &InterleavedConn{Bufio: client.Bufio}
+	client.responseChan = make(chan *Package)
+	go func() {
+		for {
+			pkg, err := ReadRtspPackage(client.Bufio.Reader, client.dispatchFrame)
+			if err != nil {
+				client.readErr = err
+				close(client.responseChan)
+				return
+			}
+			client.responseChan <- pkg
+		}
+	}()
+	return nil
+}
+
+//dispatchFrame pass interleaved frame to OnPackage
+func (client *Client) dispatchFrame(frame *InterleavedFrame) {
+	if client.OnPackage == nil {
+		return
+	}
+	for index, track := range client.Tracks {
+		switch frame.Channel {
+		case track.RtpChannel:
+			client.OnPackage(index, RtpPackage, frame.Data)
+			return
+		case track.RtcpChannel:
+			client.OnPackage(index, RtcpPackage, frame.Data)
+			return
+		}
+	}
+}
+
+//request send a rtsp request and wait for its response
+func (client *Client) request(method, requestURL string,
+	headers map[string]string, content []byte) (*Package, error) {
+	if client.InterleavedConn == nil {
+		return nil, fmt.Errorf("%v error: client not connected", method)
+	}
+	client.cSeq++
+	pack := &Package{
+		RtspHeaderMap: map[string]string{
+			"CSeq":       strconv.Itoa(client.cSeq),
+			"User-Agent": "streamProtocol",
+		},
+		Method:  method,
+		URL:     requestURL,
+		Version: "RTSP/1.0",
+		Content: content,
+	}
+	if client.SessionID != "" {
+		pack.RtspHeaderMap["Session"] = client.SessionID
+	}
+	if client.authChallenge != "" {
+		pack.RtspHeaderMap["Authorization"] = client.authorization(method, requestURL)
+	}
+	for key, value := range headers {
+		pack.RtspHeaderMap[key] = value
+	}
+	requestBuf := pack.RequestString()
+	if _, err := client.InterleavedConn.WriteString(requestBuf); err != nil {
+		return nil, fmt.Errorf("%v error: WriteString error:%v", method, err)
+	}
+	fmt.Printf("Writed to remote:\r\n%v", redactAuthorization(requestBuf))
+	response, err := client.waitResponse(method)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID, ok := response.RtspHeaderMap["Session"]; ok {
+		client.SessionID = strings.TrimSpace(strings.SplitN(sessionID, ";", 2)[0])
+	}
+	if challenge := response.RtspHeaderMap["WWW-Authenticate"]; response.StatusCode() == 401 &&
+		client.Username != "" && challenge != "" && challenge != client.authChallenge {
+		// retry once with credentials answering the new challenge
+		client.authChallenge = challenge
+		return client.request(method, requestURL, headers, content)
+	}
+	if response.StatusCode() != 200 {
+		return response, fmt.Errorf("%v error: %v", method, response.Error)
+	}
+	return response, nil
+}
+
+//waitResponse wait for response of the latest request,late responses of
+//requests timed out before are discarded
+func (client *Client) waitResponse(method string) (*Package, error) {
+	timer := time.NewTimer(client.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case response, ok := <-client.responseChan:
+			if !ok {
+				return nil, fmt.Errorf("%v error: read response error:%v", method, client.readErr)
+			}
+			cSeq, err := strconv.Atoi(strings.TrimSpace(response.RtspHeaderMap["CSeq"]))
+			if err == nil && cSeq < client.cSeq {
+				fmt.Printf("%v discard late response of CSeq %v\n", method, cSeq)
+				continue
+			}
+			if err != nil || cSeq != client.cSeq {
+				return nil, fmt.Errorf("%v error: CSeq not match", method)
+			}
+			return response, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("%v error: wait response timeout", method)
+		}
+	}
+}
+
+//Options send OPTIONS request
+func (client *Client) Options() (*Package, error) {
+	return client.request(OPTIONS, client.RtspURL.String(), nil, nil)
+}
+
+//GetParameter send GET_PARAMETER request without parameters to keep session alive
+func (client *Client) GetParameter() (*Package, error) {
+	return client.request(GET_PARAMETER, client.RtspURL.String(), nil, nil)
+}
+
+//Describe send DESCRIBE request,then parse tracks from sdp
+func (client *Client) Describe() (*Package, error) {
+	response, err := client.request(DESCRIBE, client.RtspURL.String(),
+		map[string]string{"Accept": "application/sdp"}, nil)
+	if err != nil {
+		return response, err
+	}
+	baseURL := client.RtspURL.String()
+	if contentBase, ok := response.RtspHeaderMap["Content-Base"]; ok {
+		baseURL = contentBase
+	}
+	if err := client.parseSdp(response.Content, baseURL); err != nil {
+		return response, err
+	}
+	for _, track := range client.Tracks {
+		if track.CryptoKey != nil {
+			// key offered by server is for packages from it,and puller
+			// answers its own in SETUP
+			track.ServerCryptoKey, track.CryptoKey = track.CryptoKey, NewCryptoKey()
+		}
+	}
+	return response, nil
+}
+
+//Announce send ANNOUNCE request with sdp content,then parse tracks from sdp
+func (client *Client) Announce(sdpContent string) (*Package, error) {
+	if client.Srtp && client.Transport == TransportUDP {
+		sdpContent, _ = secureSdp(sdpContent, true)
+	}
+	if err := client.parseSdp([]byte(sdpContent), client.RtspURL.String()); err != nil {
+		return nil, err
+	}
+	response, err := client.request(ANNOUNCE, client.RtspURL.String(),
+		map[string]string{"Content-Type": "application/sdp"}, []byte(sdpContent))
+	if err != nil || len(response.Content) == 0 {
+		return response, err
+	}
+	// answer of server with its srtp keys
+	answer := &Client{}
+	if err := answer.parseSdp(response.Content, client.RtspURL.String()); err != nil {
+		return response, err
+	}
+	for index, track := range answer.Tracks {
+		if index < len(client.Tracks) && client.Tracks[index].CryptoKey != nil {
+			client.Tracks[index].ServerCryptoKey = track.CryptoKey
+		}
+	}
+	return response, nil
+}
+
+//parseSdp parse sdp content to get tracks
+func (client *Client) parseSdp(content []byte, baseURL string) error {
+	var (
+		sdpSession sdp.Session
+		err        error
+	)
+	if sdpSession, err = sdp.DecodeSession(content, sdpSession); err != nil {
+		return fmt.Errorf("sdp.DecodeSession error:%v", err)
+	}
+	sdpDecoder := sdp.NewDecoder(sdpSession)
+	sdpMessage := new(sdp.Message)
+	if err = sdpDecoder.Decode(sdpMessage); err != nil {
+		return fmt.Errorf("sdpDecoder.Decode error:%v", err)
+	}
+	client.SdpContent = string(content)
+	client.SdpMessage = sdpMessage
+	client.Tracks = make([]*ClientTrack, 0, len(sdpMessage.Medias))
+	for index, media := range sdpMessage.Medias {
+		track := &ClientTrack{
+			Media:       media,
+			Control:     controlURL(baseURL, media.Attributes.Value("control")),
+			RtpChannel:  2 * index,
+			RtcpChannel: 2*index + 1,
+		}
+		if crypto := media.Attributes.Value("crypto"); crypto != "" {
+			if track.CryptoKey, err = ParseCrypto(crypto); err != nil {
+				return fmt.Errorf("ParseCrypto error:%v", err)
+			}
+		}
+		client.Tracks = append(client.Tracks, track)
+	}
+	return nil
+}
+
+//controlURL get absolute control url from base url and sdp control attribute
+func controlURL(baseURL, control string) string {
+	switch {
+	case control == "" || control == "*":
+		return baseURL
+	case strings.HasPrefix(control, "rtsp://"):
+		return control
+	case strings.HasSuffix(baseURL, "/"):
+		return baseURL + control
+	default:
+		return baseURL + "/" + control
+	}
+}
+
+//Setup send SETUP request for track,mode is "play" or "record"
+func (client *Client) Setup(trackIndex int, mode string) (response *Package, err error) {
+	if trackIndex < 0 || trackIndex >= len(client.Tracks) {
+		return nil, fmt.Errorf("SETUP error: track %v not exist", trackIndex)
+	}
+	track := client.Tracks[trackIndex]
+	defer func() {
+		if err != nil {
+			// udp connections of failed SETUP are not used
+			track.closeUDP()
+		}
+	}()
+	var transport string
+	switch client.Transport {
+	case TransportTCP:
+		transport = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%v-%v;mode=%v",
+			track.RtpChannel, track.RtcpChannel, mode)
+	case TransportUDP:
+		if track.RtpUDPConn, err = net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+			return nil, fmt.Errorf("SETUP error: ListenUDP error:%v", err)
+		}
+		if track.RtcpUDPConn, err = net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+			return nil, fmt.Errorf("SETUP error: ListenUDP error:%v", err)
+		}
+		profile := "RTP/AVP"
+		if track.CryptoKey != nil {
+			if track.secure, err = newSrtpContexts(
+				track.CryptoKey, track.ServerCryptoKey); err != nil {
+				return nil, fmt.Errorf("SETUP error:%v", err)
+			}
+			profile = "RTP/SAVP"
+		}
+		transport = fmt.Sprintf("%v;unicast;client_port=%v-%v;mode=%v", profile,
+			track.RtpUDPConn.LocalAddr().(*net.UDPAddr).Port,
+			track.RtcpUDPConn.LocalAddr().(*net.UDPAddr).Port, mode)
+	case TransportMulticast:
+		if mode != "play" {
+			return nil, fmt.Errorf("SETUP error: multicast only for pulling")
+		}
+		transport = "RTP/AVP;multicast;mode=play"
+	default:
+		return nil, fmt.Errorf("SETUP error: transport not support")
+	}
+	headers := map[string]string{"Transport": transport}
+	if track.secure != nil && mode == "play" {
+		headers["Crypto"] = cryptoValue(track.CryptoKey)
+	}
+	if response, err = client.request(SETUP, track.Control, headers, nil); err != nil {
+		return response, err
+	}
+	responseTransport := response.RtspHeaderMap["Transport"]
+	if client.Transport == TransportTCP {
+		if matcher := regexp.MustCompile("interleaved=(\\d+)(-(\\d+))?").
+			FindStringSubmatch(responseTransport); matcher != nil {
+			track.RtpChannel, _ = strconv.Atoi(matcher[1])
+			if track.RtcpChannel, err = strconv.Atoi(matcher[3]); err != nil {
+				track.RtcpChannel = track.RtpChannel + 1
+			}
+		}
+	} else if client.Transport == TransportMulticast {
+		if err := track.joinMulticastGroup(responseTransport); err != nil {
+			return response, fmt.Errorf("SETUP error:%v", err)
+		}
+	} else if matcher := regexp.MustCompile("server_port=(\\d+)(-(\\d+))?").
+		FindStringSubmatch(responseTransport); matcher != nil {
+		serverIP := client.Conn.RemoteAddr().(*net.TCPAddr).IP
+		rtpPort, _ := strconv.Atoi(matcher[1])
+		rtcpPort, err := strconv.Atoi(matcher[3])
+		if err != nil {
+			rtcpPort = rtpPort + 1
+		}
+		track.RtpServerAddr = &net.UDPAddr{IP: serverIP, Port: rtpPort}
+		track.RtcpServerAddr = &net.UDPAddr{IP: serverIP, Port: rtcpPort}
+	}
+	return response, nil
+}
+
+//closeUDP close udp connections of track
+func (track *ClientTrack) closeUDP() {
+	if track.RtpUDPConn != nil {
+		track.RtpUDPConn.Close()
+		track.RtpUDPConn = nil
+	}
+	if track.RtcpUDPConn != nil {
+		track.RtcpUDPConn.Close()
+		track.RtcpUDPConn = nil
+	}
+}
+
+//joinMulticastGroup join group of destination and port in Transport header of SETUP response
+func (track *ClientTrack) joinMulticastGroup(transport string) error {
+	destination := regexp.MustCompile("destination=([^;]+)").FindStringSubmatch(transport)
+	ports := regexp.MustCompile(";port=(\\d+)(-(\\d+))?").FindStringSubmatch(transport)
+	if destination == nil || ports == nil || !strings.Contains(transport, "multicast") {
+		return fmt.Errorf("multicast transport %v invalid", transport)
+	}
+	groupIP := net.ParseIP(destination[1])
+	if groupIP == nil || !groupIP.IsMulticast() {
+		return fmt.Errorf("multicast destination %v invalid", destination[1])
+	}
+	rtpPort, _ := strconv.Atoi(ports[1])
+	rtcpPort, err := strconv.Atoi(ports[3])
+	if err != nil {
+		rtcpPort = rtpPort + 1
+	}
+	if track.RtpUDPConn, err = net.ListenMulticastUDP("udp4", nil,
+		&net.UDPAddr{IP: groupIP, Port: rtpPort}); err != nil {
+		return fmt.Errorf("ListenMulticastUDP error:%v", err)
+	}
+	if track.RtcpUDPConn, err = net.ListenMulticastUDP("udp4", nil,
+		&net.UDPAddr{IP: groupIP, Port: rtcpPort}); err != nil {
+		return fmt.Errorf("ListenMulticastUDP error:%v", err)
+	}
+	return nil
+}
+
+//Play send PLAY request,then begin receiving packages
+func (client *Client) Play() (*Package, error) {
+	return client.PlayRange("npt=0.000-")
+}
+
+//PlayRange send PLAY request with Range header like npt=10-,
+//without Range if rangeValue is empty,to resume from the paused position
+func (client *Client) PlayRange(rangeValue string) (*Package, error) {
+	return client.play(rangeValue, nil)
+}
+
+//PlayScale send PLAY request with Range,Scale and Speed headers for trick play
+func (client *Client) PlayScale(rangeValue string, scale, speed float64) (*Package, error) {
+	return client.play(rangeValue, map[string]string{
+		"Scale": strconv.FormatFloat(scale, 'f', -1, 64),
+		"Speed": strconv.FormatFloat(speed, 'f', -1, 64),
+	})
+}
+
+//play send PLAY request with Range and headers,then begin receiving packages
+func (client *Client) play(rangeValue string, headers map[string]string) (*Package, error) {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	if rangeValue != "" {
+		headers["Range"] = rangeValue
+	}
+	response, err := client.request(PLAY, client.RtspURL.String(), headers, nil)
+	if err != nil {
+		return response, err
+	}
+	client.startUDPReading()
+	return response, nil
+}
+
+//Record send RECORD request,then packages can be pushed by PushPackage
+func (client *Client) Record() (*Package, error) {
+	response, err := client.request(RECORD, client.RtspURL.String(),
+		map[string]string{"Range": "npt=0.000-"}, nil)
+	if err != nil {
+		return response, err
+	}
+	client.startUDPReading()
+	return response, nil
+}
+
+//Pause send PAUSE request
+func (client *Client) Pause() (*Package, error) {
+	return client.request(PAUSE, client.RtspURL.String(), nil, nil)
+}
+
+//Teardown send TEARDOWN request
+func (client *Client) Teardown() (*Package, error) {
+	return client.request(TEARDOWN, client.RtspURL.String(), nil, nil)
+}
+
+//startUDPReading start goroutines reading rtp/rtcp from udp connections
+func (client *Client) startUDPReading() {
+	if client.Transport == TransportTCP {
+		return
+	}
+	for index, track := range client.Tracks {
+		for packageType, conn := range map[PackageType]*net.UDPConn{
+			RtpPackage:  track.RtpUDPConn,
+			RtcpPackage: track.RtcpUDPConn,
+		} {
+			if conn == nil {
+				continue
+			}
+			go func(index int, packageType PackageType, conn *net.UDPConn) {
+				data := make([]byte, ReadBufferSize)
+				for {
+					number, _, err := conn.ReadFromUDP(data)
+					if err != nil {
+						return
+					}
+					buf, err := client.Tracks[index].secure.unprotect(packageType, data[:number])
+					if err != nil {
+						fmt.Printf("invalid srtp package from server = %v\n", err)
+						continue
+					}
+					if client.OnPackage != nil {
+						client.OnPackage(index, packageType, append([]byte(nil), buf...))
+					}
+				}
+			}(index, packageType, conn)
+		}
+	}
+}
+
+//PushPackage push a rtp/rtcp package of track to server
+func (client *Client) PushPackage(trackIndex int,
+	packageType PackageType, data RtpRtcpPackage) error {
+	if trackIndex < 0 || trackIndex >= len(client.Tracks) {
+		return fmt.Errorf("PushPackage error: track %v not exist", trackIndex)
+	}
+	track := client.Tracks[trackIndex]
+	if client.Transport == TransportTCP {
+		channel := track.RtpChannel
+		if packageType == RtcpPackage {
+			channel = track.RtcpChannel
+		}
+		return client.InterleavedConn.WriteFrame(channel, data)
+	}
+	conn, addr := track.RtpUDPConn, track.RtpServerAddr
+	if packageType == RtcpPackage {
+		conn, addr = track.RtcpUDPConn, track.RtcpServerAddr
+	}
+	if conn == nil || addr == nil {
+		return fmt.Errorf("PushPackage error: track %v not setup for pushing", trackIndex)
+	}
+	data, err := track.secure.protect(packageType, data)
+	if err != nil {
+		return fmt.Errorf("PushPackage error:%v", err)
+	}
+	_, err = conn.WriteToUDP(data, addr)
+	return err
+}
+
+//StartPull OPTIONS,DESCRIBE,SETUP all tracks and PLAY
+func (client *Client) StartPull() error {
+	if _, err := client.Options(); err != nil {
+		return err
+	}
+	if _, err := client.Describe(); err != nil {
+		return err
+	}
+	for index := range client.Tracks {
+		if _, err := client.Setup(index, "play"); err != nil {
+			return err
+		}
+	}
+	_, err := client.Play()
+	return err
+}
+
+//StartPush OPTIONS,ANNOUNCE sdpContent,SETUP all tracks and RECORD
+func (client *Client) StartPush(sdpContent string) error {
+	if _, err := client.Options(); err != nil {
+		return err
+	}
+	if _, err := client.Announce(sdpContent); err != nil {
+		return err
+	}
+	for index := range client.Tracks {
+		if _, err := client.Setup(index, "record"); err != nil {
+			return err
+		}
+	}
+	_, err := client.Record()
+	return err
+}
+
+//Close close rtsp connection and udp connections
+func (client *Client) Close() error {
+	for _, track := range client.Tracks {
+		track.closeUDP()
+	}
+	if client.Conn != nil {
+		return client.Conn.Close()
+	}
+	return nil
+}
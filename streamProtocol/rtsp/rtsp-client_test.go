@@ -0,0 +1,314 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/internal/nettest"
+)
+
+const testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=fmtp:97 streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210\r\n" +
+	"a=control:streamid=1\r\n"
+
+//startTestServer start a rtsp server on a free local port,it's stopped by the caller
+func startTestServer(t *testing.T) (*Server, string) {
+	server := &Server{}
+	return server, nettest.Start(t, server)
+}
+
+func TestServerStop(t *testing.T) {
+	server := &Server{}
+	listener := nettest.Listen(t)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	pusher, err := NewClient(fmt.Sprintf("rtsp://%v/live/stop", listener.Addr()), TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Stop error:%v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve error:%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve not returned after Stop")
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("listener not closed by Stop")
+	}
+	// Stop waits for sessions,so the pusher's stream is already removed
+	server.PusherPullersSessionMapMutex.Lock()
+	defer server.PusherPullersSessionMapMutex.Unlock()
+	if len(server.PusherPullersSessionMap) != 0 {
+		t.Errorf("sessions %v left after Stop", len(server.PusherPullersSessionMap))
+	}
+}
+
+func TestClientLateResponse(t *testing.T) {
+	listener := nettest.Listen(t)
+	defer listener.Close()
+	// fake server answers the first request after the client timed out
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for cSeq := 1; ; cSeq++ {
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == "\r\n" {
+					break
+				}
+			}
+			status := "200 OK"
+			if cSeq == 3 {
+				status = "461 Unsupported Transport"
+			}
+			if cSeq == 2 {
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: 1\r\n\r\n")
+			}
+			if cSeq > 1 {
+				fmt.Fprintf(conn, "RTSP/1.0 %v\r\nCSeq: %v\r\n\r\n", status, cSeq)
+			}
+		}
+	}()
+	client, err := NewClient(fmt.Sprintf("rtsp://%v/live/late", listener.Addr()), TransportUDP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	client.Timeout = 200 * time.Millisecond
+	if err := client.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer client.Close()
+	if _, err := client.Options(); err == nil {
+		t.Fatalf("Options without response should time out")
+	}
+	client.Timeout = 5 * time.Second
+	if _, err := client.Options(); err != nil {
+		t.Fatalf("Options after late response error:%v", err)
+	}
+	if err := client.parseSdp([]byte(testSdp), client.RtspURL.String()); err != nil {
+		t.Fatalf("parseSdp error:%v", err)
+	}
+	if _, err := client.Setup(0, "play"); err == nil {
+		t.Fatalf("Setup of unsupported transport should fail")
+	}
+	if track := client.Tracks[0]; track.RtpUDPConn != nil || track.RtcpUDPConn != nil {
+		t.Errorf("udp connections of failed Setup not closed")
+	}
+}
+
+func TestClientPushPull(t *testing.T) {
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/test", address)
+
+	pusher, err := NewClient(streamURL, TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+
+	for _, transport := range []ClientTransport{TransportUDP, TransportTCP} {
+		puller, err := NewClient(streamURL, transport)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		received := make(chan RtpRtcpPackage, 10)
+		puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+			if trackIndex == 0 && packageType == RtpPackage {
+				received <- data
+			}
+		}
+		if err := puller.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		if err := puller.StartPull(); err != nil {
+			t.Fatalf("StartPull error:%v", err)
+		}
+		if len(puller.Tracks) != 2 {
+			t.Fatalf("puller tracks = %v, want 2", len(puller.Tracks))
+		}
+		packet := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0x65}
+		deadline := time.After(5 * time.Second)
+	waitPackage:
+		for {
+			if err := pusher.PushPackage(0, RtpPackage, packet); err != nil {
+				t.Fatalf("PushPackage error:%v", err)
+			}
+			select {
+			case data := <-received:
+				if !bytes.Equal(data, packet) {
+					t.Errorf("received %v, want %v", data, packet)
+				}
+				break waitPackage
+			case <-time.After(50 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("transport %v: puller not received package", transport)
+			}
+		}
+		if _, err := puller.Teardown(); err != nil {
+			t.Errorf("Teardown error:%v", err)
+		}
+		puller.Close()
+	}
+}
+
+const multiTrackSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=multi track\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 0\r\n" +
+	"a=control:trackID=2\r\n" +
+	"m=audio 0 RTP/AVP 8\r\n" +
+	"a=control:trackID=12\r\n" +
+	"m=application 0 RTP/AVP 107\r\n" +
+	"a=rtpmap:107 vnd.onvif.metadata/90000\r\n" +
+	"a=control:trackID=3\r\n"
+
+func TestMultiTrack(t *testing.T) {
+	server, address := startTestServer(t)
+	defer server.Stop()
+	streamURL := fmt.Sprintf("rtsp://%v/live/multi", address)
+	pusher, err := NewClient(streamURL, TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	if err := pusher.StartPush(multiTrackSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+
+	puller, err := NewClient(streamURL, TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	received := make(chan int, 10)
+	puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+		if packageType == RtpPackage {
+			received <- trackIndex
+		}
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	if _, err := puller.Describe(); err != nil {
+		t.Fatalf("Describe error:%v", err)
+	}
+	if len(puller.Tracks) != 4 {
+		t.Fatalf("puller tracks = %v, want 4", len(puller.Tracks))
+	}
+	// only the second audio and the metadata track
+	for _, index := range []int{2, 3} {
+		if _, err := puller.Setup(index, "play"); err != nil {
+			t.Fatalf("Setup track %v error:%v", index, err)
+		}
+	}
+	if _, err := puller.Play(); err != nil {
+		t.Fatalf("Play error:%v", err)
+	}
+	packet := []byte{0x80, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0xd5}
+	deadline := time.After(5 * time.Second)
+	for {
+		for _, index := range []int{1, 2} {
+			if err := pusher.PushPackage(index, RtpPackage, packet); err != nil {
+				t.Fatalf("PushPackage error:%v", err)
+			}
+		}
+		select {
+		case trackIndex := <-received:
+			if trackIndex != 2 {
+				t.Fatalf("received package of track %v, want 2", trackIndex)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("puller not received package")
+		}
+	}
+}
+
+func TestFindTrack(t *testing.T) {
+	session := &NetSession{
+		PusherPullersSessionMap:      make(map[string]*PusherPullersSession),
+		PusherPullersSessionMapMutex: new(sync.Mutex),
+		SessionType:                  PullerClient,
+	}
+	pps := &PusherPullersSession{Tracks: []*PusherPullersPair{
+		{Control: "streamid=1"}, {Control: "streamid=11"}}}
+	session.PusherPullersSessionMap["/live/test"] = pps
+	if found, _, _ := session.findPusherPullersSession("/live/test/streamid=1"); found != nil {
+		t.Errorf("puller found session not published")
+	}
+	pps.setPublished()
+	cases := map[string]string{
+		"/live/test/streamid=1":  "streamid=1",
+		"/live/test/streamid=11": "streamid=11",
+		"/live/test/":            "",
+	}
+	for path, want := range cases {
+		found, resourcePath, control := session.findPusherPullersSession(path)
+		if found != pps || resourcePath != "/live/test" || control != want {
+			t.Errorf("findPusherPullersSession(%v) = %v,%v, want %v", path,
+				resourcePath, control, want)
+		}
+	}
+	if pps.FindTrack("streamid=1") != pps.Tracks[0] || pps.FindTrack("streamid=2") != nil {
+		t.Errorf("FindTrack not match exactly")
+	}
+	if found, _, _ := session.findPusherPullersSession("/live/testing"); found != nil {
+		t.Errorf("findPusherPullersSession matched other resource")
+	}
+	session.SessionType, session.ReourcePath = PusherClient, "/live/other"
+	if found, _, _ := session.findPusherPullersSession("/live/test/streamid=1"); found != nil {
+		t.Errorf("pusher found session announced by others")
+	}
+}
@@ -0,0 +1,125 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//ReadRtspPackage read a rtsp request or response from reader,
+//rtp/rtcp interleaved frames(rfc2326 10.12) before it are passed to frameHandler
+func ReadRtspPackage(reader *bufio.Reader,
+	frameHandler func(frame *InterleavedFrame)) (*Package, error) {
+	newPackage := new(Package)
+	newPackage.RtspHeaderMap = make(map[string]string)
+	newPackage.Error = Ok
+	reqData := bytes.NewBuffer(nil)
+	for {
+		ifFrame, err := IfNextFrame(reader)
+		if err != nil {
+			return nil,
+				fmt.Errorf("IfNextFrame error : %v", err)
+		}
+		if !ifFrame {
+			break
+		}
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			return nil, err
+		}
+		if frameHandler != nil {
+			frameHandler(frame)
+		}
+	}
+	for ifFirstLine := true; ; {
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil,
+				fmt.Errorf("reader.ReadLine() : %v", err)
+		}
+		reqData.Write(line)
+		reqData.WriteString("\r\n")
+		if !isPrefix {
+			if ifFirstLine {
+				items := regexp.MustCompile("\\s+").
+					Split(strings.
+						TrimSpace(string(line)), -1)
+				if len(items) >= 3 &&
+					strings.HasPrefix(items[2], "RTSP") {
+					// request line
+					newPackage.Method = items[0]
+					newPackage.URL = items[1]
+					newPackage.Version = items[2]
+				} else if len(items) >= 2 &&
+					strings.HasPrefix(items[0], "RTSP") {
+					// status line
+					newPackage.Version = items[0]
+					newPackage.Error = CommandError(strings.Join(items[1:], " "))
+				} else {
+					return nil,
+						fmt.Errorf("first request line error")
+				}
+				ifFirstLine = false
+			} else {
+				if items := regexp.MustCompile(":\\s*").Split(strings.
+					TrimSpace(string(line)), 2); len(items) == 2 {
+					newPackage.RtspHeaderMap[items[0]] = items[1]
+				}
+			}
+		}
+		if len(line) == 0 {
+			fmt.Printf("%v", redactAuthorization(reqData.String()))
+			if length, exist :=
+				newPackage.RtspHeaderMap["Content-Length"]; exist {
+				lengthInt, err := strconv.Atoi(length)
+				if err != nil {
+					return nil, fmt.Errorf("Content-Length error:%v", err)
+				}
+				if lengthInt > 0 {
+					content := make([]byte, lengthInt)
+					if _, err := io.ReadFull(reader, content); err != nil {
+						return nil, err
+					}
+					newPackage.Content = content
+					fmt.Print(string(content))
+				}
+			}
+			reqData.Reset()
+			break
+		}
+	}
+	return newPackage, nil
+}
+
+//StatusCode status code of response package,0 if not a valid response
+func (pack *Package) StatusCode() int {
+	code, err := strconv.Atoi(strings.SplitN(string(pack.Error), " ", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+//RequestString encode the package as a rtsp request
+func (pack *Package) RequestString() string {
+	requestBuf := fmt.Sprintf("%s %s %s\r\n", pack.Method, pack.URL, pack.Version)
+	keys := make([]string, 0, len(pack.RtspHeaderMap))
+	for key := range pack.RtspHeaderMap {
+		if key != "Content-Length" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		requestBuf += fmt.Sprintf("%s: %s\r\n", key, pack.RtspHeaderMap[key])
+	}
+	if len(pack.Content) > 0 {
+		requestBuf += fmt.Sprintf("Content-Length: %v\r\n", len(pack.Content))
+	}
+	return requestBuf + "\r\n" + string(pack.Content)
+}
@@ -2,6 +2,7 @@ package rtsp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
@@ -11,15 +12,16 @@ import (
 	"github.com/teris-io/shortid"
 )
 
+// buffer settings shared by servers and clients,set them before any of them starts
 var (
 	//ReadBufferSize bio&tcp&udp read buffer size
-	ReadBufferSize int
+	ReadBufferSize int = 65536
 	//WriteBufferSize bio&tcp&udp write buffer size
-	WriteBufferSize int
+	WriteBufferSize int = 65536
 	//PushChannelBufferSize pusher channel buffer size
-	PushChannelBufferSize int
+	PushChannelBufferSize int = 1
 	//PullChannelBufferSize puller channel buffer size
-	PullChannelBufferSize int
+	PullChannelBufferSize int = 1
 )
 
 // Server rtsp server
@@ -27,10 +29,22 @@ type Server struct {
 	protocolinterface.BasicNet
 	PusherPullersSessionMap      map[string]*PusherPullersSession
 	PusherPullersSessionMapMutex sync.Mutex
+	TLSAddress                   string      // listening address of rtsps,empty to disable
+	TLSConfig                    *tls.Config // certificate and key of rtsps
+	listeners                    []net.Listener
+	conns                        map[*net.TCPConn]struct{} // connections of sessions,closed by Stop
+	stopped                      bool                      // if Stop called
+	mutex                        sync.Mutex                // provide listeners,conns and stopped's atom
+	sessions                     sync.WaitGroup            // goroutines of sessions,waited by Stop
 }
 
 // StartSession start a session with rtsp client
 func (server *Server) StartSession(conn *net.TCPConn) error {
+	return server.startSession(conn, nil)
+}
+
+// startSession start a session with rtsp client,over tls if tlsConfig is not nil
+func (server *Server) startSession(conn *net.TCPConn, tlsConfig *tls.Config) error {
 	fmt.Println(
 		"start session from ",
 		conn.LocalAddr().String(),
@@ -43,11 +57,18 @@ func (server *Server) StartSession(conn *net.TCPConn) error {
 	newSession.RemoteIP = &rip
 	newSession.PusherPullersSessionMap = server.PusherPullersSessionMap
 	newSession.PusherPullersSessionMapMutex = &server.PusherPullersSessionMapMutex
+	var stream net.Conn = conn
+	if tlsConfig != nil {
+		stream = tls.Server(conn, tlsConfig)
+		newSession.secure = true
+	}
 	newSession.Bufio =
 		bufio.NewReadWriter(
-			bufio.NewReaderSize(conn, ReadBufferSize),
-			bufio.NewWriterSize(conn, WriteBufferSize))
+			bufio.NewReaderSize(stream, ReadBufferSize),
+			bufio.NewWriterSize(stream, WriteBufferSize))
+	newSession.InterleavedConn = &InterleavedConn{Bufio: newSession.Bufio}
 	newSession.ID = shortid.MustGenerate()
+	newSession.startKeepAlive()
 	for {
 		if pkg, err := newSession.ReadPackage(); err == nil {
 			if err = newSession.ProcessPackage(pkg); err != nil {
@@ -69,20 +90,9 @@ func (server *Server) StartSession(conn *net.TCPConn) error {
 	return fmt.Errorf("StartSession error")
 }
 
-/*Start start a rtsp server
-bufferReadSize: bio&tcp read buffer size
-bufferWriteSize: bio&tcp write buffer size
-*/
-func (server *Server) Start(address string,
-	bufferReadSize int,
-	bufferWriteSize int,
-	pushChannelBufferSize int,
-	pullChannelBufferSize int) error {
-	ReadBufferSize = bufferReadSize
-	WriteBufferSize = bufferWriteSize
-	PushChannelBufferSize = pushChannelBufferSize
-	PullChannelBufferSize = pullChannelBufferSize
-	server.PusherPullersSessionMap = make(map[string]*PusherPullersSession)
+// Start start a rtsp server listening address,and rtsps if TLSAddress is set,
+// buffer size settings are set before any server or client starts
+func (server *Server) Start(address string) error {
 	addr, err := net.ResolveTCPAddr("tcp", address)
 	if err != nil {
 		return fmt.Errorf("address resolving failed : %v", err)
@@ -95,21 +105,144 @@ func (server *Server) Start(address string,
 	}
 	fmt.Println("Start listening at ", address)
 	server.TCPListener = listener
+	if server.TLSAddress != "" {
+		if server.TLSConfig == nil {
+			listener.Close()
+			return fmt.Errorf("TLSConfig of rtsps is nil")
+		}
+		tlsListener, err := net.Listen("tcp", server.TLSAddress)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("listen tcp failed : %v", err)
+		}
+		fmt.Println("Start listening rtsps at ", server.TLSAddress)
+		go func() {
+			if err := server.ServeTLS(tlsListener); err != nil {
+				fmt.Println(err)
+			}
+		}()
+	}
+	return server.Serve(listener)
+}
+
+// Serve accept rtsp sessions from listener until Stop
+func (server *Server) Serve(listener net.Listener) error {
+	return server.acceptSessions(listener, nil)
+}
+
+// ServeTLS accept rtsps sessions from listener with TLSConfig until Stop
+func (server *Server) ServeTLS(listener net.Listener) error {
+	if server.TLSConfig == nil {
+		listener.Close()
+		return fmt.Errorf("TLSConfig of rtsps is nil")
+	}
+	return server.acceptSessions(listener, server.TLSConfig)
+}
+
+// Stop close listeners and connections of sessions,
+// and wait for the sessions to be closed
+func (server *Server) Stop() error {
+	server.mutex.Lock()
+	server.stopped = true
+	listeners, conns := server.listeners, server.conns
+	server.listeners, server.conns = nil, nil
+	server.mutex.Unlock()
+	var returnErr error
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil {
+			returnErr = fmt.Errorf("close listener error:%v", err)
+		}
+	}
+	for conn := range conns {
+		conn.Close()
+	}
+	server.sessions.Wait()
+	return returnErr
+}
 
-	server.IfStop = false
-	for !server.IfStop {
-		conn, err := server.TCPListener.AcceptTCP()
+//FindPublished find pusher-pullers-session of resourcePath whose pusher has
+//set up its tracks,nil if not published
+func (server *Server) FindPublished(resourcePath string) *PusherPullersSession {
+	server.PusherPullersSessionMapMutex.Lock()
+	session, ok := server.PusherPullersSessionMap[resourcePath]
+	server.PusherPullersSessionMapMutex.Unlock()
+	if !ok || !session.Published() {
+		return nil
+	}
+	return session
+}
+
+// acceptSessions accept connections of listener,over tls if tlsConfig is not nil
+func (server *Server) acceptSessions(listener net.Listener, tlsConfig *tls.Config) error {
+	server.mutex.Lock()
+	if server.stopped {
+		server.mutex.Unlock()
+		return listener.Close()
+	}
+	server.listeners = append(server.listeners, listener)
+	server.mutex.Unlock()
+	server.PusherPullersSessionMapMutex.Lock()
+	if server.PusherPullersSessionMap == nil {
+		server.PusherPullersSessionMap = make(map[string]*PusherPullersSession)
+	}
+	server.PusherPullersSessionMapMutex.Unlock()
+	for {
+		conn, err := listener.Accept()
 		if err != nil {
+			if server.isStopped() {
+				return nil
+			}
 			fmt.Println("AcceptTCP failed : ", err)
 			continue
 		}
-		if err := conn.SetReadBuffer(ReadBufferSize); err != nil {
+		tcpConn, ok := conn.(*net.TCPConn)
+		if !ok {
+			conn.Close()
+			return fmt.Errorf("listener of rtsp is not tcp")
+		}
+		if err := tcpConn.SetReadBuffer(ReadBufferSize); err != nil {
 			return fmt.Errorf("SetReadBuffer error, %v", err)
 		}
-		if err := conn.SetWriteBuffer(WriteBufferSize); err != nil {
+		if err := tcpConn.SetWriteBuffer(WriteBufferSize); err != nil {
 			return fmt.Errorf("SetWriteBuffer error, %v", err)
 		}
-		go server.StartSession(conn)
+		if !server.addConn(tcpConn) {
+			tcpConn.Close()
+			return nil
+		}
+		go func() {
+			defer server.removeConn(tcpConn)
+			server.startSession(tcpConn, tlsConfig)
+		}()
 	}
-	return nil
+}
+
+// isStopped if Stop called
+func (server *Server) isStopped() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.stopped
+}
+
+// addConn keep connection of a new session for Stop,false if stopped
+func (server *Server) addConn(conn *net.TCPConn) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.stopped {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*net.TCPConn]struct{})
+	}
+	server.conns[conn] = struct{}{}
+	server.sessions.Add(1)
+	return true
+}
+
+// removeConn forget connection of a closed session
+func (server *Server) removeConn(conn *net.TCPConn) {
+	server.mutex.Lock()
+	delete(server.conns, conn)
+	server.mutex.Unlock()
+	server.sessions.Done()
 }
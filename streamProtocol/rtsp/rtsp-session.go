@@ -2,17 +2,15 @@ package rtsp
 
 //@todo add CMD
 //@todo add rtp protocal
-//@todo add media file transfer mode
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/darunshen/go/streamProtocol/protocolinterface"
 	"gortc.io/sdp"
@@ -34,12 +32,18 @@ const (
 	UnsupportedTransport CommandError = "461 Unsupported transport"
 	//BadRequest bad request like url invalid
 	BadRequest CommandError = "400 Bad Request"
+	//Unauthorized credentials missing or invalid
+	Unauthorized CommandError = "401 Unauthorized"
 	//Forbidden forbidden request like pusher's request's resource path already used
 	Forbidden CommandError = "403 Forbidden"
 	//NotFound not found the resource path for puller client
 	NotFound CommandError = "404 Not Found"
 	//MethodNotValid method not valid in tis state , see rtsp state machine
 	MethodNotValid CommandError = "455 Method Not Valid in This State"
+	//InvalidRange range of PLAY out of the media file
+	InvalidRange CommandError = "457 Invalid Range"
+	//ParameterNotUnderstood parameter of GET_PARAMETER or SET_PARAMETER not supported
+	ParameterNotUnderstood CommandError = "451 Parameter Not Understood"
 )
 
 // server state machine
@@ -73,6 +77,10 @@ const (
 	ANNOUNCE string = "ANNOUNCE"
 	//OPTIONS rtsp method OPTIONS
 	OPTIONS string = "OPTIONS"
+	//GET_PARAMETER rtsp method GET_PARAMETER
+	GET_PARAMETER string = "GET_PARAMETER"
+	//SET_PARAMETER rtsp method SET_PARAMETER
+	SET_PARAMETER string = "SET_PARAMETER"
 )
 
 var machinaStateMap = map[string]string{
@@ -98,6 +106,9 @@ type ResponseInfo struct {
 	OptionsMethods  string
 	SetupTransport  string
 	DescribeContent string
+	AnnounceContent string // answer sdp of srtp keys of server,empty if pusher has no keys
+	PlayInfo        string // Range and RTP-Info of playing file
+	Authenticate    string // WWW-Authenticate headers of 401 response
 }
 
 // Package rtsp package
@@ -117,14 +128,22 @@ type NetSession struct {
 	SessionType                  ClientType                       // type for pusher or puller
 	RtpChannel                   int                              // for rtp in tcp session
 	RtcpChannel                  int                              // for rtcp　in tcp session
-	AudioStreamName              string                           // audio stream name
-	VideoStreamName              string                           // video stream name
 	RtspURL                      *url.URL                         // resource path in url
 	PusherPullersSessionMap      map[string]*PusherPullersSession // map url's resource to sessions
 	PusherPullersSessionMapMutex *sync.Mutex                      // provide ResourceMap's atom
 	SdpContent                   string                           // sdp raw data from announce request
 	ReourcePath                  string                           // Resource Path of request url
+	InterleavedConn              *InterleavedConn                 // rtsp tcp connection shared with interleaved rtp/rtcp
 	serverState                  string                           // server state machine
+	interleavedSessions          map[int]*RtpRtcpSession          // map interleaved channel to rtp-rtcp-session
+	vodPlayer                    *VodPlayer                       // player of file under MediaDirectory,nil if not playing file
+	rtpRtcpSessions              []*RtpRtcpSession                // rtp-rtcp-sessions set up by this session
+	lastActive                   int64                            // unix nano time of the last rtsp request
+	keepAliveMutex               sync.Mutex                       // provide rtpRtcpSessions's atom
+	closed                       chan struct{}                    // closed by CloseSession to stop keep-alive checking
+	nonce                        string                           // nonce of digest authentication
+	secure                       bool                             // if rtsp over tls(rtsps)
+	srtpKeys                     [][]byte                         // srtp keys of server for tracks,offered in DESCRIBE over tls or answered in ANNOUNCE
 }
 
 // CloseSession close session's connection and bufio
@@ -132,8 +151,14 @@ func (session *NetSession) CloseSession() error {
 	session.Bufio.Flush()
 	session.Conn.Close()
 	session.Conn = nil
+	if session.closed != nil {
+		close(session.closed)
+	}
 	var returnErr error = nil
-	if pps, ok := session.PusherPullersSessionMap[session.ReourcePath]; ok {
+	if session.vodPlayer != nil {
+		return session.vodPlayer.Close()
+	}
+	if pps, ok := session.pusherPullersSession(); ok {
 		if errs := pps.StopSession(&session.ID); len(errs) != 0 {
 			for index, err := range errs {
 				returnErr = fmt.Errorf("%v\nindex = %v,error = %v", returnErr, index, err)
@@ -141,7 +166,11 @@ func (session *NetSession) CloseSession() error {
 		}
 		if session.SessionType == PusherClient {
 			// if this session is pusher ,
-			// we need free all resource include puller's resource
+			// we need free all resource include puller's resource,
+			// pusher's TEARDOWN also ends here
+			if err := pps.StopRecord(); err != nil {
+				returnErr = fmt.Errorf("%v\nStopRecord error = %v", returnErr, err)
+			}
 			session.PusherPullersSessionMapMutex.Lock()
 			delete(session.PusherPullersSessionMap, session.ReourcePath)
 			session.PusherPullersSessionMapMutex.Unlock()
@@ -153,68 +182,19 @@ func (session *NetSession) CloseSession() error {
 // ReadPackage read package for rtsp
 func (session *NetSession) ReadPackage() (interface{}, error) {
 	fmt.Println("reading package from rtsp session")
-	newPackage := new(Package)
-	newPackage.RtspHeaderMap = make(map[string]string)
-	newPackage.Error = Ok
-	reqData := bytes.NewBuffer(nil)
-	for ifFirstLine := true; ; {
-		line, isPrefix, err :=
-			session.Bufio.ReadLine()
-		if err != nil {
-			return nil,
-				fmt.Errorf("session.Bufio.ReadLine() : %v", err)
-		}
-		reqData.Write(line)
-		reqData.WriteString("\r\n")
-		if !isPrefix {
-			if ifFirstLine {
-				items := regexp.MustCompile("\\s+").
-					Split(strings.
-						TrimSpace(string(line)), -1)
-				if len(items) < 3 ||
-					!strings.HasPrefix(items[2], "RTSP") {
-					return nil,
-						fmt.Errorf("first request line error")
-				}
-				newPackage.Method = items[0]
-				newPackage.URL = items[1]
-				newPackage.Version = items[2]
-				ifFirstLine = false
-			} else {
-				if items := regexp.MustCompile(":\\s+").Split(strings.
-					TrimSpace(string(line)), 2); len(items) == 2 {
-					newPackage.RtspHeaderMap[items[0]] = items[1]
-				}
+	return ReadRtspPackage(session.Bufio.Reader,
+		func(frame *InterleavedFrame) {
+			if err := session.dispatchFrame(frame); err != nil {
+				fmt.Printf("dispatchFrame error:%v\n", err)
 			}
-		}
-		if len(line) == 0 {
-			fmt.Printf("%v", reqData.String())
-			if length, exist :=
-				newPackage.RtspHeaderMap["Content-Length"]; exist {
-				if lengthInt, err := strconv.Atoi(length); err == nil && lengthInt > 0 {
-					content := make([]byte, lengthInt)
-					if _, err := io.ReadFull(session.Bufio, content); err == nil {
-						newPackage.Content = content
-						fmt.Print(string(content))
-					} else {
-						return nil, err
-					}
-				} else {
-					return nil, err
-				}
-			}
-			reqData.Reset()
-			break
-		}
-
-	}
-	return newPackage, nil
+		})
 }
 
 // ProcessPackage process input package
 func (session *NetSession) ProcessPackage(pack interface{}) error {
 	inputPackage := pack.(*Package)
 	var err error
+	session.touch(time.Now())
 	if session.RtspURL, err = url.Parse(inputPackage.URL); err != nil {
 		inputPackage.ResponseInfo.Error = BadRequest
 		return fmt.Errorf("url.Parse error:%v", err)
@@ -226,6 +206,9 @@ func (session *NetSession) ProcessPackage(pack interface{}) error {
 		inputPackage.ResponseInfo.Error = MethodNotValid
 		return fmt.Errorf("method not valid in tis state, see rtsp state machine")
 	}
+	if !session.authorize(inputPackage) {
+		return nil
+	}
 	switch inputPackage.Method {
 	case OPTIONS:
 		inputPackage.ResponseInfo.Error = Ok
@@ -233,18 +216,22 @@ func (session *NetSession) ProcessPackage(pack interface{}) error {
 			"Public: " + DESCRIBE + ", " + SETUP + ", " +
 				TEARDOWN + ", " + PLAY + ", " +
 				PAUSE + ", " + OPTIONS + ", " +
-				ANNOUNCE + ", " + RECORD + "\r\n"
+				ANNOUNCE + ", " + RECORD + ", " +
+				GET_PARAMETER + ", " + SET_PARAMETER + "\r\n"
+	case GET_PARAMETER, SET_PARAMETER:
+		processParameter(inputPackage)
 	case ANNOUNCE:
 		var (
 			sdpSession sdp.Session
 		)
 		session.SessionType = PusherClient
+		pps := new(PusherPullersSession)
+		session.PusherPullersSessionMapMutex.Lock()
 		if _, ok := session.PusherPullersSessionMap[session.RtspURL.Path]; ok {
+			session.PusherPullersSessionMapMutex.Unlock()
 			inputPackage.ResponseInfo.Error = Forbidden
 			return fmt.Errorf("pusher's request's url already used")
 		}
-		pps := new(PusherPullersSession)
-		session.PusherPullersSessionMapMutex.Lock()
 		session.PusherPullersSessionMap[session.RtspURL.Path] = pps
 		session.PusherPullersSessionMapMutex.Unlock()
 		session.ReourcePath = session.RtspURL.Path
@@ -263,97 +250,192 @@ func (session *NetSession) ProcessPackage(pack interface{}) error {
 		pps.SdpContent = &sdpC
 
 		if err := session.ProcessSdpMessage(sdpMessage, inputPackage, pps); err != nil {
-			inputPackage.ResponseInfo.Error = InternalServerError
+			if inputPackage.ResponseInfo.Error == "" {
+				inputPackage.ResponseInfo.Error = InternalServerError
+			}
 			return fmt.Errorf("ProcessSdpMessage error:%v", err)
 		}
+		if strings.Contains(sdpC, "a=crypto:") {
+			// srtp keys of server for pusher's RTP/SAVP tracks are answered
+			var content string
+			content, session.srtpKeys = secureSdp(sdpC, true)
+			inputPackage.ResponseInfo.AnnounceContent =
+				fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %v\r\n\r\n%v",
+					len(content), content)
+		}
 	case SETUP:
 		/*
 			setup the udp/tcp connection for audio/video media in rtp/rtcp protocol
 
 			if udp and puller,start two connections to puller
+			if tcp,rtp/rtcp will be interleaved in rtsp connection
 		*/
-		if transport, ok := inputPackage.RtspHeaderMap["Transport"]; ok {
-			if tcpChannelMatcher :=
-				regexp.MustCompile("interleaved=(\\d+)(-(\\d+))?").
-					FindStringSubmatch(transport); tcpChannelMatcher != nil {
-				session.RtpChannel, _ = strconv.Atoi(tcpChannelMatcher[1])
-				session.RtcpChannel, _ = strconv.Atoi(tcpChannelMatcher[3])
-				inputPackage.ResponseInfo.Error = Ok
-			} else if udpChannelMatcher :=
-				regexp.MustCompile("client_port=(\\d+)(-(\\d+))?").
-					FindStringSubmatch(transport); udpChannelMatcher != nil {
-				var rtpPort = new(string)
-				var rtcpPort = new(string)
-				*rtpPort = udpChannelMatcher[1]
-				*rtcpPort = udpChannelMatcher[3]
-				var (
-					mediaType    MediaType
-					mediaName    string
-					resourcePath string
-				)
-				if index := strings.Index(session.RtspURL.Path, "/streamid="); index != -1 {
-					resourcePath = session.RtspURL.Path[:index]
-				} else {
-					resourcePath = session.RtspURL.Path
-				}
-				pps, ok := session.PusherPullersSessionMap[resourcePath]
-				if !ok {
-					inputPackage.ResponseInfo.Error = InternalServerError
-					return fmt.Errorf("not find pusher-puller-session of url:%v",
-						session.RtspURL.Path)
-				}
-
-				if strings.Contains(inputPackage.URL, *pps.VideoStreamName) {
-					mediaType = MediaVideo
-					mediaName = "video"
-				}
-				if strings.Contains(inputPackage.URL, *pps.AudioStreamName) {
-					mediaType = MediaAudio
-					mediaName = "audio"
-				}
-				if err := pps.AddRtpRtcpSession(
-					session.SessionType,
-					mediaType,
-					rtpPort,
-					rtcpPort,
-					session.RemoteIP,
-					session.ID,
-				); err != nil {
-					inputPackage.ResponseInfo.Error = InternalServerError
-					return fmt.Errorf("AddRtpRtcpSession faied:%v", err)
-				}
-				if session.SessionType == PusherClient {
-					fmt.Printf("rtp server port for %v = %v,and rtcp port = %v\n",
-						mediaName, *rtpPort, *rtcpPort)
-					inputPackage.ResponseInfo.SetupTransport =
-						fmt.Sprintf("Transport: %v;server_port=%v-%v\n",
-							transport, *rtpPort, *rtcpPort)
-					inputPackage.ResponseInfo.Error = Ok
-				} else if session.SessionType == PullerClient {
-					fmt.Printf("connected to puller\n\trtp port for %v = %v,and rtcp port = %v\r\n",
-						mediaName, *rtpPort, *rtcpPort)
-					inputPackage.ResponseInfo.SetupTransport =
-						fmt.Sprintf("Transport: %v\r\n", transport)
-					inputPackage.ResponseInfo.Error = Ok
-				}
-			} else {
+		transport, ok := inputPackage.RtspHeaderMap["Transport"]
+		if !ok {
+			inputPackage.ResponseInfo.Error = UnsupportedTransport
+			break
+		}
+		var (
+			rtpPort         = new(string)
+			rtcpPort        = new(string)
+			interleavedInfo *InterleavedInfo
+			secure          = strings.Contains(transport, "RTP/SAVP")
+			multicast       = strings.Contains(transport, "multicast")
+		)
+		if secure && strings.Contains(transport, "/TCP") {
+			// media interleaved in rtsps is already encrypted by tls
+			inputPackage.ResponseInfo.Error = UnsupportedTransport
+			break
+		}
+		if multicast && (MulticastIPRange == "" || secure || session.SessionType == PusherClient) {
+			// keys of srtp are per rtsp session,and pushers send by unicast
+			inputPackage.ResponseInfo.Error = UnsupportedTransport
+			break
+		}
+		if tcpChannelMatcher :=
+			regexp.MustCompile("interleaved=(\\d+)(-(\\d+))?").
+				FindStringSubmatch(transport); tcpChannelMatcher != nil {
+			session.RtpChannel, _ = strconv.Atoi(tcpChannelMatcher[1])
+			if session.RtcpChannel, err =
+				strconv.Atoi(tcpChannelMatcher[3]); err != nil {
+				session.RtcpChannel = session.RtpChannel + 1
+			}
+		} else if strings.Contains(transport, "RTP/AVP/TCP") {
+			// client let server choose the channels,two channels for each track
+			session.RtpChannel = len(session.interleavedSessions)
+			session.RtcpChannel = session.RtpChannel + 1
+			transport = fmt.Sprintf("%v;interleaved=%v-%v",
+				transport, session.RtpChannel, session.RtcpChannel)
+		} else if multicast {
+			// group and ports are chosen by server
+		} else if udpChannelMatcher :=
+			regexp.MustCompile("client_port=(\\d+)(-(\\d+))?").
+				FindStringSubmatch(transport); udpChannelMatcher != nil {
+			*rtpPort = udpChannelMatcher[1]
+			*rtcpPort = udpChannelMatcher[3]
+		} else {
+			inputPackage.ResponseInfo.Error = UnsupportedTransport
+			break
+		}
+		if strings.Contains(transport, "RTP/AVP/TCP") {
+			interleavedInfo = &InterleavedInfo{
+				Conn:        session.InterleavedConn,
+				RtpChannel:  session.RtpChannel,
+				RtcpChannel: session.RtcpChannel,
+			}
+		}
+		pps, resourcePath, control := session.findPusherPullersSession(session.RtspURL.Path)
+		if pps == nil {
+			inputPackage.ResponseInfo.Error = NotFound
+			return fmt.Errorf("not find pusher-puller-session of url:%v",
+				session.RtspURL.Path)
+		}
+		track := pps.FindTrack(control)
+		if track == nil {
+			inputPackage.ResponseInfo.Error = NotFound
+			return fmt.Errorf("not find track %v of url:%v", control, session.RtspURL.Path)
+		}
+		session.ReourcePath = resourcePath
+		mediaName := fmt.Sprintf("%v track %v", track.MediaType, track.Index)
+		var localKey, remoteKey []byte
+		if secure {
+			if localKey, remoteKey, err = session.cryptoKeys(track, inputPackage); err != nil {
+				fmt.Printf("cryptoKeys error:%v\n", err)
 				inputPackage.ResponseInfo.Error = UnsupportedTransport
+				break
+			}
+		}
+		var rrs *RtpRtcpSession
+		if multicast {
+			rrs, err = pps.AddMulticastPuller(track, session.ID, *session.RemoteIP)
+		} else {
+			rrs, err = pps.AddRtpRtcpSession(
+				session.SessionType,
+				track,
+				rtpPort,
+				rtcpPort,
+				session.RemoteIP,
+				session.ID,
+				interleavedInfo,
+			)
+		}
+		if err != nil {
+			inputPackage.ResponseInfo.Error = InternalServerError
+			return fmt.Errorf("AddRtpRtcpSession faied:%v", err)
+		}
+		if localKey != nil {
+			if err := rrs.EnableSrtp(localKey, remoteKey); err != nil {
+				inputPackage.ResponseInfo.Error = InternalServerError
+				return err
 			}
 		}
+		session.addRtpRtcpSession(rrs)
+		conn := session.Conn
+		rrs.disconnectHandler = func() { conn.Close() }
+		if interleavedInfo != nil {
+			if session.interleavedSessions == nil {
+				session.interleavedSessions = make(map[int]*RtpRtcpSession)
+			}
+			session.interleavedSessions[session.RtpChannel] = rrs
+			session.interleavedSessions[session.RtcpChannel] = rrs
+			fmt.Printf("rtp channel for %v = %v,and rtcp channel = %v\n",
+				mediaName, session.RtpChannel, session.RtcpChannel)
+			inputPackage.ResponseInfo.SetupTransport =
+				fmt.Sprintf("Transport: %v\r\n", transport)
+			inputPackage.ResponseInfo.Error = Ok
+		} else if multicast {
+			fmt.Printf("multicast puller of %v joined %v\n", mediaName, rrs.multicast.IP)
+			inputPackage.ResponseInfo.SetupTransport =
+				fmt.Sprintf("Transport: %v\r\n", rrs.multicast.transport())
+			inputPackage.ResponseInfo.Error = Ok
+		} else if session.SessionType == PusherClient {
+			fmt.Printf("rtp server port for %v = %v,and rtcp port = %v\n",
+				mediaName, *rtpPort, *rtcpPort)
+			inputPackage.ResponseInfo.SetupTransport =
+				fmt.Sprintf("Transport: %v;server_port=%v-%v\n",
+					transport, *rtpPort, *rtcpPort)
+			inputPackage.ResponseInfo.Error = Ok
+		} else if session.SessionType == PullerClient {
+			fmt.Printf("connected to puller\n\trtp port for %v = %v,and rtcp port = %v\r\n",
+				mediaName, *rtpPort, *rtcpPort)
+			inputPackage.ResponseInfo.SetupTransport =
+				fmt.Sprintf("Transport: %v\r\n", transport)
+			inputPackage.ResponseInfo.Error = Ok
+		}
 	case DESCRIBE:
 		session.SessionType = PullerClient
 		session.ReourcePath = session.RtspURL.Path
+		session.PusherPullersSessionMapMutex.Lock()
 		pps, ok := session.PusherPullersSessionMap[session.RtspURL.Path]
+		session.PusherPullersSessionMapMutex.Unlock()
+		if ok && !pps.Published() {
+			// sdp and tracks are still being set up by pusher
+			inputPackage.ResponseInfo.Error = NotFound
+			return fmt.Errorf("puller's request's url not published")
+		}
+		if !ok && MediaDirectory != "" {
+			if err := session.openVodPlayer(session.RtspURL.Path); err != nil {
+				inputPackage.ResponseInfo.Error = NotFound
+				return fmt.Errorf("openVodPlayer error:%v", err)
+			}
+			pps, ok = session.vodPlayer.Session, true
+		}
 		if !ok {
 			inputPackage.ResponseInfo.Error = Forbidden
 			return fmt.Errorf("puller's request's url not found")
 		}
+		// srtp keys are offered only over tls
+		var content string
+		content, session.srtpKeys = secureSdp(*pps.SdpContent, session.secure)
 		inputPackage.ResponseInfo.DescribeContent =
 			fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %v\r\n\r\n%v",
-				len(*pps.SdpContent), *pps.SdpContent)
+				len(content), content)
 	case TEARDOWN:
 	case PAUSE:
-		if pps, ok := session.PusherPullersSessionMap[session.ReourcePath]; ok {
+		if session.vodPlayer != nil {
+			session.vodPlayer.Pause()
+		}
+		if pps, ok := session.pusherPullersSession(); ok {
 			if errs := pps.PauseSession(&session.ID); len(errs) != 0 {
 				var returnErr error = nil
 				for index, err := range errs {
@@ -364,7 +446,13 @@ func (session *NetSession) ProcessPackage(pack interface{}) error {
 			}
 		}
 	case RECORD:
-		if pps, ok := session.PusherPullersSessionMap[session.ReourcePath]; ok {
+		if pps, ok := session.pusherPullersSession(); ok {
+			// tracks are all set up by pusher before RECORD
+			pps.setPublished()
+			if err := pps.StartRecord(session.ReourcePath); err != nil {
+				// stream is still forwarded without recording
+				fmt.Printf("StartRecord error:%v\n", err)
+			}
 			if errs := pps.StartSession(&session.ID); len(errs) != 0 {
 				var returnErr error = nil
 				for index, err := range errs {
@@ -375,7 +463,15 @@ func (session *NetSession) ProcessPackage(pack interface{}) error {
 			}
 		}
 	case PLAY:
-		if pps, ok := session.PusherPullersSessionMap[session.ReourcePath]; ok {
+		if session.vodPlayer != nil {
+			if err := session.playVod(inputPackage); err != nil {
+				return err
+			}
+		} else {
+			// live streams are played at normal rate
+			inputPackage.ResponseInfo.PlayInfo = scaleSpeedHeaders(inputPackage, 1, 1)
+		}
+		if pps, ok := session.pusherPullersSession(); ok {
 			if errs := pps.StartSession(&session.ID); len(errs) != 0 {
 				var returnErr error = nil
 				for index, err := range errs {
@@ -404,7 +500,7 @@ func (session *NetSession) WritePackage(pack interface{}) error {
 				outputPackage.Version,
 				outputPackage.ResponseInfo.Error,
 				seqNum,
-				session.ID,
+				session.sessionHeader(),
 			)
 		if outputPackage.Error == Ok {
 			switch outputPackage.Method {
@@ -414,22 +510,32 @@ func (session *NetSession) WritePackage(pack interface{}) error {
 				responseBuf += outputPackage.ResponseInfo.SetupTransport
 			case DESCRIBE:
 				responseBuf += outputPackage.ResponseInfo.DescribeContent
+			case ANNOUNCE:
+				responseBuf += outputPackage.ResponseInfo.AnnounceContent
+			case PLAY:
+				responseBuf += outputPackage.ResponseInfo.PlayInfo
 			}
+		} else if outputPackage.Error == Unauthorized {
+			responseBuf += outputPackage.ResponseInfo.Authenticate
 		}
-		if outputPackage.Method != DESCRIBE {
+		// content of successful DESCRIBE or ANNOUNCE ends with its own empty line
+		if outputPackage.Error != Ok || outputPackage.Method != DESCRIBE &&
+			(outputPackage.Method != ANNOUNCE || outputPackage.ResponseInfo.AnnounceContent == "") {
 			responseBuf += string("\r\n")
 		}
 		if sendNum, err :=
-			session.Bufio.WriteString(responseBuf); err != nil {
+			session.InterleavedConn.WriteString(responseBuf); err != nil {
 			return fmt.Errorf(`WritePackage's WriteString error,
 				error = %v,expected sended 
 				data number and real  = %v:%v`,
 				err, len(responseBuf), sendNum)
 		}
-		if err := session.Bufio.Flush(); err != nil {
-			return fmt.Errorf(`WritePackage's Flush error,error = %v`, err)
-		}
 		fmt.Printf("Writed to remote:\r\n%v", responseBuf)
+		if outputPackage.Method == PLAY && outputPackage.Error == Ok &&
+			session.vodPlayer != nil {
+			// packages of file follow PLAY response
+			session.vodPlayer.Start()
+		}
 		if outputPackage.Method == TEARDOWN {
 			return fmt.Errorf("TearDown,rtsp session id = %v", session.ID)
 		}
@@ -439,7 +545,17 @@ func (session *NetSession) WritePackage(pack interface{}) error {
 	return nil
 }
 
-//ProcessSdpMessage print sdp message content
+//dispatchFrame send interleaved frame to the rtp-rtcp-session owning its channel
+func (session *NetSession) dispatchFrame(frame *InterleavedFrame) error {
+	rrs, ok := session.interleavedSessions[frame.Channel]
+	if !ok {
+		return fmt.Errorf("not find rtp-rtcp-session of interleaved channel %v",
+			frame.Channel)
+	}
+	return rrs.ReceiveInterleaved(frame.Channel, frame.Data)
+}
+
+//ProcessSdpMessage print sdp message content,then make tracks from medias
 func (session *NetSession) ProcessSdpMessage(
 	sdpMessage *sdp.Message, rtspPackage *Package, pps *PusherPullersSession) error {
 	fmt.Println("URI", sdpMessage.URI)
@@ -468,20 +584,58 @@ func (session *NetSession) ProcessSdpMessage(
 		for bwType, bwValue := range media.Bandwidths {
 			fmt.Println("type =", bwType, "value =", bwValue)
 		}
-		asn := media.Attributes.Value("control")
-		switch media.Description.Type {
-		case "audio":
-			pps.AudioStreamName = &asn
-		case "video":
-			pps.VideoStreamName = &asn
-		default:
-			rtspPackage.Error = UnsupportedMediaType
-			return fmt.Errorf("Unsupported Media Type")
-		}
+	}
+	if err := pps.SetupTracks(session.ReourcePath); err != nil {
+		rtspPackage.Error = UnsupportedMediaType
+		return fmt.Errorf("SetupTracks error:%v", err)
 	}
 	return nil
 }
 
+//findPusherPullersSession find pusher-puller-session whose resource path is the
+//longest prefix of url path,return it with resource path and track path after it
+func (session *NetSession) findPusherPullersSession(
+	path string) (*PusherPullersSession, string, string) {
+	if player := session.vodPlayer; player != nil && strings.HasPrefix(path, player.ResourcePath) {
+		return player.Session, player.ResourcePath,
+			strings.Trim(path[len(player.ResourcePath):], "/")
+	}
+	session.PusherPullersSessionMapMutex.Lock()
+	defer session.PusherPullersSessionMapMutex.Unlock()
+	for resourcePath := path; resourcePath != ""; {
+		if pps, ok := session.PusherPullersSessionMap[resourcePath]; ok {
+			if session.SessionType == PusherClient && resourcePath != session.ReourcePath ||
+				session.SessionType == PullerClient && !pps.Published() {
+				// pushers set up the session they announced,pullers published ones
+				return nil, "", ""
+			}
+			return pps, resourcePath, strings.Trim(path[len(resourcePath):], "/")
+		}
+		if trimmed := strings.TrimSuffix(resourcePath, "/"); trimmed != resourcePath {
+			resourcePath = trimmed
+			continue
+		}
+		index := strings.LastIndex(resourcePath, "/")
+		if index < 0 {
+			break
+		}
+		resourcePath = resourcePath[:index]
+	}
+	return nil, "", ""
+}
+
+//pusherPullersSession pusher-pullers-session of ReourcePath,
+//the file's one if playing file
+func (session *NetSession) pusherPullersSession() (*PusherPullersSession, bool) {
+	if session.vodPlayer != nil {
+		return session.vodPlayer.Session, true
+	}
+	session.PusherPullersSessionMapMutex.Lock()
+	defer session.PusherPullersSessionMapMutex.Unlock()
+	pps, ok := session.PusherPullersSessionMap[session.ReourcePath]
+	return pps, ok
+}
+
 //CheckStateMachine check server state machine
 func (session *NetSession) CheckStateMachine(methodName string) bool {
 	if methodName != SETUP && methodName != TEARDOWN && methodName != PLAY &&
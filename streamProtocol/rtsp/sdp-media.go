@@ -0,0 +1,130 @@
+package rtsp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gortc.io/sdp"
+)
+
+//RtpMap payload format info from sdp rtpmap attribute(rfc4566 6)
+type RtpMap struct {
+	PayloadType  int    // rtp payload type
+	EncodingName string // encoding name in upper case,like H264
+	ClockRate    int    // rtp clock rate
+	Channels     int    // audio channels,1 if not given
+}
+
+//staticRtpMaps static payload types of rfc3551 which may omit rtpmap
+var staticRtpMaps = map[int]RtpMap{
+	0:  {0, "PCMU", 8000, 1},
+	3:  {3, "GSM", 8000, 1},
+	4:  {4, "G723", 8000, 1},
+	8:  {8, "PCMA", 8000, 1},
+	9:  {9, "G722", 8000, 1},
+	10: {10, "L16", 44100, 2},
+	11: {11, "L16", 44100, 1},
+	14: {14, "MPA", 90000, 1},
+	18: {18, "G729", 8000, 1},
+	26: {26, "JPEG", 90000, 1},
+	32: {32, "MPV", 90000, 1},
+	33: {33, "MP2T", 90000, 1},
+}
+
+//ParseRtpMap parse rtpmap of the first format of media
+func ParseRtpMap(media *sdp.Media) (*RtpMap, error) {
+	if len(media.Description.Formats) == 0 {
+		return nil, fmt.Errorf("media %v has no format", media.Description.Type)
+	}
+	payloadType, err := strconv.Atoi(media.Description.Formats[0])
+	if err != nil {
+		return nil, fmt.Errorf("media %v format %v invalid",
+			media.Description.Type, media.Description.Formats[0])
+	}
+	for _, value := range media.Attributes.Values("rtpmap") {
+		items := strings.SplitN(strings.TrimSpace(value), " ", 2)
+		if len(items) != 2 || items[0] != media.Description.Formats[0] {
+			continue
+		}
+		encoding := strings.Split(items[1], "/")
+		rtpMap := &RtpMap{
+			PayloadType:  payloadType,
+			EncodingName: strings.ToUpper(encoding[0]),
+			Channels:     1,
+		}
+		if len(encoding) > 1 {
+			if rtpMap.ClockRate, err = strconv.Atoi(encoding[1]); err != nil {
+				return nil, fmt.Errorf("rtpmap %v clock rate invalid", value)
+			}
+		}
+		if len(encoding) > 2 {
+			if rtpMap.Channels, err = strconv.Atoi(encoding[2]); err != nil {
+				return nil, fmt.Errorf("rtpmap %v channels invalid", value)
+			}
+		}
+		return rtpMap, nil
+	}
+	if rtpMap, ok := staticRtpMaps[payloadType]; ok {
+		return &rtpMap, nil
+	}
+	return nil, fmt.Errorf("rtpmap of payload type %v not found", payloadType)
+}
+
+//ParseMediaType media type of sdp media description,
+//types other than video and audio are handled as application data
+func ParseMediaType(mediaType string) MediaType {
+	switch mediaType {
+	case "video":
+		return MediaVideo
+	case "audio":
+		return MediaAudio
+	default:
+		return MediaApplication
+	}
+}
+
+//trackControl track path relative to resource path from control attribute,
+//SETUP url matches a track if its path after resource path equals this,
+//a media without control is "streamid=index",or "" if it is the only one
+func trackControl(media *sdp.Media, index, count int, resourcePath string) string {
+	control := media.Attributes.Value("control")
+	switch {
+	case control == "*" || (control == "" && count == 1):
+		return ""
+	case control == "":
+		return "streamid=" + strconv.Itoa(index)
+	case strings.Contains(control, "://"):
+		controlURL, err := url.Parse(control)
+		if err != nil {
+			return control
+		}
+		return strings.Trim(strings.TrimPrefix(controlURL.Path, resourcePath), "/")
+	}
+	return strings.Trim(control, "/")
+}
+
+//ParseFmtp parse fmtp parameters of the first format of media,
+//parameter names are in lower case
+func ParseFmtp(media *sdp.Media) map[string]string {
+	parameters := make(map[string]string)
+	if len(media.Description.Formats) == 0 {
+		return parameters
+	}
+	for _, value := range media.Attributes.Values("fmtp") {
+		items := strings.SplitN(strings.TrimSpace(value), " ", 2)
+		if len(items) != 2 || items[0] != media.Description.Formats[0] {
+			continue
+		}
+		for _, parameter := range strings.Split(items[1], ";") {
+			keyValue := strings.SplitN(strings.TrimSpace(parameter), "=", 2)
+			if len(keyValue) != 2 {
+				continue
+			}
+			parameters[strings.ToLower(strings.TrimSpace(keyValue[0]))] =
+				strings.TrimSpace(keyValue[1])
+		}
+	}
+	return parameters
+}
@@ -0,0 +1,176 @@
+package rtsp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/darunshen/go/streamProtocol/srtp"
+)
+
+//CryptoSuite the only srtp crypto suite of sdp crypto attributes(rfc4568 6.2)
+const CryptoSuite = "AES_CM_128_HMAC_SHA1_80"
+
+/*
+srtpContexts srtp of RTP/SAVP udp media keyed by sdes(rfc4568),each end
+encrypts packages it sends by its own master key,keys of pusher are in its
+ANNOUNCE sdp and answered by keys of server in ANNOUNCE response,keys of
+server are offered in DESCRIBE sdp and answered by key of puller in Crypto
+header of SETUP
+*/
+type srtpContexts struct {
+	in  *srtp.Context // decrypt packages from remote,nil if remote key unknown
+	out *srtp.Context // encrypt packages to remote
+}
+
+//newSrtpContexts make srtp contexts of local key encrypting and remote key
+//decrypting,keys are master key followed by master salt,remote key may be nil
+func newSrtpContexts(localKey, remoteKey []byte) (*srtpContexts, error) {
+	contexts := new(srtpContexts)
+	var err error
+	if contexts.out, err = newSrtpContext(localKey); err != nil {
+		return nil, err
+	}
+	if remoteKey != nil {
+		if contexts.in, err = newSrtpContext(remoteKey); err != nil {
+			return nil, err
+		}
+	}
+	return contexts, nil
+}
+
+//newSrtpContext make srtp context of master key followed by master salt
+func newSrtpContext(key []byte) (*srtp.Context, error) {
+	if len(key) != srtp.KeyLength+srtp.SaltLength {
+		return nil, fmt.Errorf("srtp key length %v invalid", len(key))
+	}
+	context, err := srtp.NewContext(key[:srtp.KeyLength], key[srtp.KeyLength:])
+	if err != nil {
+		return nil, fmt.Errorf("srtp.NewContext error:%v", err)
+	}
+	return context, nil
+}
+
+//protect encrypt package to remote,package is unchanged if contexts is nil
+func (contexts *srtpContexts) protect(packageType PackageType, data []byte) ([]byte, error) {
+	if contexts == nil {
+		return data, nil
+	}
+	if packageType == RtcpPackage {
+		return contexts.out.EncryptRTCP(data)
+	}
+	return contexts.out.EncryptRTP(data)
+}
+
+//unprotect decrypt package from remote,package is unchanged if contexts is nil
+func (contexts *srtpContexts) unprotect(packageType PackageType, data []byte) ([]byte, error) {
+	if contexts == nil {
+		return data, nil
+	}
+	if contexts.in == nil {
+		return nil, fmt.Errorf("srtp key of remote unknown")
+	}
+	if packageType == RtcpPackage {
+		return contexts.in.DecryptRTCP(data)
+	}
+	return contexts.in.DecryptRTP(data)
+}
+
+//NewCryptoKey random master key followed by master salt
+func NewCryptoKey() []byte {
+	key := make([]byte, srtp.KeyLength+srtp.SaltLength)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("rand.Read error:%v", err))
+	}
+	return key
+}
+
+//ParseCrypto master key followed by master salt of sdp crypto attribute
+//like "1 AES_CM_128_HMAC_SHA1_80 inline:base64key|2^20|1:32"
+func ParseCrypto(value string) ([]byte, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 || fields[1] != CryptoSuite {
+		return nil, fmt.Errorf("crypto %v not support", value)
+	}
+	keyParam := strings.SplitN(fields[2], ";", 2)[0]
+	if !strings.HasPrefix(keyParam, "inline:") {
+		return nil, fmt.Errorf("crypto key method of %v not support", value)
+	}
+	encoded := strings.SplitN(strings.TrimPrefix(keyParam, "inline:"), "|", 2)[0]
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		if key, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("crypto key %v invalid:%v", encoded, err)
+		}
+	}
+	if len(key) != srtp.KeyLength+srtp.SaltLength {
+		return nil, fmt.Errorf("crypto key length %v invalid", len(key))
+	}
+	return key, nil
+}
+
+//cryptoAttribute sdp crypto attribute line of key
+func cryptoAttribute(key []byte) string {
+	return "a=crypto:" + cryptoValue(key)
+}
+
+//cryptoValue value of sdp crypto attribute or Crypto header of key
+func cryptoValue(key []byte) string {
+	return fmt.Sprintf("1 %v inline:%v", CryptoSuite, base64.StdEncoding.EncodeToString(key))
+}
+
+/*
+secureSdp remove crypto attributes from sdp content,keys of pushers are not
+exposed to pullers,if secure,every media is offered as RTP/SAVP with a new key,
+keys are returned in media order,otherwise as RTP/AVP
+*/
+func secureSdp(content string, secure bool) (string, [][]byte) {
+	var keys [][]byte
+	lines := strings.Split(strings.TrimRight(content, "\r\n"), "\n")
+	output := make([]string, 0, len(lines))
+	endMedia := func() {
+		if secure && len(keys) > 0 {
+			output = append(output, cryptoAttribute(keys[len(keys)-1]))
+		}
+	}
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "a=crypto:"):
+			continue
+		case strings.HasPrefix(line, "m=") && secure:
+			endMedia()
+			keys = append(keys, NewCryptoKey())
+			line = strings.Replace(line, " RTP/AVP ", " RTP/SAVP ", 1)
+		case strings.HasPrefix(line, "m="):
+			line = strings.Replace(line, " RTP/SAVP ", " RTP/AVP ", 1)
+		}
+		output = append(output, line)
+	}
+	endMedia()
+	return strings.Join(output, "\r\n") + "\r\n", keys
+}
+
+//cryptoKeys srtp keys of track for RTP/SAVP,local key is the one answered in
+//ANNOUNCE or offered in DESCRIBE,remote key of pusher is from its sdp and
+//remote key of puller is from Crypto header of SETUP,nil if absent
+func (session *NetSession) cryptoKeys(track *PusherPullersPair,
+	inputPackage *Package) (localKey, remoteKey []byte, err error) {
+	if track.Index >= len(session.srtpKeys) {
+		return nil, nil, fmt.Errorf("no srtp key of track %v offered", track.Index)
+	}
+	localKey = session.srtpKeys[track.Index]
+	if session.SessionType == PusherClient {
+		if track.Media == nil {
+			return nil, nil, fmt.Errorf("no sdp media of track %v", track.Index)
+		}
+		remoteKey, err = ParseCrypto(track.Media.Attributes.Value("crypto"))
+	} else if crypto, ok := inputPackage.RtspHeaderMap["Crypto"]; ok {
+		remoteKey, err = ParseCrypto(crypto)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return localKey, remoteKey, nil
+}
@@ -0,0 +1,137 @@
+package rtsp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/dtls"
+	"github.com/darunshen/go/streamProtocol/internal/nettest"
+)
+
+func TestSecureSdp(t *testing.T) {
+	pusherSdp, keys := secureSdp(testSdp, true)
+	if len(keys) != 2 || strings.Count(pusherSdp, "m=video 0 RTP/SAVP 96\r\n") != 1 ||
+		strings.Count(pusherSdp, "a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:") != 2 {
+		t.Fatalf("secure sdp = %v", pusherSdp)
+	}
+	lines := strings.Split(pusherSdp, "\r\n")
+	for index, line := range lines {
+		if strings.HasPrefix(line, "m=audio") {
+			// crypto of video ends its media section
+			if key, err := ParseCrypto(strings.TrimPrefix(lines[index-1], "a=crypto:")); err != nil ||
+				!bytes.Equal(key, keys[0]) {
+				t.Errorf("crypto of video = %v,%v", key, err)
+			}
+		}
+	}
+	plainSdp, keys := secureSdp(pusherSdp, false)
+	if keys != nil || strings.Contains(plainSdp, "crypto") || strings.Contains(plainSdp, "SAVP") {
+		t.Errorf("plain sdp = %v", plainSdp)
+	}
+	if _, err := ParseCrypto("1 AES_CM_128_HMAC_SHA1_32 inline:" +
+		strings.Repeat("A", 40)); err == nil {
+		t.Errorf("ParseCrypto of unsupported suite should fail")
+	}
+}
+
+//startTLSTestServer start a rtsp server listening rtsp and rtsps on free local ports,
+//it's stopped by the caller
+func startTLSTestServer(t *testing.T) (*Server, string, string) {
+	certificate, err := dtls.GenerateCertificate()
+	if err != nil {
+		t.Fatalf("GenerateCertificate error:%v", err)
+	}
+	server := &Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certificate.DER},
+			PrivateKey:  certificate.PrivateKey,
+		}}},
+	}
+	listener, tlsListener := nettest.Listen(t), nettest.Listen(t)
+	go server.Serve(listener)
+	go server.ServeTLS(tlsListener)
+	return server, listener.Addr().String(), tlsListener.Addr().String()
+}
+
+func TestRtspsSrtp(t *testing.T) {
+	server, address, tlsAddress := startTLSTestServer(t)
+	defer server.Stop()
+	dial := func(scheme, address string) *Client {
+		client, err := NewClient(fmt.Sprintf("%v://%v/live/secure", scheme, address), TransportUDP)
+		if err != nil {
+			t.Fatalf("NewClient error:%v", err)
+		}
+		client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		if err := client.Dial(); err != nil {
+			t.Fatalf("Dial error:%v", err)
+		}
+		return client
+	}
+	pusher := dial("rtsps", tlsAddress)
+	defer pusher.Close()
+	pusher.Srtp = true
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+	if pusher.Tracks[0].CryptoKey == nil || pusher.Tracks[0].ServerCryptoKey == nil ||
+		bytes.Equal(pusher.Tracks[0].CryptoKey, pusher.Tracks[0].ServerCryptoKey) {
+		t.Fatalf("pusher not keyed by sdp and answer")
+	}
+
+	// keys are not offered over plain rtsp,and pusher's keys never leak
+	plain := dial("rtsp", address)
+	defer plain.Close()
+	response, err := plain.Describe()
+	if err != nil {
+		t.Fatalf("Describe error:%v", err)
+	}
+	if strings.Contains(string(response.Content), "crypto") {
+		t.Errorf("keys in sdp over plain rtsp:%v", string(response.Content))
+	}
+
+	puller := dial("rtsps", tlsAddress)
+	defer puller.Close()
+	received := make(chan RtpRtcpPackage, 10)
+	puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+		if trackIndex == 0 && packageType == RtpPackage {
+			received <- data
+		}
+	}
+	if err := puller.StartPull(); err != nil {
+		t.Fatalf("StartPull error:%v", err)
+	}
+	if puller.Tracks[0].ServerCryptoKey == nil ||
+		bytes.Equal(puller.Tracks[0].ServerCryptoKey, pusher.Tracks[0].CryptoKey) ||
+		bytes.Equal(puller.Tracks[0].ServerCryptoKey, puller.Tracks[0].CryptoKey) {
+		t.Fatalf("puller not keyed by its own key")
+	}
+	// rtcp of puller is decrypted by its own key at server
+	for _, puller := range server.FindPublished("/live/secure").Tracks[0].pullers() {
+		if puller.secure == nil || puller.secure.in == nil {
+			t.Errorf("key of puller not answered in SETUP")
+		}
+	}
+	packet := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0x65, 0x88}
+	deadline := time.After(5 * time.Second)
+	for sequence := 1; ; sequence++ {
+		packet[3] = byte(sequence)
+		if err := pusher.PushPackage(0, RtpPackage, packet); err != nil {
+			t.Fatalf("PushPackage error:%v", err)
+		}
+		select {
+		case data := <-received:
+			// decrypted by puller's key after authentication
+			if !bytes.Equal(data[12:], packet[12:]) {
+				t.Errorf("received %v, want %v", data, packet)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("puller not received package")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package rtsp
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/ts"
+)
+
+//TSMuxer subscribe frames of a pusher-pullers-session and turn them into
+//mpeg-ts,every segment begins with PAT,PMT and a keyframe of the first video
+//track(primary track),or the first audio track if no video,
+//callbacks are called in muxer goroutine
+type TSMuxer struct {
+	SegmentDuration    time.Duration // a new segment begins at the first sync frame after it
+	MaxSegmentDuration time.Duration // a new segment begins at any frame before it is exceeded,0 if no limit
+	/*
+		OnSegment a new segment begins,duration is of the last one,independent is
+		if the new one begins with a sync frame,frames are dropped if error
+	*/
+	OnSegment    func(duration time.Duration, independent bool) error
+	OnData       func(data []byte) error // packets of current segment,data is reused after return
+	tracks       []*tsTrack              // tracks with supported codecs
+	primary      *tsTrack                // track deciding segments
+	queue        *frameQueue             // frames of tracks
+	muxer        *ts.Muxer
+	buffer       bytes.Buffer // packets written by muxer
+	segmentOpen  bool         // if OnSegment succeeded
+	startTime    time.Time    // arrival time of the first frame muxed
+	segmentStart uint64       // decode time of primary track when segment began
+}
+
+//tsTrack a track being muxed
+type tsTrack struct {
+	track          *ts.Track
+	clock          frameClock
+	lastDecodeTime uint64 // decode time of the previous frame
+}
+
+//NewTSMuxer make a muxer of tracks with supported codecs in session,
+//handlerID should be unique among frame handlers of the session
+func NewTSMuxer(session *PusherPullersSession, handlerID string) (*TSMuxer, error) {
+	muxer := &TSMuxer{SegmentDuration: time.Hour}
+	pairs := make([]*PusherPullersPair, 0, len(session.Tracks))
+	tracks := make([]*ts.Track, 0, len(session.Tracks))
+	for _, pair := range session.Tracks {
+		track := new(ts.Track)
+		switch codec := pair.codec.(type) {
+		case *h264Codec:
+			track.Codec = ts.CodecH264
+		case *h265Codec:
+			track.Codec = ts.CodecH265
+		case *aacCodec:
+			track.Codec = ts.CodecAAC
+			track.AudioConfig = codec.Config
+		case *opusCodec:
+			track.Codec = ts.CodecOpus
+			track.ChannelCount = codec.Channels
+		default:
+			continue
+		}
+		if pair.ClockRate == 0 {
+			continue
+		}
+		tsTrack := &tsTrack{track: track, clock: frameClock{clockRate: uint32(pair.ClockRate)}}
+		muxer.tracks = append(muxer.tracks, tsTrack)
+		if muxer.primary == nil ||
+			(!muxer.primary.track.Codec.IsVideo() && track.Codec.IsVideo()) {
+			muxer.primary = tsTrack
+		}
+		pairs = append(pairs, pair)
+		tracks = append(tracks, track)
+	}
+	if len(muxer.tracks) == 0 {
+		return nil, fmt.Errorf("NewTSMuxer error: no track can be muxed")
+	}
+	muxer.queue = newFrameQueue(handlerID, pairs)
+	muxer.muxer = ts.NewMuxer(&muxer.buffer, tracks)
+	return muxer, nil
+}
+
+//Start subscribe frames of tracks and begin muxing
+func (muxer *TSMuxer) Start() error {
+	return muxer.queue.start(muxer.writeFrame, func() {})
+}
+
+//Close unsubscribe frames and mux frames left
+func (muxer *TSMuxer) Close() {
+	muxer.queue.close()
+}
+
+//writeFrame write frame as a PES packet,begin new segment at sync frames of primary track
+func (muxer *TSMuxer) writeFrame(item *queuedFrame) {
+	track, frame := muxer.tracks[item.index], item.frame
+	isSync := frame.IsKeyframe || !track.track.Codec.IsVideo()
+	if !muxer.segmentOpen && (track != muxer.primary || !isSync) {
+		return
+	}
+	if muxer.startTime.IsZero() {
+		muxer.startTime = item.arrival
+	}
+	decodeTime := track.clock.decode(frame.Timestamp, item.arrival, muxer.startTime)
+	if track == muxer.primary {
+		elapsed := track.clock.durationOf(decodeTime - muxer.segmentStart)
+		// the frame would make segment longer than MaxSegmentDuration
+		overflow := muxer.MaxSegmentDuration != 0 && elapsed != 0 && elapsed+
+			track.clock.durationOf(decodeTime-track.lastDecodeTime) > muxer.MaxSegmentDuration
+		track.lastDecodeTime = decodeTime
+		if !muxer.segmentOpen || isSync && elapsed >= muxer.SegmentDuration || overflow {
+			if err := muxer.beginSegment(decodeTime, elapsed, isSync); err != nil {
+				fmt.Printf("TSMuxer beginSegment error:%v\n", err)
+				return
+			}
+		}
+	}
+	timestamp := decodeTime * ts.ClockRate / uint64(track.clock.clockRate)
+	if err := muxer.muxer.WriteFrame(track.track, timestamp, timestamp,
+		frame.Units, frame.IsKeyframe); err != nil {
+		fmt.Printf("TSMuxer WriteFrame error:%v\n", err)
+	}
+	if err := muxer.flushData(); err != nil {
+		fmt.Printf("TSMuxer flushData error:%v\n", err)
+	}
+}
+
+//beginSegment call OnSegment and write PAT and PMT
+func (muxer *TSMuxer) beginSegment(decodeTime uint64, elapsed time.Duration,
+	independent bool) error {
+	if !muxer.segmentOpen {
+		elapsed = 0
+	}
+	muxer.segmentOpen = false
+	muxer.segmentStart = decodeTime
+	if muxer.OnSegment != nil {
+		if err := muxer.OnSegment(elapsed, independent); err != nil {
+			return err
+		}
+	}
+	muxer.segmentOpen = true
+	return muxer.muxer.WriteTables()
+}
+
+//flushData pass packets written to OnData
+func (muxer *TSMuxer) flushData() error {
+	defer muxer.buffer.Reset()
+	if muxer.buffer.Len() == 0 || muxer.OnData == nil {
+		return nil
+	}
+	return muxer.OnData(muxer.buffer.Bytes())
+}
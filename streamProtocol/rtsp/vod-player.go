@@ -0,0 +1,594 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/mp4"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+// video-on-demand settings,playing files is disabled if MediaDirectory is empty
+var (
+	/*
+		MediaDirectory directory of mp4 files played on demand,
+		url path /movies/a.mp4 plays {MediaDirectory}/movies/a.mp4
+		if no pusher publishes it
+	*/
+	MediaDirectory string = ""
+	//MaxScale max absolute value of Scale applied to playing files
+	MaxScale = 16.0
+	//MaxSpeed max Speed applied to playing files
+	MaxSpeed = 4.0
+)
+
+//vodPusherID rtsp session id of pusher of tracks played from file
+const vodPusherID = "vod"
+
+/*
+VodPlayer play a mp4 file to the pullers of one rtsp session,
+its pusher-pullers-session is not in PusherPullersSessionMap,
+so every rtsp session has its own position,samples are packetized
+and sent when their decode time comes
+*/
+type VodPlayer struct {
+	ResourcePath string                // url path of file
+	Session      *PusherPullersSession // tracks of file set up by puller
+	Duration     time.Duration         // duration of the longest track
+	file         *os.File
+	demuxer      *mp4.Demuxer
+	tracks       []*vodTrack   // tracks in sdp order
+	position     time.Duration // npt where playing starts or paused
+	scale, speed float64       // applied Scale and Speed of the last PLAY
+	stop         chan struct{} // closed to stop playing goroutine,nil if not playing
+	done         chan struct{} // closed when playing goroutine ends
+	mutex        sync.Mutex    // provide state's atom among Play,Pause and Close
+}
+
+//vodTrack a track of file played by VodPlayer
+type vodTrack struct {
+	pair      *PusherPullersPair
+	track     *mp4.DemuxTrack
+	demuxer   *mp4.Demuxer // reads samples of track
+	next      int          // index of the next sample to send,out of samples if all sent
+	rtpOffset uint32       // rtp timestamp of presentation time 0
+}
+
+//OpenVodPlayer open file of url path under MediaDirectory and make tracks
+//of its h264,h265,aac and opus tracks
+func OpenVodPlayer(resourcePath string) (*VodPlayer, error) {
+	if MediaDirectory == "" {
+		return nil, fmt.Errorf("media directory not set")
+	}
+	// path.Clean of rooted path removes .. elements out of directory
+	filePath := filepath.Join(MediaDirectory, filepath.FromSlash(path.Clean("/"+resourcePath)))
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open media file error:%v", err)
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("media file %v not a regular file", filePath)
+	}
+	demuxer, err := mp4.NewDemuxer(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("NewDemuxer of %v error:%v", filePath, err)
+	}
+	player := &VodPlayer{
+		ResourcePath: resourcePath,
+		file:         file,
+		demuxer:      demuxer,
+		scale:        1,
+		speed:        1,
+	}
+	sdpContent := player.sdp()
+	if player.Session, err = newPusherPullersSession(resourcePath, sdpContent); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for index, pair := range player.Session.Tracks {
+		if pair.codec == nil {
+			file.Close()
+			return nil, fmt.Errorf("track %v of %v has no codec", pair.Control, filePath)
+		}
+		player.tracks[index].pair = pair
+	}
+	for _, pair := range player.Session.Tracks {
+		// pusher without connections,only marks the track published
+		pair.Pusher = &RtpRtcpSession{
+			RtspSessionID:     vodPusherID,
+			SessionMediaType:  pair.MediaType,
+			SessionClientType: PusherClient,
+		}
+		if err := pair.StartDispatch(); err != nil {
+			player.Close()
+			return nil, err
+		}
+	}
+	player.Session.setPublished()
+	return player, nil
+}
+
+//sdp make sdp of supported tracks of file,tracks are kept in the same order
+func (player *VodPlayer) sdp() string {
+	var builder strings.Builder
+	for _, track := range player.demuxer.Tracks {
+		if duration := time.Duration(track.Duration) * time.Second /
+			time.Duration(track.TimeScale); duration > player.Duration {
+			player.Duration = duration
+		}
+	}
+	fmt.Fprintf(&builder, "v=0\r\n"+
+		"o=- 0 0 IN IP4 127.0.0.1\r\n"+
+		"s=%v\r\n"+
+		"c=IN IP4 0.0.0.0\r\n"+
+		"t=0 0\r\n"+
+		"a=range:npt=0-%.3f\r\n", path.Base(player.ResourcePath), player.Duration.Seconds())
+	for _, track := range player.demuxer.Tracks {
+		payloadType := 96 + len(player.tracks)
+		switch track.Codec {
+		case mp4.CodecH264:
+			fmt.Fprintf(&builder, "m=video 0 RTP/AVP %v\r\n"+
+				"a=rtpmap:%v H264/90000\r\n"+
+				"a=fmtp:%v packetization-mode=1;profile-level-id=%v;sprop-parameter-sets=%v\r\n",
+				payloadType, payloadType, payloadType,
+				h264.ProfileLevelID(track.SPS), h264.SpropParameterSets(track.SPS, track.PPS))
+		case mp4.CodecH265:
+			fmt.Fprintf(&builder, "m=video 0 RTP/AVP %v\r\n"+
+				"a=rtpmap:%v H265/90000\r\n"+
+				"a=fmtp:%v sprop-vps=%v;sprop-sps=%v;sprop-pps=%v\r\n",
+				payloadType, payloadType, payloadType,
+				base64.StdEncoding.EncodeToString(track.VPS),
+				base64.StdEncoding.EncodeToString(track.SPS),
+				base64.StdEncoding.EncodeToString(track.PPS))
+		case mp4.CodecAAC:
+			config := new(aac.AudioSpecificConfig)
+			if err := config.Unmarshal(track.AudioConfig); err != nil {
+				fmt.Printf("track %v aac config error:%v\n", track.ID, err)
+				continue
+			}
+			fmt.Fprintf(&builder, "m=audio 0 RTP/AVP %v\r\n"+
+				"a=rtpmap:%v MPEG4-GENERIC/%v/%v\r\n"+
+				"a=fmtp:%v profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;"+
+				"indexdeltalength=3;config=%x\r\n",
+				payloadType, payloadType, config.SampleRate, config.ChannelCount,
+				payloadType, track.AudioConfig)
+		case mp4.CodecOpus:
+			fmt.Fprintf(&builder, "m=audio 0 RTP/AVP %v\r\n"+
+				"a=rtpmap:%v OPUS/48000/2\r\n",
+				payloadType, payloadType)
+		default:
+			continue
+		}
+		fmt.Fprintf(&builder, "a=control:streamid=%v\r\n", len(player.tracks))
+		player.tracks = append(player.tracks, &vodTrack{
+			track:     track,
+			demuxer:   player.demuxer,
+			rtpOffset: rand.Uint32(),
+		})
+	}
+	return builder.String()
+}
+
+/*
+Play prepare playing from start if seek,or from the paused position,
+playing begins when Start is called after PLAY response,
+scale is the rate of npt to wallclock,negative for reverse,speed is the rate
+of delivery(rfc2326 12.34 and 12.35),they are limited by MaxScale and MaxSpeed,
+return npt where playing starts,scale and speed applied and RTP-Info of tracks,
+video is moved back to the sync sample before start
+*/
+func (player *VodPlayer) Play(start time.Duration, seek bool,
+	scale, speed float64) (*VodPlayInfo, error) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.stopPlaying()
+	if seek && (start < 0 || start > player.Duration) {
+		return nil, fmt.Errorf("npt %v out of range %v", start, player.Duration)
+	}
+	if scale == 0 || speed <= 0 {
+		return nil, fmt.Errorf("scale %v or speed %v invalid", scale, speed)
+	}
+	scale = math.Max(-MaxScale, math.Min(MaxScale, scale))
+	speed = math.Min(MaxSpeed, speed)
+	if !seek && scale != player.scale {
+		// tracks sent and their order change with scale
+		start, seek = player.position, true
+	}
+	player.scale, player.speed = scale, speed
+	if seek {
+		player.seek(start)
+	}
+	info := &VodPlayInfo{
+		Start:    player.position,
+		End:      player.Duration,
+		Scale:    scale,
+		Speed:    speed,
+		RtpInfos: make([]string, 0, len(player.tracks)),
+	}
+	if scale < 0 {
+		info.End = 0
+	}
+	for _, track := range player.tracks {
+		info.RtpInfos = append(info.RtpInfos, fmt.Sprintf("seq=%v;rtptime=%v",
+			track.pair.codec.SequenceNumber(), track.rtpTimestamp(player.position)))
+	}
+	return info, nil
+}
+
+//VodPlayInfo values of PLAY response of file
+type VodPlayInfo struct {
+	Start, End   time.Duration // Range of playing,End is 0 if reverse
+	Scale, Speed float64       // applied scale and speed
+	RtpInfos     []string      // seq and rtptime of tracks
+}
+
+//Start begin sending samples prepared by Play
+func (player *VodPlayer) Start() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	if player.stop != nil {
+		return
+	}
+	now := time.Now()
+	for _, track := range player.tracks {
+		track.pair.SetTimeReference(now, track.rtpTimestamp(player.position))
+	}
+	player.stop, player.done = make(chan struct{}), make(chan struct{})
+	go player.run(player.position, player.stop, player.done)
+}
+
+//Pause stop sending samples,the position of the next sample is kept
+func (player *VodPlayer) Pause() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.stopPlaying()
+}
+
+//Close stop playing and pullers,then close file
+func (player *VodPlayer) Close() error {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.stopPlaying()
+	pusherID := vodPusherID
+	var returnErr error
+	if errs := player.Session.StopSession(&pusherID); len(errs) != 0 {
+		for index, err := range errs {
+			returnErr = fmt.Errorf("%v\nindex = %v,error = %v", returnErr, index, err)
+		}
+	}
+	if err := player.file.Close(); err != nil {
+		returnErr = fmt.Errorf("%v\nClose error = %v", returnErr, err)
+	}
+	return returnErr
+}
+
+//stopPlaying stop playing goroutine and keep npt of the next sample as position
+func (player *VodPlayer) stopPlaying() {
+	if player.stop == nil {
+		return
+	}
+	close(player.stop)
+	<-player.done
+	player.stop, player.done = nil, nil
+	if next := player.nextTrack(); next != nil {
+		player.position = next.decodeTime(next.next)
+	} else if player.scale > 0 {
+		player.position = player.Duration
+	} else {
+		player.position = 0
+	}
+}
+
+//seek move tracks to samples from start,start of the first video track is
+//moved back to its sync sample,other tracks start from samples after it
+func (player *VodPlayer) seek(start time.Duration) {
+	var primary *vodTrack
+	for _, track := range player.tracks {
+		if !track.track.Codec.IsVideo() {
+			continue
+		}
+		primary, track.next = track, 0
+		for index := range track.track.Samples {
+			if track.decodeTime(index) > start {
+				break
+			}
+			if track.track.Samples[index].IsSync {
+				track.next = index
+			}
+		}
+		if len(track.track.Samples) > 0 {
+			start = track.decodeTime(track.next)
+		}
+		break
+	}
+	for _, track := range player.tracks {
+		if track == primary {
+			continue
+		}
+		track.next = len(track.track.Samples)
+		for index := range track.track.Samples {
+			if track.decodeTime(index) >= start {
+				track.next = index
+				break
+			}
+		}
+	}
+	player.position = start
+}
+
+//keyframesOnly if only sync samples of video are sent,
+//for fast forward and reverse
+func (player *VodPlayer) keyframesOnly() bool {
+	return player.scale < 0 || player.scale > 1
+}
+
+//nextTrack active track whose next sample is the nearest to position in
+//playing direction,audio is not sent if scale is not 1,nil if all sent
+func (player *VodPlayer) nextTrack() *vodTrack {
+	var next *vodTrack
+	for _, track := range player.tracks {
+		if track.next < 0 || track.next >= len(track.track.Samples) ||
+			(player.scale != 1 && !track.track.Codec.IsVideo()) {
+			continue
+		}
+		if next == nil || (player.scale > 0 &&
+			track.decodeTime(track.next) < next.decodeTime(next.next)) ||
+			(player.scale < 0 && track.decodeTime(track.next) > next.decodeTime(next.next)) {
+			next = track
+		}
+	}
+	return next
+}
+
+//run send samples of tracks when their time comes,
+//position is npt of now
+func (player *VodPlayer) run(position time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	begin := time.Now()
+	rate := math.Abs(player.scale) * player.speed
+	for {
+		next := player.nextTrack()
+		if next == nil {
+			fmt.Printf("vod %v played to the end\n", player.ResourcePath)
+			return
+		}
+		distance := next.decodeTime(next.next) - position
+		if player.scale < 0 {
+			distance = -distance
+		}
+		wait := time.Duration(float64(distance)/rate) - time.Since(begin)
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		sample := next.track.Samples[next.next]
+		// timestamps follow wallclock at normal delivery if scale is not 1
+		timestamp := next.rtpTimestamp(position +
+			time.Duration(float64(distance)/math.Abs(player.scale)))
+		if player.scale == 1 {
+			timestamp = next.rtpOffset + uint32((int64(sample.DecodeTime)+
+				int64(sample.CompositionOffset))*int64(next.pair.ClockRate)/
+				int64(next.track.TimeScale))
+		}
+		packets, err := next.packetize(sample, timestamp)
+		if err != nil {
+			fmt.Printf("vod %v sample %v error:%v\n", player.ResourcePath, next.next, err)
+		}
+		for _, packet := range packets {
+			if err := next.pair.PublishPacket(packet); err != nil {
+				fmt.Printf("vod %v error:%v\n", player.ResourcePath, err)
+				return
+			}
+		}
+		next.advance(player.scale, player.keyframesOnly())
+	}
+}
+
+//advance move to the next sample to send in playing direction,
+//next is out of samples if all sent
+func (track *vodTrack) advance(scale float64, keyframesOnly bool) {
+	step := 1
+	if scale < 0 {
+		step = -1
+	}
+	for track.next += step; keyframesOnly && track.next >= 0 &&
+		track.next < len(track.track.Samples); track.next += step {
+		if track.track.Samples[track.next].IsSync {
+			break
+		}
+	}
+}
+
+//decodeTime decode time of sample at index in npt
+func (track *vodTrack) decodeTime(index int) time.Duration {
+	return time.Duration(track.track.Samples[index].DecodeTime) * time.Second /
+		time.Duration(track.track.TimeScale)
+}
+
+//rtpTimestamp rtp timestamp of npt
+func (track *vodTrack) rtpTimestamp(npt time.Duration) uint32 {
+	return track.rtpOffset + uint32(int64(npt)*int64(track.pair.ClockRate)/int64(time.Second))
+}
+
+//packetize read sample and make its rtp packages with timestamp
+func (track *vodTrack) packetize(sample *mp4.SampleInfo, timestamp uint32) ([]*rtp.Packet, error) {
+	data, err := track.demuxer.ReadSample(sample)
+	if err != nil {
+		return nil, err
+	}
+	frame := &Frame{
+		MediaType:  track.pair.MediaType,
+		Codec:      track.pair.RtpMap.EncodingName,
+		ClockRate:  track.pair.ClockRate,
+		Timestamp:  timestamp,
+		IsKeyframe: sample.IsSync,
+	}
+	if track.track.Codec.IsVideo() {
+		if frame.Units, err = splitNALUs(data, track.track.LengthSize); err != nil {
+			return nil, err
+		}
+		if sample.IsSync {
+			// parameter sets before keyframes,for pullers starting after seeking
+			parameterSets := [][]byte{track.track.SPS, track.track.PPS}
+			if track.track.Codec == mp4.CodecH265 {
+				parameterSets = [][]byte{track.track.VPS, track.track.SPS, track.track.PPS}
+			}
+			frame.Units = append(parameterSets, frame.Units...)
+		}
+	} else {
+		frame.Units = [][]byte{data}
+		frame.Duration = sample.Duration
+	}
+	packets, err := track.pair.codec.Packetize(frame)
+	if err != nil {
+		return packets, fmt.Errorf("Packetize error:%v", err)
+	}
+	return packets, nil
+}
+
+//splitNALUs split length prefixed nal units of video sample
+func splitNALUs(data []byte, lengthSize int) ([][]byte, error) {
+	nalus := make([][]byte, 0, 4)
+	for offset := 0; offset < len(data); {
+		if offset+lengthSize > len(data) {
+			return nil, fmt.Errorf("nalu length out of range")
+		}
+		length := 0
+		for _, value := range data[offset : offset+lengthSize] {
+			length = length<<8 | int(value)
+		}
+		offset += lengthSize
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("nalu size %v out of range", length)
+		}
+		nalus = append(nalus, data[offset:offset+length])
+		offset += length
+	}
+	return nalus, nil
+}
+
+//ParseNptRange get start of Range header like npt=10.5- or npt=0:01:02-,
+//false if start is now or absent
+func ParseNptRange(value string) (time.Duration, bool, error) {
+	matcher := regexp.MustCompile(`npt\s*=\s*([^-\s;]*)\s*-`).FindStringSubmatch(value)
+	if matcher == nil {
+		return 0, false, fmt.Errorf("range %v not npt", value)
+	}
+	if matcher[1] == "" || matcher[1] == "now" {
+		return 0, false, nil
+	}
+	seconds := 0.0
+	for _, item := range strings.Split(matcher[1], ":") {
+		number, err := strconv.ParseFloat(item, 64)
+		if err != nil || number < 0 {
+			return 0, false, fmt.Errorf("npt %v invalid", matcher[1])
+		}
+		seconds = seconds*60 + number
+	}
+	return time.Duration(seconds * float64(time.Second)), true, nil
+}
+
+//openVodPlayer open file of resourcePath for DESCRIBE,
+//replacing the file described before
+func (session *NetSession) openVodPlayer(resourcePath string) error {
+	player, err := OpenVodPlayer(resourcePath)
+	if err != nil {
+		return err
+	}
+	if session.vodPlayer != nil {
+		if err := session.vodPlayer.Close(); err != nil {
+			fmt.Printf("VodPlayer Close error:%v\n", err)
+		}
+	}
+	session.vodPlayer = player
+	return nil
+}
+
+//playVod seek to the start of Range header,apply Scale and Speed headers,
+//then make Range,Scale,Speed and RTP-Info of PLAY response,
+//playing resumes from the paused position without Range
+func (session *NetSession) playVod(inputPackage *Package) error {
+	start, seek := time.Duration(0), false
+	if value, ok := inputPackage.RtspHeaderMap["Range"]; ok {
+		var err error
+		if start, seek, err = ParseNptRange(value); err != nil {
+			inputPackage.ResponseInfo.Error = BadRequest
+			return fmt.Errorf("ParseNptRange error:%v", err)
+		}
+	}
+	scale, speed, err := parseScaleSpeed(inputPackage)
+	if err != nil {
+		return err
+	}
+	info, err := session.vodPlayer.Play(start, seek, scale, speed)
+	if err != nil {
+		inputPackage.ResponseInfo.Error = InvalidRange
+		return fmt.Errorf("VodPlayer Play error:%v", err)
+	}
+	baseURL := strings.TrimSuffix(inputPackage.URL, "/")
+	for index, pair := range session.vodPlayer.Session.Tracks {
+		info.RtpInfos[index] = fmt.Sprintf("url=%v/%v;%v",
+			baseURL, pair.Control, info.RtpInfos[index])
+	}
+	inputPackage.ResponseInfo.PlayInfo = fmt.Sprintf("Range: npt=%.3f-%.3f\r\nRTP-Info: %v\r\n",
+		info.Start.Seconds(), info.End.Seconds(), strings.Join(info.RtpInfos, ","))
+	inputPackage.ResponseInfo.PlayInfo += scaleSpeedHeaders(inputPackage, info.Scale, info.Speed)
+	return nil
+}
+
+//parseScaleSpeed get Scale and Speed headers of PLAY,1 if absent
+func parseScaleSpeed(inputPackage *Package) (float64, float64, error) {
+	values := []float64{1, 1}
+	for index, name := range []string{"Scale", "Speed"} {
+		value, ok := inputPackage.RtspHeaderMap[name]
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || number == 0 || math.IsNaN(number) || math.IsInf(number, 0) ||
+			(name == "Speed" && number < 0) {
+			inputPackage.ResponseInfo.Error = BadRequest
+			return 0, 0, fmt.Errorf("%v %v invalid", name, value)
+		}
+		values[index] = number
+	}
+	return values[0], values[1], nil
+}
+
+//scaleSpeedHeaders Scale and Speed headers of PLAY response for those in request
+func scaleSpeedHeaders(inputPackage *Package, scale, speed float64) string {
+	headers := ""
+	if _, ok := inputPackage.RtspHeaderMap["Scale"]; ok {
+		headers += fmt.Sprintf("Scale: %v\r\n", strconv.FormatFloat(scale, 'f', -1, 64))
+	}
+	if _, ok := inputPackage.RtspHeaderMap["Speed"]; ok {
+		headers += fmt.Sprintf("Speed: %v\r\n", strconv.FormatFloat(speed, 'f', -1, 64))
+	}
+	return headers
+}
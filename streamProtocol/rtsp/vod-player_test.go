@@ -0,0 +1,265 @@
+package rtsp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/mp4"
+	"github.com/darunshen/go/streamProtocol/rtp"
+)
+
+//writeTestMedia write fragmented mp4 of 10 h264 frames at 25 fps with
+//keyframes at 0 and 0.2s,and 10 aac frames
+func writeTestMedia(t *testing.T, filePath string) {
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5,
+		0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0,
+		0x3c, 0x60, 0xc6, 0x58}
+	data, err := mp4.MarshalInit([]*mp4.Track{
+		{ID: 1, TimeScale: 90000, Codec: mp4.CodecH264, SPS: sps, PPS: []byte{0x68, 0xeb}},
+		{ID: 2, TimeScale: 44100, Codec: mp4.CodecAAC, SampleRate: 44100, ChannelCount: 2,
+			AudioConfig: []byte{0x12, 0x10}},
+	})
+	if err != nil {
+		t.Fatalf("MarshalInit error:%v", err)
+	}
+	video := &mp4.FragmentTrack{ID: 1}
+	audio := &mp4.FragmentTrack{ID: 2}
+	for i := 0; i < 10; i++ {
+		nalu := []byte{0, 0, 0, 2, 0x41, byte(i)}
+		if i%5 == 0 {
+			nalu[4] = 0x65
+		}
+		video.Samples = append(video.Samples,
+			&mp4.Sample{Duration: 3600, IsSync: i%5 == 0, Data: nalu})
+		audio.Samples = append(audio.Samples,
+			&mp4.Sample{Duration: 1024, IsSync: true, Data: []byte{0x21, byte(i)}})
+	}
+	data = append(data, mp4.MarshalFragment(1, []*mp4.FragmentTrack{video, audio})...)
+	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatalf("WriteFile error:%v", err)
+	}
+}
+
+//playInfo start npt in Range and rtptime of the first track in RTP-Info of response
+func playInfo(t *testing.T, response *Package) (string, uint32) {
+	matcher := regexp.MustCompile(`^url=[^;]*/streamid=0;seq=\d+;rtptime=(\d+),`).
+		FindStringSubmatch(response.RtspHeaderMap["RTP-Info"])
+	if matcher == nil {
+		t.Fatalf("RTP-Info = %v", response.RtspHeaderMap["RTP-Info"])
+	}
+	rtpTime, _ := strconv.ParseUint(matcher[1], 10, 32)
+	rangeValue := response.RtspHeaderMap["Range"]
+	if !strings.HasPrefix(rangeValue, "npt=") || !strings.HasSuffix(rangeValue, "-0.400") {
+		t.Fatalf("Range = %v", rangeValue)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(rangeValue, "npt="), "-0.400"), uint32(rtpTime)
+}
+
+func TestVodPlayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vod")
+	if err != nil {
+		t.Fatalf("TempDir error:%v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "movies"), 0755); err != nil {
+		t.Fatalf("Mkdir error:%v", err)
+	}
+	writeTestMedia(t, filepath.Join(dir, "movies", "test.mp4"))
+	MediaDirectory = dir
+	defer func() { MediaDirectory = "" }()
+	server, address := startTestServer(t)
+	defer server.Stop()
+
+	if _, err := OpenVodPlayer("/../movies/test.mp4/../../../etc/passwd"); err == nil {
+		t.Errorf("OpenVodPlayer out of media directory should fail")
+	}
+	puller, err := NewClient(fmt.Sprintf("rtsp://%v/movies/test.mp4", address), TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	received := make(chan *rtp.Packet, 100)
+	puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+		packet := new(rtp.Packet)
+		if trackIndex == 0 && packageType == RtpPackage && packet.Unmarshal(data) == nil {
+			received <- packet
+		}
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	response, err := puller.Describe()
+	if err != nil {
+		t.Fatalf("Describe error:%v", err)
+	}
+	if content := string(response.Content); !strings.Contains(content, "a=range:npt=0-0.400") ||
+		!strings.Contains(content, "H264/90000") || !strings.Contains(content, "config=1210") {
+		t.Fatalf("sdp = %v", content)
+	}
+	for index := range puller.Tracks {
+		if _, err := puller.Setup(index, "play"); err != nil {
+			t.Fatalf("Setup error:%v", err)
+		}
+	}
+	begin := time.Now()
+	if response, err = puller.Play(); err != nil {
+		t.Fatalf("Play error:%v", err)
+	}
+	if start, _ := playInfo(t, response); start != "0.000" {
+		t.Errorf("Range start = %v, want 0.000", start)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := puller.Pause(); err != nil {
+		t.Fatalf("Pause error:%v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if response, err = puller.PlayRange(""); err != nil {
+		t.Fatalf("PlayRange error:%v", err)
+	}
+	// paused at the samples after 0.1s,not played while pausing
+	if start, _ := playInfo(t, response); start < "0.100" || start > "0.200" {
+		t.Errorf("resumed at %v, want position of pausing", start)
+	}
+	frames := 0
+	for frames < 10 {
+		select {
+		case packet := <-received:
+			if packet.Marker {
+				frames++
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %v frames, want 10", frames)
+		}
+	}
+	// 0.36s of frames paced by timestamps and 0.1s paused
+	if elapsed := time.Since(begin); elapsed < 400*time.Millisecond {
+		t.Errorf("frames received in %v, not paced", elapsed)
+	}
+
+	if _, err := puller.Pause(); err != nil {
+		t.Fatalf("Pause error:%v", err)
+	}
+	for len(received) > 0 {
+		<-received
+	}
+	if response, err = puller.PlayRange("npt=0.3-"); err != nil {
+		t.Fatalf("PlayRange error:%v", err)
+	}
+	// seeking moves back to keyframe at 0.2s
+	start, rtpTime := playInfo(t, response)
+	if start != "0.200" {
+		t.Errorf("seeked to %v, want 0.200", start)
+	}
+	select {
+	case packet := <-received:
+		if packet.Timestamp != rtpTime {
+			t.Errorf("first timestamp after seeking = %v, want %v", packet.Timestamp, rtpTime)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no package after seeking")
+	}
+	if _, err := puller.Pause(); err != nil {
+		t.Fatalf("Pause error:%v", err)
+	}
+	if _, err := puller.PlayRange("npt=1-"); err == nil {
+		t.Errorf("PLAY out of range should fail")
+	}
+}
+
+//readTimestamps read timestamps of count frames of trick play,
+//parameter sets and slice of keyframe are aggregated in a STAP-A
+func readTimestamps(t *testing.T, received chan *rtp.Packet, count int) []uint32 {
+	timestamps := make([]uint32, 0, count)
+	for len(timestamps) < count {
+		select {
+		case packet := <-received:
+			if packet.Payload[0]&0x1f != 24 {
+				t.Fatalf("nalu type %v sent in trick play", packet.Payload[0]&0x1f)
+			}
+			timestamps = append(timestamps, packet.Timestamp)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %v keyframes, want %v", len(timestamps), count)
+		}
+	}
+	return timestamps
+}
+
+func TestVodScale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vod")
+	if err != nil {
+		t.Fatalf("TempDir error:%v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestMedia(t, filepath.Join(dir, "test.mp4"))
+	MediaDirectory = dir
+	defer func() { MediaDirectory = "" }()
+	server, address := startTestServer(t)
+	defer server.Stop()
+
+	puller, err := NewClient(fmt.Sprintf("rtsp://%v/test.mp4", address), TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	received := make(chan *rtp.Packet, 100)
+	audioReceived := make(chan bool, 100)
+	puller.OnPackage = func(trackIndex int, packageType PackageType, data RtpRtcpPackage) {
+		packet := new(rtp.Packet)
+		if packageType != RtpPackage || packet.Unmarshal(data) != nil {
+			return
+		}
+		if trackIndex == 0 {
+			received <- packet
+		} else {
+			audioReceived <- true
+		}
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	if _, err := puller.Describe(); err != nil {
+		t.Fatalf("Describe error:%v", err)
+	}
+	for index := range puller.Tracks {
+		if _, err := puller.Setup(index, "play"); err != nil {
+			t.Fatalf("Setup error:%v", err)
+		}
+	}
+	response, err := puller.PlayScale("npt=0-", 2, 1)
+	if err != nil {
+		t.Fatalf("PlayScale error:%v", err)
+	}
+	if response.RtspHeaderMap["Scale"] != "2" || response.RtspHeaderMap["Speed"] != "1" {
+		t.Errorf("Scale = %v,Speed = %v", response.RtspHeaderMap["Scale"],
+			response.RtspHeaderMap["Speed"])
+	}
+	// keyframes at 0 and 0.2s,timestamps follow delivery time
+	if timestamps := readTimestamps(t, received, 2); timestamps[1]-timestamps[0] != 9000 {
+		t.Errorf("keyframe timestamps %v, want delta 9000", timestamps)
+	}
+	if _, err := puller.Pause(); err != nil {
+		t.Fatalf("Pause error:%v", err)
+	}
+	if response, err = puller.PlayScale("npt=0.4-", -100, 2); err != nil {
+		t.Fatalf("PlayScale error:%v", err)
+	}
+	if response.RtspHeaderMap["Scale"] != "-16" || response.RtspHeaderMap["Speed"] != "2" ||
+		response.RtspHeaderMap["Range"] != "npt=0.200-0.000" {
+		t.Errorf("response of reverse = %v", response.RtspHeaderMap)
+	}
+	timestamps := readTimestamps(t, received, 2)
+	// 0.2s of npt in 1/16 of time
+	if delta := timestamps[1] - timestamps[0]; delta != 1125 {
+		t.Errorf("reverse keyframe timestamps %v, want delta 1125", timestamps)
+	}
+	if len(audioReceived) != 0 {
+		t.Errorf("audio sent in trick play")
+	}
+}
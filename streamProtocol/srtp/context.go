@@ -0,0 +1,275 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+//ProtectionProfile srtp protection profile of dtls use_srtp extension(rfc5764 4.1.2)
+type ProtectionProfile uint16
+
+const (
+	//ProfileAES128CMHMACSHA180 SRTP_AES128_CM_HMAC_SHA1_80
+	ProfileAES128CMHMACSHA180 ProtectionProfile = 0x0001
+)
+
+const (
+	//KeyLength master key length of ProfileAES128CMHMACSHA180
+	KeyLength = 16
+	//SaltLength master salt length of ProfileAES128CMHMACSHA180
+	SaltLength    = 14
+	authKeyLength = 20
+	authTagLength = 10
+	//srtcpIndexLength length of E flag and srtcp index
+	srtcpIndexLength = 4
+)
+
+//key derivation labels(rfc3711 4.3.2)
+const (
+	labelRtpEncryption  = 0
+	labelRtpAuth        = 1
+	labelRtpSalt        = 2
+	labelRtcpEncryption = 3
+	labelRtcpAuth       = 4
+	labelRtcpSalt       = 5
+)
+
+/*
+Context crypto context of one direction with AES_CM_128_HMAC_SHA1_80
+(rfc3711),key derivation rate is 0,rollover counters are kept per ssrc,
+replayed packets are not detected,it's safe for concurrent use
+*/
+type Context struct {
+	rtpBlock  cipher.Block
+	rtpSalt   []byte
+	rtpAuth   hash.Hash
+	rtcpBlock cipher.Block
+	rtcpSalt  []byte
+	rtcpAuth  hash.Hash
+	rtcpIndex uint32                  // index of the next srtcp packet sent
+	streams   map[uint32]*streamState // rollover states by ssrc
+	mutex     sync.Mutex
+}
+
+//streamState rollover state of a ssrc(rfc3711 3.3.1)
+type streamState struct {
+	rolloverCounter uint32
+	lastSequence    uint16
+	started         bool
+}
+
+//NewContext make context of master key and master salt
+func NewContext(masterKey, masterSalt []byte) (*Context, error) {
+	if len(masterKey) != KeyLength || len(masterSalt) != SaltLength {
+		return nil, fmt.Errorf("master key length %v or salt length %v invalid",
+			len(masterKey), len(masterSalt))
+	}
+	master, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher error:%v", err)
+	}
+	context := &Context{streams: make(map[uint32]*streamState)}
+	if context.rtpBlock, err = aes.NewCipher(
+		deriveKey(master, masterSalt, labelRtpEncryption, KeyLength)); err != nil {
+		return nil, fmt.Errorf("aes.NewCipher error:%v", err)
+	}
+	if context.rtcpBlock, err = aes.NewCipher(
+		deriveKey(master, masterSalt, labelRtcpEncryption, KeyLength)); err != nil {
+		return nil, fmt.Errorf("aes.NewCipher error:%v", err)
+	}
+	context.rtpSalt = deriveKey(master, masterSalt, labelRtpSalt, SaltLength)
+	context.rtcpSalt = deriveKey(master, masterSalt, labelRtcpSalt, SaltLength)
+	context.rtpAuth = hmac.New(sha1.New,
+		deriveKey(master, masterSalt, labelRtpAuth, authKeyLength))
+	context.rtcpAuth = hmac.New(sha1.New,
+		deriveKey(master, masterSalt, labelRtcpAuth, authKeyLength))
+	return context, nil
+}
+
+//deriveKey session key of label from master key,it's the aes-cm keystream of
+//iv (label||r) xor master salt,r is 0 as key derivation rate is 0(rfc3711 4.3.1)
+func deriveKey(master cipher.Block, masterSalt []byte, label byte, length int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+	key := make([]byte, length)
+	cipher.NewCTR(master, iv).XORKeyStream(key, key)
+	return key
+}
+
+//counter aes-cm iv of session salt,ssrc and packet index(rfc3711 4.1.1)
+func counter(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+	for offset := 0; offset < 4; offset++ {
+		iv[4+offset] ^= byte(ssrc >> uint(24-8*offset))
+	}
+	for offset := 0; offset < 6; offset++ {
+		iv[8+offset] ^= byte(index >> uint(40-8*offset))
+	}
+	return iv
+}
+
+//headerLength length of rtp header with csrc and header extension
+func headerLength(packet []byte) (int, error) {
+	if len(packet) < 12 {
+		return 0, fmt.Errorf("rtp packet too short:%v", len(packet))
+	}
+	length := 12 + 4*int(packet[0]&0x0f)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < length+4 {
+			return 0, fmt.Errorf("rtp header extension truncated")
+		}
+		length += 4 + 4*int(binary.BigEndian.Uint16(packet[length+2:]))
+	}
+	if len(packet) < length {
+		return 0, fmt.Errorf("rtp header truncated")
+	}
+	return length, nil
+}
+
+//estimate packet index of sequence number from rollover state(rfc3711 3.3.1)
+func (state *streamState) estimate(sequence uint16) uint32 {
+	switch {
+	case !state.started:
+		return state.rolloverCounter
+	case state.lastSequence < 1<<15:
+		if int(sequence)-int(state.lastSequence) > 1<<15 {
+			return state.rolloverCounter - 1
+		}
+	case int(state.lastSequence)-1<<15 > int(sequence):
+		return state.rolloverCounter + 1
+	}
+	return state.rolloverCounter
+}
+
+//update rollover state with index of authenticated packet
+func (state *streamState) update(rolloverCounter uint32, sequence uint16) {
+	switch {
+	case !state.started || rolloverCounter == state.rolloverCounter+1:
+		state.rolloverCounter, state.lastSequence = rolloverCounter, sequence
+	case rolloverCounter == state.rolloverCounter && sequence > state.lastSequence:
+		state.lastSequence = sequence
+	}
+	state.started = true
+}
+
+//stream rollover state of ssrc
+func (context *Context) stream(ssrc uint32) *streamState {
+	state, ok := context.streams[ssrc]
+	if !ok {
+		state = new(streamState)
+		context.streams[ssrc] = state
+	}
+	return state
+}
+
+//authTag truncated hmac-sha1 of data and rollover counter if rtp
+func authTag(auth hash.Hash, data []byte, rolloverCounter *uint32) []byte {
+	auth.Reset()
+	auth.Write(data)
+	if rolloverCounter != nil {
+		roc := make([]byte, 4)
+		binary.BigEndian.PutUint32(roc, *rolloverCounter)
+		auth.Write(roc)
+	}
+	return auth.Sum(nil)[:authTagLength]
+}
+
+//EncryptRTP protect rtp packet into a new srtp packet
+func (context *Context) EncryptRTP(packet []byte) ([]byte, error) {
+	length, err := headerLength(packet)
+	if err != nil {
+		return nil, err
+	}
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	sequence := binary.BigEndian.Uint16(packet[2:])
+	state := context.stream(ssrc)
+	rolloverCounter := state.estimate(sequence)
+	state.update(rolloverCounter, sequence)
+	output := make([]byte, len(packet), len(packet)+authTagLength)
+	copy(output, packet[:length])
+	cipher.NewCTR(context.rtpBlock, counter(context.rtpSalt, ssrc,
+		uint64(rolloverCounter)<<16|uint64(sequence))).
+		XORKeyStream(output[length:], packet[length:])
+	return append(output, authTag(context.rtpAuth, output, &rolloverCounter)...), nil
+}
+
+//DecryptRTP authenticate and decrypt srtp packet into a new rtp packet
+func (context *Context) DecryptRTP(packet []byte) ([]byte, error) {
+	length, err := headerLength(packet)
+	if err != nil {
+		return nil, err
+	}
+	if len(packet) < length+authTagLength {
+		return nil, fmt.Errorf("srtp packet too short:%v", len(packet))
+	}
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	sequence := binary.BigEndian.Uint16(packet[2:])
+	state := context.stream(ssrc)
+	rolloverCounter := state.estimate(sequence)
+	body := packet[:len(packet)-authTagLength]
+	if !hmac.Equal(authTag(context.rtpAuth, body, &rolloverCounter),
+		packet[len(body):]) {
+		return nil, fmt.Errorf("srtp authentication failed")
+	}
+	state.update(rolloverCounter, sequence)
+	output := make([]byte, len(body))
+	copy(output, body[:length])
+	cipher.NewCTR(context.rtpBlock, counter(context.rtpSalt, ssrc,
+		uint64(rolloverCounter)<<16|uint64(sequence))).
+		XORKeyStream(output[length:], body[length:])
+	return output, nil
+}
+
+//EncryptRTCP protect compound rtcp packet into a new srtcp packet
+func (context *Context) EncryptRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < 8 {
+		return nil, fmt.Errorf("rtcp packet too short:%v", len(packet))
+	}
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+	index := context.rtcpIndex
+	context.rtcpIndex = (context.rtcpIndex + 1) & 0x7fffffff
+	output := make([]byte, len(packet)+srtcpIndexLength,
+		len(packet)+srtcpIndexLength+authTagLength)
+	copy(output, packet[:8])
+	cipher.NewCTR(context.rtcpBlock, counter(context.rtcpSalt,
+		binary.BigEndian.Uint32(packet[4:]), uint64(index))).
+		XORKeyStream(output[8:len(packet)], packet[8:])
+	binary.BigEndian.PutUint32(output[len(packet):], index|1<<31)
+	return append(output, authTag(context.rtcpAuth, output, nil)...), nil
+}
+
+//DecryptRTCP authenticate and decrypt srtcp packet into a new rtcp packet
+func (context *Context) DecryptRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < 8+srtcpIndexLength+authTagLength {
+		return nil, fmt.Errorf("srtcp packet too short:%v", len(packet))
+	}
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+	body := packet[:len(packet)-authTagLength]
+	if !hmac.Equal(authTag(context.rtcpAuth, body, nil), packet[len(body):]) {
+		return nil, fmt.Errorf("srtcp authentication failed")
+	}
+	length := len(body) - srtcpIndexLength
+	index := binary.BigEndian.Uint32(body[length:])
+	output := make([]byte, length)
+	copy(output, body[:length])
+	if index&(1<<31) != 0 {
+		cipher.NewCTR(context.rtcpBlock, counter(context.rtcpSalt,
+			binary.BigEndian.Uint32(body[4:]), uint64(index&0x7fffffff))).
+			XORKeyStream(output[8:], body[8:length])
+	}
+	return output, nil
+}
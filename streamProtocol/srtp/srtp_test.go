@@ -0,0 +1,86 @@
+package srtp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHex(t *testing.T, value string) []byte {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDeriveKey(t *testing.T) {
+	// rfc3711 B.3
+	master, err := aes.NewCipher(decodeHex(t, "e1f97a0d3e018be0d64fa32c06de4139"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := decodeHex(t, "0ec675ad498afeebb6960b3aabe6")
+	for _, test := range []struct {
+		label  byte
+		length int
+		key    string
+	}{
+		{labelRtpEncryption, KeyLength, "c61e7a93744f39ee10734afe3ff7a087"},
+		{labelRtpSalt, SaltLength, "30cbbc08863d8c85d49db34a9ae1"},
+		{labelRtpAuth, authKeyLength, "cebe321f6ff7716b6fd4ab49af256a156d38baa4"},
+	} {
+		if key := deriveKey(master, salt, test.label, test.length); hex.EncodeToString(key) != test.key {
+			t.Errorf("deriveKey label %v = %x, want %v", test.label, key, test.key)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := decodeHex(t, "e1f97a0d3e018be0d64fa32c06de4139")
+	salt := decodeHex(t, "0ec675ad498afeebb6960b3aabe6")
+	sender, err := NewContext(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := NewContext(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := []byte{0x80, 0x60, 0xff, 0xff, 0, 0, 0, 1, 0xca, 0xfe, 0xba, 0xbe,
+		1, 2, 3, 4, 5, 6, 7, 8}
+	for sequence := 0; sequence < 3; sequence++ {
+		// sequence number wraps from 0xffff to 1,rollover counter follows
+		packet[2], packet[3] = byte((0xffff+sequence)>>8), byte(0xffff+sequence)
+		protected, err := sender.EncryptRTP(packet)
+		if err != nil {
+			t.Fatalf("EncryptRTP error:%v", err)
+		}
+		if len(protected) != len(packet)+authTagLength ||
+			bytes.Equal(protected[12:len(packet)], packet[12:]) {
+			t.Fatalf("EncryptRTP = %x", protected)
+		}
+		unprotected, err := receiver.DecryptRTP(protected)
+		if err != nil || !bytes.Equal(unprotected, packet) {
+			t.Fatalf("DecryptRTP = %x,%v, want %x", unprotected, err, packet)
+		}
+		protected[len(protected)-1] ^= 1
+		if _, err := receiver.DecryptRTP(protected); err == nil {
+			t.Errorf("DecryptRTP of tampered packet succeeded")
+		}
+	}
+	if state := sender.streams[0xcafebabe]; state.rolloverCounter != 1 {
+		t.Errorf("rollover counter = %v, want 1", state.rolloverCounter)
+	}
+	report := []byte{0x80, 0xc8, 0, 6, 0xca, 0xfe, 0xba, 0xbe,
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	protected, err := sender.EncryptRTCP(report)
+	if err != nil {
+		t.Fatalf("EncryptRTCP error:%v", err)
+	}
+	unprotected, err := receiver.DecryptRTCP(protected)
+	if err != nil || !bytes.Equal(unprotected, report) {
+		t.Errorf("DecryptRTCP = %x,%v, want %x", unprotected, err, report)
+	}
+}
@@ -0,0 +1,281 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+)
+
+const (
+	//MagicCookie fixed value in header of rfc5389 messages
+	MagicCookie uint32 = 0x2112a442
+	//HeaderLength length of message header
+	HeaderLength = 20
+	//fingerprintXor xored with crc32 in FINGERPRINT(rfc5389 15.5)
+	fingerprintXor uint32 = 0x5354554e
+	//integrityLength length of MESSAGE-INTEGRITY attribute with its header
+	integrityLength = 4 + sha1.Size
+	//fingerprintLength length of FINGERPRINT attribute with its header
+	fingerprintLength = 4 + 4
+)
+
+//MessageType message class and method(rfc5389 6)
+type MessageType uint16
+
+const (
+	//BindingRequest binding request
+	BindingRequest MessageType = 0x0001
+	//BindingSuccess binding success response
+	BindingSuccess MessageType = 0x0101
+	//BindingError binding error response
+	BindingError MessageType = 0x0111
+)
+
+//AttributeType attribute type(rfc5389 18.2,rfc8445 16.1)
+type AttributeType uint16
+
+const (
+	//AttributeMappedAddress MAPPED-ADDRESS
+	AttributeMappedAddress AttributeType = 0x0001
+	//AttributeUsername USERNAME
+	AttributeUsername AttributeType = 0x0006
+	//AttributeMessageIntegrity MESSAGE-INTEGRITY
+	AttributeMessageIntegrity AttributeType = 0x0008
+	//AttributeErrorCode ERROR-CODE
+	AttributeErrorCode AttributeType = 0x0009
+	//AttributeXorMappedAddress XOR-MAPPED-ADDRESS
+	AttributeXorMappedAddress AttributeType = 0x0020
+	//AttributePriority PRIORITY of ice
+	AttributePriority AttributeType = 0x0024
+	//AttributeUseCandidate USE-CANDIDATE of ice
+	AttributeUseCandidate AttributeType = 0x0025
+	//AttributeFingerprint FINGERPRINT
+	AttributeFingerprint AttributeType = 0x8028
+	//AttributeICEControlled ICE-CONTROLLED
+	AttributeICEControlled AttributeType = 0x8029
+	//AttributeICEControlling ICE-CONTROLLING
+	AttributeICEControlling AttributeType = 0x802a
+)
+
+//Attribute a type-length-value attribute
+type Attribute struct {
+	Type  AttributeType
+	Value []byte
+}
+
+/*
+Message a stun message,MESSAGE-INTEGRITY and FINGERPRINT are not kept
+in Attributes,they are checked by CheckIntegrity and Unmarshal,
+and added by Marshal
+*/
+type Message struct {
+	Type          MessageType
+	TransactionID [12]byte
+	Attributes    []Attribute
+	integrity     int // offset of MESSAGE-INTEGRITY in raw message,0 if absent
+	raw           []byte
+}
+
+//IsMessage if data looks like a stun message(rfc7983 7)
+func IsMessage(data []byte) bool {
+	return len(data) >= HeaderLength && data[0] < 4 &&
+		binary.BigEndian.Uint32(data[4:]) == MagicCookie
+}
+
+//NewMessage make a message with random transaction id
+func NewMessage(messageType MessageType) (*Message, error) {
+	message := &Message{Type: messageType}
+	if _, err := rand.Read(message.TransactionID[:]); err != nil {
+		return nil, fmt.Errorf("rand error:%v", err)
+	}
+	return message, nil
+}
+
+//Unmarshal parse message,FINGERPRINT is checked if present
+func (message *Message) Unmarshal(data []byte) error {
+	if !IsMessage(data) {
+		return fmt.Errorf("not a stun message")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length%4 != 0 || HeaderLength+length != len(data) {
+		return fmt.Errorf("stun message length %v invalid", length)
+	}
+	message.Type = MessageType(binary.BigEndian.Uint16(data))
+	copy(message.TransactionID[:], data[8:HeaderLength])
+	message.Attributes = message.Attributes[:0]
+	message.integrity = 0
+	message.raw = data
+	for offset := HeaderLength; offset < len(data); {
+		if offset+4 > len(data) {
+			return fmt.Errorf("stun attribute header truncated")
+		}
+		attributeType := AttributeType(binary.BigEndian.Uint16(data[offset:]))
+		valueLength := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if offset+4+valueLength > len(data) {
+			return fmt.Errorf("stun attribute %#x truncated", attributeType)
+		}
+		value := data[offset+4 : offset+4+valueLength]
+		switch attributeType {
+		case AttributeMessageIntegrity:
+			if valueLength != sha1.Size {
+				return fmt.Errorf("MESSAGE-INTEGRITY length %v invalid", valueLength)
+			}
+			message.integrity = offset
+		case AttributeFingerprint:
+			if valueLength != 4 || offset+fingerprintLength != len(data) {
+				return fmt.Errorf("FINGERPRINT invalid")
+			}
+			if binary.BigEndian.Uint32(value) != fingerprint(data[:offset]) {
+				return fmt.Errorf("FINGERPRINT mismatch")
+			}
+		default:
+			// attributes after MESSAGE-INTEGRITY other than FINGERPRINT are ignored
+			if message.integrity == 0 {
+				message.Attributes = append(message.Attributes,
+					Attribute{Type: attributeType, Value: value})
+			}
+		}
+		offset += 4 + (valueLength+3)/4*4
+	}
+	return nil
+}
+
+//Get value of the first attribute of type,nil if absent
+func (message *Message) Get(attributeType AttributeType) []byte {
+	for _, attribute := range message.Attributes {
+		if attribute.Type == attributeType {
+			return attribute.Value
+		}
+	}
+	return nil
+}
+
+//Has if message has attribute of type
+func (message *Message) Has(attributeType AttributeType) bool {
+	for _, attribute := range message.Attributes {
+		if attribute.Type == attributeType {
+			return true
+		}
+	}
+	return false
+}
+
+//Add append attribute
+func (message *Message) Add(attributeType AttributeType, value []byte) {
+	message.Attributes = append(message.Attributes,
+		Attribute{Type: attributeType, Value: value})
+}
+
+//CheckIntegrity check MESSAGE-INTEGRITY of unmarshaled message with
+//short-term credential key(rfc5389 15.4)
+func (message *Message) CheckIntegrity(key []byte) error {
+	if message.integrity == 0 {
+		return fmt.Errorf("MESSAGE-INTEGRITY absent")
+	}
+	data := message.raw
+	expected := integrity(data[:message.integrity], key)
+	if !hmac.Equal(expected, data[message.integrity+4:message.integrity+integrityLength]) {
+		return fmt.Errorf("MESSAGE-INTEGRITY mismatch")
+	}
+	return nil
+}
+
+//Marshal marshal message,MESSAGE-INTEGRITY is added if key is not nil,
+//FINGERPRINT is always added
+func (message *Message) Marshal(key []byte) []byte {
+	size := HeaderLength
+	for _, attribute := range message.Attributes {
+		size += 4 + (len(attribute.Value)+3)/4*4
+	}
+	if key != nil {
+		size += integrityLength
+	}
+	size += fingerprintLength
+	data := make([]byte, HeaderLength, size)
+	binary.BigEndian.PutUint16(data, uint16(message.Type))
+	binary.BigEndian.PutUint32(data[4:], MagicCookie)
+	copy(data[8:], message.TransactionID[:])
+	for _, attribute := range message.Attributes {
+		data = appendAttribute(data, attribute.Type, attribute.Value)
+	}
+	if key != nil {
+		data = appendAttribute(data, AttributeMessageIntegrity, integrity(data, key))
+	}
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, fingerprint(data))
+	return appendAttribute(data, AttributeFingerprint, value)
+}
+
+//appendAttribute append attribute with padding and update length in header
+func appendAttribute(data []byte, attributeType AttributeType, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header, uint16(attributeType))
+	binary.BigEndian.PutUint16(header[2:], uint16(len(value)))
+	data = append(data, header...)
+	data = append(data, value...)
+	data = append(data, make([]byte, (4-len(value)%4)%4)...)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)-HeaderLength))
+	return data
+}
+
+//integrity hmac-sha1 of message before MESSAGE-INTEGRITY,the length in
+//header counts MESSAGE-INTEGRITY as the last attribute
+func integrity(data []byte, key []byte) []byte {
+	header := make([]byte, HeaderLength)
+	copy(header, data)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)-HeaderLength+integrityLength))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(header)
+	mac.Write(data[HeaderLength:])
+	return mac.Sum(nil)
+}
+
+//fingerprint crc32 of message before FINGERPRINT,the length in header
+//counts FINGERPRINT as the last attribute
+func fingerprint(data []byte) uint32 {
+	header := make([]byte, HeaderLength)
+	copy(header, data)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)-HeaderLength+fingerprintLength))
+	return crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable,
+		data[HeaderLength:]) ^ fingerprintXor
+}
+
+//XorAddress value of XOR-MAPPED-ADDRESS(rfc5389 15.2)
+func XorAddress(addr *net.UDPAddr, transactionID [12]byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(1)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 2
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port)^uint16(MagicCookie>>16))
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, MagicCookie)
+	copy(key[4:], transactionID[:])
+	for index := range ip {
+		value[4+index] = ip[index] ^ key[index]
+	}
+	return value
+}
+
+//ParseXorAddress parse value of XOR-MAPPED-ADDRESS
+func ParseXorAddress(value []byte, transactionID [12]byte) (*net.UDPAddr, error) {
+	if len(value) != 8 && len(value) != 20 {
+		return nil, fmt.Errorf("XOR-MAPPED-ADDRESS length %v invalid", len(value))
+	}
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, MagicCookie)
+	copy(key[4:], transactionID[:])
+	ip := make(net.IP, len(value)-4)
+	for index := range ip {
+		ip[index] = value[4+index] ^ key[index]
+	}
+	port := binary.BigEndian.Uint16(value[2:]) ^ uint16(MagicCookie>>16)
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,69 @@
+package stun
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+//sample request of rfc5769 2.1
+const sampleRequest = "000100582112a442b7e7a701bc34d686fa87dfae" +
+	"802200105354554e207465737420636c69656e74" +
+	"002400046e0001ff" +
+	"80290008932ff9b151263b36" +
+	"000600096576746a3a68367659202020" +
+	"000800149aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2" +
+	"80280004e57a3bcf"
+
+func TestSampleRequest(t *testing.T) {
+	data, err := hex.DecodeString(sampleRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := new(Message)
+	if err := message.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	if message.Type != BindingRequest ||
+		string(message.Get(AttributeUsername)) != "evtj:h6vY" {
+		t.Errorf("Unmarshal = %+v", message)
+	}
+	if err := message.CheckIntegrity([]byte("VOkJxbRl1RmTxUk/WvJxBt")); err != nil {
+		t.Errorf("CheckIntegrity error:%v", err)
+	}
+	if err := message.CheckIntegrity([]byte("wrong")); err == nil {
+		t.Errorf("CheckIntegrity with wrong key succeeded")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	request, err := NewMessage(BindingRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Add(AttributeUsername, []byte("local:remote"))
+	request.Add(AttributeUseCandidate, nil)
+	data := request.Marshal([]byte("password"))
+	parsed := new(Message)
+	if err := parsed.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal error:%v", err)
+	}
+	if parsed.TransactionID != request.TransactionID ||
+		string(parsed.Get(AttributeUsername)) != "local:remote" ||
+		!parsed.Has(AttributeUseCandidate) {
+		t.Errorf("Unmarshal = %+v", parsed)
+	}
+	if err := parsed.CheckIntegrity([]byte("password")); err != nil {
+		t.Errorf("CheckIntegrity error:%v", err)
+	}
+	data[len(data)-1] ^= 1
+	if err := parsed.Unmarshal(data); err == nil {
+		t.Errorf("Unmarshal with wrong fingerprint succeeded")
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 32853}
+	parsedAddr, err := ParseXorAddress(XorAddress(addr, request.TransactionID),
+		request.TransactionID)
+	if err != nil || !parsedAddr.IP.Equal(addr.IP) || parsedAddr.Port != addr.Port {
+		t.Errorf("ParseXorAddress = %v,%v", parsedAddr, err)
+	}
+}
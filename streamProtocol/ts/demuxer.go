@@ -0,0 +1,282 @@
+package ts
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/h264"
+	"github.com/darunshen/go/streamProtocol/h265"
+)
+
+//Frame a frame demuxed from a PES packet
+type Frame struct {
+	Track        *Track
+	PTS          uint64   // in ClockRate
+	DTS          uint64   // in ClockRate,equals PTS if not present
+	RandomAccess bool     // keyframe of video,always true for audio
+	Units        [][]byte // nal units without delimiters of video,raw frames of audio
+}
+
+//Demuxer read frames of the first program of a transport stream,
+//psi sections are expected in single packets
+type Demuxer struct {
+	Tracks  []*Track // tracks of PMT,nil before PMT is read
+	reader  io.Reader
+	pmtPID  int // -1 before PAT is read
+	streams map[uint16]*demuxStream
+	frames  []*Frame // frames demuxed but not read
+	packet  [PacketSize]byte
+}
+
+//demuxStream PES packet being assembled of a track
+type demuxStream struct {
+	track        *Track
+	data         []byte
+	started      bool  // if data begins with a PES header
+	randomAccess bool  // random_access_indicator of the first packet
+	counter      uint8 // continuity counter of the last packet
+}
+
+//NewDemuxer make a demuxer reading from reader
+func NewDemuxer(reader io.Reader) *Demuxer {
+	return &Demuxer{
+		reader:  reader,
+		pmtPID:  -1,
+		streams: make(map[uint16]*demuxStream),
+	}
+}
+
+//ReadFrame read the next frame,io.EOF is returned after frames left are read
+func (demuxer *Demuxer) ReadFrame() (*Frame, error) {
+	for len(demuxer.frames) == 0 {
+		if _, err := io.ReadFull(demuxer.reader, demuxer.packet[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				demuxer.flushAll()
+				if len(demuxer.frames) != 0 {
+					break
+				}
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("ts read error:%v", err)
+		}
+		if err := demuxer.readPacket(demuxer.packet[:]); err != nil {
+			return nil, err
+		}
+	}
+	frame := demuxer.frames[0]
+	demuxer.frames = demuxer.frames[1:]
+	return frame, nil
+}
+
+//readPacket parse a transport stream packet
+func (demuxer *Demuxer) readPacket(packet []byte) error {
+	if packet[0] != 0x47 {
+		return fmt.Errorf("ts sync byte not found")
+	}
+	start := packet[1]&0x40 != 0
+	pid := binary.BigEndian.Uint16(packet[1:]) & 0x1fff
+	control := packet[3] >> 4 & 3
+	counter := packet[3] & 0x0f
+	payload := packet[4:]
+	randomAccess := false
+	if control&2 != 0 {
+		length := int(payload[0])
+		if 1+length > len(payload) {
+			return fmt.Errorf("ts adaptation_field_length %v out of range", length)
+		}
+		if length > 0 {
+			randomAccess = payload[1]&0x40 != 0
+		}
+		payload = payload[1+length:]
+	}
+	if control&1 == 0 {
+		return nil
+	}
+	switch {
+	case pid == pidPAT:
+		if !start {
+			return nil
+		}
+		tableID, _, body, err := parseSection(payload)
+		if err != nil || tableID != tableIDPAT {
+			return fmt.Errorf("PAT error:%v", err)
+		}
+		pmtPID, err := parsePAT(body)
+		if err != nil {
+			return err
+		}
+		demuxer.pmtPID = int(pmtPID)
+	case int(pid) == demuxer.pmtPID:
+		if !start || demuxer.Tracks != nil {
+			return nil
+		}
+		tableID, _, body, err := parseSection(payload)
+		if err != nil || tableID != tableIDPMT {
+			return fmt.Errorf("PMT error:%v", err)
+		}
+		if demuxer.Tracks, err = parsePMT(body); err != nil {
+			return err
+		}
+		for _, track := range demuxer.Tracks {
+			demuxer.streams[track.PID] = &demuxStream{track: track}
+		}
+	default:
+		stream, ok := demuxer.streams[pid]
+		if !ok {
+			return nil
+		}
+		if start {
+			demuxer.flush(stream)
+			stream.started, stream.randomAccess = true, randomAccess
+		} else if counter != (stream.counter+1)&0x0f {
+			// packet lost,drop PES packet until the next start
+			stream.started = false
+			stream.data = stream.data[:0]
+		}
+		stream.counter = counter
+		if stream.started {
+			stream.data = append(stream.data, payload...)
+			// PES packet with length is complete without waiting for the next one
+			if len(stream.data) >= 6 {
+				if length := int(binary.BigEndian.Uint16(stream.data[4:])); length != 0 &&
+					len(stream.data) >= 6+length {
+					stream.data = stream.data[:6+length]
+					demuxer.flush(stream)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//flushAll demux PES packets being assembled
+func (demuxer *Demuxer) flushAll() {
+	for _, track := range demuxer.Tracks {
+		demuxer.flush(demuxer.streams[track.PID])
+	}
+}
+
+//flush demux PES packet of stream into a frame
+func (demuxer *Demuxer) flush(stream *demuxStream) {
+	data := stream.data
+	started := stream.started
+	stream.data, stream.started = nil, false
+	if !started || len(data) == 0 {
+		return
+	}
+	frame, err := parsePES(stream.track, data)
+	if err != nil {
+		fmt.Printf("ts parsePES error:%v\n", err)
+		return
+	}
+	frame.RandomAccess = frame.RandomAccess || stream.randomAccess
+	demuxer.frames = append(demuxer.frames, frame)
+}
+
+//parsePES parse PES packet into a frame of track
+func parsePES(track *Track, data []byte) (*Frame, error) {
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return nil, fmt.Errorf("PES start code not found")
+	}
+	headerLength := int(data[8])
+	if 9+headerLength > len(data) {
+		return nil, fmt.Errorf("PES_header_data_length %v out of range", headerLength)
+	}
+	frame := &Frame{Track: track}
+	flags := data[7] >> 6
+	if flags&2 != 0 {
+		if headerLength < 5 {
+			return nil, fmt.Errorf("PES has no room for pts")
+		}
+		frame.PTS = parseTimestamp(data[9:])
+		frame.DTS = frame.PTS
+	}
+	if flags == 3 {
+		if headerLength < 10 {
+			return nil, fmt.Errorf("PES has no room for dts")
+		}
+		frame.DTS = parseTimestamp(data[14:])
+	}
+	payload := data[9+headerLength:]
+	switch track.Codec {
+	case CodecH264:
+		for _, nalu := range h264.AnnexBToNALUs(payload) {
+			if h264.TypeOf(nalu) != h264.NALUTypeAUD {
+				frame.Units = append(frame.Units, nalu)
+			}
+		}
+		frame.RandomAccess = h264.IsKeyframe(frame.Units)
+	case CodecH265:
+		for _, nalu := range h264.AnnexBToNALUs(payload) {
+			if h265.TypeOf(nalu) != h265.NALUTypeAUD {
+				frame.Units = append(frame.Units, nalu)
+			}
+		}
+		frame.RandomAccess = h265.IsKeyframe(frame.Units)
+	case CodecAAC:
+		config, units, err := aac.ParseADTS(payload)
+		if err != nil {
+			return nil, err
+		}
+		if track.AudioConfig == nil {
+			track.AudioConfig = config
+		}
+		frame.Units, frame.RandomAccess = units, true
+	case CodecOpus:
+		units, err := parseOpus(payload)
+		if err != nil {
+			return nil, err
+		}
+		frame.Units, frame.RandomAccess = units, true
+	}
+	return frame, nil
+}
+
+//parseTimestamp parse 33 bits pts or dts
+func parseTimestamp(data []byte) uint64 {
+	return uint64(data[0]>>1&0x07)<<30 | uint64(data[1])<<22 | uint64(data[2]>>1)<<15 |
+		uint64(data[3])<<7 | uint64(data[4]>>1)
+}
+
+//parseOpus split opus packets prefixed with control headers
+func parseOpus(data []byte) ([][]byte, error) {
+	units := make([][]byte, 0, 1)
+	for len(data) > 0 {
+		if len(data) < 2 || data[0] != 0x7f || data[1]&0xe0 != 0xe0 {
+			return nil, fmt.Errorf("opus control header not found")
+		}
+		flags := data[1]
+		offset, size := 2, 0
+		for {
+			if offset >= len(data) {
+				return nil, fmt.Errorf("opus au_size out of range")
+			}
+			size += int(data[offset])
+			offset++
+			if data[offset-1] != 0xff {
+				break
+			}
+		}
+		// start_trim,end_trim
+		if flags&0x10 != 0 {
+			offset += 2
+		}
+		if flags&0x08 != 0 {
+			offset += 2
+		}
+		if flags&0x04 != 0 {
+			if offset >= len(data) {
+				return nil, fmt.Errorf("opus control_extension out of range")
+			}
+			offset += 1 + int(data[offset])
+		}
+		if offset+size > len(data) {
+			return nil, fmt.Errorf("opus au_size %v out of range", size)
+		}
+		units = append(units, data[offset:offset+size])
+		data = data[offset+size:]
+	}
+	return units, nil
+}
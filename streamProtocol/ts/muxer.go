@@ -0,0 +1,301 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+	"github.com/darunshen/go/streamProtocol/h264"
+)
+
+//PacketSize size of transport stream packets
+const PacketSize = 188
+
+//ClockRate clock rate of pts and dts
+const ClockRate = 90000
+
+//pids used by Muxer
+const (
+	//DefaultPMTPID pid of PMT
+	DefaultPMTPID = 0x1000
+	//DefaultFirstPID pid of the first track,the next tracks follow it
+	DefaultFirstPID = 0x0100
+	pidPAT          = 0x0000
+)
+
+//PES stream ids(ISO 13818-1 table 2-22)
+const (
+	streamIDVideo   = 0xe0
+	streamIDAudio   = 0xc0
+	streamIDPrivate = 0xbd
+)
+
+//StreamType elementary stream type in PMT(ISO 13818-1 table 2-34)
+type StreamType uint8
+
+const (
+	//StreamTypeAAC ISO 13818-7 audio with adts transport syntax
+	StreamTypeAAC StreamType = 0x0f
+	//StreamTypeH264 ITU-T H.264 video
+	StreamTypeH264 StreamType = 0x1b
+	//StreamTypeH265 ITU-T H.265 video
+	StreamTypeH265 StreamType = 0x24
+	//StreamTypePrivate PES private data,opus with registration descriptor
+	StreamTypePrivate StreamType = 0x06
+)
+
+//Codec codec of a track
+type Codec int
+
+const (
+	//CodecH264 h264 in annex-b byte stream
+	CodecH264 Codec = iota
+	//CodecH265 h265 in annex-b byte stream
+	CodecH265
+	//CodecAAC AAC with adts headers
+	CodecAAC
+	//CodecOpus opus with control headers
+	CodecOpus
+)
+
+//IsVideo if codec is a video codec
+func (codec Codec) IsVideo() bool {
+	return codec == CodecH264 || codec == CodecH265
+}
+
+//StreamType stream type of codec in PMT
+func (codec Codec) StreamType() StreamType {
+	switch codec {
+	case CodecH264:
+		return StreamTypeH264
+	case CodecH265:
+		return StreamTypeH265
+	case CodecAAC:
+		return StreamTypeAAC
+	}
+	return StreamTypePrivate
+}
+
+//Track an elementary stream of program
+type Track struct {
+	PID          uint16
+	Codec        Codec
+	AudioConfig  *aac.AudioSpecificConfig // config of adts headers,for AAC
+	ChannelCount int                      // for opus
+}
+
+//Muxer write frames of tracks as a single program transport stream
+type Muxer struct {
+	Tracks   []*Track
+	PMTPID   uint16
+	PCRPID   uint16 // pid of the first video track,or the first track
+	writer   io.Writer
+	counters map[uint16]uint8 // continuity counters by pid
+	packet   [PacketSize]byte
+}
+
+//NewMuxer make a muxer writing to writer,tracks without pid get pids from DefaultFirstPID
+func NewMuxer(writer io.Writer, tracks []*Track) *Muxer {
+	muxer := &Muxer{
+		Tracks:   tracks,
+		PMTPID:   DefaultPMTPID,
+		writer:   writer,
+		counters: make(map[uint16]uint8),
+	}
+	for index, track := range tracks {
+		if track.PID == 0 {
+			track.PID = DefaultFirstPID + uint16(index)
+		}
+	}
+	for _, track := range tracks {
+		if track.Codec.IsVideo() {
+			muxer.PCRPID = track.PID
+			break
+		}
+	}
+	if muxer.PCRPID == 0 && len(tracks) != 0 {
+		muxer.PCRPID = tracks[0].PID
+	}
+	return muxer
+}
+
+//WriteTables write PAT and PMT,should be called at the start of stream and segments
+func (muxer *Muxer) WriteTables() error {
+	if err := muxer.writeSection(pidPAT, marshalPAT(muxer.PMTPID)); err != nil {
+		return err
+	}
+	return muxer.writeSection(muxer.PMTPID, marshalPMT(muxer.PCRPID, muxer.Tracks))
+}
+
+/*
+WriteFrame write a frame of track as a PES packet,
+pts and dts are in ClockRate,units are nal units of video or raw frames of audio,
+randomAccess is set for keyframes of video,an access unit delimiter is added to video
+*/
+func (muxer *Muxer) WriteFrame(track *Track,
+	pts, dts uint64, units [][]byte, randomAccess bool) error {
+	var (
+		payload  []byte
+		streamID byte
+		err      error
+	)
+	switch track.Codec {
+	case CodecH264:
+		streamID = streamIDVideo
+		payload = h264.NALUsToAnnexB(append([][]byte{{0x09, 0xf0}}, units...))
+	case CodecH265:
+		streamID = streamIDVideo
+		payload = h264.NALUsToAnnexB(append([][]byte{{0x46, 0x01, 0x50}}, units...))
+	case CodecAAC:
+		streamID = streamIDAudio
+		if track.AudioConfig == nil {
+			return fmt.Errorf("WriteFrame error: AAC track %v has no config", track.PID)
+		}
+		if payload, err = aac.MarshalADTS(track.AudioConfig, units); err != nil {
+			return fmt.Errorf("MarshalADTS error:%v", err)
+		}
+	case CodecOpus:
+		streamID = streamIDPrivate
+		payload = marshalOpus(units)
+	default:
+		return fmt.Errorf("WriteFrame error: codec %v not support", track.Codec)
+	}
+	var pcr *uint64
+	if track.PID == muxer.PCRPID {
+		pcr = &dts
+	}
+	return muxer.writePES(track.PID, marshalPES(streamID, pts, dts, payload),
+		pcr, randomAccess || !track.Codec.IsVideo())
+}
+
+//marshalOpus prefix each opus packet with a control header
+func marshalOpus(units [][]byte) []byte {
+	var data []byte
+	for _, unit := range units {
+		// prefix 0x3ff,no trim and extension
+		data = append(data, 0x7f, 0xe0)
+		size := len(unit)
+		for ; size >= 255; size -= 255 {
+			data = append(data, 0xff)
+		}
+		data = append(data, byte(size))
+		data = append(data, unit...)
+	}
+	return data
+}
+
+//marshalPES make a PES packet(ISO 13818-1 2.4.3.6),dts is omitted if equals pts
+func marshalPES(streamID byte, pts, dts uint64, payload []byte) []byte {
+	headerLength := 5
+	flags := byte(0x80)
+	if pts != dts {
+		headerLength += 5
+		flags = 0xc0
+	}
+	length := 3 + headerLength + len(payload)
+	if length > 0xffff {
+		// only allowed for video
+		length = 0
+	}
+	pes := make([]byte, 0, 9+headerLength+len(payload))
+	pes = append(pes, 0, 0, 1, streamID, byte(length>>8), byte(length),
+		0x84, // data_alignment_indicator
+		flags, byte(headerLength))
+	pes = appendTimestamp(pes, flags>>6, pts)
+	if pts != dts {
+		pes = appendTimestamp(pes, 1, dts)
+	}
+	return append(pes, payload...)
+}
+
+//appendTimestamp append 33 bits pts or dts with 4 bits prefix
+func appendTimestamp(data []byte, prefix byte, timestamp uint64) []byte {
+	return append(data,
+		prefix<<4|byte(timestamp>>29)&0x0e|1,
+		byte(timestamp>>22),
+		byte(timestamp>>14)|1,
+		byte(timestamp>>7),
+		byte(timestamp<<1)|1)
+}
+
+//writeSection write a psi section in a packet
+func (muxer *Muxer) writeSection(pid uint16, section []byte) error {
+	// pointer_field before section
+	return muxer.writePayload(pid, append([]byte{0}, section...), nil, false, true)
+}
+
+//writePES split PES packet into transport stream packets,
+//pcr and random_access_indicator are in the first one
+func (muxer *Muxer) writePES(pid uint16, pes []byte, pcr *uint64, randomAccess bool) error {
+	return muxer.writePayload(pid, pes, pcr, randomAccess, false)
+}
+
+//writePayload split payload into packets,the last one is stuffed with
+//adaptation field,or with 0xff if stuffTable
+func (muxer *Muxer) writePayload(pid uint16, payload []byte,
+	pcr *uint64, randomAccess, stuffTable bool) error {
+	for first := true; first || len(payload) > 0; first = false {
+		packet := muxer.packet[:0]
+		var adaptation []byte // adaptation field after adaptation_field_length
+		if first && (pcr != nil || randomAccess) {
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			adaptation = append(adaptation, flags)
+			if pcr != nil {
+				adaptation[0] |= 0x10
+				// 33 bits base,6 reserved bits,9 bits extension
+				adaptation = append(adaptation, byte(*pcr>>25), byte(*pcr>>17),
+					byte(*pcr>>9), byte(*pcr>>1), byte(*pcr<<7)|0x7e, 0)
+			}
+		}
+		hasAdaptation := adaptation != nil
+		space := PacketSize - 4
+		if hasAdaptation {
+			space -= 1 + len(adaptation)
+		}
+		size := len(payload)
+		if size > space {
+			size = space
+		}
+		stuffing := space - size
+		if stuffing > 0 && !stuffTable {
+			if !hasAdaptation {
+				hasAdaptation = true
+				stuffing--
+				if stuffing > 0 {
+					adaptation = append(adaptation, 0)
+					stuffing--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				adaptation = append(adaptation, 0xff)
+			}
+		}
+		control := byte(0x10) // payload only
+		if hasAdaptation {
+			control = 0x30
+		}
+		start := byte(0)
+		if first {
+			start = 0x40 // payload_unit_start_indicator
+		}
+		packet = append(packet, 0x47, start|byte(pid>>8)&0x1f, byte(pid),
+			control|muxer.counters[pid]&0x0f)
+		muxer.counters[pid]++
+		if hasAdaptation {
+			packet = append(packet, byte(len(adaptation)))
+			packet = append(packet, adaptation...)
+		}
+		packet = append(packet, payload[:size]...)
+		for len(packet) < PacketSize {
+			packet = append(packet, 0xff)
+		}
+		payload = payload[size:]
+		if _, err := muxer.writer.Write(packet); err != nil {
+			return fmt.Errorf("ts write error:%v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package ts
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//table ids of program specific information(ISO 13818-1 table 2-31)
+const (
+	tableIDPAT = 0x00
+	tableIDPMT = 0x02
+)
+
+//descriptor tags used by opus in mpeg-ts(ETSI TS 102 366 and opus mapping)
+const (
+	descriptorRegistration = 0x05
+	descriptorExtension    = 0x7f
+	extensionOpus          = 0x80
+)
+
+//crcTable crc32 table of polynomial 0x04c11db7 without reflection
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for index := range table {
+		crc := uint32(index) << 24
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[index] = crc
+	}
+	return table
+}()
+
+//crc32 crc of psi section(ISO 13818-1 annex A)
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+//marshalSection make a psi section with syntax,tableIDExtension is
+//transport_stream_id of PAT or program_number of PMT
+func marshalSection(tableID uint8, tableIDExtension uint16, body []byte) []byte {
+	length := 5 + len(body) + 4
+	section := make([]byte, 0, 3+length)
+	section = append(section,
+		tableID, 0xb0|byte(length>>8), byte(length),
+		byte(tableIDExtension>>8), byte(tableIDExtension),
+		0xc1, // version 0,current
+		0, 0) // section_number,last_section_number
+	section = append(section, body...)
+	crc := crc32(section)
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+//parseSection check psi section and return table id,table id extension and body
+func parseSection(payload []byte) (uint8, uint16, []byte, error) {
+	if len(payload) < 1 || int(payload[0])+1 > len(payload) {
+		return 0, 0, nil, fmt.Errorf("psi pointer_field out of range")
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 3 {
+		return 0, 0, nil, fmt.Errorf("psi section too short")
+	}
+	length := int(binary.BigEndian.Uint16(section[1:]) & 0xfff)
+	if length < 9 || 3+length > len(section) {
+		return 0, 0, nil, fmt.Errorf("psi section_length %v out of range", length)
+	}
+	section = section[:3+length]
+	if crc32(section) != 0 {
+		return 0, 0, nil, fmt.Errorf("psi section crc error")
+	}
+	return section[0], binary.BigEndian.Uint16(section[3:]), section[8 : len(section)-4], nil
+}
+
+//marshalPAT make PAT section of a single program
+func marshalPAT(pmtPID uint16) []byte {
+	return marshalSection(tableIDPAT, 1, []byte{
+		0, 1, // program_number
+		0xe0 | byte(pmtPID>>8), byte(pmtPID)})
+}
+
+//parsePAT get pid of the first program's PMT
+func parsePAT(body []byte) (uint16, error) {
+	for ; len(body) >= 4; body = body[4:] {
+		// program 0 is network information
+		if binary.BigEndian.Uint16(body) != 0 {
+			return binary.BigEndian.Uint16(body[2:]) & 0x1fff, nil
+		}
+	}
+	return 0, fmt.Errorf("PAT has no program")
+}
+
+//marshalPMT make PMT section of tracks
+func marshalPMT(pcrPID uint16, tracks []*Track) []byte {
+	body := []byte{0xe0 | byte(pcrPID>>8), byte(pcrPID), 0xf0, 0}
+	for _, track := range tracks {
+		var descriptors []byte
+		if track.Codec == CodecOpus {
+			descriptors = []byte{descriptorRegistration, 4, 'O', 'p', 'u', 's',
+				descriptorExtension, 2, extensionOpus, byte(track.ChannelCount)}
+		}
+		body = append(body, byte(track.Codec.StreamType()),
+			0xe0|byte(track.PID>>8), byte(track.PID),
+			0xf0|byte(len(descriptors)>>8), byte(len(descriptors)))
+		body = append(body, descriptors...)
+	}
+	return marshalSection(tableIDPMT, 1, body)
+}
+
+//parsePMT get tracks with supported stream types from PMT
+func parsePMT(body []byte) ([]*Track, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("PMT too short")
+	}
+	programInfoLength := int(binary.BigEndian.Uint16(body[2:]) & 0xfff)
+	if 4+programInfoLength > len(body) {
+		return nil, fmt.Errorf("PMT program_info_length out of range")
+	}
+	tracks := make([]*Track, 0, 2)
+	for body = body[4+programInfoLength:]; len(body) >= 5; {
+		streamType := StreamType(body[0])
+		pid := binary.BigEndian.Uint16(body[1:]) & 0x1fff
+		infoLength := int(binary.BigEndian.Uint16(body[3:]) & 0xfff)
+		if 5+infoLength > len(body) {
+			return nil, fmt.Errorf("PMT ES_info_length out of range")
+		}
+		descriptors := body[5 : 5+infoLength]
+		body = body[5+infoLength:]
+		track := &Track{PID: pid}
+		switch streamType {
+		case StreamTypeH264:
+			track.Codec = CodecH264
+		case StreamTypeH265:
+			track.Codec = CodecH265
+		case StreamTypeAAC:
+			track.Codec = CodecAAC
+		case StreamTypePrivate:
+			if !isOpus(descriptors, track) {
+				continue
+			}
+		default:
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+//isOpus if descriptors have opus registration,channel count is set into track
+func isOpus(descriptors []byte, track *Track) bool {
+	registered := false
+	for len(descriptors) >= 2 && 2+int(descriptors[1]) <= len(descriptors) {
+		tag, data := descriptors[0], descriptors[2:2+int(descriptors[1])]
+		descriptors = descriptors[2+len(data):]
+		switch {
+		case tag == descriptorRegistration && string(data) == "Opus":
+			registered = true
+		case tag == descriptorExtension && len(data) >= 2 && data[0] == extensionOpus:
+			track.ChannelCount = int(data[1])
+		}
+	}
+	if registered {
+		track.Codec = CodecOpus
+		if track.ChannelCount == 0 {
+			track.ChannelCount = 2
+		}
+	}
+	return registered
+}
@@ -0,0 +1,105 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/darunshen/go/streamProtocol/aac"
+)
+
+func TestMuxDemux(t *testing.T) {
+	config := &aac.AudioSpecificConfig{ObjectType: aac.ObjectTypeAACLC,
+		SampleRate: 48000, ChannelCount: 2, FrameLength: 1024}
+	video := &Track{Codec: CodecH264}
+	audio := &Track{Codec: CodecAAC, AudioConfig: config}
+	opus := &Track{Codec: CodecOpus, ChannelCount: 2}
+	buffer := new(bytes.Buffer)
+	muxer := NewMuxer(buffer, []*Track{video, audio, opus})
+	if video.PID != DefaultFirstPID || muxer.PCRPID != video.PID {
+		t.Errorf("video pid %v,pcr pid %v", video.PID, muxer.PCRPID)
+	}
+	idr := make([]byte, 1000)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	opusPacket := make([]byte, 300)
+	if err := muxer.WriteTables(); err != nil {
+		t.Fatalf("WriteTables error:%v", err)
+	}
+	if err := muxer.WriteFrame(video, 9000+3000, 9000,
+		[][]byte{{0x67, 0x42}, {0x68, 0xce}, idr}, true); err != nil {
+		t.Fatalf("WriteFrame error:%v", err)
+	}
+	if err := muxer.WriteFrame(audio, 9000, 9000,
+		[][]byte{{0x21, 0x10}, {0x21, 0x20}}, false); err != nil {
+		t.Fatalf("WriteFrame error:%v", err)
+	}
+	if err := muxer.WriteFrame(opus, 9000, 9000, [][]byte{opusPacket}, false); err != nil {
+		t.Fatalf("WriteFrame error:%v", err)
+	}
+	if err := muxer.WriteFrame(video, 1<<33-1, 1<<33-1, [][]byte{{0x41, 1}}, false); err != nil {
+		t.Fatalf("WriteFrame error:%v", err)
+	}
+	if buffer.Len()%PacketSize != 0 {
+		t.Fatalf("stream size %v is not multiple of packet size", buffer.Len())
+	}
+
+	demuxer := NewDemuxer(buffer)
+	var frames []*Frame
+	for {
+		frame, err := demuxer.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame error:%v", err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(demuxer.Tracks) != 3 || demuxer.Tracks[2].Codec != CodecOpus ||
+		demuxer.Tracks[2].ChannelCount != 2 {
+		t.Fatalf("demuxed tracks = %+v", demuxer.Tracks)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("got %v frames, want 4", len(frames))
+	}
+	if frame := frames[0]; frame.PTS != 12000 || frame.DTS != 9000 || !frame.RandomAccess ||
+		len(frame.Units) != 3 || !bytes.Equal(frame.Units[2], idr) {
+		t.Errorf("video frame = %+v", frame)
+	}
+	if frame := frames[1]; frame.PTS != 9000 || len(frame.Units) != 2 ||
+		!bytes.Equal(frame.Units[1], []byte{0x21, 0x20}) ||
+		*frame.Track.AudioConfig != *config {
+		t.Errorf("audio frame = %+v", frame)
+	}
+	if frame := frames[2]; len(frame.Units) != 1 || !bytes.Equal(frame.Units[0], opusPacket) {
+		t.Errorf("opus frame = %+v", frame)
+	}
+	if frame := frames[3]; frame.PTS != 1<<33-1 || frame.RandomAccess {
+		t.Errorf("last frame = %+v", frame)
+	}
+}
+
+func TestDemuxPacketLoss(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	track := &Track{Codec: CodecH264}
+	muxer := NewMuxer(buffer, []*Track{track})
+	muxer.WriteTables()
+	nalu := make([]byte, 1000)
+	nalu[0] = 0x41
+	muxer.WriteFrame(track, 0, 0, [][]byte{nalu}, false)
+	muxer.WriteFrame(track, 3000, 3000, [][]byte{{0x41, 2}}, false)
+	data := buffer.Bytes()
+	// drop the second packet of the first frame
+	data = append(data[:3*PacketSize:3*PacketSize], data[4*PacketSize:]...)
+	demuxer := NewDemuxer(bytes.NewReader(data))
+	frame, err := demuxer.ReadFrame()
+	if err != nil || frame.PTS != 3000 {
+		t.Errorf("ReadFrame = %+v,%v, want only the complete frame", frame, err)
+	}
+	if _, err := demuxer.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame error = %v, want EOF", err)
+	}
+}
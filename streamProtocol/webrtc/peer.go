@@ -0,0 +1,578 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/dtls"
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtp"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/darunshen/go/streamProtocol/srtp"
+	"github.com/darunshen/go/streamProtocol/stun"
+)
+
+const (
+	//readBufferSize max size of datagrams from peer
+	readBufferSize = 2048
+	//dtlsQueueSize dtls datagrams kept before they are read by handshake
+	dtlsQueueSize = 16
+)
+
+/*
+Peer a webrtc peer connection playing a published stream or publishing one,
+it's an ice-lite agent with one host candidate,
+rtp/rtcp of all tracks are bundled and muxed on one udp socket,
+keys of srtp are exported from dtls handshake
+*/
+type Peer struct {
+	ID            string                     // id in resource url,also the rtsp session id of pullers
+	ResourcePath  string                     // path of stream played
+	server        *Server                    // server this peer belongs to
+	session       *rtsp.PusherPullersSession // session of stream played or published
+	publishing    bool                       // if peer publishes stream instead of playing
+	tracks        []*peerTrack               // tracks by media of offer,nil if media rejected
+	remote        *remoteDescription         // offer of peer
+	localUfrag    string                     // ice username fragment of answer
+	localPwd      string                     // ice password of answer
+	conn          *net.UDPConn               // socket of host candidate
+	transport     *dtlsTransport             // dtls datagrams from peer
+	remoteAddr    *net.UDPAddr               // address of selected candidate pair,nil before ice checks
+	lastBinding   time.Time                  // time of the last binding request from remoteAddr
+	dtlsConn      *dtls.Conn                 // nil before handshake is complete
+	localContext  *srtp.Context              // protect packages sent,nil before handshake is complete
+	remoteContext *srtp.Context              // unprotect packages received
+	mutex         sync.Mutex                 // provide fields above's atom
+	connected     chan struct{}              // closed at the first valid binding request
+	connectOnce   sync.Once
+	closed        chan struct{} // closed by Close
+	closeOnce     sync.Once
+}
+
+//newPeer make peer with a udp socket on all interfaces,tracks are matched later,
+//session is nil for a publishing peer until it's published
+func newPeer(server *Server, id, resourcePath string,
+	session *rtsp.PusherPullersSession, remote *remoteDescription) (*Peer, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("net.ListenUDP error:%v", err)
+	}
+	peer := &Peer{
+		ID:           id,
+		ResourcePath: resourcePath,
+		server:       server,
+		session:      session,
+		remote:       remote,
+		localUfrag:   randomString(4),
+		localPwd:     randomString(16),
+		conn:         conn,
+		connected:    make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	peer.transport = &dtlsTransport{
+		peer:      peer,
+		datagrams: make(chan []byte, dtlsQueueSize),
+	}
+	return peer, nil
+}
+
+//randomString hex string of length random bytes
+func randomString(length int) string {
+	data := make([]byte, length)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+//publish register tracks of peer as a stream of resource path,
+//payload types of offer are kept in its sdp
+func (peer *Peer) publish() error {
+	session, err := peer.server.RtspServer.Publish(peer.ResourcePath,
+		publishSdp(peer.remote, peer.tracks), peer.ID, peer)
+	if err != nil {
+		return fmt.Errorf("Publish error:%v", err)
+	}
+	index := 0
+	for _, track := range peer.tracks {
+		if track != nil {
+			track.pair = session.Tracks[index]
+			index++
+		}
+	}
+	peer.session = session
+	return nil
+}
+
+//start serve packages from peer and connect tracks to pusher in background
+func (peer *Peer) start() {
+	go peer.readLoop()
+	go peer.run()
+}
+
+//run wait ice checks,do dtls handshake,then add tracks as pullers of
+//the stream if playing,until peer is gone or stream is unpublished
+func (peer *Peer) run() {
+	defer peer.Close()
+	select {
+	case <-peer.connected:
+	case <-peer.closed:
+		return
+	case <-time.After(ConnectTimeout):
+		fmt.Printf("webrtc peer %v ice connect timeout\n", peer.ID)
+		return
+	}
+	dtlsConn := dtls.Server(peer.transport, &dtls.Config{
+		Certificate:  peer.server.certificate,
+		SRTPProfiles: []srtp.ProtectionProfile{srtp.ProfileAES128CMHMACSHA180},
+		VerifyPeerCertificate: func(der []byte) error {
+			if dtls.Fingerprint(der) != peer.remote.fingerprint {
+				return fmt.Errorf("certificate fingerprint mismatch")
+			}
+			return nil
+		},
+	})
+	if err := dtlsConn.Handshake(); err != nil {
+		fmt.Printf("webrtc peer %v %v\n", peer.ID, err)
+		return
+	}
+	localContext, remoteContext, err := dtlsConn.SRTPContexts()
+	if err != nil {
+		fmt.Printf("webrtc peer %v SRTPContexts error:%v\n", peer.ID, err)
+		return
+	}
+	peer.mutex.Lock()
+	peer.dtlsConn = dtlsConn
+	peer.localContext, peer.remoteContext = localContext, remoteContext
+	peer.mutex.Unlock()
+	if !peer.publishing {
+		if err := peer.addPullers(); err != nil {
+			fmt.Printf("webrtc peer %v %v\n", peer.ID, err)
+			return
+		}
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- dtlsConn.Serve()
+	}()
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-served:
+			if err != nil {
+				fmt.Printf("webrtc peer %v dtls error:%v\n", peer.ID, err)
+			}
+			return
+		case <-peer.closed:
+			return
+		case <-ticker.C:
+			peer.mutex.Lock()
+			expired := time.Since(peer.lastBinding) > ConsentTimeout
+			peer.mutex.Unlock()
+			if expired {
+				fmt.Printf("webrtc peer %v consent expired\n", peer.ID)
+				return
+			}
+			if peer.server.RtspServer.FindPublished(peer.ResourcePath) != peer.session {
+				// pusher is gone or republished
+				return
+			}
+		}
+	}
+}
+
+//addPullers add tracks as pullers of the stream played and start them
+func (peer *Peer) addPullers() error {
+	for _, track := range peer.tracks {
+		if track == nil {
+			continue
+		}
+		puller, err := peer.session.AddTransportPuller(track.pair, peer.ID, track)
+		if err != nil {
+			return fmt.Errorf("AddTransportPuller error:%v", err)
+		}
+		track.puller = puller
+	}
+	if errs := peer.session.StartSession(&peer.ID); len(errs) != 0 {
+		return fmt.Errorf("StartSession error:%v", errs)
+	}
+	return nil
+}
+
+//readLoop demultiplex stun,dtls and srtcp from peer(rfc7983 7)
+func (peer *Peer) readLoop() {
+	defer peer.Close()
+	buffer := make([]byte, readBufferSize)
+	for {
+		number, addr, err := peer.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		data := buffer[:number]
+		if stun.IsMessage(data) {
+			peer.handleBinding(data, addr)
+			continue
+		}
+		peer.mutex.Lock()
+		remoteAddr := peer.remoteAddr
+		peer.mutex.Unlock()
+		if number == 0 || remoteAddr == nil || !addr.IP.Equal(remoteAddr.IP) ||
+			addr.Port != remoteAddr.Port {
+			continue
+		}
+		switch {
+		case data[0] >= 20 && data[0] <= 63:
+			datagram := make([]byte, number)
+			copy(datagram, data)
+			select {
+			case peer.transport.datagrams <- datagram:
+			default:
+				// handshake will resend flights
+			}
+		case data[0] >= 128 && data[0] <= 191 && number > 1 &&
+			data[1] >= 192 && data[1] <= 223:
+			if err := peer.handleRtcp(data); err != nil {
+				fmt.Printf("webrtc peer %v %v\n", peer.ID, err)
+			}
+		case data[0] >= 128 && data[0] <= 191 && peer.publishing:
+			if err := peer.handleRtp(data); err != nil {
+				fmt.Printf("webrtc peer %v %v\n", peer.ID, err)
+			}
+		}
+	}
+}
+
+//handleBinding answer binding request of ice checks and consent freshness,
+//the pair nominated by USE-CANDIDATE is selected
+func (peer *Peer) handleBinding(data []byte, addr *net.UDPAddr) {
+	request := new(stun.Message)
+	if err := request.Unmarshal(data); err != nil || request.Type != stun.BindingRequest {
+		return
+	}
+	if !strings.HasPrefix(string(request.Get(stun.AttributeUsername)), peer.localUfrag+":") {
+		return
+	}
+	if err := request.CheckIntegrity([]byte(peer.localPwd)); err != nil {
+		return
+	}
+	response := &stun.Message{Type: stun.BindingSuccess, TransactionID: request.TransactionID}
+	response.Add(stun.AttributeXorMappedAddress, stun.XorAddress(addr, request.TransactionID))
+	if _, err := peer.conn.WriteToUDP(response.Marshal([]byte(peer.localPwd)), addr); err != nil {
+		return
+	}
+	peer.mutex.Lock()
+	if peer.remoteAddr == nil || request.Has(stun.AttributeUseCandidate) {
+		peer.remoteAddr = addr
+	}
+	if addr.IP.Equal(peer.remoteAddr.IP) && addr.Port == peer.remoteAddr.Port {
+		peer.lastBinding = time.Now()
+	}
+	peer.mutex.Unlock()
+	peer.connectOnce.Do(func() {
+		close(peer.connected)
+	})
+}
+
+//handleRtp unprotect srtp from publishing peer and publish it to track
+//of its payload type
+func (peer *Peer) handleRtp(data []byte) error {
+	peer.mutex.Lock()
+	remoteContext := peer.remoteContext
+	peer.mutex.Unlock()
+	if remoteContext == nil {
+		return nil
+	}
+	data, err := remoteContext.DecryptRTP(data)
+	if err != nil {
+		return fmt.Errorf("DecryptRTP error:%v", err)
+	}
+	packet := new(rtp.Packet)
+	if err := packet.Unmarshal(data); err != nil {
+		return fmt.Errorf("invalid rtp package from peer:%v", err)
+	}
+	for _, track := range peer.tracks {
+		if track != nil && track.payloadType == packet.PayloadType {
+			atomic.StoreUint32(&track.pusherSSRC, packet.SSRC)
+			return track.pair.PublishPacket(packet)
+		}
+	}
+	return nil
+}
+
+//handleRtcp unprotect srtcp from peer,sender reports of publishing peer are
+//passed to its tracks,rtcp of playing peer is passed to pullers of tracks,
+//report blocks and feedback about a track are rewritten to ssrc of its pusher
+func (peer *Peer) handleRtcp(data []byte) error {
+	peer.mutex.Lock()
+	remoteContext := peer.remoteContext
+	peer.mutex.Unlock()
+	if remoteContext == nil {
+		return nil
+	}
+	data, err := remoteContext.DecryptRTCP(data)
+	if err != nil {
+		return fmt.Errorf("DecryptRTCP error:%v", err)
+	}
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("invalid rtcp package from peer:%v", err)
+	}
+	for _, track := range peer.tracks {
+		if track != nil && peer.publishing {
+			if err := track.publishRtcp(packets); err != nil {
+				return err
+			}
+		}
+		if track == nil || track.puller == nil {
+			continue
+		}
+		if forward := track.filterRtcp(packets); len(forward) != 0 {
+			data, err := rtcp.Marshal(forward)
+			if err != nil {
+				return fmt.Errorf("rtcp.Marshal error:%v", err)
+			}
+			if err := track.puller.ReceiveRtcp(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//write send datagram to selected candidate pair
+func (peer *Peer) write(data []byte) (int, error) {
+	peer.mutex.Lock()
+	remoteAddr := peer.remoteAddr
+	peer.mutex.Unlock()
+	if remoteAddr == nil {
+		return 0, fmt.Errorf("webrtc peer %v not connected", peer.ID)
+	}
+	return peer.conn.WriteToUDP(data, remoteAddr)
+}
+
+//WritePackage protect feedback of pullers to publishing peer and send it
+func (peer *Peer) WritePackage(packageType rtsp.PackageType, data []byte) error {
+	peer.mutex.Lock()
+	localContext := peer.localContext
+	peer.mutex.Unlock()
+	if packageType != rtsp.RtcpPackage || localContext == nil {
+		return nil
+	}
+	data, err := localContext.EncryptRTCP(data)
+	if err != nil {
+		return fmt.Errorf("EncryptRTCP error:%v", err)
+	}
+	_, err = peer.write(data)
+	return err
+}
+
+//Close stop pullers or unpublish stream of peer and close its socket,
+//it's removed from server
+func (peer *Peer) Close() error {
+	peer.closeOnce.Do(func() {
+		peer.mutex.Lock()
+		dtlsConn := peer.dtlsConn
+		peer.mutex.Unlock()
+		if dtlsConn != nil {
+			dtlsConn.Close()
+		}
+		if peer.publishing {
+			if peer.session != nil {
+				peer.server.RtspServer.Unpublish(peer.ResourcePath, peer.ID)
+			}
+		} else {
+			peer.session.StopSession(&peer.ID)
+		}
+		close(peer.closed)
+		peer.conn.Close()
+		peer.server.removePeer(peer)
+	})
+	return nil
+}
+
+//peerTrack a published track sent to peer,it's the transport of a puller,
+//or a track published by peer
+type peerTrack struct {
+	peer        *Peer
+	pair        *rtsp.PusherPullersPair // track of published stream
+	puller      *rtsp.RtpRtcpSession    // puller added to pair after dtls handshake,nil if publishing
+	mid         string                  // media id in sdp
+	payloadType uint8                   // payload type of offer
+	ssrc        uint32                  // ssrc of packages sent to peer
+	pusherSSRC  uint32                  // ssrc of the latest rtp package from pusher,atomic
+}
+
+//WritePackage protect rtp or sender report of pusher with payload type
+//and ssrc of this track and send it to peer,packages are dropped
+//if peer is not connected or closed
+func (track *peerTrack) WritePackage(packageType rtsp.PackageType, data []byte) error {
+	peer := track.peer
+	select {
+	case <-peer.closed:
+		return nil
+	default:
+	}
+	peer.mutex.Lock()
+	localContext := peer.localContext
+	peer.mutex.Unlock()
+	if localContext == nil {
+		return nil
+	}
+	if packageType == rtsp.RtcpPackage {
+		var err error
+		if data, err = track.senderReport(data); err != nil || data == nil {
+			return err
+		}
+		if data, err = localContext.EncryptRTCP(data); err != nil {
+			return fmt.Errorf("EncryptRTCP error:%v", err)
+		}
+	} else {
+		if len(data) < 12 {
+			return fmt.Errorf("rtp package too short:%v", len(data))
+		}
+		packet := make([]byte, len(data))
+		copy(packet, data)
+		atomic.StoreUint32(&track.pusherSSRC, binary.BigEndian.Uint32(packet[8:]))
+		packet[1] = packet[1]&0x80 | track.payloadType
+		binary.BigEndian.PutUint32(packet[8:], track.ssrc)
+		var err error
+		if data, err = localContext.EncryptRTP(packet); err != nil {
+			return fmt.Errorf("EncryptRTP error:%v", err)
+		}
+	}
+	if _, err := peer.write(data); err != nil {
+		fmt.Printf("webrtc peer %v write error:%v\n", peer.ID, err)
+	}
+	return nil
+}
+
+//senderReport keep only sender report of rtcp to puller with ssrc of this track,
+//nil if there is no sender report
+func (track *peerTrack) senderReport(data []byte) ([]byte, error) {
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rtcp package to peer:%v", err)
+	}
+	for _, packet := range packets {
+		if report, ok := packet.(*rtcp.SenderReport); ok {
+			report.SSRC = track.ssrc
+			report.Reports = nil
+			return rtcp.Marshal([]rtcp.Packet{report,
+				rtcp.NewCNAME(track.ssrc, rtsp.ReportCNAME)})
+		}
+	}
+	return nil, nil
+}
+
+//publishRtcp publish sender report of peer about this track
+func (track *peerTrack) publishRtcp(packets []rtcp.Packet) error {
+	pusherSSRC := atomic.LoadUint32(&track.pusherSSRC)
+	for _, packet := range packets {
+		if report, ok := packet.(*rtcp.SenderReport); ok && report.SSRC == pusherSSRC {
+			data, err := report.Marshal()
+			if err != nil {
+				return fmt.Errorf("SenderReport Marshal error:%v", err)
+			}
+			return track.pair.PublishRtcp(data)
+		}
+	}
+	return nil
+}
+
+//filterRtcp reports and feedback of peer about this track,
+//media ssrc is rewritten to ssrc of pusher
+func (track *peerTrack) filterRtcp(packets []rtcp.Packet) []rtcp.Packet {
+	pusherSSRC := atomic.LoadUint32(&track.pusherSSRC)
+	filterReports := func(reports []rtcp.ReceptionReport) []rtcp.ReceptionReport {
+		filtered := make([]rtcp.ReceptionReport, 0, 1)
+		for _, report := range reports {
+			if report.SSRC == track.ssrc {
+				report.SSRC = pusherSSRC
+				filtered = append(filtered, report)
+			}
+		}
+		return filtered
+	}
+	forward := make([]rtcp.Packet, 0, 1)
+	for _, packet := range packets {
+		switch packet := packet.(type) {
+		case *rtcp.ReceiverReport:
+			if reports := filterReports(packet.Reports); len(reports) != 0 {
+				forward = append(forward, &rtcp.ReceiverReport{SSRC: packet.SSRC, Reports: reports})
+			}
+		case *rtcp.SenderReport:
+			if reports := filterReports(packet.Reports); len(reports) != 0 {
+				forward = append(forward, &rtcp.ReceiverReport{SSRC: packet.SSRC, Reports: reports})
+			}
+		case *rtcp.TransportLayerFeedback:
+			if packet.MediaSSRC == track.ssrc {
+				feedback := *packet
+				feedback.MediaSSRC = pusherSSRC
+				forward = append(forward, &feedback)
+			}
+		case *rtcp.PayloadSpecificFeedback:
+			if packet.MediaSSRC == track.ssrc {
+				feedback := *packet
+				feedback.MediaSSRC = pusherSSRC
+				forward = append(forward, &feedback)
+			}
+		}
+	}
+	return forward
+}
+
+//dtlsTransport datagrams of dtls from peer,writes go to selected candidate pair
+type dtlsTransport struct {
+	peer      *Peer
+	datagrams chan []byte
+	deadline  time.Time
+	mutex     sync.Mutex // provide deadline's atom
+}
+
+//timeoutError error of read after deadline
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "dtls read timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+//Read read a datagram before deadline
+func (transport *dtlsTransport) Read(data []byte) (int, error) {
+	transport.mutex.Lock()
+	deadline := transport.deadline
+	transport.mutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case datagram := <-transport.datagrams:
+		return copy(data, datagram), nil
+	case <-timeout:
+		return 0, timeoutError{}
+	case <-transport.peer.closed:
+		return 0, io.EOF
+	}
+}
+
+//Write send a datagram to peer
+func (transport *dtlsTransport) Write(data []byte) (int, error) {
+	return transport.peer.write(data)
+}
+
+//SetReadDeadline set deadline of Read,zero for no deadline
+func (transport *dtlsTransport) SetReadDeadline(deadline time.Time) error {
+	transport.mutex.Lock()
+	transport.deadline = deadline
+	transport.mutex.Unlock()
+	return nil
+}
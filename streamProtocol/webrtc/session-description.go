@@ -0,0 +1,281 @@
+package webrtc
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"gortc.io/sdp"
+)
+
+//codecs of tracks forwarded to and from peers,by media type of sdp
+var codecs = map[string]struct {
+	encodingName string // encoding name of published track
+	rtpMap       string // rtpmap of offer in lower case
+}{
+	"video": {"H264", "h264/90000"},
+	"audio": {"OPUS", "opus/48000/2"},
+}
+
+//remoteDescription ice credentials,fingerprint and medias of offer
+type remoteDescription struct {
+	message     *sdp.Message
+	ufrag       string
+	pwd         string
+	fingerprint string // sha-256 fingerprint in upper case
+}
+
+//parseOffer decode offer and get ice credentials and fingerprint,
+//they are in session level or the first media
+func parseOffer(offer string) (*remoteDescription, error) {
+	sdpSession, err := sdp.DecodeSession([]byte(offer), nil)
+	if err != nil {
+		return nil, fmt.Errorf("sdp.DecodeSession error:%v", err)
+	}
+	sdpDecoder := sdp.NewDecoder(sdpSession)
+	message := new(sdp.Message)
+	if err := sdpDecoder.Decode(message); err != nil {
+		return nil, fmt.Errorf("sdpDecoder.Decode error:%v", err)
+	}
+	if len(message.Medias) == 0 {
+		return nil, fmt.Errorf("offer has no media")
+	}
+	attribute := func(key string) string {
+		if value := message.Attributes.Value(key); value != "" {
+			return value
+		}
+		return message.Medias[0].Attributes.Value(key)
+	}
+	remote := &remoteDescription{
+		message: message,
+		ufrag:   attribute("ice-ufrag"),
+		pwd:     attribute("ice-pwd"),
+	}
+	if remote.ufrag == "" || remote.pwd == "" {
+		return nil, fmt.Errorf("offer has no ice credentials")
+	}
+	fingerprint := strings.Fields(attribute("fingerprint"))
+	if len(fingerprint) != 2 || strings.ToLower(fingerprint[0]) != "sha-256" {
+		return nil, fmt.Errorf("offer has no sha-256 fingerprint")
+	}
+	remote.fingerprint = strings.ToUpper(fingerprint[1])
+	if setup := attribute("setup"); setup == "passive" {
+		return nil, fmt.Errorf("offer setup %v not support", setup)
+	}
+	return remote, nil
+}
+
+//offerPayloadType payload type of media for rtpmap,packetization-mode=1 is
+//preferred for h264,-1 if not offered
+func offerPayloadType(media *sdp.Media, rtpMap string) int {
+	payloadType := -1
+	for _, format := range media.Description.Formats {
+		matched := false
+		for _, value := range media.Attributes.Values("rtpmap") {
+			items := strings.SplitN(strings.TrimSpace(value), " ", 2)
+			matched = matched || (len(items) == 2 && items[0] == format &&
+				strings.ToLower(items[1]) == rtpMap)
+		}
+		if !matched {
+			continue
+		}
+		value, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(formatParameters(media, format), "packetization-mode=1") {
+			return value
+		}
+		if payloadType < 0 {
+			payloadType = value
+		}
+	}
+	return payloadType
+}
+
+//formatParameters fmtp parameters of format
+func formatParameters(media *sdp.Media, format string) string {
+	for _, value := range media.Attributes.Values("fmtp") {
+		items := strings.SplitN(strings.TrimSpace(value), " ", 2)
+		if len(items) == 2 && items[0] == format {
+			return items[1]
+		}
+	}
+	return ""
+}
+
+//offerCodec payload type of media for the codec of its media type,
+//direction of media is checked for playing or publishing,-1 if not matched
+func offerCodec(media *sdp.Media, publishing bool) int {
+	codec, ok := codecs[media.Description.Type]
+	if !ok || media.Attributes.Flag("inactive") {
+		return -1
+	}
+	if (publishing && media.Attributes.Flag("recvonly")) ||
+		(!publishing && media.Attributes.Flag("sendonly")) {
+		return -1
+	}
+	return offerPayloadType(media, codec.rtpMap)
+}
+
+//matchTracks choose a published track and payload type for each media of offer,
+//the first unused h264 track for video and opus track for audio,
+//tracks of medias not matched are nil
+func matchTracks(remote *remoteDescription,
+	session *rtsp.PusherPullersSession, peer *Peer) []*peerTrack {
+	tracks := make([]*peerTrack, len(remote.message.Medias))
+	used := make(map[*rtsp.PusherPullersPair]bool)
+	for index := range remote.message.Medias {
+		media := &remote.message.Medias[index]
+		payloadType := offerCodec(media, false)
+		if payloadType < 0 {
+			continue
+		}
+		for _, pair := range session.Tracks {
+			if used[pair] || pair.RtpMap == nil ||
+				pair.RtpMap.EncodingName != codecs[media.Description.Type].encodingName {
+				continue
+			}
+			used[pair] = true
+			tracks[index] = &peerTrack{
+				peer:        peer,
+				pair:        pair,
+				mid:         media.Attributes.Value("mid"),
+				payloadType: uint8(payloadType),
+				ssrc:        rand.Uint32(),
+			}
+			break
+		}
+	}
+	return tracks
+}
+
+//matchPublishTracks choose payload type of h264 and opus medias of offer
+//to publish,tracks of medias not matched are nil,pairs are set after publishing
+func matchPublishTracks(remote *remoteDescription, peer *Peer) []*peerTrack {
+	tracks := make([]*peerTrack, len(remote.message.Medias))
+	for index := range remote.message.Medias {
+		media := &remote.message.Medias[index]
+		if payloadType := offerCodec(media, true); payloadType >= 0 {
+			tracks[index] = &peerTrack{
+				peer:        peer,
+				mid:         media.Attributes.Value("mid"),
+				payloadType: uint8(payloadType),
+			}
+		}
+	}
+	return tracks
+}
+
+//publishSdp sdp of tracks published by peer,payload types and format
+//parameters are of offer,so rtp of peer is forwarded as it is
+func publishSdp(remote *remoteDescription, tracks []*peerTrack) string {
+	var builder strings.Builder
+	builder.WriteString("v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=webrtc stream\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n")
+	index := 0
+	for mediaIndex, track := range tracks {
+		if track == nil {
+			continue
+		}
+		media := &remote.message.Medias[mediaIndex]
+		format := strconv.Itoa(int(track.payloadType))
+		fmt.Fprintf(&builder, "m=%v 0 RTP/AVP %v\r\n"+
+			"a=rtpmap:%v %v\r\n",
+			media.Description.Type, format, format, codecs[media.Description.Type].rtpMap)
+		if parameters := formatParameters(media, format); parameters != "" {
+			fmt.Fprintf(&builder, "a=fmtp:%v %v\r\n", format, parameters)
+		}
+		fmt.Fprintf(&builder, "a=control:streamid=%v\r\n", index)
+		index++
+	}
+	return builder.String()
+}
+
+//answer make answer of offer,medias of tracks not matched are rejected,
+//medias are recvonly if peer publishes stream
+func (peer *Peer) answer(remote *remoteDescription, host string, port int) string {
+	var builder strings.Builder
+	mids := make([]string, 0, len(peer.tracks))
+	for _, track := range peer.tracks {
+		if track != nil {
+			mids = append(mids, track.mid)
+		}
+	}
+	fmt.Fprintf(&builder, "v=0\r\n"+
+		"o=- %v 1 IN IP4 127.0.0.1\r\n"+
+		"s=-\r\n"+
+		"t=0 0\r\n"+
+		"a=ice-lite\r\n"+
+		"a=group:BUNDLE %v\r\n"+
+		"a=msid-semantic: WMS *\r\n",
+		rand.Int63(), strings.Join(mids, " "))
+	for index := range remote.message.Medias {
+		media := &remote.message.Medias[index]
+		track := peer.tracks[index]
+		if track == nil {
+			format := "0"
+			if len(media.Description.Formats) > 0 {
+				format = media.Description.Formats[0]
+			}
+			fmt.Fprintf(&builder, "m=%v 0 %v %v\r\n"+
+				"c=IN IP4 0.0.0.0\r\n"+
+				"a=mid:%v\r\n"+
+				"a=inactive\r\n",
+				media.Description.Type, media.Description.Protocol, format,
+				media.Attributes.Value("mid"))
+			continue
+		}
+		format := strconv.Itoa(int(track.payloadType))
+		direction := "sendonly"
+		if peer.publishing {
+			direction = "recvonly"
+		}
+		fmt.Fprintf(&builder, "m=%v 9 %v %v\r\n"+
+			"c=IN IP4 0.0.0.0\r\n"+
+			"a=mid:%v\r\n"+
+			"a=ice-ufrag:%v\r\n"+
+			"a=ice-pwd:%v\r\n"+
+			"a=fingerprint:sha-256 %v\r\n"+
+			"a=setup:passive\r\n"+
+			"a=%v\r\n"+
+			"a=rtcp-mux\r\n"+
+			"a=rtpmap:%v %v\r\n",
+			media.Description.Type, media.Description.Protocol, format,
+			track.mid, peer.localUfrag, peer.localPwd,
+			peer.server.certificate.Fingerprint(), direction,
+			format, codecRtpMap(track.pair.RtpMap))
+		if parameters := formatParameters(media, format); parameters != "" {
+			fmt.Fprintf(&builder, "a=fmtp:%v %v\r\n", format, parameters)
+		}
+		for _, value := range media.Attributes.Values("rtcp-fb") {
+			items := strings.SplitN(strings.TrimSpace(value), " ", 2)
+			// feedback is forwarded to pusher,others are not supported
+			if len(items) == 2 && items[0] == format &&
+				(items[1] == "nack" || items[1] == "nack pli" || items[1] == "ccm fir") {
+				fmt.Fprintf(&builder, "a=rtcp-fb:%v %v\r\n", format, items[1])
+			}
+		}
+		if !peer.publishing {
+			fmt.Fprintf(&builder, "a=msid:%v %v\r\n"+
+				"a=ssrc:%v cname:%v\r\n",
+				rtsp.ReportCNAME, track.mid, track.ssrc, rtsp.ReportCNAME)
+		}
+		fmt.Fprintf(&builder, "a=candidate:1 1 UDP 2130706431 %v %v typ host\r\n"+
+			"a=end-of-candidates\r\n", host, port)
+	}
+	return builder.String()
+}
+
+//codecRtpMap rtpmap of track in answer
+func codecRtpMap(rtpMap *rtsp.RtpMap) string {
+	if rtpMap.EncodingName == "OPUS" {
+		return "opus/48000/2"
+	}
+	return fmt.Sprintf("%v/%v", rtpMap.EncodingName, rtpMap.ClockRate)
+}
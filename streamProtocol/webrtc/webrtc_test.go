@@ -0,0 +1,422 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/dtls"
+	"github.com/darunshen/go/streamProtocol/internal/nettest"
+	"github.com/darunshen/go/streamProtocol/rtcp"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/darunshen/go/streamProtocol/srtp"
+	"github.com/darunshen/go/streamProtocol/stun"
+)
+
+const testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAKKzZQHgCJ+XARAAAAwAEAAADAPA8YMZY,aOvjyyLA\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 111\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=control:streamid=1\r\n"
+
+//testOffer offer of a browser,h264 with packetization-mode=1 is 102,
+//the application media is rejected,direction is recvonly for playing
+//or sendonly for publishing
+func testOffer(fingerprint, direction string) string {
+	return "v=0\r\n" +
+		"o=- 1 2 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=group:BUNDLE 0 1 2\r\n" +
+		"a=ice-ufrag:clnt\r\n" +
+		"a=ice-pwd:clientpasswordclientpassword\r\n" +
+		"a=fingerprint:sha-256 " + fingerprint + "\r\n" +
+		"a=setup:actpass\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 98 102\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=" + direction + "\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtpmap:98 H264/90000\r\n" +
+		"a=fmtp:98 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+		"a=rtcp-fb:102 nack pli\r\n" +
+		"a=rtcp-fb:102 transport-cc\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 109\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=" + direction + "\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtpmap:109 opus/48000/2\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:2\r\n"
+}
+
+//post serve request of method with sdp body by server
+func post(server *Server, method, url, contentType, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, url, strings.NewReader(body))
+	request.Header.Set("Content-Type", contentType)
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+//answerLines values of lines in answer beginning with prefix
+func answerLines(answer, prefix string) []string {
+	values := make([]string, 0)
+	for _, line := range strings.Split(answer, "\r\n") {
+		if strings.HasPrefix(line, prefix) {
+			values = append(values, strings.TrimPrefix(line, prefix))
+		}
+	}
+	return values
+}
+
+//bind send binding request nominating candidate and check the response
+func bind(t *testing.T, conn *net.UDPConn, ufrag, pwd string) {
+	request, err := stun.NewMessage(stun.BindingRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Add(stun.AttributeUsername, []byte(ufrag+":clnt"))
+	request.Add(stun.AttributeUseCandidate, nil)
+	request.Add(stun.AttributeICEControlling, make([]byte, 8))
+	if _, err := conn.Write(request.Marshal([]byte(pwd))); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	number, err := conn.Read(data)
+	if err != nil {
+		t.Fatalf("binding response error:%v", err)
+	}
+	response := new(stun.Message)
+	if err := response.Unmarshal(data[:number]); err != nil {
+		t.Fatalf("binding response Unmarshal error:%v", err)
+	}
+	if response.Type != stun.BindingSuccess || response.TransactionID != request.TransactionID {
+		t.Fatalf("binding response type %#x", response.Type)
+	}
+	if err := response.CheckIntegrity([]byte(pwd)); err != nil {
+		t.Errorf("binding response CheckIntegrity error:%v", err)
+	}
+	addr, err := stun.ParseXorAddress(response.Get(stun.AttributeXorMappedAddress), response.TransactionID)
+	if err != nil || addr.String() != conn.LocalAddr().String() {
+		t.Errorf("XOR-MAPPED-ADDRESS = %v,%v, want %v", addr, err, conn.LocalAddr())
+	}
+}
+
+//readRtp read srtp from server until a rtp package,rtcp is skipped
+func readRtp(conn *net.UDPConn, context *srtp.Context) ([]byte, error) {
+	data := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		number, err := conn.Read(data)
+		if err != nil {
+			return nil, fmt.Errorf("read rtp error:%v", err)
+		}
+		if number < 12 || data[1] >= 192 && data[1] <= 223 {
+			continue
+		}
+		return context.DecryptRTP(data[:number])
+	}
+}
+
+//connect do ice check and dtls handshake with candidate of answer,
+//return connected socket and srtp contexts
+func connect(t *testing.T, answer string,
+	certificate *dtls.Certificate) (*net.UDPConn, *srtp.Context, *srtp.Context) {
+	ufrag := answerLines(answer, "a=ice-ufrag:")[0]
+	pwd := answerLines(answer, "a=ice-pwd:")[0]
+	fingerprint := answerLines(answer, "a=fingerprint:sha-256 ")[0]
+	candidate := strings.Fields(answerLines(answer, "a=candidate:")[0])
+	if len(candidate) < 6 || candidate[4] != "127.0.0.1" {
+		t.Fatalf("answer candidate = %q", candidate)
+	}
+	port, _ := strconv.Atoi(candidate[5])
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind(t, conn, ufrag, pwd)
+	client := dtls.Client(conn, &dtls.Config{
+		Certificate:  certificate,
+		SRTPProfiles: []srtp.ProtectionProfile{srtp.ProfileAES128CMHMACSHA180},
+		VerifyPeerCertificate: func(der []byte) error {
+			if dtls.Fingerprint(der) != fingerprint {
+				return fmt.Errorf("server fingerprint mismatch")
+			}
+			return nil
+		},
+	})
+	if err := client.Handshake(); err != nil {
+		conn.Close()
+		t.Fatalf("Handshake error:%v", err)
+	}
+	localContext, remoteContext, err := client.SRTPContexts()
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn, localContext, remoteContext
+}
+
+//startServer start a rtsp server and a webrtc server of it with candidates on loopback,
+//the rtsp server is stopped by the caller
+func startServer(t *testing.T) (*Server, string) {
+	rtspServer := &rtsp.Server{}
+	address := nettest.Start(t, rtspServer)
+	server := &Server{RtspServer: rtspServer}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	ICEHost = "127.0.0.1"
+	return server, address
+}
+
+func TestWHEP(t *testing.T) {
+	server, address := startServer(t)
+	defer server.RtspServer.Stop()
+	certificate, err := dtls.GenerateCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := testOffer(certificate.Fingerprint(), "recvonly")
+	if response := post(server, http.MethodPost, "/live/test/whep",
+		"application/sdp", offer); response.Code != http.StatusNotFound {
+		t.Errorf("unpublished path response %v, want 404", response.Code)
+	}
+
+	pusher, err := rtsp.NewClient(fmt.Sprintf("rtsp://%v/live/test", address), rtsp.TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	feedback := make(chan *rtcp.PayloadSpecificFeedback, 10)
+	pusher.OnPackage = func(trackIndex int, packageType rtsp.PackageType, data rtsp.RtpRtcpPackage) {
+		packets, _ := rtcp.Unmarshal(data)
+		for _, packet := range packets {
+			if packet, ok := packet.(*rtcp.PayloadSpecificFeedback); ok && trackIndex == 0 {
+				feedback <- packet
+			}
+		}
+	}
+	if err := pusher.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer pusher.Close()
+	if err := pusher.StartPush(testSdp); err != nil {
+		t.Fatalf("StartPush error:%v", err)
+	}
+
+	if response := post(server, http.MethodPost, "/live/test/whep",
+		"application/json", offer); response.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("json offer response %v, want 415", response.Code)
+	}
+	response := post(server, http.MethodPost, "/live/test/whep", "application/sdp", offer)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("offer response %v", response.Code)
+	}
+	location := response.Header().Get("Location")
+	if !strings.HasPrefix(location, "/live/test/whep/") {
+		t.Fatalf("Location = %v", location)
+	}
+	answer := response.Body.String()
+	if medias := answerLines(answer, "m="); len(medias) != 3 ||
+		medias[0] != "video 9 UDP/TLS/RTP/SAVPF 102" ||
+		medias[1] != "audio 9 UDP/TLS/RTP/SAVPF 109" ||
+		!strings.HasPrefix(medias[2], "application 0 ") {
+		t.Fatalf("answer medias = %q", medias)
+	}
+	if feedbacks := answerLines(answer, "a=rtcp-fb:"); len(feedbacks) != 1 ||
+		feedbacks[0] != "102 nack pli" {
+		t.Errorf("answer rtcp-fb = %q", feedbacks)
+	}
+	ssrc := strings.Fields(answerLines(answer, "a=ssrc:")[0])[0]
+	conn, localContext, remoteContext := connect(t, answer, certificate)
+	defer conn.Close()
+
+	// the marker bit is kept and payload type and ssrc are of answer
+	packet := []byte{0x80, 0xe0, 0, 1, 0, 0, 0, 0, 0, 0, 0x12, 0x34, 0x65, 0x88, 0x80}
+	received := make(chan []byte, 1)
+	go func() {
+		packet, err := readRtp(conn, remoteContext)
+		if err != nil {
+			fmt.Println(err)
+		}
+		received <- packet
+	}()
+	var forwarded []byte
+	for deadline := time.Now().Add(5 * time.Second); forwarded == nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer not received rtp")
+		}
+		if err := pusher.PushPackage(0, rtsp.RtpPackage, packet); err != nil {
+			t.Fatalf("PushPackage error:%v", err)
+		}
+		binary.BigEndian.PutUint16(packet[2:], binary.BigEndian.Uint16(packet[2:])+1)
+		select {
+		case forwarded = <-received:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if forwarded[1] != 0x80|102 || strconv.FormatUint(uint64(binary.BigEndian.Uint32(forwarded[8:])), 10) != ssrc ||
+		!bytes.Equal(forwarded[12:], packet[12:]) {
+		t.Errorf("forwarded rtp = %x", forwarded)
+	}
+
+	// picture loss indication goes to pusher with its ssrc
+	answerSSRC, _ := strconv.ParseUint(ssrc, 10, 32)
+	pli, _ := rtcp.Marshal([]rtcp.Packet{rtcp.NewPictureLossIndication(1, uint32(answerSSRC))})
+	protected, err := localContext.EncryptRTCP(pli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(protected); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case packet := <-feedback:
+		if !packet.IfKeyframeRequest() || packet.MediaSSRC != 0x1234 {
+			t.Errorf("pusher feedback = %+v", packet)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("pusher not received picture loss indication")
+	}
+
+	if response := post(server, http.MethodDelete, location, "", ""); response.Code != http.StatusOK {
+		t.Errorf("delete response %v", response.Code)
+	}
+	if server.findPeer(location[len("/live/test/whep/"):]) != nil {
+		t.Errorf("peer not removed at delete")
+	}
+	if response := post(server, http.MethodDelete, location, "", ""); response.Code != http.StatusNotFound {
+		t.Errorf("delete again response %v, want 404", response.Code)
+	}
+}
+
+func TestWHIP(t *testing.T) {
+	server, address := startServer(t)
+	defer server.RtspServer.Stop()
+	certificate, err := dtls.GenerateCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := testOffer(certificate.Fingerprint(), "sendonly")
+	response := post(server, http.MethodPost, "/live/whip/whip", "application/sdp", offer)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("offer response %v", response.Code)
+	}
+	location := response.Header().Get("Location")
+	answer := response.Body.String()
+	if !strings.HasPrefix(location, "/live/whip/whip/") ||
+		len(answerLines(answer, "a=recvonly")) != 2 || len(answerLines(answer, "a=ssrc:")) != 0 {
+		t.Fatalf("Location = %v,answer:\n%v", location, answer)
+	}
+	if response := post(server, http.MethodPost, "/live/whip/whip",
+		"application/sdp", offer); response.Code != http.StatusConflict {
+		t.Errorf("publish again response %v, want 409", response.Code)
+	}
+	session := server.RtspServer.FindPublished("/live/whip")
+	if session == nil {
+		t.Fatalf("stream not published")
+	}
+	for _, line := range []string{"m=video 0 RTP/AVP 102\r\n", "a=rtpmap:102 h264/90000\r\n",
+		"packetization-mode=1", "m=audio 0 RTP/AVP 109\r\n", "a=rtpmap:109 opus/48000/2\r\n"} {
+		if !strings.Contains(*session.SdpContent, line) {
+			t.Errorf("published sdp has no %q:\n%v", line, *session.SdpContent)
+		}
+	}
+	conn, localContext, remoteContext := connect(t, answer, certificate)
+	defer conn.Close()
+
+	puller, err := rtsp.NewClient(fmt.Sprintf("rtsp://%v/live/whip", address), rtsp.TransportTCP)
+	if err != nil {
+		t.Fatalf("NewClient error:%v", err)
+	}
+	received := make(chan []byte, 10)
+	puller.OnPackage = func(trackIndex int, packageType rtsp.PackageType, data rtsp.RtpRtcpPackage) {
+		if trackIndex == 0 && packageType == rtsp.RtpPackage {
+			received <- data
+		}
+	}
+	if err := puller.Dial(); err != nil {
+		t.Fatalf("Dial error:%v", err)
+	}
+	defer puller.Close()
+	if _, err := puller.Describe(); err != nil {
+		t.Fatalf("Describe error:%v", err)
+	}
+	if _, err := puller.Setup(0, "play"); err != nil {
+		t.Fatalf("Setup error:%v", err)
+	}
+	if _, err := puller.Play(); err != nil {
+		t.Fatalf("Play error:%v", err)
+	}
+
+	// rtp of peer is forwarded to rtsp puller as it is
+	packet := []byte{0x80, 0xe6, 0, 1, 0, 0, 0, 0, 0, 0, 0xab, 0xcd, 0x65, 0x88, 0x80}
+	var forwarded []byte
+	for deadline := time.Now().Add(5 * time.Second); forwarded == nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("puller not received rtp")
+		}
+		protected, err := localContext.EncryptRTP(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(protected); err != nil {
+			t.Fatal(err)
+		}
+		binary.BigEndian.PutUint16(packet[2:], binary.BigEndian.Uint16(packet[2:])+1)
+		select {
+		case forwarded = <-received:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if forwarded[1] != 0xe6 || binary.BigEndian.Uint32(forwarded[8:]) != 0xabcd ||
+		!bytes.Equal(forwarded[12:], packet[12:]) {
+		t.Errorf("forwarded rtp = %x", forwarded)
+	}
+
+	// picture loss indication of puller goes to peer
+	pli, _ := rtcp.Marshal([]rtcp.Packet{rtcp.NewPictureLossIndication(1, 0xabcd)})
+	if err := puller.InterleavedConn.WriteFrame(puller.Tracks[0].RtcpChannel, pli); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	number, err := conn.Read(data)
+	if err != nil {
+		t.Fatalf("peer not received picture loss indication:%v", err)
+	}
+	unprotected, err := remoteContext.DecryptRTCP(data[:number])
+	if err != nil {
+		t.Fatalf("DecryptRTCP error:%v", err)
+	}
+	if !bytes.Equal(unprotected, pli) {
+		t.Errorf("feedback = %x, want %x", unprotected, pli)
+	}
+
+	if response := post(server, http.MethodDelete, location, "", ""); response.Code != http.StatusOK {
+		t.Errorf("delete response %v", response.Code)
+	}
+	if server.RtspServer.FindPublished("/live/whip") != nil {
+		t.Errorf("stream not unpublished at delete")
+	}
+}
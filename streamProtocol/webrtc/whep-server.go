@@ -0,0 +1,228 @@
+package webrtc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darunshen/go/streamProtocol/dtls"
+	"github.com/darunshen/go/streamProtocol/rtsp"
+	"github.com/teris-io/shortid"
+)
+
+// default values are used by Server
+var (
+	//ICEHost ip of host candidate in answers,local address of http request if empty
+	ICEHost = ""
+	//ConnectTimeout peer is closed if ice checks do not arrive in it
+	ConnectTimeout = 15 * time.Second
+	//ConsentTimeout peer is closed if no binding request in it(rfc7675)
+	ConsentTimeout = 30 * time.Second
+	//CheckInterval interval of checking consent and pusher of peers
+	CheckInterval = time.Second
+)
+
+//maxOfferSize max size of sdp offer in request body
+const maxOfferSize = 64 * 1024
+
+/*
+Server whep(draft-ietf-wish-whep) and whip(rfc9725) server of RtspServer,
+offer of playing resource path /live/test is posted to /live/test/whep,
+offer of publishing it is posted to /live/test/whip,
+the peer is deleted at the Location of response,
+h264 and opus tracks are forwarded to and from peers without transcoding,
+streams published by whip are in PusherPullersSessionMap like rtsp pushers
+*/
+type Server struct {
+	RtspServer  *rtsp.Server
+	httpServer  *http.Server
+	certificate *dtls.Certificate // dtls certificate of all peers
+	peers       map[string]*Peer  // peers by id
+	peersMutex  sync.Mutex
+}
+
+//Start start a whep and whip server listening at address
+func (server *Server) Start(address string) error {
+	if err := server.init(); err != nil {
+		return err
+	}
+	server.httpServer = &http.Server{Addr: address, Handler: server}
+	fmt.Println("Start whep and whip listening at ", address)
+	if err := server.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("ListenAndServe error:%v", err)
+	}
+	return nil
+}
+
+//init generate certificate of peers
+func (server *Server) init() error {
+	certificate, err := dtls.GenerateCertificate()
+	if err != nil {
+		return fmt.Errorf("GenerateCertificate error:%v", err)
+	}
+	server.peersMutex.Lock()
+	defer server.peersMutex.Unlock()
+	server.certificate = certificate
+	server.peers = make(map[string]*Peer)
+	return nil
+}
+
+//Stop close http server and all peers
+func (server *Server) Stop() error {
+	err := server.httpServer.Close()
+	server.peersMutex.Lock()
+	peers := make([]*Peer, 0, len(server.peers))
+	for _, peer := range server.peers {
+		peers = append(peers, peer)
+	}
+	server.peersMutex.Unlock()
+	for _, peer := range peers {
+		peer.Close()
+	}
+	return err
+}
+
+//ServeHTTP create peer by offer posted to {resource path}/whep or
+//{resource path}/whip,delete peer at {resource path}/whep/{id} or whip/{id}
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	header := writer.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Access-Control-Allow-Methods", "OPTIONS, POST, DELETE")
+	header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	header.Set("Access-Control-Expose-Headers", "Location")
+	if request.Method == http.MethodOptions {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	resourcePath, endpoint, id := request.URL.Path, "", ""
+	dir, name := path.Split(resourcePath)
+	switch {
+	case name == "whep" || name == "whip":
+		resourcePath, endpoint = strings.TrimSuffix(dir, "/"), name
+	case name != "" && (strings.HasSuffix(dir, "/whep/") || strings.HasSuffix(dir, "/whip/")):
+		dir = strings.TrimSuffix(dir, "/")
+		resourcePath, endpoint, id = path.Dir(dir), path.Base(dir), name
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case id == "" && request.Method == http.MethodPost:
+		server.createPeer(writer, request, resourcePath, endpoint == "whip")
+	case id != "" && request.Method == http.MethodDelete:
+		peer := server.findPeer(id)
+		if peer == nil || peer.ResourcePath != resourcePath || peer.publishing != (endpoint == "whip") {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		peer.Close()
+		writer.WriteHeader(http.StatusOK)
+	default:
+		// trickle ice and ice restarts are not supported
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//createPeer answer offer in request body and start peer,
+//the stream is published by peer if publishing
+func (server *Server) createPeer(writer http.ResponseWriter,
+	request *http.Request, resourcePath string, publishing bool) {
+	if contentType := request.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/sdp") {
+		writer.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := ioutil.ReadAll(io.LimitReader(request.Body, maxOfferSize))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session := server.RtspServer.FindPublished(resourcePath)
+	if session == nil && !publishing {
+		fmt.Printf("whep resource path %v not published\n", resourcePath)
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if session != nil && publishing {
+		fmt.Printf("whip resource path %v already published\n", resourcePath)
+		writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	remote, err := parseOffer(string(offer))
+	if err != nil {
+		fmt.Printf("webrtc parseOffer error:%v\n", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	host := ICEHost
+	if host == "" {
+		if addr, ok := request.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+			host = addr.IP.String()
+		}
+	}
+	peer, err := newPeer(server, shortid.MustGenerate(), resourcePath, session, remote)
+	if err != nil {
+		fmt.Printf("webrtc newPeer error:%v\n", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if publishing {
+		peer.publishing = true
+		peer.tracks = matchPublishTracks(remote, peer)
+	} else {
+		peer.tracks = matchTracks(remote, session, peer)
+	}
+	matched := false
+	for _, track := range peer.tracks {
+		matched = matched || track != nil
+	}
+	if !matched {
+		fmt.Printf("webrtc offer has no h264 or opus media of %v\n", resourcePath)
+		peer.conn.Close()
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if publishing {
+		if err := peer.publish(); err != nil {
+			fmt.Printf("whip %v\n", err)
+			peer.conn.Close()
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+	answer := peer.answer(remote, host, peer.conn.LocalAddr().(*net.UDPAddr).Port)
+	server.peersMutex.Lock()
+	server.peers[peer.ID] = peer
+	server.peersMutex.Unlock()
+	peer.start()
+	endpoint := "/whep/"
+	if publishing {
+		endpoint = "/whip/"
+	}
+	header := writer.Header()
+	header.Set("Content-Type", "application/sdp")
+	header.Set("Location", resourcePath+endpoint+peer.ID)
+	writer.WriteHeader(http.StatusCreated)
+	io.WriteString(writer, answer)
+}
+
+//findPeer find peer by id
+func (server *Server) findPeer(id string) *Peer {
+	server.peersMutex.Lock()
+	defer server.peersMutex.Unlock()
+	return server.peers[id]
+}
+
+//removePeer remove closed peer from server
+func (server *Server) removePeer(peer *Peer) {
+	server.peersMutex.Lock()
+	defer server.peersMutex.Unlock()
+	if server.peers[peer.ID] == peer {
+		delete(server.peers, peer.ID)
+	}
+}